@@ -0,0 +1,148 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"time"
+)
+
+/*
+an achievement unlocked once when its predicate holds for the game state
+*/
+type Achievement struct {
+	id          string
+	name        string
+	description string
+	predicate   func(g *Game) bool
+	unlocked    string // RFC3339 time of the unlock. empty while locked
+}
+
+/*
+serialized form of an achievement in achievementsFileName
+*/
+type achievementJSON struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Unlocked    string `json:"unlocked,omitempty"`
+}
+
+/*
+Returns the built-in achievements, all locked. The predicates are evaluated by Game.checkAchievements.
+*/
+func getBuiltinAchievements() []*Achievement {
+	return []*Achievement{
+		{id: "firstBody", name: "First Body", description: "Complete a body",
+			predicate: func(g *Game) bool { return 0 < len(g.completionLog) }},
+		{id: "chain3", name: "Chain 3", description: "Reach a combo of 3",
+			predicate: func(g *Game) bool { return 3 <= g.maxCombo }},
+		{id: "speedDemon", name: "Speed Demon", description: "Reach the maximum speed",
+			// reached by the play, not by the start level like in the marathon
+			predicate: func(g *Game) bool { return g.speedLevelIdx == len(speedLevels)-1 && g.startSpeedLevelIdx < g.speedLevelIdx }},
+		{id: "bombSquad", name: "Bomb Squad", description: "Detonate 10 bombs in one game",
+			predicate: func(g *Game) bool { return 10 <= g.bombCnt }},
+	}
+}
+
+/*
+loadAchievements loads the unlock state of the achievements from a JSON file. The name and the description
+of the file override the built-in ones, the unknown achievements are skipped.
+*/
+func loadAchievements(path string, achievements []*Achievement) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to load achievements: %w", err)
+	}
+
+	var desc []achievementJSON
+	if err := json.Unmarshal(data, &desc); err != nil {
+		return fmt.Errorf("failed to parse achievements '%s': %w", path, err)
+	}
+
+	byID := map[string]*Achievement{}
+	for _, a := range achievements {
+		byID[a.id] = a
+	}
+	for _, d := range desc {
+		a, ok := byID[d.ID]
+		if !ok {
+			log.Printf("Unknown achievement '%s' in '%s' is skipped", d.ID, path)
+			continue
+		}
+		if d.Name != "" {
+			a.name = d.Name
+		}
+		if d.Description != "" {
+			a.description = d.Description
+		}
+		a.unlocked = d.Unlocked
+	}
+	return nil
+}
+
+func saveAchievements(path string, achievements []*Achievement) error {
+	desc := make([]achievementJSON, 0, len(achievements))
+	for _, a := range achievements {
+		desc = append(desc, achievementJSON{ID: a.id, Name: a.name, Description: a.description, Unlocked: a.unlocked})
+	}
+
+	data, err := json.MarshalIndent(desc, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal achievements: %w", err)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("failed to save achievements '%s': %w", path, err)
+	}
+	return nil
+}
+
+/*
+initAchievements creates the achievements with the unlock state of achievementsFileName.
+*/
+func (g *Game) initAchievements() {
+	g.achievements = getBuiltinAchievements()
+	if err := loadAchievements(achievementsFileName, g.achievements); err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.Printf("%v", err)
+	}
+}
+
+/*
+checkAchievements evaluates the predicates of the locked achievements. The first unlock is notified
+at the top of the screen and saved.
+*/
+func (g *Game) checkAchievements() {
+	var unlocked []*Achievement
+	for _, a := range g.achievements {
+		if a.unlocked == "" && a.predicate(g) {
+			a.unlocked = time.Now().Format(time.RFC3339)
+			unlocked = append(unlocked, a)
+			log.Printf("Achievement '%s' unlocked", a.name)
+		}
+	}
+	if len(unlocked) == 0 {
+		return
+	}
+
+	// the achievements unlocked together are notified together
+	text := []string{T("dialog.achievementUnlocked")}
+	for _, a := range unlocked {
+		text = append(text, a.name)
+	}
+	g.achievementNotice.text = text
+	g.achievementNotice.activate(true)
+
+	if err := saveAchievements(achievementsFileName, g.achievements); err != nil {
+		log.Printf("%v", err)
+	}
+}
+
+/*
+Returns the position of the achievement notification, at the top of the grid.
+*/
+func (g *Game) getAchievementNoticePos() Pos {
+	x, _ := grid2ScrPos(float32(gridSize.w)/2, 0)
+	return Pos{int(x), achievementNoticeTop}
+}
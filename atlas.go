@@ -0,0 +1,56 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"image"
+	"os"
+
+	"github.com/hajimehoshi/ebiten/v2"
+)
+
+const spriteAtlasPath = "assets/sprites" // the atlas of the piece images without the extension, generated by atlasgen.go
+
+/*
+the place of a sprite in the atlas image
+*/
+type SpriteRect struct {
+	X int `json:"x"`
+	Y int `json:"y"`
+	W int `json:"w"`
+	H int `json:"h"`
+}
+
+var spriteAtlases = map[*ebiten.Image]*ebiten.Image{} // the atlas image of each sprite cut from an atlas
+
+/*
+loadSpriteAtlas loads the atlas image and its descriptor of the path without the extension.
+Returns the sprites cut from the atlas by the paths of their source images.
+*/
+func loadSpriteAtlas(path string) (map[string]*ebiten.Image, error) {
+	data, err := os.ReadFile(path + ".json")
+	if err != nil {
+		return nil, fmt.Errorf("failed to load sprite atlas: %w", err)
+	}
+
+	var rects map[string]SpriteRect
+	if err := json.Unmarshal(data, &rects); err != nil {
+		return nil, fmt.Errorf("failed to parse sprite atlas '%s': %w", path, err)
+	}
+
+	atlas, err := LoadImage(path + ".png")
+	if err != nil {
+		return nil, err
+	}
+
+	sprites := map[string]*ebiten.Image{}
+	for spritePath, rect := range rects {
+		if !image.Rect(rect.X, rect.Y, rect.X+rect.W, rect.Y+rect.H).In(atlas.Bounds()) {
+			return nil, fmt.Errorf("sprite '%s' is out of the atlas '%s'", spritePath, path)
+		}
+		sprite := atlas.SubImage(image.Rect(rect.X, rect.Y, rect.X+rect.W, rect.Y+rect.H)).(*ebiten.Image)
+		sprites[spritePath] = sprite
+		spriteAtlases[sprite] = atlas
+	}
+	return sprites, nil
+}
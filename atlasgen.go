@@ -0,0 +1,88 @@
+//go:build ignore
+
+/*
+atlasgen packs the sprite images of the arguments into a texture atlas, so the sprites can be drawn by a single
+DrawTriangles call, see BatchRenderer. The atlas image is written to <out>.png, the rectangles of the sprites
+by their paths to <out>.json. Run by go generate.
+*/
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"image"
+	"image/draw"
+	"image/png"
+	"log"
+	"os"
+)
+
+const atlasPadding = 1 // the transparent pixels between the sprites, so the filtering does not bleed the neighbors
+
+/*
+the place of a sprite in the atlas. the same as SpriteRect of the game
+*/
+type SpriteRect struct {
+	X int `json:"x"`
+	Y int `json:"y"`
+	W int `json:"w"`
+	H int `json:"h"`
+}
+
+func loadPNG(path string) image.Image {
+	file, err := os.Open(path)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer file.Close()
+
+	img, err := png.Decode(file)
+	if err != nil {
+		log.Fatalf("failed to decode %s: %v", path, err)
+	}
+	return img
+}
+
+func main() {
+	out := flag.String("out", "assets/sprites", "path of the atlas without the extension")
+	flag.Parse()
+	if flag.NArg() == 0 {
+		log.Fatal("no sprites given")
+	}
+
+	// the sprites are placed in a row in the order of the arguments
+	images := make([]image.Image, flag.NArg())
+	rects := map[string]SpriteRect{}
+	w, h := 0, 0
+	for i, path := range flag.Args() {
+		images[i] = loadPNG(path)
+		size := images[i].Bounds().Size()
+		rects[path] = SpriteRect{X: w, Y: 0, W: size.X, H: size.Y}
+		w += size.X + atlasPadding
+		h = max(h, size.Y)
+	}
+
+	atlas := image.NewNRGBA(image.Rect(0, 0, w-atlasPadding, h))
+	for i, path := range flag.Args() {
+		rect := rects[path]
+		draw.Draw(atlas, image.Rect(rect.X, rect.Y, rect.X+rect.W, rect.Y+rect.H), images[i], images[i].Bounds().Min, draw.Src)
+	}
+
+	file, err := os.Create(*out + ".png")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer file.Close()
+	if err := png.Encode(file, atlas); err != nil {
+		log.Fatalf("failed to encode the atlas: %v", err)
+	}
+
+	data, err := json.MarshalIndent(rects, "", "  ")
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := os.WriteFile(*out+".json", data, 0644); err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("%d sprites packed to %s.png", len(rects), *out)
+}
@@ -1,11 +1,17 @@
 package main
 
 import (
+	"bytes"
+	"fmt"
 	"log"
+	"math"
+	"math/rand"
 	"time"
 	"os"
 	"path"
 	"io"
+	"slices"
+	"sync/atomic"
 
 	"github.com/hajimehoshi/ebiten/v2/audio"
 	"github.com/hajimehoshi/ebiten/v2/audio/mp3"
@@ -14,14 +20,32 @@ import (
 
 var globalAudioContext *audio.Context
 
+// change of the playback rate per update
+const playbackRateStep = 0.005
+
 type Audio struct {
-	// Theme music asset file
+	// Theme music asset file, the current track of the playlist
 	themeMusicAssetFile string
 	loopedPlay bool
+	// playlist
+	tracks []string
+	trackIdx int
+	// the track order is shuffled after each full cycle
+	shuffle bool
+	// started by Play and not paused. used to detect the end of the track
+	playing bool
+	// the stream of the player, resampled by the playback rate
+	rateStream *rateReader
+	// the playback rate approaches the target rate by playbackRateStep per update
+	playbackRate float64
+	targetPlaybackRate float64
 	// player
 	player *audio.Player
 	// music file
 	musicFile *os.File
+	// volume in [0,1], kept while muted
+	volume float64
+	muted bool
 }
 
 // createMusicPlayer initializes the audio context and creates a music player
@@ -39,24 +63,7 @@ func (a *Audio) createMusicPlayer() {
 		log.Fatal(err)
 	}
 	
-	fileExt := path.Ext(a.themeMusicAssetFile)
-
-	var audioStream io.ReadSeeker
-	audioStreamLength := int64(0)
-	if fileExt == ".mp3" {
-		mp3AudioStream, err2 := mp3.DecodeWithSampleRate(globalAudioContext.SampleRate(), a.musicFile)
-		audioStreamLength = mp3AudioStream.Length()
-		audioStream = mp3AudioStream
-		err = err2
-	} else if fileExt == ".wav" {
-		wavAudioStream, err2 := wav.DecodeWithSampleRate(globalAudioContext.SampleRate(), a.musicFile)
-		audioStreamLength = wavAudioStream.Length()
-		audioStream = wavAudioStream
-		err = err2
-	} else {
-		log.Fatalf("Unknown auido file extension '%s'", a.themeMusicAssetFile)
-	}
-
+	audioStream, audioStreamLength, err := decodeAudioStream(a.musicFile, a.themeMusicAssetFile)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -65,34 +72,129 @@ func (a *Audio) createMusicPlayer() {
 	log.Printf("Stream '%s' (length %d s) prepared (infinite=%t)", a.themeMusicAssetFile, audioLengthSec, a.loopedPlay)
 
 	if a.loopedPlay {
-		loopedStream := audio.NewInfiniteLoop(audioStream, audioStreamLength)
-		a.player, err = globalAudioContext.NewPlayer(loopedStream)
-	} else  {
-		a.player, err = globalAudioContext.NewPlayer(audioStream)
+		audioStream = audio.NewInfiniteLoop(audioStream, audioStreamLength)
 	}
+	a.rateStream = newRateReader(audioStream, a.playbackRate)
+	a.player, err = globalAudioContext.NewPlayer(a.rateStream)
 
 	if err != nil {
 		log.Fatal(err)
 	}
 }
 
-// NewAudio creates a new Audio instance with the provided theme music asset file.
-// It initializes the music player for the audio.
+// decodeAudioStream decodes the mp3 or wav stream by the extension of the file name,
+// resampled to the sample rate of the audio context.
+// It returns the decoded stream and its length in bytes.
+func decodeAudioStream(src io.ReadSeeker, fileName string) (io.ReadSeeker, int64, error) {
+	switch path.Ext(fileName) {
+	case ".mp3":
+		stream, err := mp3.DecodeWithSampleRate(globalAudioContext.SampleRate(), src)
+		if err != nil {
+			return nil, 0, err
+		}
+		return stream, stream.Length(), nil
+	case ".wav":
+		stream, err := wav.DecodeWithSampleRate(globalAudioContext.SampleRate(), src)
+		if err != nil {
+			return nil, 0, err
+		}
+		return stream, stream.Length(), nil
+	default:
+		return nil, 0, fmt.Errorf("unknown audio file extension '%s'", fileName)
+	}
+}
+
+// NewAudio creates a new Audio instance with the provided playlist.
+// It initializes the music player for the first track.
 //
 // Parameters:
-//   - themeMusicAssetFile: The file path to the theme music asset.
+//   - tracks: The file paths to the music assets. Must not be empty.
+//   - loopedPlay: The current track is looped instead of advancing to the next one.
 //
 // Returns:
 //   - *Audio: A pointer to the newly created Audio instance.
-func NewAudio(themeMusicAssetFile string, loopedPlay bool) *Audio {
+func NewAudio(tracks []string, loopedPlay bool) *Audio {
+	if len(tracks) == 0 {
+		log.Fatalf("No audio tracks")
+	}
+
 	a := &Audio{
-		themeMusicAssetFile: themeMusicAssetFile,
+		themeMusicAssetFile: tracks[0],
 		loopedPlay: loopedPlay,
+		tracks: slices.Clone(tracks),
+		volume: 1,
+		playbackRate: 1,
+		targetPlaybackRate: 1,
 	}
 	a.createMusicPlayer()
 	return a
 }
 
+// NextTrack stops the current track and starts the next track of the playlist.
+// After the last track the playlist wraps around, shuffled if the shuffle mode is on.
+func (a *Audio) NextTrack() {
+	a.getPlayer().Pause()
+	if err := a.getPlayer().Close(); err != nil {
+		log.Printf("Failed to close the player of '%s': %v", a.themeMusicAssetFile, err)
+	}
+	a.musicFile.Close()
+
+	a.trackIdx++
+	if a.trackIdx == len(a.tracks) {
+		a.trackIdx = 0
+		if a.shuffle {
+			rand.Shuffle(len(a.tracks), func(i, j int) { a.tracks[i], a.tracks[j] = a.tracks[j], a.tracks[i] })
+		}
+	}
+
+	a.themeMusicAssetFile = a.tracks[a.trackIdx]
+	a.createMusicPlayer()
+	if a.muted {
+		a.getPlayer().SetVolume(0)
+	} else {
+		a.getPlayer().SetVolume(a.volume)
+	}
+	a.Play()
+}
+
+// SetShuffle turns on or off the shuffle mode of the playlist.
+func (a *Audio) SetShuffle(shuffle bool) {
+	a.shuffle = shuffle
+}
+
+// SetPlaybackRate changes the speed of the playback. The pitch is shifted together with the speed.
+// The rate is changed smoothly by update, in steps of playbackRateStep.
+func (a *Audio) SetPlaybackRate(rate float64) {
+	if rate <= 0 {
+		log.Printf("Invalid playback rate %f", rate)
+		return
+	}
+	a.targetPlaybackRate = rate
+}
+
+// update moves the playback rate towards the target rate and advances to the next track
+// when the current track is over. The latter has effect only for the non-looped playlists
+// of more tracks. Call it in every frame.
+func (a *Audio) update() {
+	if a.playbackRate != a.targetPlaybackRate {
+		if a.playbackRate < a.targetPlaybackRate {
+			a.playbackRate = min(a.playbackRate+playbackRateStep, a.targetPlaybackRate)
+		} else {
+			a.playbackRate = max(a.playbackRate-playbackRateStep, a.targetPlaybackRate)
+		}
+		a.rateStream.setRate(a.playbackRate)
+	}
+
+	if a.loopedPlay || len(a.tracks) < 2 {
+		return
+	}
+
+	if a.playing && !a.getPlayer().IsPlaying() {
+		log.Printf("Track '%s' is over", a.themeMusicAssetFile)
+		a.NextTrack()
+	}
+}
+
 // getPlayer returns the audio player associated with the Audio instance.
 // It provides access to the underlying *audio.Player.
 func (a *Audio) getPlayer() *audio.Player {
@@ -104,11 +206,42 @@ func (a *Audio) getPlayer() *audio.Player {
 func (a *Audio) Play() {
 	a.getPlayer().Rewind()
 	a.getPlayer().Play()
+	a.playing = true
 }
 
 // Pause pauses the audio playback by calling the Pause method on the underlying player.
 func (a *Audio) Pause() {
 	a.getPlayer().Pause()
+	a.playing = false
+}
+
+// SetVolume sets the volume of the playback. The volume is clamped to [0,1].
+// While muted, the volume is applied only by Unmute.
+func (a *Audio) SetVolume(v float64) {
+	a.volume = clampVolume(v)
+	if !a.muted {
+		a.getPlayer().SetVolume(a.volume)
+	}
+}
+
+// Mute silences the playback. The volume is kept for Unmute.
+func (a *Audio) Mute() {
+	a.muted = true
+	a.getPlayer().SetVolume(0)
+}
+
+// Unmute restores the volume set before muting.
+func (a *Audio) Unmute() {
+	a.muted = false
+	a.getPlayer().SetVolume(a.volume)
+}
+
+func (a *Audio) IsMuted() bool {
+	return a.muted
+}
+
+func clampVolume(v float64) float64 {
+	return min(max(v, 0), 1)
 }
 
 func (a *Audio) SeekPlay(offset time.Duration) {
@@ -116,3 +249,212 @@ func (a *Audio) SeekPlay(offset time.Duration) {
 	a.getPlayer().Seek(offset)
 	a.getPlayer().Play()
 }
+
+// SFXPlayer plays short sound effects once. The decoded sounds are cached and each sound
+// has a pool of players, so a sound can overlap itself without allocating new players.
+type SFXPlayer struct {
+	poolSize int
+	volume   float64 // volume in [0,1], kept while muted
+	muted    bool
+	pools    map[string][]*audio.Player // players by asset file
+	nextIdx  map[string]int             // the player to reuse next if all players of the pool are playing
+}
+
+// NewSFXPlayer creates a new SFXPlayer having poolSize players per sound.
+func NewSFXPlayer(poolSize int) *SFXPlayer {
+	return &SFXPlayer{
+		poolSize: poolSize,
+		volume:   1,
+		pools:    map[string][]*audio.Player{},
+		nextIdx:  map[string]int{},
+	}
+}
+
+// preload decodes the sound files and creates their player pools, so PlaySFX does not
+// have to read files during the game.
+func (s *SFXPlayer) preload(assetFiles ...string) {
+	for _, assetFile := range assetFiles {
+		if _, err := s.getPool(assetFile); err != nil {
+			log.Printf("Failed to preload sound effect: %v", err)
+		}
+	}
+}
+
+func (s *SFXPlayer) getPool(assetFile string) ([]*audio.Player, error) {
+	if pool, ok := s.pools[assetFile]; ok {
+		return pool, nil
+	}
+
+	if globalAudioContext == nil {
+		globalAudioContext = audio.NewContext(44100)
+	}
+
+	data, err := os.ReadFile(assetFile)
+	if err != nil {
+		return nil, err
+	}
+	stream, _, err := decodeAudioStream(bytes.NewReader(data), assetFile)
+	if err != nil {
+		return nil, err
+	}
+	pcm, err := io.ReadAll(stream)
+	if err != nil {
+		return nil, err
+	}
+
+	pool := make([]*audio.Player, s.poolSize)
+	for i := range pool {
+		pool[i] = globalAudioContext.NewPlayerFromBytes(pcm)
+	}
+	s.pools[assetFile] = pool
+	log.Printf("Sound effect '%s' loaded", assetFile)
+
+	return pool, nil
+}
+
+// PlaySFX plays the sound effect once. It uses an idle player of the pool of the sound,
+// or restarts the least recently started one if all of them are playing.
+// The call does not block, the sound is played in the background.
+func (s *SFXPlayer) PlaySFX(assetFile string) {
+	pool, err := s.getPool(assetFile)
+	if err != nil {
+		log.Printf("Failed to play sound effect: %v", err)
+		return
+	}
+
+	idx := s.nextIdx[assetFile]
+	for i, player := range pool {
+		if !player.IsPlaying() {
+			idx = i
+			break
+		}
+	}
+	s.nextIdx[assetFile] = (idx + 1) % len(pool)
+
+	player := pool[idx]
+	player.SetVolume(s.getEffectiveVolume())
+	player.Rewind()
+	player.Play()
+}
+
+// SetVolume sets the volume of the sound effects. The volume is clamped to [0,1].
+func (s *SFXPlayer) SetVolume(v float64) {
+	s.volume = clampVolume(v)
+	s.applyVolume()
+}
+
+// Mute silences the sound effects. The volume is kept for Unmute.
+func (s *SFXPlayer) Mute() {
+	s.muted = true
+	s.applyVolume()
+}
+
+// Unmute restores the volume set before muting.
+func (s *SFXPlayer) Unmute() {
+	s.muted = false
+	s.applyVolume()
+}
+
+func (s *SFXPlayer) IsMuted() bool {
+	return s.muted
+}
+
+func (s *SFXPlayer) getEffectiveVolume() float64 {
+	if s.muted {
+		return 0
+	}
+	return s.volume
+}
+
+// applyVolume applies the volume to the players of the sounds being played.
+func (s *SFXPlayer) applyVolume() {
+	for _, pool := range s.pools {
+		for _, player := range pool {
+			player.SetVolume(s.getEffectiveVolume())
+		}
+	}
+}
+
+// rateReader resamples a 16 bit stereo stream by the playback rate, i.e. it skips or repeats
+// sample frames. The rate can be changed while the stream is played by the player goroutine.
+type rateReader struct {
+	src     io.ReadSeeker
+	rateBits atomic.Uint64 // float64 bits of the rate
+	buf     []byte  // source bytes read ahead
+	bufPos  float64 // position of the next output frame in buf, in frames
+	pos     int64   // position of the output in bytes
+}
+
+const bytesPerFrame = 4 // 16 bits stereo
+
+func newRateReader(src io.ReadSeeker, rate float64) *rateReader {
+	r := &rateReader{src: src}
+	r.setRate(rate)
+	return r
+}
+
+func (r *rateReader) setRate(rate float64) {
+	r.rateBits.Store(math.Float64bits(rate))
+}
+
+func (r *rateReader) getRate() float64 {
+	return math.Float64frombits(r.rateBits.Load())
+}
+
+func (r *rateReader) Read(p []byte) (int, error) {
+	rate := r.getRate()
+	var srcBuf [4096]byte
+
+	n := 0
+	for n+bytesPerFrame <= len(p) {
+		frameCnt := len(r.buf) / bytesPerFrame
+		idx := int(r.bufPos)
+		if frameCnt <= idx {
+			// drop the consumed frames, keep the incomplete one
+			r.buf = append(r.buf[:0], r.buf[frameCnt*bytesPerFrame:]...)
+			r.bufPos -= float64(frameCnt)
+
+			m, err := r.src.Read(srcBuf[:])
+			r.buf = append(r.buf, srcBuf[:m]...)
+			if err != nil && len(r.buf) < bytesPerFrame*(int(r.bufPos)+1) {
+				if n == 0 {
+					return 0, err
+				}
+				return n, nil
+			}
+			continue
+		}
+
+		copy(p[n:n+bytesPerFrame], r.buf[idx*bytesPerFrame:])
+		n += bytesPerFrame
+		r.pos += bytesPerFrame
+		r.bufPos += rate
+	}
+
+	return n, nil
+}
+
+// Seek seeks in the source stream. The offset is scaled by the current rate, so the position
+// of the output matches the position of the source only at rate 1.
+func (r *rateReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += r.pos
+	default:
+		return 0, fmt.Errorf("rateReader does not support seeking from the end")
+	}
+	if offset == r.pos {
+		return r.pos, nil
+	}
+
+	srcOffset := int64(float64(offset/bytesPerFrame)*r.getRate()) * bytesPerFrame
+	if _, err := r.src.Seek(srcOffset, io.SeekStart); err != nil {
+		return 0, err
+	}
+	r.buf = r.buf[:0]
+	r.bufPos = 0
+	r.pos = offset
+
+	return offset, nil
+}
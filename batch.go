@@ -0,0 +1,83 @@
+package main
+
+import (
+	"github.com/hajimehoshi/ebiten/v2"
+)
+
+/*
+the quads of the sprites sharing a texture, drawn by one DrawTriangles call
+*/
+type spriteBatch struct {
+	texture  *ebiten.Image
+	vertices []ebiten.Vertex
+	indices  []uint16
+}
+
+/*
+BatchRenderer accumulates the sprites and draws the ones sharing a texture by a single DrawTriangles call instead of
+a DrawImage call per sprite. The sprites cut from a sprite atlas share the texture of the atlas, see loadSpriteAtlas.
+The sprites are expected not to overlap, as the batches are drawn in the order of the first use of their texture.
+*/
+type BatchRenderer struct {
+	batches []*spriteBatch
+}
+
+var batchMaxSprites = min(ebiten.MaxVertexCount/4, ebiten.MaxIndicesCount/6) // the sprites over it are drawn by another call
+
+func NewBatchRenderer() *BatchRenderer {
+	return &BatchRenderer{}
+}
+
+/*
+add queues the image drawn by the geometry and the color scale of the options.
+*/
+func (b *BatchRenderer) add(img *ebiten.Image, op *ebiten.DrawImageOptions) {
+	texture := img
+	if atlas, ok := spriteAtlases[img]; ok {
+		texture = atlas
+	}
+
+	var batch *spriteBatch
+	for _, existing := range b.batches {
+		if existing.texture == texture && len(existing.vertices)/4 < batchMaxSprites {
+			batch = existing
+			break
+		}
+	}
+	if batch == nil {
+		batch = &spriteBatch{texture: texture}
+		b.batches = append(b.batches, batch)
+	}
+
+	// the corners of the source image, mapped to the screen by the geometry. the sprites of an atlas are offset in it
+	bounds := img.Bounds()
+	base := uint16(len(batch.vertices))
+	for _, corner := range []Pos{{0, 0}, {bounds.Dx(), 0}, {0, bounds.Dy()}, {bounds.Dx(), bounds.Dy()}} {
+		x, y := op.GeoM.Apply(float64(corner.x), float64(corner.y))
+		batch.vertices = append(batch.vertices, ebiten.Vertex{
+			DstX: float32(x),
+			DstY: float32(y),
+			SrcX: float32(bounds.Min.X + corner.x),
+			SrcY: float32(bounds.Min.Y + corner.y),
+			ColorR: op.ColorScale.R(),
+			ColorG: op.ColorScale.G(),
+			ColorB: op.ColorScale.B(),
+			ColorA: op.ColorScale.A(),
+		})
+	}
+	batch.indices = append(batch.indices, base, base+1, base+2, base+1, base+3, base+2)
+}
+
+/*
+flush draws the queued sprites and empties the queue. Returns the nr of the draw calls.
+*/
+func (b *BatchRenderer) flush(dst *ebiten.Image) int {
+	// the color scales of the options are premultiplied
+	op := &ebiten.DrawTrianglesOptions{ColorScaleMode: ebiten.ColorScaleModePremultipliedAlpha}
+	for _, batch := range b.batches {
+		dst.DrawTriangles(batch.vertices, batch.indices, batch.texture, op)
+	}
+	drawCnt := len(b.batches)
+	b.batches = b.batches[:0]
+	return drawCnt
+}
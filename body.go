@@ -1,16 +1,23 @@
 package main
 
 import (
+	"encoding/json"
+	"fmt"
 	"log"
+	"math"
+	"os"
+	"slices"
 )
 
 /*
 represents a piece of the body
 */
 type BodyPiece struct {
-	pos       Pos    // relative position in the body local CS (origin of the CS is not fixed, can be one of the body piece)
-	rotation  int    // rotation 0:right, 90:top, 180:left, 270:bottom
-	pieceType string // type of the piece. must match to one of the globally available piece types
+	pos         Pos     // relative position in the body local CS (origin of the CS is not fixed, can be one of the body piece)
+	rotation    int     // rotation 0:right, 90:top, 180:left, 270:bottom
+	pieceType   string  // type of the piece. must match to one of the globally available piece types
+	scoreWeight float32 // relative value of the piece in the body score. 0 means the default 1.0
+	optional    bool    // the body matches also without this piece. the score is reduced by its weight
 }
 
 /*
@@ -23,9 +30,49 @@ type Body struct {
 	score          int              //
 	bodyPieces     []BodyPiece      // pieces which can be joined
 	pieceTypeToIdx map[string][]int // maps piece type to indices in bodyPieces
+	requiredPieceCnt map[string]int // nr of non-optional body pieces per piece type
+	triggerEffect  func(game *Game, matchedPieces []*Piece) // optional special effect after scoring the body. nil means no effect
+	completionSFX  string           // sound effect asset file played when the body is completed. empty means the default
+	countAllRotations bool          // the body also matches with its pieces rotated by 90, 180 or 270 degrees relative to the body
+	rotationVariants []*Body        // the synthetic bodies created by init for countAllRotations
+	variantOf      *Body            // the original body of a rotation variant. nil for the other bodies
+	hitCount       int              // nr of matchAtLockedPiece calls finding the body. for profiling
+	missCount      int              // nr of matchAtLockedPiece calls not finding the body. for profiling
+}
+
+/*
+a body joined in the grid with the matched pieces
+*/
+type BodyMatch struct {
+	body   *Body
+	pieces []*Piece
+	score  int // reduced by the missing optional pieces
+}
+
+/*
+an entry of the body completion history
+*/
+type BodyCompletion struct {
+	bodyName string // name of the completed body
+	score    int    // score awarded for the body
+	frameCnt int    // frame of the completion
+}
+
+/*
+Returns the opacity of the history entry in [0,1]. The entry fades out linearly during fadeFrameCnt frames.
+*/
+func (c BodyCompletion) getAlpha(frameCnt int, fadeFrameCnt int) float32 {
+	age := frameCnt - c.frameCnt
+	if fadeFrameCnt <= age {
+		return 0
+	}
+	return 1 - float32(age)/float32(fadeFrameCnt)
 }
 
 func (b *Body) init() {
+	b.hitCount = 0
+	b.missCount = 0
+
 	// already initialized?
 	print(b.name)
 	if b.pieceTypeToIdx != nil {
@@ -33,15 +80,204 @@ func (b *Body) init() {
 	}
 
 	b.pieceTypeToIdx = make(map[string][]int)
+	b.requiredPieceCnt = make(map[string]int)
+
+	if b.countAllRotations && b.variantOf == nil {
+		b.initRotationVariants()
+	}
 
 	for idx, bodyPiece := range b.bodyPieces {
 		idxList := b.pieceTypeToIdx[bodyPiece.pieceType]
 		b.pieceTypeToIdx[bodyPiece.pieceType] = append(idxList, idx)
+		if !bodyPiece.optional {
+			b.requiredPieceCnt[bodyPiece.pieceType]++
+		}
+
+		if bodyPiece.scoreWeight == 0 {
+			b.bodyPieces[idx].scoreWeight = 1
+		}
+	}
+
+	if weightSum := b.getScoreWeightSum(); weightSum <= 0 {
+		log.Fatalf("Body '%s' has non-positive sum of score weights %f", b.name, weightSum)
+	}
+
+	if !slices.ContainsFunc(b.bodyPieces, func(bp BodyPiece) bool { return !bp.optional }) {
+		log.Fatalf("Body '%s' has optional pieces only", b.name)
 	}
 
 	log.Printf("Body.init() name:'%s' pieceTypeToIdx:%v", b.name, b.pieceTypeToIdx)
 }
 
+/*
+serialized form of a body piece, used by the body definition files
+*/
+type bodyPieceJSON struct {
+	PieceType   string  `json:"pieceType"`
+	Rotation    int     `json:"rotation"`
+	X           int     `json:"x"`
+	Y           int     `json:"y"`
+	ScoreWeight float32 `json:"scoreWeight,omitempty"`
+	Optional    bool    `json:"optional,omitempty"`
+}
+
+/*
+serialized form of a body, used by the body definition files
+*/
+type bodyJSON struct {
+	Name          string          `json:"name"`
+	Score         int             `json:"score"`
+	BodyPieces    []bodyPieceJSON `json:"bodyPieces"`
+	CompletionSFX string          `json:"completionSFX,omitempty"`
+	CountAllRotations bool        `json:"countAllRotations,omitempty"`
+}
+
+/*
+LoadBodiesFromJSON loads the bodies from a JSON array of {name, score, bodyPieces} objects.
+The trigger effects cannot be described in JSON, so a loaded body takes the effect of the built-in body of the same name.
+The bodies are validated before Body.init, which would stop the program on an invalid body.
+*/
+func LoadBodiesFromJSON(path string) ([]*Body, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load bodies: %w", err)
+	}
+
+	var desc []bodyJSON
+	if err := json.Unmarshal(data, &desc); err != nil {
+		return nil, fmt.Errorf("failed to parse bodies '%s': %w", path, err)
+	}
+	// the game and the tutorial need at least one body, the built-in ones are used instead of none
+	if len(desc) == 0 {
+		return nil, fmt.Errorf("no bodies in '%s'", path)
+	}
+
+	bodies := make([]*Body, 0, len(desc))
+	for i, d := range desc {
+		if d.Name == "" || len(d.BodyPieces) == 0 {
+			return nil, fmt.Errorf("body #%d in '%s' has no name or no pieces", i, path)
+		}
+
+		body := &Body{name: d.Name, score: d.Score, completionSFX: d.CompletionSFX, countAllRotations: d.CountAllRotations}
+		for _, bp := range d.BodyPieces {
+			if getPieceByType(bp.PieceType) == nil {
+				return nil, fmt.Errorf("body '%s' has unknown piece type '%s'", d.Name, bp.PieceType)
+			}
+			if bp.ScoreWeight < 0 {
+				return nil, fmt.Errorf("body '%s' has negative score weight %f", d.Name, bp.ScoreWeight)
+			}
+			body.bodyPieces = append(body.bodyPieces, BodyPiece{pos: Pos{bp.X, bp.Y}, rotation: bp.Rotation, pieceType: bp.PieceType, scoreWeight: bp.ScoreWeight, optional: bp.Optional})
+		}
+		if !slices.ContainsFunc(body.bodyPieces, func(bp BodyPiece) bool { return !bp.optional }) {
+			return nil, fmt.Errorf("body '%s' has optional pieces only", d.Name)
+		}
+
+		if idx := slices.IndexFunc(builtinBodies, func(b *Body) bool { return b.name == d.Name }); 0 <= idx {
+			body.triggerEffect = builtinBodies[idx].triggerEffect
+		}
+
+		body.init()
+		bodies = append(bodies, body)
+	}
+
+	log.Printf("Loaded %d bodies from '%s'", len(bodies), path)
+	return bodies, nil
+}
+
+/*
+SaveBodiesToJSON saves the bodies in the format read by LoadBodiesFromJSON. The trigger effects are not saved.
+*/
+func SaveBodiesToJSON(bodies []*Body, path string) error {
+	desc := make([]bodyJSON, 0, len(bodies))
+	for _, body := range bodies {
+		d := bodyJSON{Name: body.name, Score: body.score, CompletionSFX: body.completionSFX, CountAllRotations: body.countAllRotations}
+		for _, bp := range body.bodyPieces {
+			d.BodyPieces = append(d.BodyPieces, bodyPieceJSON{PieceType: bp.pieceType, Rotation: bp.rotation, X: bp.pos.x, Y: bp.pos.y, ScoreWeight: bp.scoreWeight, Optional: bp.optional})
+		}
+		desc = append(desc, d)
+	}
+
+	data, err := json.MarshalIndent(desc, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal bodies: %w", err)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("failed to save bodies '%s': %w", path, err)
+	}
+	return nil
+}
+
+/*
+initRotationVariants creates the bodies having the positions of the body pieces rotated by 90, 180 and 270 degrees
+while the pieces keep their rotation. The matching already accepts the whole body rotated, so together with the
+variants the body matches regardless of the rotation of its pieces. The variants have the name and score of the body.
+*/
+func (b *Body) initRotationVariants() {
+	b.rotationVariants = nil
+	for _, angle := range []int{90, 180, 270} {
+		variant := &Body{
+			name:          b.name,
+			score:         b.score,
+			triggerEffect: b.triggerEffect,
+			completionSFX: b.completionSFX,
+			variantOf:     b,
+		}
+		for _, bp := range b.bodyPieces {
+			bp.pos = rotatePos(bp.pos, angle)
+			variant.bodyPieces = append(variant.bodyPieces, bp)
+		}
+		variant.init()
+		b.rotationVariants = append(b.rotationVariants, variant)
+	}
+}
+
+/*
+Returns the bodies followed by the rotation variants of the bodies.
+*/
+func appendRotationVariants(bodies []*Body) []*Body {
+	result := slices.Clone(bodies)
+	for _, body := range bodies {
+		result = append(result, body.rotationVariants...)
+	}
+	return result
+}
+
+/*
+Returns allBodies without the rotation variants. These are the bodies shown to the player.
+*/
+func getBaseBodies() []*Body {
+	return slices.DeleteFunc(slices.Clone(allBodies), func(b *Body) bool { return b.variantOf != nil })
+}
+
+func (b *Body) getScoreWeightSum() float32 {
+	var weightSum float32
+	for _, bp := range b.bodyPieces {
+		weightSum += bp.scoreWeight
+	}
+	return weightSum
+}
+
+/*
+Returns the score awarded for the body. The score is scaled by the average score weight of the body pieces.
+*/
+func (b *Body) getScore() int {
+	return b.getPartialScore(0)
+}
+
+/*
+Returns the score awarded for the body when body pieces with the given total score weight are missing.
+*/
+func (b *Body) getPartialScore(missingWeight float32) int {
+	return int(math.Round(float64(b.score) * float64(b.getScoreWeightSum()-missingWeight) / float64(len(b.bodyPieces))))
+}
+
+/*
+Returns the nr of matchAtLockedPiece calls finding and not finding the body since init.
+*/
+func (b *Body) matchStats() (hits, misses int) {
+	return b.hitCount, b.missCount
+}
+
 /*
 Returns the bounding box of a body in bodyPiece CS.
 */
@@ -73,69 +309,151 @@ func (b *Body) getBoundingBox() (Pos, Size) {
 /*
 checks if the Body is located in the game's grid at the location of a locked piece
 */
-func (body *Body) matchAtLockedPiece(grid *GridComp, lockedPiece *Piece) []*Piece {
+func (body *Body) matchAtLockedPiece(grid *GridComp, lockedPiece *Piece) *BodyMatch {
 	log.Printf(" Body[%s].matchAtLockedPiece(lockedPiece:%s,pos:%v,rot:%d)", body.name, lockedPiece.pieceType, lockedPiece.pos, lockedPiece.currentRotation)
+	// a wildcard does not start a match. the body is found from its other pieces
+	if lockedPiece.isWildcard() {
+		body.missCount++
+		return nil
+	}
+
 	// check if the body contains at least one body piece having the same type as the locked piece?
 	idxList, ok := body.pieceTypeToIdx[lockedPiece.pieceType]
 	if !ok {
+		body.missCount++
 		return nil
 	}
 
+	// are there enough locked pieces of each type for the required body pieces? the wildcards can stand for any type
+	wildcardCnt := grid.countPiecesByType("Wildcard")
+	for pieceType, requiredCnt := range body.requiredPieceCnt {
+		if grid.countPiecesByType(pieceType)+wildcardCnt < requiredCnt {
+			log.Printf("  Not enough '%s' pieces in the grid", pieceType)
+			body.missCount++
+			return nil
+		}
+	}
+
+	// the locked pieces of the rarest required type anchor the body. the wildcards can stand for them too
+	anchorType := body.getAnchorType(grid)
+	anchors := append(grid.piecesByType(anchorType), grid.piecesByType("Wildcard")...)
+
 	// enumerate the body pieces of the body having the required type. try to match the body to the grid at that body piece
 	for _, idx := range idxList {
 		bodyPiece := &body.bodyPieces[idx]
-		pieceList := body.matchBodyPieceAtLockedPiece(grid, bodyPiece, lockedPiece)
-		if 0 < len(pieceList) {
-			return pieceList
+		if !body.isAnchoredAt(bodyPiece, lockedPiece, anchorType, anchors) {
+			continue
+		}
+		match := body.matchBodyPieceAtLockedPiece(grid, bodyPiece, lockedPiece)
+		if match != nil {
+			body.hitCount++
+			return match
 		}
 	}
+	body.missCount++
 	return nil
 }
 
+/*
+Returns the required piece type of the body having the fewest locked pieces in the grid.
+The ties are broken by the order of the body pieces.
+*/
+func (body *Body) getAnchorType(grid *GridComp) string {
+	anchorType, anchorCnt := "", 0
+	for _, bp := range body.bodyPieces {
+		if bp.optional || bp.pieceType == anchorType {
+			continue
+		}
+		if cnt := grid.countPiecesByType(bp.pieceType); anchorType == "" || cnt < anchorCnt {
+			anchorType, anchorCnt = bp.pieceType, cnt
+		}
+	}
+	return anchorType
+}
+
+/*
+checks if the body pieces of the anchor type are on the anchors, when the locked piece is located at the body piece.
+The body is not matched at the other positions, so the grid is checked around the anchors only.
+*/
+func (body *Body) isAnchoredAt(bodyPiece *BodyPiece, lockedPiece *Piece, anchorType string, anchors []*Piece) bool {
+	bodyCsRotation := bodyPiece.rotation - lockedPiece.currentRotation
+	for _, bp := range body.bodyPieces {
+		if bp.optional || bp.pieceType != anchorType {
+			continue
+		}
+		posGridCs := addPos(lockedPiece.pos, rotatePos(subPos(bp.pos, bodyPiece.pos), bodyCsRotation))
+		if !slices.ContainsFunc(anchors, func(piece *Piece) bool { return piece.pos == posGridCs }) {
+			log.Printf("  Body[%s] is not anchored at '%s'@%v", body.name, anchorType, posGridCs)
+			return false
+		}
+	}
+	return true
+}
+
 /*
 checks if the Body is located in the game's grid at the location of a locked piece.
 the check assumes that the locked piece is located at a specific body piece.
+the optional body pieces not found in the grid are skipped.
 */
-func (body *Body) matchBodyPieceAtLockedPiece(grid *GridComp, bodyPiece *BodyPiece, lockedPiece *Piece) []*Piece {
+func (body *Body) matchBodyPieceAtLockedPiece(grid *GridComp, bodyPiece *BodyPiece, lockedPiece *Piece) *BodyMatch {
 	bodyCsOrigin := bodyPiece.pos // fix the origin of the body CS
 	bodyCsRotation := bodyPiece.rotation - lockedPiece.currentRotation
 
 	log.Printf("  Body[%s].matchBodyPieceAtLockedPiece(bodyPiece:%v) - bodyCs:%v,%ddeg", body.name, bodyPiece, bodyCsOrigin, bodyCsRotation)
 
 	var matchedPieceList []*Piece
+	var missingWeight float32
 	for _, bp := range body.bodyPieces {
 		relPosBodyCs := subPos(bp.pos, bodyCsOrigin)
 		relPosGridCs := rotatePos(relPosBodyCs, bodyCsRotation)
 		posGridCs := addPos(lockedPiece.pos, relPosGridCs)
 
-		if !isOverlap(posGridCs, Size{1, 1}, Pos{0, 0}, gridSize) {
-			log.Printf("   Checking '%s'@%v... Outside the grid.", bp.pieceType, posGridCs)
-			return nil
+		piece := body.matchPieceAt(grid, &bp, posGridCs, bodyCsRotation)
+		if piece == nil && bp.optional {
+			log.Printf("  Optional '%s'@%v is skipped", bp.pieceType, posGridCs)
+			missingWeight += bp.scoreWeight
+			continue
 		}
-
-		piece := grid.getPiece(posGridCs)
 		if piece == nil {
-			log.Printf("  Checking '%s'@%v... Empty grid location.", bp.pieceType, posGridCs)
-			return nil
-		}
-		if piece.pieceType != bp.pieceType {
-			log.Printf("  Checking '%s'@%v... Type %s does not match.", bp.pieceType, posGridCs, piece.pieceType)
-			return nil
-		}
-
-		if piece.pos != posGridCs {
-			log.Printf("  Checking '%s'@%v... Position %v is slightly off", bp.pieceType, posGridCs, piece.pos)
-			return nil
-		}
-		if !angleDegEq(piece.currentRotation, bp.rotation-bodyCsRotation) {
-			log.Printf("  Checking '%s'@%v... Piece orientation %d mismatches to expected %d.", bp.pieceType, posGridCs, piece.currentRotation, bp.rotation+bodyCsRotation)
 			return nil
 		}
 
-		log.Printf("  Checking '%s'@%v... Body piece matches", bp.pieceType, posGridCs)
 		matchedPieceList = append(matchedPieceList, piece)
 	}
 
 	log.Printf("   Body matched. Returning piece list %v", matchedPieceList)
-	return matchedPieceList
+	return &BodyMatch{body: body, pieces: matchedPieceList, score: body.getPartialScore(missingWeight)}
+}
+
+/*
+Returns the locked piece matching the body piece at the grid position, nil if there is none.
+A wildcard matches any piece type.
+*/
+func (body *Body) matchPieceAt(grid *GridComp, bp *BodyPiece, posGridCs Pos, bodyCsRotation int) *Piece {
+	if !isOverlap(posGridCs, Size{1, 1}, Pos{0, 0}, gridSize) {
+		log.Printf("   Checking '%s'@%v... Outside the grid.", bp.pieceType, posGridCs)
+		return nil
+	}
+
+	piece := grid.getPiece(posGridCs)
+	if piece == nil {
+		log.Printf("  Checking '%s'@%v... Empty grid location.", bp.pieceType, posGridCs)
+		return nil
+	}
+	if piece.pieceType != bp.pieceType && !piece.isWildcard() {
+		log.Printf("  Checking '%s'@%v... Type %s does not match.", bp.pieceType, posGridCs, piece.pieceType)
+		return nil
+	}
+
+	if piece.pos != posGridCs {
+		log.Printf("  Checking '%s'@%v... Position %v is slightly off", bp.pieceType, posGridCs, piece.pos)
+		return nil
+	}
+	if !angleDegEq(piece.currentRotation, bp.rotation-bodyCsRotation) {
+		log.Printf("  Checking '%s'@%v... Piece orientation %d mismatches to expected %d.", bp.pieceType, posGridCs, piece.currentRotation, bp.rotation+bodyCsRotation)
+		return nil
+	}
+
+	log.Printf("  Checking '%s'@%v... Body piece matches", bp.pieceType, posGridCs)
+	return piece
 }
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+/*
+a built-in challenge. The pieces of the grid are locked before the first spawn and the player has to clear them
+by forming bodies.
+*/
+type Challenge struct {
+	Name string   `json:"name"`
+	Grid []string `json:"grid"` // rows of piece descriptions, see parseGridDesc
+}
+
+/*
+the piece types of the grid description codes
+*/
+var gridDescPieceTypes = map[byte]string{
+	'H': "Head",
+	'T': "Torso",
+	'L': "Leg",
+	'B': "Bomb",
+	'R': "RightBrkTorso",
+	'F': "LeftBrkTorso",
+	'W': "Wildcard",
+	'I': "Bar",
+}
+
+/*
+the rotations of the grid description codes
+*/
+var gridDescRotations = map[byte]int{'^': 0, '<': 90, 'v': 180, '>': 270}
+
+/*
+LoadChallengesFromJSON loads the challenges from a JSON file. The grid of each challenge is validated
+on the default grid size.
+*/
+func LoadChallengesFromJSON(path string) ([]Challenge, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load challenges: %w", err)
+	}
+
+	var challenges []Challenge
+	if err := json.Unmarshal(data, &challenges); err != nil {
+		return nil, fmt.Errorf("failed to parse challenges '%s': %w", path, err)
+	}
+
+	for i, c := range challenges {
+		if c.Name == "" || len(c.Grid) == 0 {
+			return nil, fmt.Errorf("challenge #%d in '%s' has no name or no grid", i, path)
+		}
+		if _, err := parseGridDesc(c.Grid, gridSize); err != nil {
+			return nil, fmt.Errorf("challenge '%s': %w", c.Name, err)
+		}
+	}
+	return challenges, nil
+}
+
+/*
+parseGridDesc creates the pieces of a grid description. Each row lists the pieces separated by spaces
+from the left side of the grid. A piece is described by its rotation (^ < v >) and its type code
+(see gridDescPieceTypes), "_" leaves the place empty. The last row is placed to the bottom of the grid.
+A piece wider than a cell, e.g. the horizontal bar, takes the places of its width.
+*/
+func parseGridDesc(gridRows []string, size Size) ([]*Piece, error) {
+	s := getPieceByType("Head").size.w // all pieces have the same w+h size
+	top := size.h - 1 - len(gridRows)*s
+	if top < 0 {
+		return nil, fmt.Errorf("%d rows do not fit to the grid", len(gridRows))
+	}
+
+	var pieces []*Piece
+	for rowIdx, rowDesc := range gridRows {
+		piecePos := Pos{1, top + rowIdx*s}
+		for _, pieceDesc := range strings.Fields(rowDesc) {
+			if pieceDesc != "_" {
+				pieceType, typeOk := gridDescPieceTypes[pieceDesc[len(pieceDesc)-1]]
+				rotation, rotationOk := gridDescRotations[pieceDesc[0]]
+				if len(pieceDesc) != 2 || !typeOk || !rotationOk {
+					return nil, fmt.Errorf("invalid piece '%s' in row %d", pieceDesc, rowIdx)
+				}
+				piece := getPieceByType(pieceType).clone()
+				piece.currentRotation = rotation
+				piece.pos = piecePos
+				rotatedSize := rotateSize(piece.size, rotation)
+				if size.w-1 < piecePos.x+rotatedSize.w || size.h-1 < piecePos.y+rotatedSize.h {
+					return nil, fmt.Errorf("row %d does not fit to the grid", rowIdx)
+				}
+				pieces = append(pieces, piece)
+				piecePos.x += rotatedSize.w
+			} else {
+				piecePos.x += s
+			}
+		}
+	}
+	return pieces, nil
+}
+
+/*
+Returns the seed of the daily challenge of the date: year*10000 + month*100 + day.
+*/
+func getDailySeed(date time.Time) int64 {
+	return int64(date.Year()*10000 + int(date.Month())*100 + date.Day())
+}
+
+/*
+Returns the key of the daily challenge scores in the high score file. Empty if not a daily challenge.
+*/
+func (g *Game) getDailyKey() string {
+	if g.dailyDate.IsZero() {
+		return ""
+	}
+	return g.dailyDate.Format(time.DateOnly)
+}
+
+/*
+startDailyChallenge starts a new game in daily challenge mode. The other modes are ended.
+*/
+func (g *Game) startDailyChallenge() {
+	g.clearGameMode()
+	g.dailyDate = time.Now()
+	g.Reset()
+}
+
+/*
+getChallengeScore returns the score of the completed challenge. The base score is reduced by
+the time taken and the pieces used.
+*/
+func (g *Game) getChallengeScore() int {
+	penalty := int(g.gameTimeSec)*challengeSecPenalty + g.placedPieceCnt*challengePiecePenalty
+	return max(challengeBaseScore-penalty, 0)
+}
+
+/*
+lockChallengePieces locks the pieces of the challenge grid to the empty grid.
+*/
+func (g *Game) lockChallengePieces() {
+	pieces, err := parseGridDesc(g.challengeDesc, gridSize)
+	if err != nil {
+		log.Printf("%v", err)
+		return
+	}
+	g.grid.lockPieceBatch(pieces)
+}
+
+/*
+completeChallenge ends the challenge successfully when all the pre-placed pieces are cleared.
+The challenge scores are not saved.
+*/
+func (g *Game) completeChallenge() {
+	g.apc.activate(false)
+	MUSIC_PLAYER.Pause()
+	g.updateLifetimeStats()
+
+	score := g.getChallengeScore()
+	log.Printf("Challenge completed in %f sec with %d pieces, score %d", g.gameTimeSec, g.placedPieceCnt, score)
+	g.gameOver.setStats(nil)
+	g.gameOver.text = []string{T("dialog.challengeComplete"), fmt.Sprintf(T("dialog.score"), score)}
+	g.gameOver.activate(true)
+}
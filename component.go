@@ -2,13 +2,17 @@ package main
 
 import (
 	"fmt"
+	"image/color"
 	"log"
 	"math"
 	"reflect"
 	"slices"
 	"sort"
+	"time"
+	"unicode"
 
 	"github.com/hajimehoshi/ebiten/v2"
+	"github.com/hajimehoshi/ebiten/v2/ebitenutil"
 	"github.com/hajimehoshi/ebiten/v2/text/v2"
 	"github.com/hajimehoshi/ebiten/v2/vector"
 )
@@ -27,6 +31,7 @@ type Component interface {
 	update(gamePaused bool, frameCnt int) // frameCnt counts also in paused state
 	draw(screen *ebiten.Image)
 	getDrawOrder() int
+	getPriority() int // components with higher priority are updated first. the default is 0
 	getState() ComponentState
 }
 
@@ -39,17 +44,25 @@ type ComponentMgr struct {
 	compList       Components
 	order2CompList map[int]Components
 	sortedOrders   []int
+	pausedComps    map[Component]bool // updated as paused regardless of the blocking components
 }
 
 func NewComponentMgr() *ComponentMgr {
 	return &ComponentMgr {
 		order2CompList: map[int]Components{},
+		pausedComps: map[Component]bool{},
 	}
 }
 
 func (mgr *ComponentMgr) add(comp Component) {
 	log.Printf("ComponentMgr.add() comp type is %s\n", reflect.TypeOf(comp))
-	mgr.compList = append(mgr.compList, comp)
+
+	// insert after the components having the same or higher priority
+	idx := slices.IndexFunc(mgr.compList, func(c Component) bool { return c.getPriority() < comp.getPriority() })
+	if idx < 0 {
+		idx = len(mgr.compList)
+	}
+	mgr.compList = slices.Insert(mgr.compList, idx, comp)
 
 	// add to order hash
 	drawOrder := comp.getDrawOrder()
@@ -66,10 +79,9 @@ func (mgr *ComponentMgr) add(comp Component) {
 func (mgr *ComponentMgr) remove(comp Component) {
 	drawOrder := comp.getDrawOrder()
 	
-	// remove from comps
+	// remove from comps, keeping the priority order
 	idx := slices.Index(mgr.compList, comp)
-	mgr.compList[idx] = mgr.compList[len(mgr.compList)-1]
-	mgr.compList = mgr.compList[:len(mgr.compList)-1]
+	mgr.compList = slices.Delete(mgr.compList, idx, idx+1)
 
 	// remove from order hash
 	slice := mgr.order2CompList[drawOrder]
@@ -89,6 +101,19 @@ func (mgr *ComponentMgr) reset() {
 	for _, c := range mgr.compList {
 		c.reset()
 	}
+	clear(mgr.pausedComps)
+}
+
+/*
+pauseComponent pauses the update of the component until resumeComponent is called.
+The other components are not affected.
+*/
+func (mgr *ComponentMgr) pauseComponent(comp Component) {
+	mgr.pausedComps[comp] = true
+}
+
+func (mgr *ComponentMgr) resumeComponent(comp Component) {
+	delete(mgr.pausedComps, comp)
 }
 
 func (mgr *ComponentMgr) isBlocked() bool {
@@ -108,7 +133,7 @@ func (mgr *ComponentMgr) update(frameCnt int) {
 
 	for _, c := range mgr.compList {
 		if c.getState() != StateInactive {
-			c.update(gameBlocked, frameCnt)
+			c.update(gameBlocked || mgr.pausedComps[c], frameCnt)
 
 			// if a component is just blocking ensure is has effect on updating the follower components
 			gameBlocked = gameBlocked || c.getState() == StateBlocking
@@ -129,6 +154,13 @@ func (mgr *ComponentMgr) draw(screen *ebiten.Image) {
 	}
 }
 
+/*
+uiPx scales the size or the position in pixels by uiScale.
+*/
+func uiPx(v int) int {
+	return int(math.Round(float64(v) * uiScale))
+}
+
 func renderText(screen *ebiten.Image, s string, x int, y int, textFace *text.GoTextFace) {
 	op := &text.DrawOptions{}
 	op.GeoM.Translate(float64(x), float64(y))
@@ -136,6 +168,22 @@ func renderText(screen *ebiten.Image, s string, x int, y int, textFace *text.GoT
 	text.Draw(screen, s, textFace, op)
 }
 
+func renderTextFaded(screen *ebiten.Image, s string, x int, y int, textFace *text.GoTextFace, alpha float32) {
+	op := &text.DrawOptions{}
+	op.GeoM.Translate(float64(x), float64(y))
+	op.LineSpacing = textFace.Size * 1.5
+	op.ColorScale.ScaleAlpha(alpha)
+	text.Draw(screen, s, textFace, op)
+}
+
+func renderTextColored(screen *ebiten.Image, s string, x int, y int, textFace *text.GoTextFace, c color.RGBA) {
+	op := &text.DrawOptions{}
+	op.GeoM.Translate(float64(x), float64(y))
+	op.LineSpacing = textFace.Size * 1.5
+	op.ColorScale.ScaleWithColor(c)
+	text.Draw(screen, s, textFace, op)
+}
+
 func renderTextCentered(screen *ebiten.Image, s string, x int, y int, textFace *text.GoTextFace) {
 	op := &text.DrawOptions{}
 	op.GeoM.Translate(float64(x), float64(y))
@@ -147,6 +195,8 @@ func renderTextCentered(screen *ebiten.Image, s string, x int, y int, textFace *
 //
 // ------------ dialog ------------
 //
+const dialogBorder = 15
+
 type DialogComp struct {
 	state ComponentState
 	isBlocking bool
@@ -155,6 +205,9 @@ type DialogComp struct {
 	drawOrder int
 	timeoutFrameCnt int
 	countdownFrameCnt int
+	background *ebiten.Image // scaled to the dialog bounds. solid fillColor if nil
+	fillColor color.Color // sidebarColor if nil, following the theme
+	stats *GameStats // table shown below the text. nil if there is no table
 }
 
 func NewModalDialog(text []string, screenPos Pos, drawOrder int) *DialogComp {
@@ -176,6 +229,29 @@ func NewDialog(text []string, screenPos Pos, timeoutFrameCnt int, drawOrder int)
 	}
 }
 
+/*
+WithBackground sets the background image of the dialog. Returns the dialog for chaining.
+*/
+func (d *DialogComp) WithBackground(img *ebiten.Image) *DialogComp {
+	d.background = img
+	return d
+}
+
+/*
+WithFillColor sets the color filling the dialog without a background image. Returns the dialog for chaining.
+*/
+func (d *DialogComp) WithFillColor(c color.Color) *DialogComp {
+	d.fillColor = c
+	return d
+}
+
+/*
+setStats sets the statistics table shown below the text. The dialog is widened to statsTableWidth for the table.
+*/
+func (d *DialogComp) setStats(stats *GameStats) {
+	d.stats = stats
+}
+
 func (d *DialogComp) activate(isActive bool) {
 	d.countdownFrameCnt = d.timeoutFrameCnt
 
@@ -205,29 +281,79 @@ func (d *DialogComp) update(paused bool, frameCnt int) {
 	}
 }
 
+/*
+getRect returns the bounds of the dialog on the screen, fitting the text and the statistics table.
+*/
+func (d *DialogComp) getRect() Rect {
+	lineHeight := normTextFace.Size*1.5
+	textWidth := float64(0)
+	textHeight := int(lineHeight)*len(d.text)
+	for _, t := range d.text {
+		w, _ := text.Measure(t, normTextFace, lineHeight)
+		textWidth = math.Max(textWidth, w)
+	}
+
+	if d.stats != nil {
+		statsLineHeight := int(smallTextFace.Size*1.5)
+		textWidth = math.Max(textWidth, statsTableWidth*uiScale)
+		textHeight += statsLineHeight/2 + statsLineHeight*len(d.stats.getRows())
+	}
+
+	return Rect{
+		Pos{d.screenPos.x - int(textWidth/2) - dialogBorder, d.screenPos.y - textHeight/2 - dialogBorder},
+		Size{int(textWidth)+2*dialogBorder, textHeight+2*dialogBorder},
+	}
+}
+
+/*
+contains checks if the screen position is within the dialog.
+*/
+func (d *DialogComp) contains(pos Pos) bool {
+	r := d.getRect()
+	return isOverlap(pos, Size{1, 1}, r.pos, r.size)
+}
+
 func (d *DialogComp) draw(screen *ebiten.Image) {
 	if d.state != StateInactive {
 		lineHeight := normTextFace.Size*1.5
-		textWidth := float64(0)
-		textHeight := int(lineHeight)*len(d.text)
-		for _, t := range d.text {
-			w, _ := text.Measure(t, normTextFace, lineHeight)
-			textWidth = math.Max(textWidth, w)
+		var statsRows [][2]string
+		statsLineHeight := int(smallTextFace.Size*1.5)
+		if d.stats != nil {
+			statsRows = d.stats.getRows()
 		}
 
-		dialogBorder := 15
-		rectX := d.screenPos.x - int(textWidth/2) - dialogBorder
-		rectY := d.screenPos.y - textHeight/2 - dialogBorder
-		rectW := int(textWidth)+2*dialogBorder
-		rectH := textHeight+2*dialogBorder
-
-		vector.DrawFilledRect(screen, float32(rectX), float32(rectY), float32(rectW), float32(rectH), sidebarColor, false)
+		r := d.getRect()
+		rectX, rectY, rectW, rectH := r.pos.x, r.pos.y, r.size.w, r.size.h
+
+		if d.background != nil {
+			bounds := d.background.Bounds()
+			op := &ebiten.DrawImageOptions{}
+			op.GeoM.Scale(float64(rectW)/float64(bounds.Dx()), float64(rectH)/float64(bounds.Dy()))
+			op.GeoM.Translate(float64(rectX), float64(rectY))
+			op.Filter = ebiten.FilterLinear
+			screen.DrawImage(d.background, op)
+		} else {
+			var fillColor color.Color = sidebarColor
+			if d.fillColor != nil {
+				fillColor = d.fillColor
+			}
+			vector.DrawFilledRect(screen, float32(rectX), float32(rectY), float32(rectW), float32(rectH), fillColor, false)
+		}
 
 		ypos := float64(rectY + dialogBorder)
 		for _, t := range d.text {
 			renderTextCentered(screen, t, d.screenPos.x, int(ypos), normTextFace)
 			ypos += lineHeight
 		}
+
+		// the labels are aligned left, the values right
+		ypos += float64(statsLineHeight/2)
+		for _, row := range statsRows {
+			renderText(screen, row[0], rectX+dialogBorder, int(ypos), smallTextFace)
+			w, _ := text.Measure(row[1], smallTextFace, float64(statsLineHeight))
+			renderText(screen, row[1], rectX+rectW-dialogBorder-int(w), int(ypos), smallTextFace)
+			ypos += float64(statsLineHeight)
+		}
 	}
 }
 
@@ -235,10 +361,296 @@ func (d *DialogComp) getDrawOrder() int {
   return d.drawOrder
 }
 
+func (d *DialogComp) getPriority() int {
+  return PriorityDefault
+}
+
 func (d *DialogComp) getState() ComponentState {
 	return d.state
 }
 
+//
+// ------------ menu dialog ------------
+//
+type MenuItem struct {
+	label  string // key of the label, see T. the labels without a key, e.g. the profile names, are shown as is
+	action func()
+}
+
+/*
+a modal dialog listing the menu items. the item is selected by the up and down arrows,
+and its action is fired by Enter or clicking it.
+*/
+type MenuDialogComp struct {
+	state ComponentState
+	input *UserInput
+	items []MenuItem
+	selectedIdx int
+	screenPos Pos // center of the menu
+	drawOrder int
+	upState ControlState
+	downState ControlState
+	enterState ControlState
+}
+
+func NewMenuDialog(input *UserInput, items []MenuItem, screenPos Pos, drawOrder int) *MenuDialogComp {
+	return &MenuDialogComp {
+		input: input,
+		items: items,
+		screenPos: screenPos,
+		drawOrder: drawOrder,
+	}
+}
+
+func (m *MenuDialogComp) activate(isActive bool) {
+	if isActive {
+		m.state = StateBlocking
+		m.selectedIdx = 0
+		// the keys held at the opening are not pressed
+		m.upState = ControlState{down: true}
+		m.downState = ControlState{down: true}
+		m.enterState = ControlState{down: true}
+	} else {
+		m.state = StateInactive
+	}
+}
+
+func (m *MenuDialogComp) reset() {
+	m.state = StateInactive
+}
+
+/*
+update handles the selection. The menu blocks the game, so the paused flag is ignored.
+*/
+func (m *MenuDialogComp) update(paused bool, frameCnt int) {
+	if m.state == StateInactive {
+		return
+	}
+
+	m.input.handleKeyPress(KeyList{ebiten.KeyArrowUp}, false, &m.upState)
+	m.input.handleKeyPress(KeyList{ebiten.KeyArrowDown}, false, &m.downState)
+	m.input.handleKeyPress(KeyList{ebiten.KeyEnter, ebiten.KeyNumpadEnter}, false, &m.enterState)
+
+	if m.upState.press {
+		m.selectedIdx = (m.selectedIdx + len(m.items) - 1) % len(m.items)
+	}
+	if m.downState.press {
+		m.selectedIdx = (m.selectedIdx + 1) % len(m.items)
+	}
+
+	if m.input.isMouseLeftClick() {
+		x, y := m.input.cursorPosition()
+		if idx := m.getItemAt(Pos{x, y}); 0 <= idx {
+			m.selectedIdx = idx
+			m.fire(idx)
+			return
+		}
+	}
+
+	if m.enterState.press {
+		m.fire(m.selectedIdx)
+	}
+}
+
+/*
+fire calls the action of the item.
+*/
+func (m *MenuDialogComp) fire(idx int) {
+	log.Printf("Menu item '%s' fired", m.items[idx].label)
+	if m.items[idx].action != nil {
+		m.items[idx].action()
+	}
+}
+
+/*
+getItemRect returns the bounds of the item on the screen.
+*/
+func (m *MenuDialogComp) getItemRect(idx int) Rect {
+	itemHeight := int(normTextFace.Size * 1.5)
+	width := uiPx(menuMinWidth)
+	for _, item := range m.items {
+		w, _ := text.Measure(T(item.label), normTextFace, float64(itemHeight))
+		width = max(width, int(w)+2*dialogBorder)
+	}
+
+	top := m.screenPos.y - itemHeight*len(m.items)/2
+	return Rect{Pos{m.screenPos.x - width/2, top + idx*itemHeight}, Size{width, itemHeight}}
+}
+
+/*
+getItemAt returns the index of the item at the screen position, -1 if there is no item.
+*/
+func (m *MenuDialogComp) getItemAt(pos Pos) int {
+	for idx := range m.items {
+		r := m.getItemRect(idx)
+		if isOverlap(pos, Size{1, 1}, r.pos, r.size) {
+			return idx
+		}
+	}
+	return -1
+}
+
+func (m *MenuDialogComp) draw(screen *ebiten.Image) {
+	if m.state == StateInactive {
+		return
+	}
+
+	first, last := m.getItemRect(0), m.getItemRect(len(m.items)-1)
+	vector.DrawFilledRect(screen, float32(first.pos.x), float32(first.pos.y-dialogBorder), float32(first.size.w), float32(last.pos.y+last.size.h-first.pos.y+2*dialogBorder), sidebarColor, false)
+
+	for idx, item := range m.items {
+		r := m.getItemRect(idx)
+		if idx == m.selectedIdx {
+			vector.DrawFilledRect(screen, float32(r.pos.x), float32(r.pos.y), float32(r.size.w), float32(r.size.h), menuSelectedColor, false)
+		}
+		renderTextCentered(screen, T(item.label), m.screenPos.x, r.pos.y+(r.size.h-int(normTextFace.Size))/2, normTextFace)
+	}
+}
+
+func (m *MenuDialogComp) getDrawOrder() int {
+  return m.drawOrder
+}
+
+func (m *MenuDialogComp) getPriority() int {
+  return PriorityDefault
+}
+
+func (m *MenuDialogComp) getState() ComponentState {
+	return m.state
+}
+
+//
+// ------------ name entry ------------
+//
+/*
+a modal dialog capturing the name of the player. the typed name is confirmed by Enter.
+*/
+type NameEntryComp struct {
+	state ComponentState
+	input *UserInput
+	dialog *DialogComp // draws the prompt and the name
+	name []rune
+	maxLen int
+	enterState ControlState
+	backspaceState ControlState
+	prompt []string // the lines above the typed name
+	confirmAction func(name string)
+}
+
+func NewNameEntry(input *UserInput, maxLen int, screenPos Pos, drawOrder int) *NameEntryComp {
+	return &NameEntryComp {
+		input: input,
+		dialog: NewModalDialog([]string{}, screenPos, drawOrder),
+		maxLen: maxLen,
+	}
+}
+
+/*
+start clears the name and shows the dialog with the new high score prompt. confirmAction is called with the name
+when it is confirmed.
+*/
+func (n *NameEntryComp) start(confirmAction func(name string)) {
+	n.startPrompt([]string{T("dialog.newHighScore"), T("dialog.enterName")}, confirmAction)
+}
+
+/*
+startPrompt is start with the prompt lines shown above the name.
+*/
+func (n *NameEntryComp) startPrompt(prompt []string, confirmAction func(name string)) {
+	n.name = nil
+	n.prompt = prompt
+	n.confirmAction = confirmAction
+	n.activate(true)
+}
+
+/*
+confirm closes the dialog and passes the name to the confirm action.
+*/
+func (n *NameEntryComp) confirm() {
+	n.activate(false)
+	if n.confirmAction != nil {
+		n.confirmAction(n.getName())
+	}
+}
+
+func (n *NameEntryComp) getName() string {
+	return string(n.name)
+}
+
+/*
+handleChars appends the letters, digits and spaces to the name, up to maxLen characters.
+*/
+func (n *NameEntryComp) handleChars(chars []rune) {
+	for _, c := range chars {
+		if len(n.name) < n.maxLen && isNameChar(c) {
+			n.name = append(n.name, c)
+		}
+	}
+}
+
+/*
+Returns true if the character can be typed into the names: the letters, the digits and the space.
+*/
+func isNameChar(c rune) bool {
+	return unicode.IsLetter(c) || unicode.IsDigit(c) || c == ' '
+}
+
+func (n *NameEntryComp) activate(isActive bool) {
+	if isActive {
+		n.state = StateBlocking
+		// the keys held at the start are not pressed
+		n.enterState = ControlState{down: true}
+		n.backspaceState = ControlState{down: true}
+	} else {
+		n.state = StateInactive
+	}
+	n.dialog.activate(isActive)
+}
+
+func (n *NameEntryComp) reset() {
+	n.activate(false)
+}
+
+/*
+update handles the typing. The dialog blocks the game, so the paused flag is ignored.
+*/
+func (n *NameEntryComp) update(paused bool, frameCnt int) {
+	if n.state == StateInactive {
+		return
+	}
+
+	n.handleChars(ebiten.AppendInputChars(nil))
+
+	n.input.handleKeyPress(KeyList{ebiten.KeyBackspace}, false, &n.backspaceState)
+	if n.backspaceState.press && 0 < len(n.name) {
+		n.name = n.name[:len(n.name)-1]
+	}
+
+	n.input.handleKeyPress(KeyList{ebiten.KeyEnter, ebiten.KeyNumpadEnter}, false, &n.enterState)
+	if n.enterState.press {
+		n.confirm()
+	}
+}
+
+func (n *NameEntryComp) draw(screen *ebiten.Image) {
+	if n.state != StateInactive {
+		n.dialog.text = append(slices.Clone(n.prompt), n.getName()+"_")
+		n.dialog.draw(screen)
+	}
+}
+
+func (n *NameEntryComp) getDrawOrder() int {
+  return n.dialog.getDrawOrder()
+}
+
+func (n *NameEntryComp) getPriority() int {
+  return PriorityDefault
+}
+
+func (n *NameEntryComp) getState() ComponentState {
+	return n.state
+}
+
 //
 // ------------ side bar ------------
 //
@@ -249,24 +661,58 @@ type SideBarComp struct {
 	drawOrder int
 	input *UserInput
 	restartAction func()
+	dropAction func()
+	muteAction func()
+	resizeAction func() // called when the width is changed by dragging the divider
+	width int // the sidebar is laid out by this width, see Game.relayout
+	dragging bool // the divider is being dragged
 	restartTextBox Rect
-	nextPiece *Piece
+	speakerBox Rect // clicking the speaker icon toggles the mute
+	muted bool
+	nextPieces []*Piece // the upcoming pieces, the immediate next first
+	holdPiece *Piece // nil if nothing is held
+	activePiece *Piece // its rotations are shown below the next pieces. nil between the pieces
 	score int
-	speedLevel int
-	topScores []int
+	speedLevel int // 1-based
+	gameTimeSec float32
+	topScores []ScoreRecord
+	completionLog []BodyCompletion
+	frameCnt int
+	showRotationPreview bool
+	practice bool // the practice mode is shown
+	marathon bool // MARATHON is shown instead of the speed level
+	maxSpeedLevel int // 1-based, the speed is not increased over it. 0 means all levels
+	dailyDate time.Time // the date of the daily challenge is shown. zero if not a daily challenge
+	profileName string // the name of the active profile, shown by the top scores. empty if there is no profile
+	bodyCandidate *CandidateMatch // the body closest to completion, shown by the progress bar. nil if there is none
 }
 
-func NewSideBar(input *UserInput, pos Pos, size Size, restartAction func(), drawOrder int) *SideBarComp {
+func NewSideBar(input *UserInput, pos Pos, size Size, restartAction func(), dropAction func(), muteAction func(), resizeAction func(), drawOrder int) *SideBarComp {
 	return &SideBarComp {
 		pos: pos,
 		size: size,
 		drawOrder: drawOrder,
 		input: input,
 		restartAction: restartAction,
-		restartTextBox: Rect{Pos{pos.x + 10, 160}, Size{100, 20}},
+		dropAction: dropAction,
+		muteAction: muteAction,
+		resizeAction: resizeAction,
+		width: size.w,
+		restartTextBox: Rect{Pos{pos.x + uiPx(10), uiPx(160)}, Size{uiPx(100), uiPx(20)}},
+		speakerBox: Rect{Pos{pos.x + size.w - uiPx(26), uiPx(4)}, Size{uiPx(20), uiPx(14)}},
 	}
 }
 
+/*
+setLayout moves the sidebar to the resized screen.
+*/
+func (s *SideBarComp) setLayout(pos Pos, size Size) {
+	s.pos = pos
+	s.size = size
+	s.restartTextBox.pos = Pos{pos.x + uiPx(10), uiPx(160)}
+	s.speakerBox.pos = Pos{pos.x + size.w - uiPx(26), uiPx(4)}
+}
+
 func (s *SideBarComp) activate(isActive bool) {
 	s.state = StateActive
 }
@@ -276,20 +722,65 @@ func (s *SideBarComp) update(paused bool, frameCnt int) {
 		return
 	}
 
+	s.frameCnt = frameCnt
+
+	x, y := s.input.cursorPosition()
+	if s.dragDivider(Pos{x, y}) {
+		return
+	}
+
 	if s.input.isMouseLeftClick() {
-		x, y := ebiten.CursorPosition()
 		if isOverlap(Pos{x, y}, Size{1, 1}, s.restartTextBox.pos, s.restartTextBox.size) {
 			s.restartAction()
 		}
+		if isOverlap(Pos{x, y}, Size{1, 1}, s.speakerBox.pos, s.speakerBox.size) {
+			s.muteAction()
+		}
+	}
+
+	// double click on the grid area drops the active piece
+	if s.input.isDoubleClick() && x < s.pos.x {
+		s.dropAction()
+	}
+}
+
+/*
+dragDivider resizes the sidebar by dragging its left edge with the mouse. The play area is kept a multiple of the grid
+width, so the divider snaps to the cell boundaries of the fitted grid. The sidebar is not narrower than sidebarWidth,
+and the cells of the play area are not smaller than sidebarDragMinCellSize. Returns true while dragging.
+*/
+func (s *SideBarComp) dragDivider(cursor Pos) bool {
+	if s.input.mouseLeftState.press && abs(cursor.x-s.pos.x) <= sidebarDividerGrabDist {
+		s.dragging = true
+	}
+	if !s.dragging {
+		return false
+	}
+	if !s.input.mouseLeftState.down {
+		s.dragging = false
+		return false
+	}
+
+	maxPlayWidth := (screenWidth - sidebarWidth) / gridSize.w * gridSize.w
+	playWidth := (cursor.x + gridSize.w/2) / gridSize.w * gridSize.w
+	playWidth = min(max(playWidth, sidebarDragMinCellSize*gridSize.w), maxPlayWidth)
+	if width := screenWidth - playWidth; width != s.width {
+		log.Printf("Sidebar resized to %d", width)
+		s.width = width
+		s.resizeAction()
 	}
+	return true
 }
 
 func (s *SideBarComp) reset() {
 	s.state = StateInactive
-	s.nextPiece = nil
+	s.nextPieces = nil
+	s.holdPiece = nil
+	s.activePiece = nil
 	s.score = 0
 	s.speedLevel = 0
-	s.topScores = []int{}
+	s.topScores = []ScoreRecord{}
+	s.completionLog = nil
 }
 
 func (s *SideBarComp) draw(screen *ebiten.Image) {
@@ -302,15 +793,245 @@ func (s *SideBarComp) getDrawOrder() int {
   return s.drawOrder
 }
 
+func (s *SideBarComp) getPriority() int {
+  return PriorityDefault
+}
+
 func (s *SideBarComp) getState() ComponentState {
 	return s.state
 }
 
-func (s *SideBarComp) setValues(nextPiece *Piece, score int, speedLevel int, topScores []int) {
-	s.nextPiece = nextPiece
+func (s *SideBarComp) setHoldPiece(piece *Piece) {
+	s.holdPiece = piece
+}
+
+func (s *SideBarComp) setPractice(practice bool) {
+	s.practice = practice
+}
+
+func (s *SideBarComp) setMarathon(marathon bool) {
+	s.marathon = marathon
+}
+
+func (s *SideBarComp) setMaxSpeedLevel(level int) {
+	s.maxSpeedLevel = level
+}
+
+func (s *SideBarComp) setDailyDate(date time.Time) {
+	s.dailyDate = date
+}
+
+func (s *SideBarComp) setProfileName(name string) {
+	s.profileName = name
+}
+
+func (s *SideBarComp) setActivePiece(piece *Piece) {
+	s.activePiece = piece
+}
+
+func (s *SideBarComp) setBodyCandidate(candidate *CandidateMatch) {
+	s.bodyCandidate = candidate
+}
+
+func (s *SideBarComp) setMuted(muted bool) {
+	s.muted = muted
+}
+
+/*
+drawSpeaker draws the speaker icon, with sound waves if the audio is on or crossed if muted.
+*/
+func (s *SideBarComp) drawSpeaker(screen *ebiten.Image) {
+	x, y := float32(s.speakerBox.pos.x), float32(s.speakerBox.pos.y)
+	h := float32(s.speakerBox.size.h)
+	k := float32(uiScale) // the horizontal offsets and the line width are scaled
+
+	// box and cone
+	vector.DrawFilledRect(screen, x, y+h/3, 4*k, h/3, speakerColor, false)
+	vector.StrokeLine(screen, x+4*k, y+h/3, x+9*k, y, k, speakerColor, false)
+	vector.StrokeLine(screen, x+4*k, y+2*h/3, x+9*k, y+h, k, speakerColor, false)
+	vector.StrokeLine(screen, x+9*k, y, x+9*k, y+h, k, speakerColor, false)
+
+	if s.muted {
+		vector.StrokeLine(screen, x+12*k, y+h/4, x+19*k, y+3*h/4, k, speakerColor, false)
+		vector.StrokeLine(screen, x+12*k, y+3*h/4, x+19*k, y+h/4, k, speakerColor, false)
+	} else {
+		vector.StrokeLine(screen, x+12*k, y+h/3, x+12*k, y+2*h/3, k, speakerColor, false)
+		vector.StrokeLine(screen, x+15*k, y+h/4, x+15*k, y+3*h/4, k, speakerColor, false)
+		vector.StrokeLine(screen, x+18*k, y+h/6, x+18*k, y+5*h/6, k, speakerColor, false)
+	}
+}
+
+/*
+Returns the highest speed level of the game, 1-based.
+*/
+func (s *SideBarComp) getMaxSpeedLevel() int {
+	if 0 < s.maxSpeedLevel {
+		return min(s.maxSpeedLevel, len(speedLevels))
+	}
+	return len(speedLevels)
+}
+
+/*
+Returns the progress towards the next speed level in [0,1], and if the speed level is the maximum one.
+*/
+func (s *SideBarComp) getSpeedProgress() (float32, bool) {
+	idx := max(s.speedLevel-1, 0) // the level is 0 until setValues is called
+	if s.getMaxSpeedLevel() <= idx+1 {
+		return 1, true
+	}
+	return min(max(s.gameTimeSec/float32(speedLevels[idx].nextLevelTimeSec), 0), 1), false
+}
+
+/*
+Returns the whole seconds remaining until the next speed level, and if the speed level is the maximum one.
+*/
+func (s *SideBarComp) getSecondsToNextLevel() (int, bool) {
+	idx := max(s.speedLevel-1, 0)
+	if s.getMaxSpeedLevel() <= idx+1 {
+		return 0, true
+	}
+	return max(int(math.Ceil(float64(float32(speedLevels[idx].nextLevelTimeSec)-s.gameTimeSec))), 0), false
+}
+
+/*
+drawSpeedCountdown draws the seconds until the next speed level, or MAX at the maximum level.
+The countdown flashes red during the last speedCountdownWarnSec seconds.
+*/
+func (s *SideBarComp) drawSpeedCountdown(screen *ebiten.Image, x, y int) {
+	sec, isMax := s.getSecondsToNextLevel()
+	if isMax {
+		renderText(screen, T("sidebar.max"), x, y, smallTextFace)
+		return
+	}
+
+	c := color.RGBA{255, 255, 255, 255}
+	if sec <= speedCountdownWarnSec && (s.frameCnt/speedCountdownFlashFrameCnt)%2 == 0 {
+		c = speedCountdownWarnColor
+	}
+	renderTextColored(screen, fmt.Sprintf("%ds", sec), x, y, smallTextFace, c)
+}
+
+/*
+drawSpeedProgress draws the progress bar of the speed level. The fill turns from green to red towards the next level.
+At the maximum level the bar is full with a distinct color.
+*/
+func (s *SideBarComp) drawSpeedProgress(screen *ebiten.Image, r Rect) {
+	x, y := float32(r.pos.x), float32(r.pos.y)
+	w, h := float32(r.size.w), float32(r.size.h)
+	vector.DrawFilledRect(screen, x, y, w, h, speedBarBkgdColor, false)
+
+	progress, isMax := s.getSpeedProgress()
+	if isMax {
+		vector.DrawFilledRect(screen, x, y, w, h, speedBarMaxColor, false)
+		return
+	}
+
+	// a column of one pixel for each color of the gradient
+	for dx := float32(0); dx < w*progress; dx++ {
+		t := dx / w
+		c := color.RGBA{
+			R: uint8(float32(speedBarStartColor.R)*(1-t) + float32(speedBarEndColor.R)*t),
+			G: uint8(float32(speedBarStartColor.G)*(1-t) + float32(speedBarEndColor.G)*t),
+			B: uint8(float32(speedBarStartColor.B)*(1-t) + float32(speedBarEndColor.B)*t),
+			A: 255,
+		}
+		vector.DrawFilledRect(screen, x+dx, y, 1, h, c, false)
+	}
+}
+
+func (s *SideBarComp) setShowRotationPreview(v bool) {
+	s.showRotationPreview = v
+}
+
+/*
+drawNextPieces draws the queue of the upcoming pieces in a column. The later pieces are drawn progressively smaller.
+Returns the bottom of the column.
+*/
+func (s *SideBarComp) drawNextPieces(screen *ebiten.Image) float64 {
+	top := float64(uiPx(sidebarPiecesTop))
+	for i, piece := range s.nextPieces {
+		pieceScale := nextPieceScales[min(i, len(nextPieceScales)-1)]
+		cellSize := float64(scale) * pieceScale
+
+		op := &ebiten.DrawImageOptions{}
+		imageScaleX, imageScaleY := piece.getScale()
+		op.GeoM.Scale(imageScaleX*pieceScale, imageScaleY*pieceScale)
+		op.GeoM.Translate(float64(s.pos.x) + (float64(s.size.w)-cellSize)/2, top)
+		if piece.color != (color.RGBA{}) {
+			op.ColorScale.ScaleWithColor(piece.color)
+		}
+		screen.DrawImage(piece.image, op)
+
+		top += cellSize + 2
+	}
+	return top
+}
+
+/*
+drawActivePieceRotations draws the active piece in all four rotations at half scale, in a row from the top.
+The current rotation is framed.
+*/
+func (s *SideBarComp) drawActivePieceRotations(screen *ebiten.Image, top float64) {
+	const gap = 4
+	// the slots fit the multi-cell pieces in any rotation
+	slotSize := float64(max(s.activePiece.size.w, s.activePiece.size.h)*scale) / 2
+	left := float64(s.pos.x) + (float64(s.size.w)-4*slotSize-3*gap)/2
+	currentIdx := ((s.activePiece.currentRotation%360 + 360) % 360) / 90
+
+	for i := 0; i < 4; i++ {
+		piece := *s.activePiece
+		piece.pos = Pos{0, 0}
+		piece.currentRotation = i * 90
+		size := rotateSize(piece.size, piece.currentRotation)
+		x := left + float64(i)*(slotSize+gap)
+		y := top
+
+		op := &ebiten.DrawImageOptions{}
+		applyRotationToPiece(op, &piece)
+		op.GeoM.Scale(0.5, 0.5)
+		// centered in the slot
+		op.GeoM.Translate(x+(slotSize-float64(size.w*scale)/2)/2, y+(slotSize-float64(size.h*scale)/2)/2)
+		screen.DrawImage(piece.image, op)
+
+		if i == currentIdx {
+			vector.StrokeRect(screen, float32(x-1), float32(y-1), float32(slotSize+2), float32(slotSize+2), 1, boundingBoxColor, false)
+		}
+	}
+}
+
+/*
+drawNextPieceRotations draws the next piece in all four rotations at half scale, in a 2x2 arrangement
+right to the next piece.
+*/
+func (s *SideBarComp) drawNextPieceRotations(screen *ebiten.Image) {
+	nextPiece := s.nextPieces[0]
+	cellSize := scale / 2
+	left := s.pos.x + s.size.w/2 + scale
+	top := uiPx(sidebarPiecesTop)
+
+	for i := 0; i < 4; i++ {
+		imageScaleX, imageScaleY := nextPiece.getScale()
+
+		op := &ebiten.DrawImageOptions{}
+		op.GeoM.Scale(imageScaleX/2, imageScaleY/2)
+		// rotate around the center of the cell
+		op.GeoM.Translate(-float64(cellSize)/2, -float64(cellSize)/2)
+		op.GeoM.Rotate(-getRotationTheta(i * 90))
+		op.GeoM.Translate(float64(left + (i%2)*cellSize) + float64(cellSize)/2, float64(top + (i/2)*cellSize) + float64(cellSize)/2)
+		if nextPiece.color != (color.RGBA{}) {
+			op.ColorScale.ScaleWithColor(nextPiece.color)
+		}
+		screen.DrawImage(nextPiece.image, op)
+	}
+}
+
+func (s *SideBarComp) setValues(nextPieces []*Piece, score int, speedLevel int, gameTimeSec float32, topScores []ScoreRecord, log []BodyCompletion) {
+	s.nextPieces = nextPieces
 	s.score = score
 	s.speedLevel = speedLevel
+	s.gameTimeSec = gameTimeSec
 	s.topScores = topScores
+	s.completionLog = log
 }
 
 /*
@@ -322,55 +1043,107 @@ Parameters:
 */
 func (s *SideBarComp) drawSidebar(screen *ebiten.Image) {
 	vector.DrawFilledRect(screen, float32(s.pos.x), float32(s.pos.y), float32(s.size.w), float32(s.size.h), sidebarColor, false)
+	if s.dragging {
+		vector.StrokeLine(screen, float32(s.pos.x), float32(s.pos.y), float32(s.pos.x), float32(s.pos.y+s.size.h), 2, menuSelectedColor, false)
+	}
 
 	lineHeight := int(smallTextFace.Size * 1.5)
 	// Draw "Next Piece"
-	renderTextCentered(screen, "NEXT PIECE", s.pos.x+s.size.w/2, 20, smallTextFace)
+	renderTextCentered(screen, T("sidebar.nextPiece"), s.pos.x+s.size.w/2, uiPx(12), smallTextFace)
 
-	op := &ebiten.DrawImageOptions{}
-	imageScaleX, imageScaleY := s.nextPiece.getScale()
-	op.GeoM.Scale(imageScaleX, imageScaleY) // Apply scaling to the next piece
-	op.GeoM.Translate(float64(s.pos.x + (s.size.w - scale)/2), 50)
-	screen.DrawImage(s.nextPiece.image, op)
+	nextPiecesBottom := s.drawNextPieces(screen)
+
+	// Draw the rotations of the active piece below the next pieces
+	if s.activePiece != nil {
+		s.drawActivePieceRotations(screen, nextPiecesBottom+2)
+	}
+
+	// Draw the held piece left to the next piece
+	if s.holdPiece != nil {
+		renderText(screen, T("sidebar.hold"), s.pos.x+uiPx(10), uiPx(12), smallTextFace)
+
+		op := &ebiten.DrawImageOptions{}
+		imageScaleX, imageScaleY := s.holdPiece.getScale()
+		op.GeoM.Scale(imageScaleX, imageScaleY)
+		op.GeoM.Translate(float64(s.pos.x+uiPx(10)), float64(uiPx(sidebarPiecesTop)))
+		if s.holdPiece.color != (color.RGBA{}) {
+			op.ColorScale.ScaleWithColor(s.holdPiece.color)
+		}
+		screen.DrawImage(s.holdPiece.image, op)
+	}
+
+	if s.showRotationPreview && 0 < len(s.nextPieces) {
+		s.drawNextPieceRotations(screen)
+	}
+
+	s.drawSpeaker(screen)
 
 	// Draw restart button
-	renderText(screen, "RESTART", s.restartTextBox.pos.x, s.restartTextBox.pos.y, smallTextFace)
+	renderText(screen, T("sidebar.restart"), s.restartTextBox.pos.x, s.restartTextBox.pos.y, smallTextFace)
+
+	// Draw the practice mode right to the restart button
+	if s.practice {
+		renderText(screen, T("sidebar.practice"), s.pos.x+s.size.w-uiPx(106), s.restartTextBox.pos.y-uiPx(4), normTextFace)
+	}
 
-	// Draw top 5 scores
-	renderText(screen, "TOP 5 SCORES", s.pos.x+10, 200, smallTextFace)
-	for i, score := range s.topScores {
-		renderText(screen, fmt.Sprintf("%d: %d", i+1, score), s.pos.x+10, 200+(i+1)*lineHeight, smallTextFace)
+	// Draw the date and the day of the year of the daily challenge below the restart button
+	if !s.dailyDate.IsZero() {
+		dailyText := fmt.Sprintf("DAILY %s  DAY %d", s.dailyDate.Format(time.DateOnly), s.dailyDate.YearDay())
+		renderText(screen, dailyText, s.restartTextBox.pos.x, s.restartTextBox.pos.y+lineHeight, smallTextFace)
+	}
+
+	// Draw top 5 scores. the name and the score are in two lines to fit left to the controls
+	renderText(screen, T("sidebar.topScores"), s.pos.x+uiPx(10), uiPx(200), smallTextFace)
+	renderText(screen, s.profileName, s.pos.x+uiPx(100), uiPx(200), smallTextFace)
+	for i, record := range s.topScores {
+		name := record.Name
+		if name == "" {
+			name = "-"
+		}
+		renderText(screen, fmt.Sprintf("%d: %s", i+1, name), s.pos.x+uiPx(10), uiPx(200)+(2*i+1)*lineHeight, smallTextFace)
+		renderText(screen, fmt.Sprintf("   %d", record.Score), s.pos.x+uiPx(10), uiPx(200)+(2*i+2)*lineHeight, smallTextFace)
 	}
 	
 	// Draw controls
-	renderText(screen, "LEF: 7 <-",     s.pos.x+90, 200+1*lineHeight, smallTextFace)
-	renderText(screen, "ROT: 8 ENT ^",  s.pos.x+90, 200+2*lineHeight, smallTextFace)
-	renderText(screen, "           |",  s.pos.x+90, 200+2*lineHeight, smallTextFace)
-	renderText(screen, "RIG: 9 ->",     s.pos.x+90, 200+3*lineHeight, smallTextFace)
-	renderText(screen, "DRO: SPC 5 v",  s.pos.x+90, 200+4*lineHeight, smallTextFace)
-	renderText(screen, "           |",  s.pos.x+90, 200+4*lineHeight-3, smallTextFace)
-	renderText(screen, "SPD: S",        s.pos.x+90, 200+5*lineHeight, smallTextFace)
+	for i, key := range []string{"sidebar.controlLeft", "sidebar.controlRotate", "sidebar.controlRight", "sidebar.controlDrop", "sidebar.controlSpeedup",
+		"sidebar.controlHint", "sidebar.controlForfeit", "sidebar.controlHold", "sidebar.controlMute", "sidebar.controlMusic", "sidebar.controlPause"} {
+		renderText(screen, T(key), s.pos.x+uiPx(90), uiPx(200)+(i+1)*lineHeight, smallTextFace)
+	}
+	// the arrows of the rotate and the drop keys are joined to their lines
+	renderText(screen, "           |",  s.pos.x+uiPx(90), uiPx(200)+2*lineHeight, smallTextFace)
+	renderText(screen, "           |",  s.pos.x+uiPx(90), uiPx(200)+4*lineHeight-uiPx(3), smallTextFace)
 
 	// Draw current score
-	renderText(screen, "SCORE", s.pos.x+10, 120, smallTextFace)
-	renderText(screen, fmt.Sprintf("%d", s.score), s.pos.x+80, 120, smallTextFace)
+	renderText(screen, T("sidebar.score"), s.pos.x+uiPx(10), uiPx(120), smallTextFace)
+	renderText(screen, fmt.Sprintf("%d", s.score), s.pos.x+uiPx(80), uiPx(120), smallTextFace)
 
 	// Draw current speed level
-	renderText(screen, "SPEED", s.pos.x+10, 120 + lineHeight, smallTextFace)
-	renderText(screen, fmt.Sprintf("%d", s.speedLevel), s.pos.x+80, 120 + lineHeight, smallTextFace)
+	renderText(screen, T("sidebar.speed"), s.pos.x+uiPx(10), uiPx(120) + lineHeight, smallTextFace)
+	if s.marathon {
+		// the speed is fixed, there is no countdown
+		renderText(screen, T("sidebar.marathon"), s.pos.x+uiPx(80), uiPx(120) + lineHeight, smallTextFace)
+	} else {
+		renderText(screen, fmt.Sprintf("%d", s.speedLevel), s.pos.x+uiPx(80), uiPx(120) + lineHeight, smallTextFace)
+		s.drawSpeedCountdown(screen, s.pos.x+uiPx(115), uiPx(120) + lineHeight)
+	}
+	s.drawSpeedProgress(screen, Rect{Pos{s.pos.x + uiPx(10), uiPx(120) + 2*lineHeight - uiPx(3)}, Size{s.size.w - uiPx(20), uiPx(4)}})
+
+	s.drawBodyProgress(screen, Rect{Pos{s.pos.x + uiPx(10), uiPx(200) + 12*lineHeight}, Size{s.size.w - uiPx(20), lineHeight}})
+	s.renderBodyCompletionHistory(screen)
 
 	// Draw hints about joint bodies
 	hintPosLL := Pos{s.pos.x, screenHeight}
 	hintRowHeight := 0
-	for i := 0; i < len(allBodies); i++ {
-		body := allBodies[len(allBodies)-1-i]
+	bodies := getBaseBodies()
+	for i := 0; i < len(bodies); i++ {
+		body := bodies[len(bodies)-1-i]
 
 		ok, hintAreaSize := s.drawSidebarHint(screen, body, hintPosLL, lineHeight)
 
 		// go to row above if no more space on the sidebar row
 		if !ok {
 			hintPosLL.x = s.pos.x
-			hintPosLL.y -= hintRowHeight + 10
+			hintPosLL.y -= hintRowHeight + uiPx(10)
 			_, hintAreaSize = s.drawSidebarHint(screen, body, hintPosLL, lineHeight)
 		}
 
@@ -382,8 +1155,51 @@ func (s *SideBarComp) drawSidebar(screen *ebiten.Image) {
 	}
 }
 
+/*
+drawBodyProgress draws the name of the body closest to completion, and a bar of a segment for each piece of
+the body below it. The segments of the present pieces are green, the missing ones are red.
+*/
+func (s *SideBarComp) drawBodyProgress(screen *ebiten.Image, r Rect) {
+	if s.bodyCandidate == nil {
+		return
+	}
+
+	pieceCnt := len(s.bodyCandidate.pieces)
+	text := fmt.Sprintf("%s %d/%d", s.bodyCandidate.body.name, pieceCnt-s.bodyCandidate.getMissingCnt(), pieceCnt)
+	renderText(screen, text, r.pos.x, r.pos.y, smallTextFace)
+
+	lineHeight := int(smallTextFace.Size * 1.5)
+	x, y := float32(r.pos.x), float32(r.pos.y+lineHeight-uiPx(3))
+	segmentW := float32(r.size.w) / float32(pieceCnt)
+	for i, piece := range s.bodyCandidate.pieces {
+		c := hintMissingColor
+		if piece != nil {
+			c = hintPresentColor
+		}
+		vector.DrawFilledRect(screen, x+float32(i)*segmentW, y, segmentW-1, float32(uiPx(4)), c, false)
+	}
+}
+
+/*
+renderBodyCompletionHistory renders the last completed bodies below the top scores and the body completion progress.
+The entries fade out by their age.
+*/
+func (s *SideBarComp) renderBodyCompletionHistory(screen *ebiten.Image) {
+	lineHeight := int(smallTextFace.Size * 1.5)
+	ypos := uiPx(200) + 13*lineHeight
+
+	for i := len(s.completionLog) - 1; 0 <= i && len(s.completionLog)-5 <= i; i-- {
+		entry := s.completionLog[i]
+		alpha := entry.getAlpha(s.frameCnt, completionLogFadeSec*ticksPerSec)
+		if 0 < alpha {
+			renderTextFaded(screen, fmt.Sprintf("%s +%d", entry.bodyName, entry.score), s.pos.x+uiPx(10), ypos, smallTextFace, alpha)
+			ypos += lineHeight
+		}
+	}
+}
+
 func (s *SideBarComp) drawSidebarHint(screen *ebiten.Image, body *Body, posLL Pos, lineHeight int) (bool, Size) {
-	hintTextAreaHeight := 50
+	hintTextAreaHeight := uiPx(50)
 	hintAreaSize := Size{s.size.w/2, hintTextAreaHeight} // text + pieces together
 
 	// check if outside of screen
@@ -392,51 +1208,78 @@ func (s *SideBarComp) drawSidebarHint(screen *ebiten.Image, body *Body, posLL Po
 	}
 
 	// draw text
-	hintTextPos := addPos(posLL, Pos{hintAreaSize.w/2, -hintTextAreaHeight+5})
+	hintTextPos := addPos(posLL, Pos{hintAreaSize.w/2, -hintTextAreaHeight+uiPx(5)})
 
-	renderText(screen, "SPEED", s.pos.x+10, 120 + lineHeight, smallTextFace)
+	renderText(screen, T("sidebar.speed"), s.pos.x+uiPx(10), uiPx(120) + lineHeight, smallTextFace)
 
 	renderTextCentered(screen, body.name, hintTextPos.x, hintTextPos.y, smallTextFace)
-	renderTextCentered(screen, fmt.Sprintf("%d", body.score), hintTextPos.x, hintTextPos.y+lineHeight, smallTextFace)
+	renderTextCentered(screen, fmt.Sprintf("%d", body.getScore()), hintTextPos.x, hintTextPos.y+lineHeight, smallTextFace)
 
 	// get dimension of the body
-	boxPos, boxSize := body.getBoundingBox()
+	_, boxSize := body.getBoundingBox()
 	hintAreaSize.h += boxSize.h * scale
 
 	// draw text pieces
 	bodyPosUL := addPos(posLL, Pos{hintAreaSize.w/2 - scale*boxSize.w/2, -hintAreaSize.h})
-	for _, bp := range body.bodyPieces {
-		piece := getPieceByType(bp.pieceType)
-		w, h := grid2ScrSize(float32(piece.size.w)/2, float32(piece.size.h)/2)
-
-		op := &ebiten.DrawImageOptions{}
-		imageScaleX, imageScaleY := piece.getScale()
-		op.GeoM.Scale(imageScaleX, imageScaleY) // Apply scaling to the next piece
-		op.GeoM.Translate(float64(-w), float64(-h))
-		op.GeoM.Rotate(-getRotationTheta(bp.rotation))
-		op.GeoM.Translate(float64(bodyPosUL.x+(bp.pos.x-boxPos.x)*scale), float64(bodyPosUL.y+(bp.pos.y-boxPos.y)*scale))
-		op.GeoM.Translate(float64(w), float64(h))
-		screen.DrawImage(piece.image, op)
+	for i := range body.bodyPieces {
+		drawBodyPiece(screen, body, i, PosF{float64(bodyPosUL.x), float64(bodyPosUL.y)}, float64(scale))
 	}
 
 	return true, hintAreaSize
 }
 
+/*
+drawBodyPiece draws a piece of the body diagram. posUL is the upper left corner of the bounding box of the body,
+cellSize is the size of a grid cell in pixels.
+*/
+func drawBodyPiece(screen *ebiten.Image, body *Body, idx int, posUL PosF, cellSize float64) {
+	bp := body.bodyPieces[idx]
+	boxPos, _ := body.getBoundingBox()
+	piece := getPieceByType(bp.pieceType)
+	w, h := float64(piece.size.w)*cellSize/2, float64(piece.size.h)*cellSize/2
+	rotatedSize := rotateSize(piece.size, bp.rotation)
+
+	op := &ebiten.DrawImageOptions{}
+	imageScaleX, imageScaleY := piece.getScale()
+	op.GeoM.Scale(imageScaleX*cellSize/float64(scale), imageScaleY*cellSize/float64(scale))
+	op.GeoM.Translate(-w, -h)
+	op.GeoM.Rotate(-getRotationTheta(bp.rotation))
+	op.GeoM.Translate(posUL.x+float64(bp.pos.x-boxPos.x)*cellSize, posUL.y+float64(bp.pos.y-boxPos.y)*cellSize)
+	op.GeoM.Translate(float64(rotatedSize.w)*cellSize/2, float64(rotatedSize.h)*cellSize/2)
+	screen.DrawImage(piece.image, op)
+}
+
 //
 // ------------ background ------------
 //
+/*
+BackgroundComp fills the play area of screenLayout.
+*/
 type BackgroundComp struct {
 	state ComponentState
-	pos Pos
-	size Size
 	drawOrder int
+	color color.RGBA // shifts from backgroundColor to backgroundFastColor as the speed level increases
 }
 
-func NewBackground(pos Pos, size Size, drawOrder int) *BackgroundComp {
+func NewBackground(drawOrder int) *BackgroundComp {
 	return &BackgroundComp {
-		pos: pos,
-		size: size,
 		drawOrder: drawOrder,
+		color: backgroundColor,
+	}
+}
+
+/*
+setSpeedLevel adjusts the background color to the speed level index.
+*/
+func (b *BackgroundComp) setSpeedLevel(level int) {
+	t := float64(level) / float64(len(speedLevels)-1)
+	lerp := func(from, to uint8) uint8 { return uint8(math.Round(float64(from) + t*(float64(to)-float64(from)))) }
+
+	b.color = color.RGBA{
+		R: lerp(backgroundColor.R, backgroundFastColor.R),
+		G: lerp(backgroundColor.G, backgroundFastColor.G),
+		B: lerp(backgroundColor.B, backgroundFastColor.B),
+		A: 255,
 	}
 }
 
@@ -451,12 +1294,14 @@ func (b *BackgroundComp) update(paused bool, frameCnt int) {
 }
 
 func (b *BackgroundComp) reset() {
+	b.color = backgroundColor
 }
 
 func (b *BackgroundComp) draw(screen *ebiten.Image) {
 	if b.state != StateInactive {
 		// Fill only the game area with the background color
-		vector.DrawFilledRect(screen, float32(b.pos.x), float32(b.pos.y), float32(b.size.w), float32(b.size.h), backgroundColor, false)
+		area := screenLayout.playArea
+		vector.DrawFilledRect(screen, float32(area.pos.x), float32(area.pos.y), float32(area.size.w), float32(area.size.h), b.color, false)
 	}
 }
 
@@ -464,6 +1309,157 @@ func (b *BackgroundComp) getDrawOrder() int {
   return b.drawOrder
 }
 
+func (b *BackgroundComp) getPriority() int {
+  return PriorityDefault
+}
+
 func (b *BackgroundComp) getState() ComponentState {
 	return b.state
 }
+
+//
+// ------------ FPS counter ------------
+//
+type FPSCounterComp struct {
+	state ComponentState
+	pos Pos // top-left corner of the text
+	drawOrder int
+	samples []float64 // the last fpsSampleCnt values of ebiten.ActualTPS(), a ring buffer
+	sampleIdx int // the next sample is written here
+}
+
+func NewFPSCounter(pos Pos, drawOrder int) *FPSCounterComp {
+	return &FPSCounterComp {
+		pos: pos,
+		drawOrder: drawOrder,
+	}
+}
+
+func (f *FPSCounterComp) activate(isActive bool) {
+	if isActive {
+		f.state = StateActive
+	} else {
+		f.state = StateInactive
+	}
+	f.samples = nil
+	f.sampleIdx = 0
+}
+
+func (f *FPSCounterComp) reset() {
+	f.activate(false)
+}
+
+/*
+update samples the frame rate, also while the game is paused.
+*/
+func (f *FPSCounterComp) update(paused bool, frameCnt int) {
+	if f.state == StateInactive {
+		return
+	}
+
+	f.addSample(ebiten.ActualTPS())
+}
+
+func (f *FPSCounterComp) addSample(fps float64) {
+	if len(f.samples) < fpsSampleCnt {
+		f.samples = append(f.samples, fps)
+	} else {
+		f.samples[f.sampleIdx] = fps
+	}
+	f.sampleIdx = (f.sampleIdx + 1) % fpsSampleCnt
+}
+
+/*
+Returns the rolling average of the sampled frame rates. 0 if there is no sample yet.
+*/
+func (f *FPSCounterComp) getAverage() float64 {
+	if len(f.samples) == 0 {
+		return 0
+	}
+
+	sum := 0.0
+	for _, fps := range f.samples {
+		sum += fps
+	}
+	return sum / float64(len(f.samples))
+}
+
+func (f *FPSCounterComp) draw(screen *ebiten.Image) {
+	if f.state != StateInactive {
+		// the fixed-width debug font of Ebiten differs from the fonts of the game
+		ebitenutil.DebugPrintAt(screen, fmt.Sprintf("FPS %5.1f", f.getAverage()), f.pos.x, f.pos.y)
+	}
+}
+
+func (f *FPSCounterComp) getDrawOrder() int {
+  return f.drawOrder
+}
+
+func (f *FPSCounterComp) getPriority() int {
+  return PriorityDefault
+}
+
+func (f *FPSCounterComp) getState() ComponentState {
+	return f.state
+}
+
+//
+// ------------ sprint countdown ------------
+//
+
+/*
+SprintCountdownComp shows the score remaining to the target of the sprint mode in large numbers.
+*/
+type SprintCountdownComp struct {
+	state ComponentState
+	screenPos Pos // top center of the text
+	remaining int
+	drawOrder int
+}
+
+func NewSprintCountdown(screenPos Pos, drawOrder int) *SprintCountdownComp {
+	return &SprintCountdownComp {
+		screenPos: screenPos,
+		drawOrder: drawOrder,
+	}
+}
+
+func (s *SprintCountdownComp) activate(isActive bool) {
+	if isActive {
+		s.state = StateActive
+	} else {
+		s.state = StateInactive
+	}
+}
+
+func (s *SprintCountdownComp) reset() {
+	s.activate(false)
+}
+
+func (s *SprintCountdownComp) update(paused bool, frameCnt int) {
+}
+
+func (s *SprintCountdownComp) setRemaining(remaining int) {
+	s.remaining = remaining
+}
+
+func (s *SprintCountdownComp) draw(screen *ebiten.Image) {
+	if s.state == StateInactive {
+		return
+	}
+
+	renderTextCentered(screen, fmt.Sprintf("%d", s.remaining), s.screenPos.x, s.screenPos.y, bigTextFace)
+	renderTextCentered(screen, T("sidebar.toGo"), s.screenPos.x, s.screenPos.y+int(bigTextFace.Size*1.2), smallTextFace)
+}
+
+func (s *SprintCountdownComp) getDrawOrder() int {
+  return s.drawOrder
+}
+
+func (s *SprintCountdownComp) getPriority() int {
+  return PriorityDefault
+}
+
+func (s *SprintCountdownComp) getState() ComponentState {
+	return s.state
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"reflect"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+/*
+the tunable game parameters. loaded from configFileName, the defaults are the built-in values.
+The keys of the file are the toml tags, the doc tags are written as comments to the default file.
+*/
+type Config struct {
+	ScreenWidth             int      `toml:"screen_width" doc:"initial size of the window"`
+	ScreenHeight            int      `toml:"screen_height"`
+	GridSize                int      `toml:"grid_size" doc:"width and height of the grid, including the border"`
+	SpeedLevels             [][2]int `toml:"speed_levels" doc:"ticks per drop and the time of the next level in seconds, per speed level"`
+	WaveEffectLifeTimeSec   float32  `toml:"wave_effect_life_time_sec"`
+	RockEffectLifeTimeSec   float32  `toml:"rock_effect_life_time_sec"`
+	ComboLabelLifeTimeSec   float32  `toml:"combo_label_life_time_sec"`
+	BombParticleLifeTimeSec float32  `toml:"bomb_particle_life_time_sec"`
+	ForfeitHoldSec          int      `toml:"forfeit_hold_sec" doc:"the forfeit key has to be held for this time"`
+	DASDelayFrameCnt        int      `toml:"das_delay_frame_cnt" doc:"a held directional key is repeated after this delay"`
+	DASRepeatFrameCnt       int      `toml:"das_repeat_frame_cnt"`
+	UIScale                 float64  `toml:"ui_scale" doc:"the texts and the sidebar are enlarged by this, in [1, 3]. the window is enlarged to fit them"`
+	ColorblindMode          bool     `toml:"colorblind_mode" doc:"the body pieces are marked by symbols: circle, square, triangle and diamond"`
+	Language                string   `toml:"language" doc:"code of the language pack of the lang directory, empty for the language of the system locale"`
+}
+
+/*
+getDefaultConfig returns the built-in values of the parameters.
+*/
+func getDefaultConfig() *Config {
+	config := &Config{
+		ScreenWidth: defaultScreenWidth,
+		ScreenHeight: defaultScreenHeight,
+		GridSize: gridSize.w,
+		WaveEffectLifeTimeSec: waveEffectLifeTimeSec,
+		RockEffectLifeTimeSec: rockEffectLifeTimeSec,
+		ComboLabelLifeTimeSec: comboLabelLifeTimeSec,
+		BombParticleLifeTimeSec: bombParticleLifeTimeSec,
+		ForfeitHoldSec: forfeitHoldSec,
+		DASDelayFrameCnt: dasDelayFrameCnt,
+		DASRepeatFrameCnt: dasRepeatFrameCnt,
+		UIScale: 1,
+	}
+	for _, level := range speedLevels {
+		config.SpeedLevels = append(config.SpeedLevels, [2]int{level.ticksPerDrop, level.nextLevelTimeSec})
+	}
+	return config
+}
+
+/*
+LoadConfig loads the parameters from a TOML file. The parameters missing from the file keep their defaults.
+The defaults are written when the file is absent.
+*/
+func LoadConfig(path string) (*Config, error) {
+	config := getDefaultConfig()
+	if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+		log.Printf("No config '%s', the defaults are written", path)
+		return config, saveConfig(path, config)
+	}
+
+	meta, err := toml.DecodeFile(path, config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load config '%s': %w", path, err)
+	}
+	if undecoded := meta.Undecoded(); 0 < len(undecoded) {
+		return nil, fmt.Errorf("invalid config '%s': unknown keys %v", path, undecoded)
+	}
+	if err := config.validate(); err != nil {
+		return nil, fmt.Errorf("invalid config '%s': %w", path, err)
+	}
+	return config, nil
+}
+
+/*
+saveConfig writes the parameters as TOML, one key per line, preceded by its doc comment.
+*/
+func saveConfig(path string, config *Config) error {
+	var sb strings.Builder
+	sb.WriteString("# TESTRis game parameters, applied at the next start\n")
+	t := reflect.TypeOf(*config)
+	v := reflect.ValueOf(*config)
+	for i := 0; i < t.NumField(); i++ {
+		if doc := t.Field(i).Tag.Get("doc"); doc != "" {
+			sb.WriteString("\n# " + doc + "\n")
+		}
+		line, err := toml.Marshal(map[string]any{t.Field(i).Tag.Get("toml"): v.Field(i).Interface()})
+		if err != nil {
+			return fmt.Errorf("failed to marshal config: %w", err)
+		}
+		sb.Write(line)
+	}
+
+	if err := os.WriteFile(path, []byte(sb.String()), 0644); err != nil {
+		return fmt.Errorf("failed to save config '%s': %w", path, err)
+	}
+	return nil
+}
+
+func (c *Config) validate() error {
+	if c.UIScale < 1 || 3 < c.UIScale {
+		return fmt.Errorf("ui scale %v is out of [1, 3]", c.UIScale)
+	}
+	if c.ScreenWidth <= baseSidebarWidth || c.ScreenHeight <= 0 {
+		return fmt.Errorf("screen size %dx%d is too small", c.ScreenWidth, c.ScreenHeight)
+	}
+	if c.GridSize < optionsGridSizeMin || optionsGridSizeMax < c.GridSize {
+		return fmt.Errorf("grid size %d is out of [%d, %d]", c.GridSize, optionsGridSizeMin, optionsGridSizeMax)
+	}
+	if len(c.SpeedLevels) == 0 {
+		return fmt.Errorf("no speed levels")
+	}
+	for i, level := range c.SpeedLevels {
+		if level[0] <= 0 || level[1] <= 0 {
+			return fmt.Errorf("speed level %d has non-positive values", i+1)
+		}
+	}
+	if c.ForfeitHoldSec <= 0 || c.DASDelayFrameCnt < 0 || c.DASRepeatFrameCnt <= 0 {
+		return fmt.Errorf("non-positive key timing")
+	}
+	// the effects last at least a frame, their progress is divided by the lifetime in frames
+	for _, lifeTimeSec := range []float32{c.WaveEffectLifeTimeSec, c.RockEffectLifeTimeSec, c.ComboLabelLifeTimeSec, c.BombParticleLifeTimeSec} {
+		if int(lifeTimeSec*ticksPerSec) < 1 {
+			return fmt.Errorf("effect life time %vs is shorter than a frame", lifeTimeSec)
+		}
+	}
+	return nil
+}
+
+/*
+apply sets the game parameters to the config. Called before the game is created.
+*/
+func (c *Config) apply() {
+	log.Printf("Applying config %+v", *c)
+	// the window is widened by the enlarged sidebar, and heightened to fit the enlarged sidebar contents
+	uiScale = c.UIScale
+	sidebarWidth = uiPx(baseSidebarWidth)
+	screenWidth, screenHeight = c.ScreenWidth+sidebarWidth-baseSidebarWidth, int(math.Round(float64(c.ScreenHeight)*uiScale))
+	gridSize = Size{c.GridSize, c.GridSize}
+	speedLevels = nil
+	for _, level := range c.SpeedLevels {
+		speedLevels = append(speedLevels, SpeedLevel{ticksPerDrop: level[0], nextLevelTimeSec: level[1]})
+	}
+	waveEffectLifeTimeSec = c.WaveEffectLifeTimeSec
+	rockEffectLifeTimeSec = c.RockEffectLifeTimeSec
+	comboLabelLifeTimeSec = c.ComboLabelLifeTimeSec
+	bombParticleLifeTimeSec = c.BombParticleLifeTimeSec
+	forfeitHoldSec = c.ForfeitHoldSec
+	dasDelayFrameCnt = c.DASDelayFrameCnt
+	dasRepeatFrameCnt = c.DASRepeatFrameCnt
+
+	// the empty language is resolved from the system locale by main
+	configLanguage = c.Language
+	if configLanguage == "" {
+		configLanguage = defaultLanguage
+	}
+
+	colorblindMode = c.ColorblindMode
+	for i := range allPieces {
+		allPieces[i].image = getSymbolImage(defaultPieceImages[allPieces[i].pieceType], allPieces[i].pieceType)
+	}
+}
+
+/*
+the game parameters given by the command line flags. They take precedence over the config and the options.
+The zero values mean no override.
+*/
+type CommandLineConfig struct {
+	gridWidth     int // including the border
+	gridHeight    int
+	startSpeed    int // 1-based
+	marathonLevel int // 1-based, 0 means the highest level
+	fullscreen    bool
+	profile       string // the profile is created if it does not exist
+}
+
+var commandLine CommandLineConfig
+
+func (c *CommandLineConfig) validate() error {
+	for _, size := range []int{c.gridWidth, c.gridHeight} {
+		if size != 0 && (size < optionsGridSizeMin || optionsGridSizeMax < size) {
+			return fmt.Errorf("grid size %d is out of [%d, %d]", size, optionsGridSizeMin, optionsGridSizeMax)
+		}
+	}
+	if c.startSpeed < 0 || len(speedLevels) < c.startSpeed {
+		return fmt.Errorf("start speed %d is out of [1, %d]", c.startSpeed, len(speedLevels))
+	}
+	if c.marathonLevel < 0 || len(speedLevels) < c.marathonLevel {
+		return fmt.Errorf("marathon level %d is out of [1, %d]", c.marathonLevel, len(speedLevels))
+	}
+	// the profile name is a directory name, the same characters are allowed as in the name entry
+	if strings.ContainsFunc(c.profile, func(c rune) bool { return !isNameChar(c) }) || c.profile != strings.TrimSpace(c.profile) {
+		return fmt.Errorf("profile name %q has invalid characters", c.profile)
+	}
+	return nil
+}
+
+/*
+apply overrides the applied config by the flags. Called before the game is created.
+*/
+func (c *CommandLineConfig) apply() {
+	if c.gridWidth != 0 {
+		gridSize.w = c.gridWidth
+	}
+	if c.gridHeight != 0 {
+		gridSize.h = c.gridHeight
+	}
+	if c.profile != "" {
+		activateProfile(c.profile)
+	}
+}
+
+/*
+overrideOptions overrides the loaded options by the flags.
+*/
+func (c *CommandLineConfig) overrideOptions(options *Options) {
+	if c.gridWidth != 0 {
+		options.GridSize = c.gridWidth
+	}
+	if c.startSpeed != 0 {
+		options.StartSpeedLevel = c.startSpeed
+	}
+	if c.fullscreen {
+		options.Fullscreen = true
+	}
+}
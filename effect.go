@@ -1,10 +1,12 @@
 package main
 
 import (
+	"image/color"
 	"log"
 	"math"
 	"math/rand"
 	"github.com/hajimehoshi/ebiten/v2"
+	"github.com/hajimehoshi/ebiten/v2/text/v2"
 	"github.com/hajimehoshi/ebiten/v2/vector"
 )
 
@@ -16,6 +18,8 @@ type WaveEffectComp struct {
 	isBlocking bool
 	rect Rect
 	center Pos
+	isHorizontalLine bool // the wave propagates vertically from a horizontal line through the center
+	initialRadius float64 // the wave starts at this distance from the center in pixels. the area inside is lit at the start
 	pixelSize int
 	pixelSize_2 int
 	waveFill float64
@@ -83,7 +87,10 @@ func (w *WaveEffectComp) draw(screen *ebiten.Image) {
 			for y := w.rect.pos.y; y < pLR.y; y += w.pixelSize {
 				dx := float64(w.center.x - x - w.pixelSize_2)
 				dy := float64(w.center.y - y - w.pixelSize_2)
-				dstPcnt := math.Sqrt(dx*dx+dy*dy) / float64(w.rect.size.w)
+				if w.isHorizontalLine {
+					dx = 0
+				}
+				dstPcnt := math.Max(math.Sqrt(dx*dx+dy*dy)-w.initialRadius, 0) / float64(w.rect.size.w)
 				intensity := w.getWaveIntensity(dstPcnt, agePercent)
 				if 0 < intensity {
 					color := waveEffectColor
@@ -99,6 +106,10 @@ func (w *WaveEffectComp) getDrawOrder() int {
   return w.drawOrder
 }
 
+func (w *WaveEffectComp) getPriority() int {
+  return PriorityDefault
+}
+
 func (w *WaveEffectComp) getState() ComponentState {
 	return w.state
 }
@@ -130,6 +141,14 @@ func (w *WaveEffectComp) setCenter(center Pos) {
 	w.center = center
 }
 
+func (w *WaveEffectComp) setHorizontalLine(isHorizontalLine bool) {
+	w.isHorizontalLine = isHorizontalLine
+}
+
+func (w *WaveEffectComp) setInitialRadius(radius float64) {
+	w.initialRadius = radius
+}
+
 //
 // ------------ RockEffect ------------
 //
@@ -208,10 +227,10 @@ func (r *RockEffectComp) draw(screen *ebiten.Image) {
 		if r.rockCnt < rockCnt {
 			r.rockCnt = rockCnt
 
-			// generate new random rock displacement
+			// generate new random rock displacement. the small cells are not displaced
 			for idx := 0; idx < len(r.rockState); idx++ {
-				r.rockState[idx].pos.x = rand.Intn(scale/4) - scale/8
-				r.rockState[idx].pos.y = rand.Intn(scale/4) - scale/8
+				r.rockState[idx].pos.x = rand.Intn(max(1, scale/4)) - scale/8
+				r.rockState[idx].pos.y = rand.Intn(max(1, scale/4)) - scale/8
 				r.rockState[idx].orient = rand.Intn(21) - 10 // +- 10 deg
 			}
 		}
@@ -231,6 +250,10 @@ func (r *RockEffectComp) getDrawOrder() int {
   return r.drawOrder
 }
 
+func (r *RockEffectComp) getPriority() int {
+  return PriorityDefault
+}
+
 func (r *RockEffectComp) getState() ComponentState {
 	return r.state
 }
@@ -242,3 +265,487 @@ func (r *RockEffectComp) setTarget(target []*Piece) {
 func (r *RockEffectComp) setCompletedCallback(completed func()) {
   r.completedCallback = completed
 }
+
+//
+// ------------ BodyMatchEffect ------------
+//
+type BodyMatchEffectComp struct {
+	state            ComponentState
+	target           []*Piece // pieces of the matched body, no longer in the grid
+	centroid         PosF     // the pieces fly away from this point, in screen CS
+	lifetimeFrameCnt int      // length of the effect
+	flyDist          float64  // distance of the flight in pixels
+	ageFrameCnt      int
+	drawOrder        int
+}
+
+func NewBodyMatchEffect(lifetimeFrameCnt int, flyDist float64, drawOrder int) *BodyMatchEffectComp {
+	return &BodyMatchEffectComp {
+		lifetimeFrameCnt: lifetimeFrameCnt,
+		flyDist: flyDist,
+		drawOrder: drawOrder,
+	}
+}
+
+func (b *BodyMatchEffectComp) activate(isActive bool) {
+	if isActive {
+		b.state = StateActive
+		b.ageFrameCnt = 0
+	} else {
+		b.state = StateInactive
+		b.target = nil
+	}
+}
+
+func (b *BodyMatchEffectComp) reset() {
+	b.activate(false)
+}
+
+func (b *BodyMatchEffectComp) update(paused bool, frameCnt int) {
+	if b.state == StateInactive {
+		return
+	}
+
+	if b.ageFrameCnt < b.lifetimeFrameCnt {
+		b.ageFrameCnt++
+	} else {
+		b.activate(false)
+	}
+}
+
+func (b *BodyMatchEffectComp) draw(screen *ebiten.Image) {
+	if b.state != StateInactive {
+		progress := float64(b.ageFrameCnt) / float64(max(b.lifetimeFrameCnt, 1))
+		for _, piece := range b.target {
+			offset := b.getOffset(piece)
+			op := &ebiten.DrawImageOptions{}
+			applyRotationToPiece(op, piece)
+			op.GeoM.Translate(offset.x, offset.y)
+			op.ColorScale.ScaleAlpha(float32(1 - progress))
+			screen.DrawImage(piece.image, op)
+		}
+	}
+}
+
+/*
+Returns the displacement of the piece at the actual age. The piece flies along the line from the centroid
+through its center. The piece at the centroid only fades out.
+*/
+func (b *BodyMatchEffectComp) getOffset(piece *Piece) PosF {
+	r := getPieceScrRect(piece)
+	dx := float64(r.pos.x) + float64(r.size.w)/2 - b.centroid.x
+	dy := float64(r.pos.y) + float64(r.size.h)/2 - b.centroid.y
+	dist := math.Hypot(dx, dy)
+	if dist == 0 {
+		return PosF{}
+	}
+
+	flown := b.flyDist * float64(b.ageFrameCnt) / float64(max(b.lifetimeFrameCnt, 1))
+	return PosF{dx / dist * flown, dy / dist * flown}
+}
+
+func (b *BodyMatchEffectComp) getDrawOrder() int {
+  return b.drawOrder
+}
+
+func (b *BodyMatchEffectComp) getPriority() int {
+  return PriorityDefault
+}
+
+func (b *BodyMatchEffectComp) getState() ComponentState {
+	return b.state
+}
+
+/*
+start plays the effect on the pieces flying away from the centroid.
+*/
+func (b *BodyMatchEffectComp) start(pieces []*Piece, centroid PosF) {
+	b.activate(true)
+	b.target = pieces
+	b.centroid = centroid
+}
+
+//
+// ------------ FlashEffect ------------
+//
+type FlashEffectComp struct {
+	state        ComponentState
+	regions      []Rect // flashing rectangles in screen CS
+	color        color.RGBA
+	holdFrameCnt int // the regions are drawn at full alpha during this time
+	fadeFrameCnt int // then fade out during this time
+	ageFrameCnt  int
+	drawOrder    int
+}
+
+func NewFlashEffect(holdFrameCnt int, fadeFrameCnt int, drawOrder int) *FlashEffectComp {
+	return &FlashEffectComp {
+		color: color.RGBA{255, 255, 255, 255},
+		holdFrameCnt: holdFrameCnt,
+		fadeFrameCnt: fadeFrameCnt,
+		drawOrder: drawOrder,
+	}
+}
+
+func (f *FlashEffectComp) activate(isActive bool) {
+	if isActive {
+		f.state = StateActive
+		f.ageFrameCnt = 0
+	} else {
+		f.state = StateInactive
+	}
+}
+
+func (f *FlashEffectComp) reset() {
+	f.state = StateInactive
+}
+
+func (f *FlashEffectComp) update(paused bool, frameCnt int) {
+	if f.state == StateInactive {
+		return
+	}
+
+	if f.ageFrameCnt < f.holdFrameCnt+f.fadeFrameCnt {
+		f.ageFrameCnt++
+	} else {
+		f.state = StateInactive
+	}
+}
+
+func (f *FlashEffectComp) draw(screen *ebiten.Image) {
+	if f.state != StateInactive {
+		// the color is premultiplied by alpha
+		alpha := f.getAlpha()
+		c := color.RGBA{uint8(float32(f.color.R) * alpha), uint8(float32(f.color.G) * alpha), uint8(float32(f.color.B) * alpha), uint8(float32(f.color.A) * alpha)}
+		for _, r := range f.regions {
+			vector.DrawFilledRect(screen, float32(r.pos.x), float32(r.pos.y), float32(r.size.w), float32(r.size.h), c, false)
+		}
+	}
+}
+
+/*
+Returns the opacity of the flash in [0,1].
+*/
+func (f *FlashEffectComp) getAlpha() float32 {
+	fadeAge := f.ageFrameCnt - f.holdFrameCnt
+	if fadeAge <= 0 {
+		return 1
+	}
+	return max(0, 1-float32(fadeAge)/float32(max(f.fadeFrameCnt, 1)))
+}
+
+func (f *FlashEffectComp) getDrawOrder() int {
+  return f.drawOrder
+}
+
+func (f *FlashEffectComp) getPriority() int {
+  return PriorityDefault
+}
+
+func (f *FlashEffectComp) getState() ComponentState {
+	return f.state
+}
+
+/*
+SetRegions sets the rectangles to be flashed, in screen CS.
+*/
+func (f *FlashEffectComp) SetRegions(rects []Rect) {
+	f.regions = rects
+}
+
+/*
+SetColor sets the color of the flash.
+*/
+func (f *FlashEffectComp) SetColor(c color.RGBA) {
+	f.color = c
+}
+
+//
+// ------------ ParticleSystem ------------
+//
+type Particle struct {
+	pos              PosF
+	velocity         PosF // pixels per frame
+	lifetimeFrameCnt int  // remaining lifetime. the particle is dead at 0
+}
+
+type ParticleSystemComp struct {
+	state            ComponentState
+	particles        []Particle // the pool of the particles
+	lifetimeFrameCnt int        // max lifetime of a particle
+	particleSize     float32
+	color            color.RGBA
+	drawOrder        int
+}
+
+func NewParticleSystem(particleCnt int, lifetimeFrameCnt int, particleSize float32, color color.RGBA, drawOrder int) *ParticleSystemComp {
+	return &ParticleSystemComp {
+		particles: make([]Particle, particleCnt),
+		lifetimeFrameCnt: lifetimeFrameCnt,
+		particleSize: particleSize,
+		color: color,
+		drawOrder: drawOrder,
+	}
+}
+
+func (p *ParticleSystemComp) activate(isActive bool) {
+	if isActive {
+		p.state = StateActive
+	} else {
+		p.state = StateInactive
+	}
+}
+
+func (p *ParticleSystemComp) reset() {
+	p.state = StateInactive
+}
+
+func (p *ParticleSystemComp) update(paused bool, frameCnt int) {
+	if p.state == StateInactive {
+		return
+	}
+
+	alive := false
+	for i := range p.particles {
+		particle := &p.particles[i]
+		if 0 < particle.lifetimeFrameCnt {
+			particle.pos.x += particle.velocity.x
+			particle.pos.y += particle.velocity.y
+			particle.lifetimeFrameCnt--
+			alive = alive || 0 < particle.lifetimeFrameCnt
+		}
+	}
+
+	if !alive {
+		p.state = StateInactive
+	}
+}
+
+func (p *ParticleSystemComp) draw(screen *ebiten.Image) {
+	if p.state != StateInactive {
+		for _, particle := range p.particles {
+			if 0 < particle.lifetimeFrameCnt {
+				// fade out with the remaining lifetime. the color is premultiplied by alpha
+				alpha := float32(particle.lifetimeFrameCnt) / float32(p.lifetimeFrameCnt)
+				c := color.RGBA{uint8(float32(p.color.R) * alpha), uint8(float32(p.color.G) * alpha), uint8(float32(p.color.B) * alpha), uint8(float32(p.color.A) * alpha)}
+				vector.DrawFilledRect(screen, float32(particle.pos.x)-p.particleSize/2, float32(particle.pos.y)-p.particleSize/2, p.particleSize, p.particleSize, c, false)
+			}
+		}
+	}
+}
+
+func (p *ParticleSystemComp) getDrawOrder() int {
+  return p.drawOrder
+}
+
+func (p *ParticleSystemComp) getPriority() int {
+  return PriorityDefault
+}
+
+func (p *ParticleSystemComp) getState() ComponentState {
+	return p.state
+}
+
+/*
+burst scatters all particles of the pool from the center in random directions, then activates the component.
+The particles travel at most blastRadius pixels during their lifetime.
+*/
+func (p *ParticleSystemComp) burst(center Pos, blastRadius float64) {
+	for i := range p.particles {
+		angle := rand.Float64() * 2 * math.Pi
+		speed := blastRadius / float64(p.lifetimeFrameCnt) * (0.5 + rand.Float64()/2)
+		p.particles[i] = Particle {
+			pos: PosF{float64(center.x), float64(center.y)},
+			velocity: PosF{speed * math.Cos(angle), speed * math.Sin(angle)},
+			lifetimeFrameCnt: p.lifetimeFrameCnt/2 + rand.Intn(p.lifetimeFrameCnt/2+1),
+		}
+	}
+	p.activate(true)
+}
+
+//
+// ------------ ScreenShake ------------
+//
+type ScreenShakeComp struct {
+	state            ComponentState
+	durationFrameCnt int // length of the current shake
+	remainingFrameCnt int
+	maxDisplacement  int // max displacement in pixels at the start of the shake
+	offset           Pos // displacement of the screen in the current frame
+	buffer           *ebiten.Image // copy of the screen being shaken
+	drawOrder        int
+}
+
+/*
+NewScreenShake creates the shake component. Its draw order must be the highest one, since it shakes
+everything drawn before it.
+*/
+func NewScreenShake(drawOrder int) *ScreenShakeComp {
+	return &ScreenShakeComp {
+		drawOrder: drawOrder,
+	}
+}
+
+func (s *ScreenShakeComp) activate(isActive bool) {
+	if isActive {
+		s.state = StateActive
+	} else {
+		s.state = StateInactive
+		s.offset = Pos{}
+	}
+}
+
+func (s *ScreenShakeComp) reset() {
+	s.activate(false)
+}
+
+func (s *ScreenShakeComp) update(paused bool, frameCnt int) {
+	if s.state == StateInactive {
+		return
+	}
+
+	if s.remainingFrameCnt <= 0 {
+		s.activate(false)
+		return
+	}
+
+	// the displacement dampens linearly
+	maxDisp := s.maxDisplacement * s.remainingFrameCnt / s.durationFrameCnt
+	s.offset = Pos{rand.Intn(2*maxDisp+1) - maxDisp, rand.Intn(2*maxDisp+1) - maxDisp}
+	s.remainingFrameCnt--
+}
+
+/*
+draw translates the content of the screen by the current offset.
+*/
+func (s *ScreenShakeComp) draw(screen *ebiten.Image) {
+	if s.state == StateInactive || s.offset == (Pos{}) {
+		return
+	}
+
+	if s.buffer == nil || s.buffer.Bounds() != screen.Bounds() {
+		s.buffer = ebiten.NewImage(screen.Bounds().Dx(), screen.Bounds().Dy())
+	}
+	s.buffer.Clear()
+	s.buffer.DrawImage(screen, nil)
+
+	screen.Clear()
+	op := &ebiten.DrawImageOptions{}
+	op.GeoM.Translate(float64(s.offset.x), float64(s.offset.y))
+	screen.DrawImage(s.buffer, op)
+}
+
+func (s *ScreenShakeComp) getDrawOrder() int {
+  return s.drawOrder
+}
+
+func (s *ScreenShakeComp) getPriority() int {
+  return PriorityDefault
+}
+
+func (s *ScreenShakeComp) getState() ComponentState {
+	return s.state
+}
+
+/*
+SetShake starts shaking the screen for the duration. A running shake is restarted.
+*/
+func (s *ScreenShakeComp) SetShake(durationFrames, maxPixels int) {
+	if durationFrames <= 0 {
+		return
+	}
+	s.durationFrameCnt = durationFrames
+	s.remainingFrameCnt = durationFrames
+	s.maxDisplacement = maxPixels
+	s.activate(true)
+}
+
+//
+// ------------ FloatingTextEffect ------------
+//
+type FloatingTextComp struct {
+	state            ComponentState
+	text             string
+	pos              Pos // center of the text at the start of the effect
+	riseDist         int // the text rises by this distance in pixels during the effect
+	lifetimeFrameCnt int
+	ageFrameCnt      int
+	drawOrder        int
+	color            color.RGBA
+}
+
+func NewFloatingText(riseDist int, lifetimeFrameCnt int, drawOrder int) *FloatingTextComp {
+	return &FloatingTextComp {
+		riseDist: riseDist,
+		lifetimeFrameCnt: lifetimeFrameCnt,
+		drawOrder: drawOrder,
+		color: color.RGBA{255, 255, 255, 255},
+	}
+}
+
+/*
+WithColor sets the color of the text. Returns the component for chaining.
+*/
+func (f *FloatingTextComp) WithColor(c color.RGBA) *FloatingTextComp {
+	f.color = c
+	return f
+}
+
+func (f *FloatingTextComp) activate(isActive bool) {
+	if isActive {
+		f.state = StateActive
+		f.ageFrameCnt = 0
+	} else {
+		f.state = StateInactive
+	}
+}
+
+func (f *FloatingTextComp) reset() {
+	f.state = StateInactive
+}
+
+func (f *FloatingTextComp) update(paused bool, frameCnt int) {
+	if f.state == StateInactive {
+		return
+	}
+
+	if f.ageFrameCnt < f.lifetimeFrameCnt {
+		f.ageFrameCnt++
+	} else {
+		f.state = StateInactive
+	}
+}
+
+func (f *FloatingTextComp) draw(screen *ebiten.Image) {
+	if f.state != StateInactive {
+		agePercent := float64(f.ageFrameCnt) / float64(f.lifetimeFrameCnt)
+
+		op := &text.DrawOptions{}
+		op.GeoM.Translate(float64(f.pos.x), float64(f.pos.y) - float64(f.riseDist)*agePercent)
+		op.PrimaryAlign = text.AlignCenter
+		op.ColorScale.ScaleWithColor(f.color)
+		op.ColorScale.ScaleAlpha(float32(1 - agePercent))
+		text.Draw(screen, f.text, normTextFace, op)
+	}
+}
+
+func (f *FloatingTextComp) getDrawOrder() int {
+  return f.drawOrder
+}
+
+func (f *FloatingTextComp) getPriority() int {
+  return PriorityDefault
+}
+
+func (f *FloatingTextComp) getState() ComponentState {
+	return f.state
+}
+
+/*
+show starts the effect with the text centered at the position. A running effect is restarted.
+*/
+func (f *FloatingTextComp) show(text string, pos Pos) {
+	f.text = text
+	f.pos = pos
+	f.activate(true)
+}
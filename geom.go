@@ -5,6 +5,7 @@ import (
 )
 
 type Pos struct{ x, y int }  // position in the grid
+type PosF struct{ x, y float64 } // sub-pixel position or velocity on the screen
 type Size struct{ w, h int } // dimensions of a piece in the grid
 
 type Rect struct {
@@ -12,6 +13,29 @@ type Rect struct {
 	size Size
 }
 
+/*
+the areas of the screen. The grid fills the play area left to the sidebar.
+*/
+type Layout struct {
+	playArea Rect
+	sidebar  Rect
+}
+
+func getLayout(screenSize Size, sidebarWidth int) Layout {
+	playWidth := screenSize.w - sidebarWidth
+	return Layout{
+		playArea: Rect{Pos{0, 0}, Size{playWidth, screenSize.h}},
+		sidebar: Rect{Pos{playWidth, 0}, Size{sidebarWidth, screenSize.h}},
+	}
+}
+
+/*
+getCellSize returns the largest cell size fitting the grid to the play area, at least 1.
+*/
+func (l Layout) getCellSize(gridSize Size) int {
+	return max(1, min(l.playArea.size.w/gridSize.w, l.playArea.size.h/gridSize.h))
+}
+
 func angleDegEq(ang1, ang2 int) bool {
 	return (ang1-ang2)%360 == 0
 }
@@ -46,12 +70,56 @@ func subPos(left Pos, right Pos) Pos {
 	return Pos{left.x - right.x, left.y - right.y}
 }
 
+/*
+Returns the bounding box of the pieces in grid CS. The box of no pieces is empty.
+*/
+func getBoundingBox(pieces []*Piece) Rect {
+	if len(pieces) == 0 {
+		return Rect{}
+	}
+
+	minPos := pieces[0].pos
+	maxPos := pieces[0].pos
+	for _, p := range pieces {
+		size := rotateSize(p.size, p.currentRotation)
+		minPos = Pos{min(minPos.x, p.pos.x), min(minPos.y, p.pos.y)}
+		maxPos = Pos{max(maxPos.x, p.pos.x+size.w), max(maxPos.y, p.pos.y+size.h)}
+	}
+
+	return Rect{minPos, Size{maxPos.x - minPos.x, maxPos.y - minPos.y}}
+}
+
+/*
+Returns the mean of the piece centers in screen CS.
+*/
+func getCentroid(pieces []*Piece) PosF {
+	var centroid PosF
+	for _, p := range pieces {
+		r := getPieceScrRect(p)
+		centroid.x += float64(r.pos.x) + float64(r.size.w)/2
+		centroid.y += float64(r.pos.y) + float64(r.size.h)/2
+	}
+	if 0 < len(pieces) {
+		centroid.x /= float64(len(pieces))
+		centroid.y /= float64(len(pieces))
+	}
+	return centroid
+}
+
+/*
+Returns the rectangle of the piece in screen CS.
+*/
+func getPieceScrRect(piece *Piece) Rect {
+	size := rotateSize(piece.size, piece.currentRotation)
+	return Rect{Pos{piece.pos.x * scale, piece.pos.y * scale}, Size{size.w * scale, size.h * scale}}
+}
+
 func grid2ScrPos(x, y float32) (float32, float32) {
-	return x * scale, y * scale
+	return x * float32(scale), y * float32(scale)
 }
 
 func grid2ScrSize(w, h float32) (float32, float32) {
-	return w * scale, h * scale
+	return w * float32(scale), h * float32(scale)
 }
 
 func isWithinBounds(pos Pos, size Size, boundsMin, boundsMax Pos) bool {
@@ -62,10 +130,6 @@ func isOverlap(pos1 Pos, size1 Size, pos2 Pos, size2 Size) bool {
 	return pos1.x < pos2.x+size2.w && pos2.x < pos1.x+size1.w && pos1.y < pos2.y+size2.h && pos2.y < pos1.y+size1.h
 }
 
-func (piece *Piece) isColliding(pos Pos, size Size) bool {
-	return pos.x < piece.pos.x+size.w && piece.pos.x < pos.x+piece.size.w && pos.y < piece.pos.y+size.h && piece.pos.y < pos.y+piece.size.h
-}
-
 /*
 getRotationTheta converts degrees to radians.
 
@@ -78,3 +142,10 @@ Returns:
 func getRotationTheta(deg int) float64 {
 	return float64(deg) * (math.Pi / 180)
 }
+
+func abs(a int) int {
+	if a < 0 {
+		return -a
+	}
+	return a
+}
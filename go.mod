@@ -2,7 +2,10 @@ module testris
 
 go 1.23
 
-require github.com/hajimehoshi/ebiten/v2 v2.8.5
+require (
+	github.com/BurntSushi/toml v1.4.0
+	github.com/hajimehoshi/ebiten/v2 v2.8.5
+)
 
 require (
 	github.com/ebitengine/gomobile v0.0.0-20240911145611-4856209ac325 // indirect
@@ -1,32 +1,75 @@
 package main
 
 import (
+	"encoding/json"
+	"fmt"
 	"log"
+	"math"
+	"slices"
 	"sort"
 
 	"github.com/hajimehoshi/ebiten/v2"
 	"github.com/hajimehoshi/ebiten/v2/vector"
 )
 
+/*
+a potential body match in the grid: the body would be complete if the missing pieces were present
+*/
+type CandidateMatch struct {
+	body      *Body
+	positions []Pos    // grid positions of the body pieces, in the order of body.bodyPieces
+	rotations []int    // required rotations of the pieces at the positions
+	pieces    []*Piece // locked piece at each position, nil if missing
+}
+
+/*
+Returns the ratio of the present pieces of the candidate, in [0,1].
+*/
+func (c CandidateMatch) getProgress() float32 {
+	return float32(len(c.pieces)-c.getMissingCnt()) / float32(len(c.pieces))
+}
+
+func (c CandidateMatch) getMissingCnt() int {
+	cnt := 0
+	for _, piece := range c.pieces {
+		if piece == nil {
+			cnt++
+		}
+	}
+	return cnt
+}
+
 type GridComp struct {
 	size         Size
 	content      [][]*Piece // Store piece references for each grid cell
 	lockedPieces []*Piece   // Array to store locked pieces, sorted first by y then x coordinate
+	lockOrderIndex int      // counts the locked pieces. used for the unlock strategies (oldest/newest first)
+	showHints    bool       // draw the body candidates over the grid
+	adjacentPairCnt int     // nr of locked piece pairs sharing a side. maintained by changePieceInGrid
+	typeIndex    map[string][]*Piece // locked pieces by piece type, sorted like lockedPieces. maintained by changePieceInGrid
 	state        ComponentState
 	drawOrder    int
+	frameCnt     int // the danger overlay pulses by this
+	lockedPieceCache *ebiten.Image // the locked pieces rendered at the current scale
+	cacheDirty   bool // lockedPieceCache is re-rendered at the next draw. set when the locked pieces change
+	batch        *BatchRenderer // renders the locked pieces to lockedPieceCache
+	bestCandidate *CandidateMatch // cached by getBestCandidate, nil if there is no candidate
+	candidateDirty bool // bestCandidate is searched again. set when the locked pieces change
 }
 
 func NewGridComp(size Size, drawOrder int) *GridComp {
 	// allocate grid
 	theGrid := make([][]*Piece, size.w)
-	for i := 0; i < gridSize.w; i++ {
+	for i := 0; i < size.w; i++ {
 		theGrid[i] = make([]*Piece, size.h)
 	}
 
 	return &GridComp {
 		size: size,
 		content: theGrid,
+		typeIndex: map[string][]*Piece{},
 		drawOrder: drawOrder,
+		batch: NewBatchRenderer(),
 	}
 }
 
@@ -46,16 +89,41 @@ func (g *GridComp) reset() {
 	}
 
 	g.lockedPieces = nil
+	g.cacheDirty = true
+	g.candidateDirty = true
+	g.lockOrderIndex = 0
+	g.adjacentPairCnt = 0
+	clear(g.typeIndex)
+}
+
+/*
+resize changes the size of the grid. The locked pieces are removed, the state is kept.
+*/
+func (g *GridComp) resize(size Size) {
+	state := g.state
+	g.size = size
+	g.content = make([][]*Piece, size.w)
+	g.reset()
+	g.state = state
 }
 
 func (g *GridComp) update(gamePaused bool, frameCnt int) {
-	// grid has no dynamic behavior
+	g.frameCnt = frameCnt
 }
 
 func (g *GridComp) draw(screen *ebiten.Image) {
 	if g.state != StateInactive {
 		g.drawLockedPieces(screen)
+		g.drawDangerZone(screen)
 		g.drawBorder(screen)
+
+		if g.showHints {
+			g.drawHints(screen)
+		}
+
+		if showDebugOverlay {
+			g.drawDebugOverlay(screen)
+		}
 	}
 }
 
@@ -63,27 +131,120 @@ func (g *GridComp) getDrawOrder() int {
   return g.drawOrder
 }
 
+func (g *GridComp) getPriority() int {
+  return PriorityDefault
+}
+
 func (g *GridComp) getState() ComponentState {
 	return g.state
 }
 
 /*
 drawLockedPieces renders all locked pieces on the grid.
+The pieces are rendered to lockedPieceCache only when they changed or the scale changed, the cache is drawn otherwise.
+The pieces of the same image are rendered together by the batch renderer.
 
 Parameters:
 - screen: The ebiten.Image to draw the locked pieces onto.
 */
 func (g *GridComp) drawLockedPieces(screen *ebiten.Image) {
-	for _, lp := range g.lockedPieces {
-		op := &ebiten.DrawImageOptions{}
+	w, h := g.size.w*scale, g.size.h*scale
+	if g.lockedPieceCache == nil || g.lockedPieceCache.Bounds().Dx() != w || g.lockedPieceCache.Bounds().Dy() != h {
+		if g.lockedPieceCache != nil {
+			g.lockedPieceCache.Deallocate()
+		}
+		g.lockedPieceCache = ebiten.NewImage(w, h)
+		g.cacheDirty = true
+	}
+
+	if g.cacheDirty {
+		g.lockedPieceCache.Clear()
+		for _, lp := range g.lockedPieces {
+			op := &ebiten.DrawImageOptions{}
+
+			// Calculate the top-left corner of the locked piece in screen coordinates.
 
-		// Calculate the top-left corner of the locked piece in screen coordinates.
+			applyRotationToPiece(op, lp)
+			g.batch.add(lp.image, op)
+		}
+		g.batch.flush(g.lockedPieceCache)
+		g.cacheDirty = false
+	}
+	screen.DrawImage(g.lockedPieceCache, nil)
+}
+
+/*
+drawDebugOverlay labels the occupied cells by the first letter of the piece type and the rotation,
+and outlines the empty cells of the playable area.
+*/
+func (g *GridComp) drawDebugOverlay(screen *ebiten.Image) {
+	for x := 1; x < g.size.w-1; x++ {
+		for y := 0; y < g.size.h-1; y++ {
+			scrX, scrY := grid2ScrPos(float32(x), float32(y))
+			if piece := g.content[x][y]; piece != nil {
+				label := fmt.Sprintf("%c%d", piece.pieceType[0], ((piece.currentRotation%360)+360)%360)
+				renderText(screen, label, int(scrX)+2, int(scrY)+2, smallTextFace)
+			} else {
+				vector.StrokeRect(screen, scrX, scrY, float32(scale), float32(scale), 1, debugEmptyCellColor, false)
+			}
+		}
+	}
+}
+
+/*
+drawHints marks the cells of the body candidates. Present pieces are green, missing ones are red.
+*/
+func (g *GridComp) drawHints(screen *ebiten.Image) {
+	for _, body := range allBodies {
+		for _, candidate := range g.findBodyCandidates(body) {
+			for i, pos := range candidate.positions {
+				hintColor := hintMissingColor
+				if candidate.pieces[i] != nil {
+					hintColor = hintPresentColor
+				}
 
-		applyRotationToPiece(op, lp)
-		screen.DrawImage(lp.image, op)
+				x, y := grid2ScrPos(float32(pos.x), float32(pos.y))
+				w, h := grid2ScrSize(1, 1)
+				vector.StrokeRect(screen, x+2, y+2, w-4, h-4, 2, hintColor, false)
+			}
+		}
 	}
 }
 
+/*
+Returns the ratio of the occupied cells in the top dangerRows rows, in [0,1].
+*/
+func (g *GridComp) getDangerLevel() float64 {
+	rows := min(dangerRows, g.size.h)
+	occupiedCnt := 0
+	for x := 1; x < g.size.w-1; x++ {
+		for y := 0; y < rows; y++ {
+			if g.content[x][y] != nil {
+				occupiedCnt++
+			}
+		}
+	}
+	return float64(occupiedCnt) / float64(max((g.size.w-2)*rows, 1))
+}
+
+/*
+drawDangerZone overlays the top rows by a pulsing red while locked pieces are there.
+The fuller the danger zone, the stronger the red.
+*/
+func (g *GridComp) drawDangerZone(screen *ebiten.Image) {
+	level := g.getDangerLevel()
+	if level == 0 {
+		return
+	}
+
+	pulse := 0.75 + 0.25*math.Sin(2*math.Pi*float64(g.frameCnt)/float64(dangerZonePulseFrameCnt))
+	c := dangerZoneColor
+	c.A = uint8(255 * min(1, level*dangerZoneMaxAlpha*pulse))
+	x, y := grid2ScrPos(1, 0)
+	w, h := grid2ScrSize(float32(g.size.w-2), float32(min(dangerRows, g.size.h)))
+	vector.DrawFilledRect(screen, x, y, w, h, c, false)
+}
+
 /*
 drawBorder draws a border around the game area.
 
@@ -94,7 +255,7 @@ func (g *GridComp) drawBorder(screen *ebiten.Image) {
 	x, y := grid2ScrPos(0.5, -0.5)
 	w, h := grid2ScrSize(float32(g.size.w-1), float32(g.size.h))
 	// draw a rectangle with thick border. the top border is invisible (intentionally outside of the screen) intentionally.
-	vector.StrokeRect(screen, x, y, w, h, scale, boundingBoxColor, false)
+	vector.StrokeRect(screen, x, y, w, h, float32(scale), boundingBoxColor, false)
 }
 
 func (g *GridComp) getPiece(p Pos) *Piece {
@@ -119,9 +280,12 @@ func (g *GridComp) canMove(piece *Piece, dx, dy int) bool {
 		return false
 	}
 
-	for _, piece := range g.lockedPieces {
-		if piece.isColliding(newPos, size) {
-			return false
+	// the cells the piece would occupy are looked up in the content matrix
+	for x := newPos.x; x < newPos.x+size.w; x++ {
+		for y := newPos.y; y < newPos.y+size.h; y++ {
+			if g.content[x][y] != nil {
+				return false
+			}
 		}
 	}
 
@@ -137,10 +301,19 @@ func (g *GridComp) drop(piece *Piece) {
 	}
 }
 
-func (g *GridComp) joinPieces(changedPieces []*Piece) ([]*Body, []*Piece) {
+/*
+getLandingPos returns the position where the piece would land if it is dropped. The piece is not moved.
+*/
+func (g *GridComp) getLandingPos(piece *Piece) Pos {
+	ghost := *piece
+	g.drop(&ghost)
+	return ghost.pos
+}
+
+func (g *GridComp) joinPieces(changedPieces []*Piece) ([]BodyMatch, []*Piece) {
 	log.Printf("joinPieces(changedPieces: %v)", changedPieces)
 
-	var bodies []*Body
+	var matches []BodyMatch
 	var joinedPieces []*Piece
 	for 0 < len(changedPieces) {
 		// dequeue first piece
@@ -149,25 +322,46 @@ func (g *GridComp) joinPieces(changedPieces []*Piece) ([]*Body, []*Piece) {
 
 		if piece != nil {
 			for _, body := range allBodies {
-				pieces := body.matchAtLockedPiece(g, piece)
+				match := body.matchAtLockedPiece(g, piece)
 
-				if 0 < len(pieces) {
-					g.unlockPieces(pieces)
-					joinedPieces = append(joinedPieces, pieces...)
-					bodies = append(bodies, body)
+				if match != nil {
+					g.unlockPieces(match.pieces)
+					joinedPieces = append(joinedPieces, match.pieces...)
+					matches = append(matches, *match)
 				}
 			}
 		}
 	}
 
-	return bodies, joinedPieces
+	return matches, joinedPieces
 }
 
 func (g *GridComp) compactGrid() []*Piece {
+	return g.compactPieces(slices.Clone(g.lockedPieces))
+}
+
+/*
+applyGravityAfterClear lets the pieces fall after the rows have been cleared.
+Only the pieces above the lowest cleared row can fall, the ones below keep their support.
+Returns the fallen pieces.
+*/
+func (g *GridComp) applyGravityAfterClear(clearedRows []int) []*Piece {
+	if len(clearedRows) == 0 {
+		return []*Piece{}
+	}
+
+	return g.compactPieces(g.piecesAboveRow(slices.Max(clearedRows)))
+}
+
+/*
+compactPieces moves the given locked pieces down as far as possible.
+The pieces are expected in the order of the locked list and processed bottom-up.
+*/
+func (g *GridComp) compactPieces(pieces []*Piece) []*Piece {
 	fallenPieces := make([]*Piece, 0)
 
-	for i := len(g.lockedPieces) - 1; 0 <= i; i-- {
-		piece := g.lockedPieces[i]
+	for i := len(pieces) - 1; 0 <= i; i-- {
+		piece := pieces[i]
 
 		// check if piece can fall
 		size := rotateSize(piece.size, piece.currentRotation)
@@ -205,6 +399,12 @@ func (g *GridComp) lockPiece(piece *Piece) {
 		log.Fatalf("The piece %v is not expected in the locked list!", piece)
 	}
 
+	// remember the locking order. a piece re-locked after falling keeps its original order
+	if piece.lockOrderIndex == 0 {
+		g.lockOrderIndex++
+		piece.lockOrderIndex = g.lockOrderIndex
+	}
+
 	// insert to sorted list
 	g.lockedPieces = append(g.lockedPieces, nil)
 	copy(g.lockedPieces[idx+1:], g.lockedPieces[idx:])
@@ -214,6 +414,63 @@ func (g *GridComp) lockPiece(piece *Piece) {
 	g.changePieceInGrid(piece, true)
 }
 
+/*
+lockPieceBatch locks several pieces at once. The pieces are sorted and merged into the locked list
+in one pass, instead of inserting them one by one.
+*/
+func (g *GridComp) lockPieceBatch(pieces []*Piece) {
+	// remember the locking order in the order of the input
+	for _, piece := range pieces {
+		if piece.lockOrderIndex == 0 {
+			g.lockOrderIndex++
+			piece.lockOrderIndex = g.lockOrderIndex
+		}
+	}
+
+	sorted := slices.Clone(pieces)
+	sort.Slice(sorted, func(i, j int) bool { return isLockedBefore(sorted[i], sorted[j]) })
+
+	// merge the sorted lists
+	merged := make([]*Piece, 0, len(g.lockedPieces)+len(sorted))
+	i, j := 0, 0
+	for i < len(g.lockedPieces) || j < len(sorted) {
+		if j == len(sorted) || (i < len(g.lockedPieces) && isLockedBefore(g.lockedPieces[i], sorted[j])) {
+			merged = append(merged, g.lockedPieces[i])
+			i++
+		} else {
+			if 0 < len(merged) && merged[len(merged)-1] == sorted[j] || i < len(g.lockedPieces) && g.lockedPieces[i] == sorted[j] {
+				log.Fatalf("The piece %v is not expected in the locked list!", sorted[j])
+			}
+			merged = append(merged, sorted[j])
+			j++
+		}
+	}
+	g.lockedPieces = merged
+
+	// add references to the locked pieces in the grid
+	for _, piece := range sorted {
+		g.changePieceInGrid(piece, true)
+	}
+}
+
+/*
+sortLockedPieces restores the order of the locked list after bulk mutations of the piece positions.
+*/
+func (g *GridComp) sortLockedPieces() {
+	sort.Slice(g.lockedPieces, func(i, j int) bool { return isLockedBefore(g.lockedPieces[i], g.lockedPieces[j]) })
+}
+
+func (g *GridComp) isSorted() bool {
+	return sort.SliceIsSorted(g.lockedPieces, func(i, j int) bool { return isLockedBefore(g.lockedPieces[i], g.lockedPieces[j]) })
+}
+
+/*
+isLockedBefore returns if piece a precedes piece b in the locked list, i.e. sorted first by y then x coordinate.
+*/
+func isLockedBefore(a, b *Piece) bool {
+	return a.pos.y < b.pos.y || (a.pos.y == b.pos.y && a.pos.x < b.pos.x)
+}
+
 /*
 Remove piece from the locked list and grid matrix.
 */
@@ -240,10 +497,82 @@ func (g *GridComp) unlockPieces(pieces []*Piece) {
 	}
 }
 
+/*
+Unlocks the n pieces locked first (FIFO). Returns the unlocked pieces.
+*/
+func (g *GridComp) unlockOldestN(n int) []*Piece {
+	pieces := g.getPiecesByLockOrder()
+	pieces = pieces[:min(n, len(pieces))]
+	g.unlockPieces(pieces)
+
+	return pieces
+}
+
+/*
+Unlocks the n pieces locked last (LIFO). Returns the unlocked pieces, the newest first.
+*/
+func (g *GridComp) unlockNewestN(n int) []*Piece {
+	pieces := g.getPiecesByLockOrder()
+	slices.Reverse(pieces)
+	pieces = pieces[:min(n, len(pieces))]
+	g.unlockPieces(pieces)
+
+	return pieces
+}
+
+/*
+Returns the locked pieces sorted by the locking order, the oldest first.
+*/
+func (g *GridComp) getPiecesByLockOrder() []*Piece {
+	pieces := slices.Clone(g.lockedPieces)
+	sort.Slice(pieces, func(i, j int) bool { return pieces[i].lockOrderIndex < pieces[j].lockOrderIndex })
+
+	return pieces
+}
+
+/*
+Returns the index of the first locked piece positioned in row y or below.
+*/
+func (g *GridComp) getRowStartIdx(y int) int {
+	return sort.Search(len(g.lockedPieces), func(i int) bool {
+		return y <= g.lockedPieces[i].pos.y
+	})
+}
+
+/*
+Returns the locked pieces positioned above row y, in the order of the locked list.
+*/
+func (g *GridComp) piecesAboveRow(y int) []*Piece {
+	return slices.Clone(g.lockedPieces[:g.getRowStartIdx(y)])
+}
+
+/*
+Returns the locked pieces positioned below row y, in the order of the locked list.
+*/
+func (g *GridComp) piecesBelowRow(y int) []*Piece {
+	return slices.Clone(g.lockedPieces[g.getRowStartIdx(y+1):])
+}
+
 /*
 add/remove references to the locked piece in the grid
 */
 func (g *GridComp) changePieceInGrid(piece *Piece, add bool) {
+	g.cacheDirty = true
+	g.candidateDirty = true
+	typePieces := g.typeIndex[piece.pieceType]
+	if add {
+		g.adjacentPairCnt += len(g.getAdjacentPieces(piece))
+
+		idx := sort.Search(len(typePieces), func(i int) bool { return !isLockedBefore(typePieces[i], piece) })
+		g.typeIndex[piece.pieceType] = slices.Insert(typePieces, idx, piece)
+	} else {
+		g.adjacentPairCnt -= len(g.getAdjacentPieces(piece))
+
+		if idx := slices.Index(typePieces, piece); 0 <= idx {
+			g.typeIndex[piece.pieceType] = slices.Delete(typePieces, idx, idx+1)
+		}
+	}
+
 	rotatedSize := rotateSize(piece.size, piece.currentRotation)
 	for x := piece.pos.x; x < piece.pos.x+rotatedSize.w; x++ {
 		for y := piece.pos.y; y < piece.pos.y+rotatedSize.h; y++ {
@@ -256,21 +585,622 @@ func (g *GridComp) changePieceInGrid(piece *Piece, add bool) {
 	}
 }
 
-func (g *GridComp) getPiecesBelow(piece *Piece) []*Piece {
+/*
+Returns the distinct pieces in the grid sharing a side with the piece.
+*/
+func (g *GridComp) getAdjacentPieces(piece *Piece) []*Piece {
+	var adjacentPieces []*Piece
+
+	size := rotateSize(piece.size, piece.currentRotation)
+	for x := piece.pos.x; x < piece.pos.x+size.w; x++ {
+		for y := piece.pos.y; y < piece.pos.y+size.h; y++ {
+			for _, d := range []Pos{{-1, 0}, {1, 0}, {0, -1}, {0, 1}} {
+				neighborPos := Pos{x + d.x, y + d.y}
+				if !isWithinBounds(neighborPos, Size{1, 1}, Pos{0, 0}, Pos{g.size.w, g.size.h}) {
+					continue
+				}
+
+				neighbor := g.content[neighborPos.x][neighborPos.y]
+				if neighbor != nil && neighbor != piece && !slices.Contains(adjacentPieces, neighbor) {
+					adjacentPieces = append(adjacentPieces, neighbor)
+				}
+			}
+		}
+	}
+
+	return adjacentPieces
+}
+
+/*
+Returns the locked pieces of the piece type, in the order of the locked list.
+The locked list is scanned if there is no type index, used by the benchmarks.
+*/
+func (g *GridComp) piecesByType(pieceType string) []*Piece {
+	if g.typeIndex == nil {
+		return slices.DeleteFunc(slices.Clone(g.lockedPieces), func(piece *Piece) bool { return piece.pieceType != pieceType })
+	}
+	return slices.Clone(g.typeIndex[pieceType])
+}
+
+func (g *GridComp) countPiecesByType(pieceType string) int {
+	if g.typeIndex == nil {
+		return len(g.piecesByType(pieceType))
+	}
+	return len(g.typeIndex[pieceType])
+}
+
+/*
+countSkyline returns the height of the locked pieces in each column of the game area, from left to right.
+*/
+func (g *GridComp) countSkyline() []int {
+	skyline := make([]int, 0, g.size.w-2)
+	for x := 1; x < g.size.w-1; x++ {
+		height := 0
+		for y := 0; y < g.size.h-1; y++ {
+			if g.content[x][y] != nil {
+				height = g.size.h - 1 - y
+				break
+			}
+		}
+		skyline = append(skyline, height)
+	}
+	return skyline
+}
+
+/*
+skylineBumpiness returns the sum of the absolute height differences between the adjacent columns.
+*/
+func (g *GridComp) skylineBumpiness() int {
+	skyline := g.countSkyline()
+
+	bumpiness := 0
+	for i := 1; i < len(skyline); i++ {
+		bumpiness += abs(skyline[i] - skyline[i-1])
+	}
+	return bumpiness
+}
+
+/*
+skylineMaxDrop returns the largest height difference between adjacent columns.
+*/
+func (g *GridComp) skylineMaxDrop() int {
+	skyline := g.countSkyline()
+
+	maxDrop := 0
+	for i := 1; i < len(skyline); i++ {
+		maxDrop = max(maxDrop, abs(skyline[i] - skyline[i-1]))
+	}
+	return maxDrop
+}
+
+/*
+countAdjacentPairs returns the nr of locked piece pairs sharing a side. more pairs means less gaps.
+*/
+func (g *GridComp) countAdjacentPairs() int {
+	return g.adjacentPairCnt
+}
+
+/*
+applyRowClear removes all pieces touching row y, then lets the pieces above fall.
+Returns the removed pieces.
+*/
+func (g *GridComp) applyRowClear(y int) []*Piece {
+	var removedPieces []*Piece
+	for _, piece := range g.lockedPieces {
+		size := rotateSize(piece.size, piece.currentRotation)
+		if piece.pos.y <= y && y < piece.pos.y+size.h {
+			removedPieces = append(removedPieces, piece)
+		}
+	}
+
+	g.unlockPieces(removedPieces)
+	g.applyGravityAfterClear([]int{y})
+
+	return removedPieces
+}
+
+/*
+removeRowRange removes all pieces touching any row in [yStart, yEnd], then compacts the grid.
+The range is clamped to the playable rows. Returns the removed pieces.
+*/
+func (g *GridComp) removeRowRange(yStart, yEnd int) []*Piece {
+	yStart = max(yStart, 0)
+	yEnd = min(yEnd, g.size.h-2)
+
+	var removedPieces []*Piece
+	for _, piece := range g.lockedPieces {
+		size := rotateSize(piece.size, piece.currentRotation)
+		if piece.pos.y <= yEnd && yStart < piece.pos.y+size.h {
+			removedPieces = append(removedPieces, piece)
+		}
+	}
+
+	g.unlockPieces(removedPieces)
+	g.compactGrid()
+
+	return removedPieces
+}
+
+/*
+transpose rotates the entire board by 90 degrees counterclockwise around the center of the grid.
+The rotation of each piece is adjusted by 90 degrees, so the joined bodies keep their shape.
+Pieces landing outside of the grid are removed and an error is returned.
+*/
+func (g *GridComp) transpose() error {
+	// center of rotation in doubled grid coordinates. the center must be chosen so that cells are mapped to cells
+	center2 := Pos{g.size.w, g.size.h - 1}
+	if (center2.x-center2.y)%2 != 0 {
+		center2.y++
+	}
+
+	pieces := g.lockedPieces
+	for _, piece := range pieces {
+		g.changePieceInGrid(piece, false)
+	}
+
+	var keptPieces, skippedPieces []*Piece
+	for _, piece := range pieces {
+		size := rotateSize(piece.size, piece.currentRotation)
+		pieceCenter2 := Pos{2*piece.pos.x + size.w, 2*piece.pos.y + size.h}
+		rotatedCenter2 := addPos(center2, rotatePos(subPos(pieceCenter2, center2), 270))
+
+		piece.currentRotation = (piece.currentRotation + 90) % 360
+		size = rotateSize(piece.size, piece.currentRotation)
+		piece.pos = Pos{(rotatedCenter2.x - size.w) / 2, (rotatedCenter2.y - size.h) / 2}
+
+		if !isWithinBounds(piece.pos, size, Pos{1, 0}, Pos{g.size.w - 1, g.size.h - 1}) {
+			skippedPieces = append(skippedPieces, piece)
+			continue
+		}
+
+		keptPieces = append(keptPieces, piece)
+	}
+
+	g.lockedPieces = keptPieces
+	g.sortLockedPieces()
+	for _, piece := range g.lockedPieces {
+		g.changePieceInGrid(piece, true)
+	}
+
+	if 0 < len(skippedPieces) {
+		return fmt.Errorf("%d pieces are outside of the grid after transposing: %v", len(skippedPieces), skippedPieces)
+	}
+
+	return nil
+}
+
+/*
+applyTranslation shifts all locked pieces by (dx, dy).
+Pieces shifted outside of the grid are removed and an error is returned.
+*/
+func (g *GridComp) applyTranslation(dx, dy int) error {
+	pieces := g.lockedPieces
+	for _, piece := range pieces {
+		g.changePieceInGrid(piece, false)
+	}
+
+	var keptPieces, skippedPieces []*Piece
+	for _, piece := range pieces {
+		piece.pos = addPos(piece.pos, Pos{dx, dy})
+
+		size := rotateSize(piece.size, piece.currentRotation)
+		if !isWithinBounds(piece.pos, size, Pos{1, 0}, Pos{g.size.w - 1, g.size.h - 1}) {
+			skippedPieces = append(skippedPieces, piece)
+			continue
+		}
+
+		keptPieces = append(keptPieces, piece)
+	}
+
+	g.lockedPieces = keptPieces
+	g.sortLockedPieces()
+	for _, piece := range g.lockedPieces {
+		g.changePieceInGrid(piece, true)
+	}
+
+	if 0 < len(skippedPieces) {
+		return fmt.Errorf("%d pieces are outside of the grid after shifting by (%d, %d): %v", len(skippedPieces), dx, dy, skippedPieces)
+	}
+
+	return nil
+}
+
+/*
+findBodyCandidates returns all placements of the body where each body piece is either present
+or missing in the grid. Complete bodies and placements without any present piece are not returned.
+*/
+func (g *GridComp) findBodyCandidates(body *Body) []CandidateMatch {
+	type placement struct {
+		pos      Pos // grid position of the first body piece
+		rotation int // rotation of the body CS
+	}
+
+	var candidates []CandidateMatch
+	found := map[placement]bool{}
+
+	for _, lockedPiece := range g.lockedPieces {
+		for _, idx := range body.pieceTypeToIdx[lockedPiece.pieceType] {
+			bodyCsOrigin := body.bodyPieces[idx].pos
+			bodyCsRotation := body.bodyPieces[idx].rotation - lockedPiece.currentRotation
+
+			candidate := CandidateMatch{body: body}
+			for _, bp := range body.bodyPieces {
+				posGridCs := addPos(lockedPiece.pos, rotatePos(subPos(bp.pos, bodyCsOrigin), bodyCsRotation))
+				rotation := ((bp.rotation-bodyCsRotation)%360 + 360) % 360
+
+				if !isWithinBounds(posGridCs, Size{1, 1}, Pos{1, 0}, Pos{g.size.w - 1, g.size.h - 1}) {
+					candidate.positions = nil
+					break
+				}
+
+				piece := g.getPiece(posGridCs)
+				if piece != nil && (piece.pieceType != bp.pieceType || piece.pos != posGridCs || !angleDegEq(piece.currentRotation, rotation)) {
+					candidate.positions = nil
+					break
+				}
+
+				candidate.positions = append(candidate.positions, posGridCs)
+				candidate.rotations = append(candidate.rotations, rotation)
+				candidate.pieces = append(candidate.pieces, piece)
+			}
+
+			if len(candidate.positions) == 0 || candidate.getMissingCnt() == 0 {
+				continue
+			}
+
+			key := placement{candidate.positions[0], (bodyCsRotation%360 + 360) % 360}
+			if !found[key] {
+				found[key] = true
+				candidates = append(candidates, candidate)
+			}
+		}
+	}
+
+	return candidates
+}
+
+/*
+getNearCompleteTypes returns the piece types missing from the body candidates which lack one piece only.
+The bodies of less than 3 pieces are skipped, a single piece is not a nearly complete body. Each type is listed once.
+*/
+func (g *GridComp) getNearCompleteTypes() []string {
+	var types []string
+	for _, body := range allBodies {
+		if len(body.bodyPieces) < 3 {
+			continue
+		}
+		for _, candidate := range g.findBodyCandidates(body) {
+			if candidate.getMissingCnt() != 1 {
+				continue
+			}
+			pieceType := body.bodyPieces[slices.Index(candidate.pieces, nil)].pieceType
+			if !slices.Contains(types, pieceType) {
+				types = append(types, pieceType)
+			}
+		}
+	}
+	return types
+}
+
+/*
+getBestCandidate returns the body candidate with the largest ratio of present pieces, shown by the body completion
+progress bar of the sidebar. The bodies of less than 3 pieces are skipped like by getNearCompleteTypes.
+The candidate is searched again only when the locked pieces changed. Returns nil if there is no candidate.
+*/
+func (g *GridComp) getBestCandidate() *CandidateMatch {
+	if !g.candidateDirty {
+		return g.bestCandidate
+	}
+
+	g.bestCandidate = nil
+	for _, body := range allBodies {
+		if len(body.bodyPieces) < 3 {
+			continue
+		}
+		for _, candidate := range g.findBodyCandidates(body) {
+			if g.bestCandidate == nil || g.bestCandidate.getProgress() < candidate.getProgress() {
+				g.bestCandidate = &candidate
+			}
+		}
+	}
+	g.candidateDirty = false
+	return g.bestCandidate
+}
+
+/*
+disjoint sets of pieces. used for the connectivity analysis of the locked pieces.
+*/
+type pieceUnionFind struct {
+	parent map[*Piece]*Piece
+	size   map[*Piece]int // nr of pieces in the set, valid for the roots only
+}
+
+func newPieceUnionFind(pieces []*Piece) *pieceUnionFind {
+	u := &pieceUnionFind{parent: make(map[*Piece]*Piece, len(pieces)), size: make(map[*Piece]int, len(pieces))}
+	for _, piece := range pieces {
+		u.parent[piece] = piece
+		u.size[piece] = 1
+	}
+	return u
+}
+
+func (u *pieceUnionFind) find(piece *Piece) *Piece {
+	for u.parent[piece] != piece {
+		// path halving
+		u.parent[piece] = u.parent[u.parent[piece]]
+		piece = u.parent[piece]
+	}
+	return piece
+}
+
+func (u *pieceUnionFind) union(a, b *Piece) {
+	rootA, rootB := u.find(a), u.find(b)
+	if rootA == rootB {
+		return
+	}
+
+	// attach the smaller set to the larger one
+	if u.size[rootA] < u.size[rootB] {
+		rootA, rootB = rootB, rootA
+	}
+	u.parent[rootB] = rootA
+	u.size[rootA] += u.size[rootB]
+}
+
+/*
+getIslands groups the locked pieces into islands, i.e. groups connected by the sides of their cells.
+*/
+func (g *GridComp) getIslands() *pieceUnionFind {
+	islands := newPieceUnionFind(g.lockedPieces)
+
+	for x := 1; x < g.size.w-1; x++ {
+		for y := 0; y < g.size.h-1; y++ {
+			piece := g.content[x][y]
+			if piece == nil {
+				continue
+			}
+
+			if right := g.content[x+1][y]; right != nil && right != piece {
+				islands.union(piece, right)
+			}
+			if below := g.content[x][y+1]; below != nil && below != piece {
+				islands.union(piece, below)
+			}
+		}
+	}
+
+	return islands
+}
+
+/*
+islandCount returns the nr of connected groups of locked pieces.
+*/
+func (g *GridComp) islandCount() int {
+	islands := g.getIslands()
+
+	cnt := 0
+	for _, piece := range g.lockedPieces {
+		if islands.find(piece) == piece {
+			cnt++
+		}
+	}
+	return cnt
+}
+
+/*
+largestIslandSize returns the nr of pieces in the largest connected group of locked pieces.
+*/
+func (g *GridComp) largestIslandSize() int {
+	islands := g.getIslands()
+
+	largest := 0
+	for _, piece := range g.lockedPieces {
+		if islands.find(piece) == piece {
+			largest = max(largest, islands.size[piece])
+		}
+	}
+	return largest
+}
+
+/*
+getPiecesBelow returns the locked pieces in the blast area of the bomb piece: the square of 2*radius+1 cells
+centered at the cell below the bomb, cut at the row of the bomb. A wider bomb widens the area.
+*/
+func (g *GridComp) getPiecesBelow(piece *Piece, radius int) []*Piece {
 	pieces := make([]*Piece, 0, 1) // empty, capacity=1
 
-	below := addPos(piece.pos, Pos{0, 1})
-	// is the location below the bomb within the grid?
-	if isWithinBounds(below, Size{1, 1}, Pos{1, 1}, Pos{g.size.w - 1, g.size.h - 1}) {
-		// remove (unlock) each piece below the bomb
-		for i := 0; i < piece.size.w; i++ {
-			piece := g.getPiece(below)
-			if piece != nil {
-				pieces = append(pieces, piece)
+	size := rotateSize(piece.size, piece.currentRotation)
+	centerY := piece.pos.y + size.h
+	for y := max(centerY-radius, piece.pos.y); y <= centerY+radius; y++ {
+		for x := piece.pos.x - radius; x < piece.pos.x+size.w+radius; x++ {
+			// is the location within the grid?
+			if !isWithinBounds(Pos{x, y}, Size{1, 1}, Pos{1, 1}, Pos{g.size.w - 1, g.size.h - 1}) {
+				continue
+			}
+
+			p := g.getPiece(Pos{x, y})
+			if p != nil && p != piece && !slices.Contains(pieces, p) {
+				pieces = append(pieces, p)
 			}
-			below.x++
 		}
 	}
 
 	return pieces
 }
+
+/*
+serialized form of a locked piece, used by the save states
+*/
+type lockedPieceJSON struct {
+	Type     string `json:"type"`
+	X        int    `json:"x"`
+	Y        int    `json:"y"`
+	Rotation int    `json:"rotation"`
+}
+
+/*
+exportLockedPiecesJSON marshals the locked pieces as a JSON array of {type, x, y, rotation} objects.
+*/
+func (g *GridComp) exportLockedPiecesJSON() ([]byte, error) {
+	pieces := make([]lockedPieceJSON, 0, len(g.lockedPieces))
+	for _, piece := range g.lockedPieces {
+		pieces = append(pieces, lockedPieceJSON{Type: piece.pieceType, X: piece.pos.x, Y: piece.pos.y, Rotation: piece.currentRotation})
+	}
+
+	return json.Marshal(pieces)
+}
+
+/*
+importLockedPiecesJSON locks the pieces described by the JSON data created by exportLockedPiecesJSON.
+All the pieces are validated before locking, so the grid is left untouched on error.
+*/
+func (g *GridComp) importLockedPiecesJSON(data []byte) error {
+	var desc []lockedPieceJSON
+	if err := json.Unmarshal(data, &desc); err != nil {
+		return fmt.Errorf("failed to parse locked pieces: %w", err)
+	}
+
+	pieces, err := g.createLockedPieces(desc, true)
+	if err != nil {
+		return err
+	}
+
+	for _, piece := range pieces {
+		g.lockPiece(piece)
+	}
+
+	return nil
+}
+
+/*
+createLockedPieces creates the pieces of the descriptions and validates their types, rotations and positions.
+The rotations must be multiples of 90, they are normalized into [0, 360).
+The pieces must not overlap each other, nor the locked pieces of the grid if checkGrid is set.
+*/
+func (g *GridComp) createLockedPieces(desc []lockedPieceJSON, checkGrid bool) ([]*Piece, error) {
+	occupied := map[Pos]bool{}
+	pieces := make([]*Piece, 0, len(desc))
+	for i, d := range desc {
+		prototype := getPieceByType(d.Type)
+		if prototype == nil {
+			return nil, fmt.Errorf("locked piece #%d has unknown piece type '%s'", i, d.Type)
+		}
+
+		if d.Rotation%90 != 0 {
+			return nil, fmt.Errorf("locked piece #%d '%s' has invalid rotation %d", i, d.Type, d.Rotation)
+		}
+
+		piece := prototype.clone()
+		piece.pos = Pos{d.X, d.Y}
+		piece.currentRotation = (d.Rotation%360 + 360) % 360
+
+		size := rotateSize(piece.size, piece.currentRotation)
+		if !isWithinBounds(piece.pos, size, Pos{1, 0}, Pos{g.size.w - 1, g.size.h - 1}) {
+			return nil, fmt.Errorf("locked piece #%d '%s'@%v is outside of the grid", i, d.Type, piece.pos)
+		}
+
+		for x := piece.pos.x; x < piece.pos.x+size.w; x++ {
+			for y := piece.pos.y; y < piece.pos.y+size.h; y++ {
+				if occupied[Pos{x, y}] || (checkGrid && g.content[x][y] != nil) {
+					return nil, fmt.Errorf("locked piece #%d '%s'@%v overlaps another piece", i, d.Type, piece.pos)
+				}
+				occupied[Pos{x, y}] = true
+			}
+		}
+
+		pieces = append(pieces, piece)
+	}
+
+	return pieces, nil
+}
+
+/*
+serialized form of the grid state
+*/
+type gridStateJSON struct {
+	Width  int               `json:"width"`  // including the border
+	Height int               `json:"height"` // including the border
+	Pieces []lockedPieceJSON `json:"pieces"` // the locked pieces in the locking order
+}
+
+/*
+ExportJSON returns the grid state as JSON: the size of the grid and the type, position and rotation of
+the locked pieces. The locked pieces are listed in their locking order, so the import keeps the order.
+*/
+func (g *GridComp) ExportJSON() string {
+	state := gridStateJSON{Width: g.size.w, Height: g.size.h, Pieces: []lockedPieceJSON{}}
+	lockedPieces := slices.Clone(g.lockedPieces)
+	sort.SliceStable(lockedPieces, func(i, j int) bool { return lockedPieces[i].lockOrderIndex < lockedPieces[j].lockOrderIndex })
+	for _, piece := range lockedPieces {
+		state.Pieces = append(state.Pieces, lockedPieceJSON{Type: piece.pieceType, X: piece.pos.x, Y: piece.pos.y, Rotation: piece.currentRotation})
+	}
+
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		log.Printf("Failed to export grid state: %v", err)
+		return ""
+	}
+	return string(data)
+}
+
+/*
+ImportJSON replaces the locked pieces by the grid state created by ExportJSON. The size of the state must match
+the size of the grid. All the pieces are validated before, so the grid is left untouched on error.
+*/
+func (g *GridComp) ImportJSON(s string) error {
+	var state gridStateJSON
+	if err := json.Unmarshal([]byte(s), &state); err != nil {
+		return fmt.Errorf("failed to parse grid state: %w", err)
+	}
+	if stateSize := (Size{state.Width, state.Height}); stateSize != g.size {
+		return fmt.Errorf("grid state of size %v does not match the grid of size %v", stateSize, g.size)
+	}
+
+	pieces, err := g.createLockedPieces(state.Pieces, false)
+	if err != nil {
+		return err
+	}
+
+	g.resize(g.size) // removes the locked pieces
+	g.lockPieceBatch(pieces)
+	return nil
+}
+
+/*
+validateConsistency checks that the locked list is sorted and the grid matrix refers exactly to the locked pieces.
+*/
+func (g *GridComp) validateConsistency() error {
+	cellCnt := 0
+	for i, piece := range g.lockedPieces {
+		if 0 < i {
+			prev := g.lockedPieces[i-1]
+			if piece.pos.y < prev.pos.y || (piece.pos.y == prev.pos.y && piece.pos.x < prev.pos.x) {
+				return fmt.Errorf("locked pieces are not sorted at index %d: %v is after %v", i, piece.pos, prev.pos)
+			}
+		}
+
+		size := rotateSize(piece.size, piece.currentRotation)
+		for x := piece.pos.x; x < piece.pos.x+size.w; x++ {
+			for y := piece.pos.y; y < piece.pos.y+size.h; y++ {
+				if g.content[x][y] != piece {
+					return fmt.Errorf("grid cell %v does not refer to the locked piece '%s'@%v", Pos{x, y}, piece.pieceType, piece.pos)
+				}
+				cellCnt++
+			}
+		}
+	}
+
+	for x := 0; x < g.size.w; x++ {
+		for y := 0; y < g.size.h; y++ {
+			if g.content[x][y] != nil {
+				cellCnt--
+			}
+		}
+	}
+
+	if cellCnt != 0 {
+		return fmt.Errorf("grid refers to %d cells not belonging to any locked piece", -cellCnt)
+	}
+
+	return nil
+}
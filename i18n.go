@@ -0,0 +1,115 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+/*
+the UI strings of a language by their keys. loaded from langDirName/<language code>.json.
+*/
+type LangPack map[string]string
+
+var (
+	language        string   // code of the active language
+	langPack        LangPack // the strings of the active language
+	defaultLangPack LangPack // the strings of defaultLanguage, used for the keys missing from the active language
+	configLanguage  = defaultLanguage // the language of the config, the options may override it
+)
+
+/*
+LoadLangPack loads a language pack from a JSON file.
+*/
+func LoadLangPack(path string) (LangPack, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load language pack: %w", err)
+	}
+
+	var pack LangPack
+	if err := json.Unmarshal(data, &pack); err != nil {
+		return nil, fmt.Errorf("failed to parse language pack '%s': %w", path, err)
+	}
+	return pack, nil
+}
+
+/*
+setLanguage activates the language of the code. The default language is used if there is no such language pack.
+The strings are looked up at each use, so the change takes effect immediately.
+*/
+func setLanguage(code string) {
+	if code == language {
+		return
+	}
+	pack, err := LoadLangPack(filepath.Join(langDirName, code+".json"))
+	if err != nil {
+		log.Printf("%v", err)
+		code, pack = defaultLanguage, nil
+	}
+	log.Printf("Language '%s' activated", code)
+	language, langPack = code, pack
+}
+
+/*
+T returns the string of the key in the active language. The missing strings are taken from the default language,
+the key itself is returned if that lacks it too.
+*/
+func T(key string) string {
+	if s, ok := langPack[key]; ok {
+		return s
+	}
+	if defaultLangPack == nil {
+		pack, err := LoadLangPack(filepath.Join(langDirName, defaultLanguage+".json"))
+		if err != nil {
+			log.Printf("%v", err)
+			pack = LangPack{} // not retried
+		}
+		defaultLangPack = pack
+	}
+	if s, ok := defaultLangPack[key]; ok {
+		return s
+	}
+	return key
+}
+
+/*
+getSystemLanguage returns the language code of the system locale, e.g. "de" of "de_DE.UTF-8".
+The default language is returned if the locale is not set.
+*/
+func getSystemLanguage() string {
+	locale := os.Getenv("LANG")
+	code, _, _ := strings.Cut(locale, "_")
+	code, _, _ = strings.Cut(code, ".")
+	if code == "" || code == "C" || code == "POSIX" {
+		return defaultLanguage
+	}
+	return strings.ToLower(code)
+}
+
+/*
+getLanguages returns the codes of the language packs of the directory, sorted.
+*/
+func getLanguages(dir string) []string {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			log.Printf("Failed to read the languages: %v", err)
+		}
+		return []string{defaultLanguage}
+	}
+
+	var codes []string
+	for _, entry := range entries {
+		if code, ok := strings.CutSuffix(entry.Name(), ".json"); ok && !entry.IsDir() {
+			codes = append(codes, code)
+		}
+	}
+	slices.Sort(codes)
+	return codes
+}
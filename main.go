@@ -1,14 +1,24 @@
 package main
 
 import (
+	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
+	"image"
 	"image/color"
+	"image/png"
 	"log"
+	"maps"
+	"math"
 	"math/rand"
 	"os"
+	"slices"
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/hajimehoshi/ebiten/v2"
 	"github.com/hajimehoshi/ebiten/v2/ebitenutil"
@@ -16,21 +26,65 @@ import (
 )
 
 const highScoreFileName = "highscore.txt"
+const keyBindingsFileName = "bindings.json"
+const optionsFileName = "options.json"
+const bodiesFileName = "bodies.json"
+const challengesFileName = "challenges.json"
+const sprintTimesFileName = "sprinttimes.txt"
+const statsFileName = "stats.json"
+const achievementsFileName = "achievements.json"
+const configFileName = "config.toml"
+const themesDirName = "themes" // the JSON theme descriptors besides the built-in themes
+var profilesFileName = "profiles.json" // the profile paths are variables, so the tests can move them
+var profilesDirName = "profiles" // the files of each profile are in its subdirectory
+const langDirName = "lang" // the language packs, named by the language codes
+const defaultLanguage = "en"
+const screenshotFileFormat = "screenshot_20060102_150405.png" // time layout of the screenshot file names
+const screenshotMaxSuffix = 100 // nr of the screenshots of the same second, suffixed by their counter
 
 const (
-	screenWidth  = 800
-	screenHeight = 600
-	sidebarWidth = 180
+	defaultScreenWidth  = 800 // initial size of the window
+	defaultScreenHeight = 600
+	baseSidebarWidth = 180 // width of the sidebar at uiScale 1
+	normTextSize = 20 // font sizes at uiScale 1
+	smallTextSize = 12
+	bigTextSize = 40
 	ticksPerSec  = 60 // Update() is called with this frequency
-	scale        = 30 // Unified scale factor for cells and sprites
+	defaultScale = 30 // scale of the initial window
+	lockDelayMaxFrames = 30 // frames the landed piece can be moved before it locks
+	nextPieceCnt = 3 // nr of upcoming pieces in the queue
+	hardDropPointsPerRow = 2 // bonus score per row travelled by the dropped piece
+	dangerRows = 3 // the locked pieces in the top rows of the grid are warned by the danger overlay
+	sidebarDividerGrabDist = 4 // the divider of the sidebar is dragged by a press this close to it, in pixels
+	sidebarDragMinCellSize = 4 // the sidebar is not dragged over the play area of smaller cells
 	
 	DrawOrderBkgd = 10
 	DrawOrderWaveEffect = 15
+	DrawOrderChainWave = 16 // the chain waves use the draw orders from DrawOrderChainWave to DrawOrderChainWave+chainWaveMaxCnt-1
 	DrawOrderGrid = 20
+	DrawOrderBodyMatch = 21 // the fly-off effects use the draw orders from DrawOrderBodyMatch to DrawOrderBodyMatch+bodyMatchEffectMaxCnt-1
 	DrawOrderRockEffect = 25
+	DrawOrderFlashEffect = 27
+	DrawOrderParticles = 28
 	DrawOrderActivePiece = 30
+	DrawOrderSprintCountdown = 33
+	DrawOrderComboLabel = 35
+	DrawOrderScorePopup = 36 // the popups use the draw orders from DrawOrderScorePopup to DrawOrderScorePopup+scorePopupMaxCnt-1
 	DrawOrderSideBar = 40
+	DrawOrderTutorialPrompt = 42
+	DrawOrderAchievement = 43
+	DrawOrderPause = 45
 	DrawOrderGameOver = 50
+	DrawOrderMenu = 55 // the menu is opened over the game over dialog too
+	DrawOrderProfiles = 57
+	DrawOrderOptions = 60
+	DrawOrderStats = 62
+	DrawOrderTutorial = 65
+	DrawOrderScreenShake = 100 // shakes everything drawn before
+	DrawOrderFPS = 110 // over everything, not shaken
+
+	PriorityDefault = 0
+	PriorityInput = 10 // the input handling components are updated before the displaying ones
 )
 
 type SpeedLevel struct {
@@ -39,26 +93,150 @@ type SpeedLevel struct {
 }
 
 var (
+	screenWidth      = defaultScreenWidth // the logical screen size follows the window, updated by Game.Layout
+	screenHeight     = defaultScreenHeight
+	scale            = defaultScale // unified scale factor for cells and sprites. the grid fills the area left to the sidebar
+	uiScale          = float64(1) // the texts and the sidebar are enlarged by this, see Config.UIScale
+	sidebarWidth     = baseSidebarWidth // the initial and the minimal width of the sidebar, scaled by uiScale. see SideBarComp.width
+	screenLayout     = getLayout(Size{defaultScreenWidth, defaultScreenHeight}, baseSidebarWidth) // updated by Game.relayout
 	gridSize         = Size{18, 18}
 	speedLevels      = []SpeedLevel{{30, 30}, {26, 60}, {22, 90}, {19, 120}, {16, 150}, {13, 180}, {11, 210}, {9, 240}, {7, 270}, {6, 300}}
 	boundingBoxColor = color.RGBA{R: 255, G: 255, B: 0, A: 255}
 	sidebarColor     = color.RGBA{R: 130, G: 130, B: 130, A: 255}
 	backgroundColor  = color.RGBA{R: 100, G: 100, B: 100, A: 255}
+	backgroundFastColor   = color.RGBA{R: 120, G: 80, B: 80, A: 255} // background color at the maximum speed level
 	waveEffectColor       = color.RGBA{R: 183, G: 87, B: 8, A: 255}
+	hintPresentColor      = color.RGBA{R: 0, G: 220, B: 16, A: 255}
+	hintMissingColor      = color.RGBA{R: 253, G: 0, B: 0, A: 255}
+	debugEmptyCellColor   = color.RGBA{R: 0, G: 160, B: 0, A: 255} // outline of the empty cells in debug mode
+	speakerColor          = color.RGBA{R: 230, G: 230, B: 230, A: 255}
+	speedBarStartColor    = color.RGBA{R: 0, G: 200, B: 0, A: 255} // the speed progress bar turns from green to red
+	speedBarEndColor      = color.RGBA{R: 230, G: 0, B: 0, A: 255}
+	speedBarMaxColor      = color.RGBA{R: 190, G: 90, B: 230, A: 255} // the full bar at the maximum speed level
+	speedBarBkgdColor     = color.RGBA{R: 90, G: 90, B: 90, A: 255}
+	menuSelectedColor     = color.RGBA{R: 90, G: 110, B: 160, A: 255}
+	menuMinWidth          = 160
+	optionsScreenWidth    = 300
+	optionsVolumeStep     = 0.1
+	optionsGridSizeMin    = 12
+	optionsGridSizeMax    = 20 // the largest grid fitting the screen
+	optionsGridSizeStep   = 2
+	optionsBombBlastRadiusMax = 3
+	tutorialScreenSize    = Size{420, 460}
+	tutorialCellSize      = 80 // the bodies of the tutorial are drawn enlarged
+	tutorialPieceDropFrameCnt = 30 // a piece of the tutorial falls to its place during this time
+	tutorialHoldFrameCnt  = 120 // the assembled body of the tutorial is shown for this time
+	tutorialRandSeed      = int64(1) // the pieces of the tutorial mode are the same in each run
+	pauseDialogColor      = color.NRGBA{R: 130, G: 130, B: 130, A: 160} // semi-transparent fill of the pause dialog
+	dangerZoneColor       = color.NRGBA{R: 255, G: 0, B: 0, A: 255} // the alpha is set by the fullness of the danger zone
+	dangerZoneMaxAlpha    = 0.5 // opacity of the overlay over the full danger zone
+	dangerZonePulseFrameCnt = 40 // period of the pulsing of the danger overlay
+	playerNameMaxLen      = 8 // max nr of characters of the player name saved with the high score
+	statsTableWidth       = float64(260) // min width of the statistics table of the game over dialog
+	speedCountdownWarnColor = color.RGBA{R: 255, G: 40, B: 40, A: 255}
+	speedCountdownWarnSec = 3 // the countdown to the next speed level flashes during the last seconds
+	speedCountdownFlashFrameCnt = 10 // the flashing countdown changes color after this many frames
+	nextPieceScales       = []float64{0.9, 0.7, 0.55} // relative size of the upcoming pieces in the sidebar, the immediate next first
+	sidebarPiecesTop      = 36 // the next, the held and the preview pieces are drawn from this height in the sidebar
+	ghostPieceAlpha       = uint8(80) // opacity of the ghost piece at the landing position of the active piece
+	spawnAnimStartY       = float32(-2.0) // the spawned piece falls in from this many cells above its position
+	spawnAnimFrameCnt     = 10 // the spawned piece falls in during this time
 	waveEffectLifeTimeSec = float32(0.5) // length of the effect
 	waveEffectFillPcnt    = 0.3 // means x percent of the effect area is filled with the waveEffect
+	chainWaveMaxCnt       = 4 // max nr of chained bomb waves shown at the same time
+	bombChainMaxDepth     = 10 // max nr of bombs detonated by a chain reaction
 	rockEffectLifeTimeSec = float32(0.3) // length of the effect
 	rockEffectNofRock     = 5 // nr of rock events during the effect is playing
+	bodyMatchEffectFrameCnt = 20 // the pieces of the matched body fly off during this time
+	bodyMatchEffectFlyDist = float64(2*scale) // the pieces fly off this far from the center of the body
+	bodyMatchEffectMaxCnt = 4 // max nr of bodies flying off at the same time
+	comboLabelLifeTimeSec = float32(1.5) // the combo label floats up and fades out during this time
+	musicRatePerSpeedLevel = 0.05 // the music is played faster by this rate per speed level
+	flashEffectHoldFrameCnt = 4 // the removed pieces flash at full alpha during this time
+	flashEffectFadeFrameCnt = 8 // then the flash fades out during this time
+	flashEffectColor      = color.RGBA{R: 255, G: 255, B: 240, A: 255}
+	challengeBaseScore    = 10000 // score of a challenge completed immediately
+	challengeSecPenalty   = 20 // the challenge score is reduced by this per second
+	challengePiecePenalty = 100 // the challenge score is reduced by this per placed piece
+	defaultSprintTarget   = 10000 // target score of the Sprint 10K mode
+	defaultAdaptiveBias   = float32(0.25) // see GameConfig.adaptiveBias
+	achievementNoticeFrameCnt = 180 // the unlocked achievement is notified during this time
+	achievementNoticeTop  = 60 // center of the achievement notification from the top of the screen
+	adaptiveBiasDecay     = 0.5 // the adaptive bias is multiplied by this at each spawn while the bodies are waiting
+	bombParticleCnt       = 60 // nr of particles in the burst of a bomb
+	bombParticleLifeTimeSec = float32(0.6) // max lifetime of the particles
+	bombParticleRange     = float64(3 * scale) // the particles of the burst travel at most this distance in pixels per unit of the blast radius
+	bombParticleColor     = color.RGBA{R: 255, G: 170, B: 40, A: 255}
+	bombShakeFrameCnt     = 20 // the screen is shaken during this time when a bomb detonates
+	bombShakePixels       = 8 // max displacement of the bomb shake
+	levelUpShakeFrameCnt  = 15
+	levelUpShakePixels    = 4
+	scorePopupLifeTimeFrameCnt = 60 // the score popup rises and fades out during this time
+	scorePopupMaxCnt      = 4 // max nr of score popups shown at the same time
+	scorePopupColor       = color.RGBA{R: 255, G: 230, B: 60, A: 255}
+	comboMultiplierStep   = 0.5 // the body score is multiplied by 1 + comboMultiplierStep*comboCount
+	eraserPieceCnt        = 3 // nr of pieces removed by an eraser
+	completionLogMaxLen   = 20 // max nr of body completions kept in the history
+	completionLogFadeSec  = 10 // history entries fade out during this time
+	doubleClickFrameCnt   = 10 // max frames between the two clicks of a double click
+	dasDelayFrameCnt      = 10 // a held directional key is repeated after this delay
+	dasRepeatFrameCnt     = 2 // frames between the repeats of a held directional key
+	dasKeyNames           = []string{"left", "right"} // the keys having delayed auto-shift
+	touchSwipeMinDist     = scale // a touch moved farther than a cell is a swipe
+	touchTapMaxDist       = scale / 3 // a touch moved less than this is a tap
+	touchFastSwipeSpeed   = 20 // a downward swipe faster than this, in pixels per frame, is a hard drop
+	forfeitHoldSec        = 2 // the forfeit key has to be held for this time
+	wallKickOffsets       = []Pos{{-1, 0}, {1, 0}, {0, -1}, {-1, -1}, {1, -1}} // tried in order when the rotation is blocked
+	earthquakeShifts      = []int{-2, -1, 1, 2} // horizontal shifts of the earthquake, one is chosen randomly
+	nukeRowCnt            = 3 // nr of bottom rows removed by a nuke
+	pieceColorScheme      = ColorScheme{pieceColors: map[string]color.RGBA{
+		"Head":          {R: 255, G: 225, B: 215, A: 255},
+		"Torso":         {R: 215, G: 240, B: 255, A: 255},
+		"RightBrkTorso": {R: 200, G: 225, B: 255, A: 255},
+		"LeftBrkTorso":  {R: 225, G: 215, B: 255, A: 255},
+		"Leg":           {R: 220, G: 255, B: 215, A: 255},
+		"Bomb":          {R: 255, G: 200, B: 200, A: 255},
+		"EraserOld":     {R: 255, G: 245, B: 210, A: 255},
+		"EraserNew":     {R: 255, G: 255, B: 235, A: 255},
+		"RowBomb":       {R: 255, G: 215, B: 180, A: 255},
+		"Transpose":     {R: 235, G: 210, B: 255, A: 255},
+		"Earthquake":    {R: 255, G: 235, B: 200, A: 255},
+		"Nuke":          {R: 255, G: 255, B: 200, A: 255},
+	}}
+	colorblindColorScheme = ColorScheme{pieceColors: map[string]color.RGBA{ // the body pieces are told apart by hue only, distinct for color vision deficiencies
+		"Head":          {R: 240, G: 228, B: 66, A: 255},
+		"Torso":         {R: 86, G: 180, B: 233, A: 255},
+		"RightBrkTorso": {R: 0, G: 158, B: 115, A: 255},
+		"LeftBrkTorso":  {R: 213, G: 94, B: 0, A: 255},
+		"Leg":           {R: 204, G: 121, B: 167, A: 255},
+	}}
+	symbolImageScale      = 4 // the piece images with the colorblind symbols are enlarged by this
+	symbolSizeRatio       = float32(0.5) // size of the colorblind symbol relative to the piece image
+	pieceBagCopiesPerProb = float32(10) // nr of copies of a piece type in the piece bag per unit of spawn probability
+	difficultyRampSec     = float32(300) // spawn probabilities reach spawnProbRampEnd at this game time
+	dailyRampBagCnt       = 5 // the daily challenge reaches spawnProbRampEnd after this nr of piece bags, independently of the game time
+	spawnProbRampStart    = map[string]float32{"Bomb": 0.2} // spawn probabilities at the game start, overrides Game.spawnProb
+	spawnProbRampEnd      = map[string]float32{"Bomb": 0.9} // spawn probabilities at the end of the difficulty ramp
 	userInput        *UserInput
+	textFaceSource   *text.GoTextFaceSource
 	normTextFace     *text.GoTextFace
 	smallTextFace    *text.GoTextFace
+	bigTextFace      *text.GoTextFace
+	fpsSampleCnt     = 60 // the FPS counter shows the average of this many frames
 )
 
 /*
 dropPiece moves the active piece as far down as possible.
+The rows travelled by the piece are awarded with bonus score.
 */
 func (g *Game) dropPiece() {
+	startY := g.activePiece.pos.y
 	g.grid.drop(g.activePiece)
+
+	dropRows := g.activePiece.pos.y - startY
+	g.score += dropRows * hardDropPointsPerRow
+	playSFX("hardDrop")
+
 	g.handleActivePieceLanded()
 }
 
@@ -71,75 +249,298 @@ func mustLoadImage(path string) *ebiten.Image {
 }
 
 /*
-loadTopScores loads and returns the top 5 scores from the highscore.txt file.
+a line of the highscore.txt file. the name is empty if the player did not enter it.
+*/
+type ScoreRecord struct {
+	Name  string `json:"name"`
+	Score int    `json:"score"`
+	Date  string `json:"date"`
+	Daily string `json:"daily,omitempty"` // date of the daily challenge, see Game.getDailyKey. empty for the other games
+}
+
+/*
+readScoresFromFile reads the score records from the highscore.txt file.
+The records are JSON lines. The lines of a plain number are the anonymous scores of the earlier versions.
 */
-func readScoresFromFile() []int {
-	data, err := os.ReadFile(highScoreFileName)
+func readScoresFromFile() []ScoreRecord {
+	data, err := os.ReadFile(getProfilePath(highScoreFileName))
 	if err != nil {
 		if os.IsNotExist(err) {
-			return []int{}
+			return []ScoreRecord{}
 		}
 		log.Printf("Failed to read high scores: %v", err)
-		return []int{}
+		return []ScoreRecord{}
 	}
 
-	scoreStrings := strings.Split(string(data), "\n")
-	var scores []int
-	for _, scoreStr := range scoreStrings {
-		if scoreStr == "" {
+	lines := strings.Split(string(data), "\n")
+	var records []ScoreRecord
+	for _, line := range lines {
+		if line == "" {
+			continue
+		}
+
+		if !strings.HasPrefix(line, "{") {
+			if score, err := strconv.Atoi(line); err == nil {
+				records = append(records, ScoreRecord{Score: score})
+			}
+			continue
+		}
+
+		var entry struct {
+			ScoreRecord
+			Forfeit bool `json:"forfeit"`
+		}
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			log.Printf("Invalid high score entry %q: %v", line, err)
 			continue
 		}
-		score, err := strconv.Atoi(scoreStr)
-		if err == nil {
-			scores = append(scores, score)
+		// skip the forfeit entries
+		if !entry.Forfeit {
+			records = append(records, entry.ScoreRecord)
 		}
 	}
-	return scores
+	return records
+}
+
+/*
+readGameScores returns the score records of the game mode: the scores of the current daily challenge
+in daily challenge mode, the normal scores otherwise.
+*/
+func (g *Game) readGameScores() []ScoreRecord {
+	dailyKey := g.getDailyKey()
+	return slices.DeleteFunc(readScoresFromFile(), func(r ScoreRecord) bool { return r.Daily != dailyKey })
 }
 
-func (g *Game) loadTopScores() []int {
-	scores := readScoresFromFile()
-	sort.Sort(sort.Reverse(sort.IntSlice(scores)))
-	if len(scores) > 5 {
-		scores = scores[:5]
+/*
+loadTopScores loads and returns the top 5 score records from the highscore.txt file, the highest first.
+*/
+func (g *Game) loadTopScores() []ScoreRecord {
+	records := g.readGameScores()
+	sort.SliceStable(records, func(i, j int) bool { return records[j].Score < records[i].Score })
+	if len(records) > 5 {
+		records = records[:5]
 	}
-	return scores
+	return records
 }
 
 /*
-saveScore appends the current score to the highscore.txt file.
+saveScore appends the score of the player to the highscore.txt file.
 */
-func (g *Game) saveScore(score int) {
-	file, err := os.OpenFile(highScoreFileName, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+func (g *Game) saveScore(name string, score int) {
+	entry, err := json.Marshal(ScoreRecord{Name: name, Score: score, Date: time.Now().Format(time.RFC3339), Daily: g.getDailyKey()})
+	if err != nil {
+		log.Printf("Failed to marshal score: %v", err)
+		return
+	}
+
+	file, err := os.OpenFile(getProfilePath(highScoreFileName), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
 	if err != nil {
 		log.Printf("Failed to open high score file: %v", err)
 		return
 	}
 	defer file.Close()
 
-	if _, err := file.WriteString(fmt.Sprintf("%d\n", score)); err != nil {
+	if _, err := file.WriteString(string(entry) + "\n"); err != nil {
 		log.Printf("Failed to write score: %v", err)
 	}
 }
 
+/*
+saveForfeit appends a forfeit entry to the highscore.txt file. The entry is not counted as a score.
+*/
+func (g *Game) saveForfeit() {
+	entry, err := json.Marshal(struct {
+		Forfeit bool   `json:"forfeit"`
+		Date    string `json:"date"`
+	}{true, time.Now().Format(time.RFC3339)})
+	if err != nil {
+		log.Printf("Failed to marshal forfeit entry: %v", err)
+		return
+	}
+
+	file, err := os.OpenFile(getProfilePath(highScoreFileName), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		log.Printf("Failed to open high score file: %v", err)
+		return
+	}
+	defer file.Close()
+
+	if _, err := file.WriteString(string(entry) + "\n"); err != nil {
+		log.Printf("Failed to write forfeit entry: %v", err)
+	}
+}
+
+/*
+forfeit ends the game immediately without scoring.
+*/
+func (g *Game) forfeit() {
+	log.Printf("Game forfeited with score %d", g.score)
+	g.forfeited = true
+	g.score = 0
+	g.endGame()
+}
+
+/*
+handlePause pauses the active play by the pause key. The pause key or a click on the pause dialog resumes.
+*/
+func (g *Game) handlePause() {
+	if g.paused {
+		clicked := false
+		if g.input.isMouseLeftClick() {
+			x, y := g.input.cursorPosition()
+			clicked = g.pauseDialog.contains(Pos{x, y})
+		}
+		if g.input.isKeyPressed("pause") || clicked {
+			g.setPaused(false)
+		}
+	} else if g.input.isKeyPressed("pause") && !g.compMgr.isBlocked() {
+		g.setPaused(true)
+	}
+}
+
+func (g *Game) setPaused(paused bool) {
+	log.Printf("Game paused: %t", paused)
+	g.paused = paused
+	g.pauseDialog.text = []string{T("dialog.paused")}
+	g.pauseDialog.activate(paused)
+}
+
+/*
+handleMenuKey opens or closes the menu when the menu key is released. The key is shared with the forfeit key,
+so holding it for forfeitHoldSec does not open the menu.
+*/
+func (g *Game) handleMenuKey() {
+	// the options, the statistics, the profile and the tutorial screens are closed back to the menu
+	if g.options.getState() != StateInactive || g.stats.getState() != StateInactive || g.profileMenu.getState() != StateInactive || g.tutorial.getState() != StateInactive {
+		if g.input.isKeyReleased("menu") && g.options.getState() != StateInactive {
+			g.options.close()
+		} else if g.input.isKeyReleased("menu") && g.profileMenu.getState() != StateInactive {
+			g.closeProfileMenu()
+		} else if g.input.isKeyReleased("menu") && g.stats.getState() != StateInactive {
+			g.stats.close()
+		} else if g.input.isKeyReleased("menu") {
+			g.tutorial.close()
+		}
+		g.menuKeyFrameCnt = 0
+		return
+	}
+
+	if g.input.isKeyDown("menu") {
+		g.menuKeyFrameCnt++
+		return
+	}
+
+	heldFrameCnt := g.menuKeyFrameCnt
+	g.menuKeyFrameCnt = 0
+	if !g.input.isKeyReleased("menu") || forfeitHoldSec*ticksPerSec <= heldFrameCnt || g.nameEntry.getState() != StateInactive {
+		return
+	}
+
+	g.menu.activate(g.menu.getState() == StateInactive)
+}
+
+/*
+handleForfeitKey forfeits the game when the forfeit key is held for forfeitHoldSec.
+*/
+func (g *Game) handleForfeitKey() {
+	if !g.input.isKeyDown("forfeit") {
+		g.forfeitHoldFrameCnt = 0
+		return
+	}
+
+	g.forfeitHoldFrameCnt++
+	if g.forfeitHoldFrameCnt == forfeitHoldSec*ticksPerSec {
+		g.forfeit()
+	}
+}
+
 /*
 endGame handles the end of the game, saving the score and checking for a new high score.
 */
 func (g *Game) endGame() {
 	g.apc.activate(false)
 	MUSIC_PLAYER.Pause()
+	g.saveReplay()
+	g.updateLifetimeStats()
 	log.Printf("Game ended. Spawn stat: %v", g.spawnStat)
+	for _, body := range allBodies {
+		hits, misses := body.matchStats()
+		log.Printf("Body '%s' match stat: %d hits, %d misses", body.name, hits, misses)
+	}
+
+	g.gameOver.setStats(&GameStats{
+		bodyCnt: maps.Clone(g.bodyCnt),
+		placedPieceCnt: g.placedPieceCnt,
+		bombCnt: g.bombCnt,
+		peakCombo: g.maxCombo,
+	})
+
+	if g.forfeited {
+		if g.isRanked() {
+			g.saveForfeit()
+		}
+		g.gameOver.text = []string{T("dialog.forfeited")}
+		g.gameOver.activate(true)
+		return
+	}
+
+	// the scores of the special modes are not saved
+	if !g.isRanked() {
+		g.showGameOver(false)
+		return
+	}
+
+	// a new high score is saved with the name of the player
+	if 0 < g.score && g.loadHighScore() <= g.score {
+		log.Printf("New high score %d achieved!", g.score)
+		g.nameEntry.start(func(name string) {
+			g.saveScore(name, g.score)
+			g.showGameOver(true)
+		})
+		return
+	}
+
 	// Save the current score to the highscore file
-	g.saveScore(g.score)
+	g.saveScore("", g.score)
+	g.showGameOver(false)
+}
+
+/*
+ExportState returns the state of the grid as JSON, see GridComp.ExportJSON.
+*/
+func (g *Game) ExportState() string {
+	return g.grid.ExportJSON()
+}
+
+/*
+ImportState replaces the locked pieces of the grid by the exported state, see GridComp.ImportJSON.
+*/
+func (g *Game) ImportState(s string) error {
+	if err := g.grid.ImportJSON(s); err != nil {
+		return err
+	}
+	log.Printf("Imported grid state with %d locked pieces", len(g.grid.lockedPieces))
+	return nil
+}
+
+/*
+Returns true if the scores of the game are saved to the high scores: false in practice, challenge and sprint mode.
+*/
+func (g *Game) isRanked() bool {
+	return !g.practice && g.challengeDesc == nil && g.sprintTarget == 0
+}
 
+/*
+showGameOver shows the game over dialog with the score.
+*/
+func (g *Game) showGameOver(isHighScore bool) {
 	gameOverText := []string{}
-	if g.score >= g.loadHighScore() {
-		gameOverText = append(gameOverText, "New High Score!")
-		log.Printf("New high score %d achieved!", g.score)
+	if isHighScore {
+		gameOverText = append(gameOverText, T("dialog.newHighScore"))
 	} else {
-		gameOverText = append(gameOverText, "GAME OVER")
+		gameOverText = append(gameOverText, T("dialog.gameOver"))
 	}
-	gameOverText = append(gameOverText, fmt.Sprintf("Score: %d", g.score))
+	gameOverText = append(gameOverText, fmt.Sprintf(T("dialog.score"), g.score))
 
 	g.gameOver.text = gameOverText
 	g.gameOver.activate(true)
@@ -149,35 +550,166 @@ func (g *Game) endGame() {
 loadHighScore loads the high score from a file.
 */
 func (g *Game) loadHighScore() int {
-	scores := readScoresFromFile()
 	var highScore int
-	for _, score := range scores {
-		if score > highScore {
-			highScore = score
+	for _, record := range g.readGameScores() {
+		if record.Score > highScore {
+			highScore = record.Score
 		}
 	}
 	return highScore
 }
 
+/*
+GameConfig holds the optional gameplay features.
+*/
+type GameConfig struct {
+	turboDropEnabled    bool // holding the drop key accelerates gravity instead of dropping the piece instantly
+	showRotationPreview bool // the sidebar shows all rotations of the next piece
+	maxSpeedLevel       int  // 1-based, the speed level is not increased over it. 0 means no cap
+	adaptiveBias        float32 // probability of spawning the missing piece of a body lacking one piece, see pickAdaptivePieceType. 0 disables it
+}
+
+/*
+GameMetrics describes the state of the grid.
+*/
+type GameMetrics struct {
+	skyline          []int // column heights from left to right
+	skylineBumpiness int   // sum of the height differences between adjacent columns
+	skylineMaxDrop   int   // largest height difference between adjacent columns
+}
+
+/*
+GameStats is the summary of a game shown on the game over screen.
+*/
+type GameStats struct {
+	bodyCnt      map[string]int // nr of completions per body name
+	placedPieceCnt int          // nr of landed pieces, including the special ones
+	bombCnt      int            // nr of landed bombs, row bombs and nukes
+	peakCombo    int            // the deepest join chain
+}
+
+/*
+Returns the rows of the statistics table as label and value pairs. The bodies are listed in the order of allBodies.
+*/
+func (s *GameStats) getRows() [][2]string {
+	var rows [][2]string
+	for _, body := range getBaseBodies() {
+		rows = append(rows, [2]string{body.name, strconv.Itoa(s.bodyCnt[body.name])})
+	}
+	rows = append(rows, [2]string{T("stats.piecesPlaced"), strconv.Itoa(s.placedPieceCnt)})
+	rows = append(rows, [2]string{T("stats.bombsUsed"), strconv.Itoa(s.bombCnt)})
+	rows = append(rows, [2]string{T("stats.peakCombo"), strconv.Itoa(s.peakCombo)})
+	return rows
+}
+
 type Game struct {
 	compMgr             *ComponentMgr
 	background          *BackgroundComp
 	waveEffect          *WaveEffectComp
+	chainWaves          []*WaveEffectComp // the waves of the bombs detonated by a chain reaction
 	grid                *GridComp
 	rockEffect          *RockEffectComp
+	bodyMatchEffects    []*BodyMatchEffectComp // the fly-off of the matched bodies
 	input               *UserInput
 	apc                 *PieceComp
+	comboLabel          *FloatingTextComp
+	flashEffect         *FlashEffectComp
+	bombParticles       *ParticleSystemComp
+	screenShake         *ScreenShakeComp
+	scorePopups         []*FloatingTextComp // the score popups added to compMgr
 	gameOver            *DialogComp
+	pauseDialog         *DialogComp
+	menu                *MenuDialogComp
+	profileMenu         *MenuDialogComp // the profile picker, its items are listed by openProfileMenu
+	nameEntry           *NameEntryComp
+	options             *OptionsComp
+	tutorial            *TutorialScreenComp
+	stats               *StatsScreenComp
+	achievements        []*Achievement
+	achievementNotice   *DialogComp // notifies the unlocked achievements
+	tutorialPrompt      *DialogComp // the instruction of the tutorial step
 	sideBar             *SideBarComp
+	config              GameConfig
 	activePiece         *Piece // can be nil while rockEffect is active on the joined pieces
-	nextPiece           *Piece
+	nextPieces          [nextPieceCnt]*Piece // queue of the upcoming pieces, the immediate next first
+	holdPiece           *Piece // piece saved for later by the hold action. nil if empty
+	holdUsed            bool   // the hold action is allowed once until the active piece lands
+	lockDelayFrameCount int    // counts frames since the active piece touched down. 0 if it is not touching down
 	score               int
 	frameCount          int
 	dropFrameCount      int // counts frames. used for determining time to drop the piece
+	turboDrop           bool // true while the drop key is held in turbo drop mode
 	gameTimeSec         float32
 	speedLevelIdx       int                // index in speedLevels
+	startSpeedLevelIdx  int                // the speed level index of a new game
+	difficulty          int                // progress of the difficulty ramp in percent
 	spawnProb           map[string]float32 // relative probability by piece type (default is 1.0)
 	spawnStat           map[string]int     // game statistics: number of spawned pieces per piece type
+	pieceBag            []int              // shuffled indices in allPieces. the pieces are drawn from the end
+	pieceBagCnt         int                // nr of the piece bags filled in the game
+	completionLog       []BodyCompletion   // history of the completed bodies, the oldest first
+	forfeitHoldFrameCnt int                // counts the frames while the forfeit key is held
+	forfeited           bool
+	paused              bool // the game time stops and the pause dialog blocks the game
+	menuKeyFrameCnt     int  // counts the frames while the menu key is held
+	speedHooks          []func(int)        // called with the new speed level index when the speed level increases
+	chainDepth          int                // nr of consecutive joins since the active piece landed
+	maxCombo            int                // the deepest join chain of the game
+	comboCount          int                // nr of consecutive joins completing bodies. reset when a piece lands without a join
+	bodyCnt             map[string]int     // game statistics: number of completions per body name
+	placedPieceCnt      int                // game statistics: number of landed pieces
+	bombCnt             int                // game statistics: number of landed bombs, row bombs and nukes
+	bombBlastRadius     int                // a bomb destroys the pieces in the square of 2*bombBlastRadius+1 cells centered below it
+	debugMode           bool               // the grid shows the type and the rotation of the pieces in the cells
+	fpsCounter          *FPSCounterComp
+	showFPS             bool               // the FPS counter is shown over the game
+	layoutScreenSize    Size               // the screen size of the last relayout
+	layoutGridSize      Size               // the grid size of the last relayout
+	rng                 *rand.Rand         // generates the pieces. seeded by tutorialRandSeed in tutorial mode
+	rngSeed             int64              // the last seed of rng, see seedRng
+	adaptiveRng         *rand.Rand         // rolls the adaptive spawns, so they do not shift the sequence of rng. seeded with rng
+	tutorialMode        bool               // the player is guided by tutorialSteps
+	tutorialStepIdx     int                // index of the current step in tutorialSteps
+	practice            bool               // the full grid is cleared instead of ending the game. the scores are not saved
+	challengeDesc       []string           // grid description of the challenge locked at the start. nil if not a challenge
+	dailyDate           time.Time          // start date of the daily challenge. zero if not a daily challenge
+	sprintTarget        int                // the sprint ends successfully at this score. 0 if not a sprint
+	sprintStartTime     time.Time          // wall-clock time of the first spawn of the sprint
+	sprintCountdown     *SprintCountdownComp
+	marathon            bool               // the speed level is fixed at the cap of GameConfig.maxSpeedLevel
+	adaptiveSpawnCnt    int                // spawns while bodies lacking one piece are waiting. the adaptive bias decays by it
+	updateCnt           int                // nr of updates since the start of the game, also while paused. the frames of the replays
+	replayRecorder      *ReplayRecorder    // records the inputs of the game. nil if not recorded
+	replayPlayer        *ReplayPlayer      // plays the inputs of a recorded game instead of the keyboard. nil if not replayed
+	screenshotRequested bool               // the next Draw saves the screen to a PNG file
+	screenshotOnce      sync.Once          // allocates screenshotImage at the first screenshot
+	screenshotImage     *ebiten.Image      // off-screen copy of the screen for the screenshots
+}
+
+/*
+toggleDebugMode switches the debug overlay of the grid on and off.
+*/
+func (g *Game) toggleDebugMode() {
+	g.debugMode = !g.debugMode
+	showDebugOverlay = g.debugMode
+	log.Printf("Debug mode %v", g.debugMode)
+}
+
+/*
+toggleFPS shows and hides the FPS counter.
+*/
+func (g *Game) toggleFPS() {
+	g.showFPS = !g.showFPS
+	g.fpsCounter.activate(g.showFPS)
+}
+
+/*
+toggleFullscreen switches between the window and the fullscreen. The logical screen size is kept by Layout,
+Ebiten scales it to the display.
+*/
+func (g *Game) toggleFullscreen() {
+	ebiten.SetFullscreen(!ebiten.IsFullscreen())
+	syncFullscreen()
 }
 
 /*
@@ -186,26 +718,177 @@ Reset reinitializes the game state to start a new game.
 func (g *Game) Reset() {
 	log.Printf("Game reset. Spawn stat: %v", g.spawnStat)
 
+	// a restart during the name entry saves the high score with the name typed so far
+	if g.nameEntry.getState() != StateInactive {
+		g.nameEntry.confirm()
+	}
 	g.compMgr.reset() // makes all component inactive
 
+	if g.replayPlayer != nil {
+		g.seedRng(g.replayPlayer.replay.Seed)
+		g.replayPlayer.restart()
+	} else if g.tutorialMode {
+		g.seedRng(tutorialRandSeed)
+	} else if !g.dailyDate.IsZero() {
+		// the date is taken at each start, so the sequence changes at midnight
+		g.dailyDate = time.Now()
+		g.seedRng(getDailySeed(g.dailyDate))
+	} else if g.replayRecorder != nil {
+		// the recorded game is reproduced from its seed
+		g.seedRng(time.Now().UnixNano())
+	}
+	if g.replayRecorder != nil {
+		g.replayRecorder.start(g.rngSeed)
+	}
+	g.updateCnt = 0
+	g.pieceBag = nil
+	g.pieceBagCnt = 0
 	g.activePiece = g.generatePiece()
-	g.nextPiece = g.generatePiece()
+	g.fillNextPieces()
 	g.score = 0
 	g.frameCount = 0
 	g.dropFrameCount = 0
 	g.gameTimeSec = 0
-	g.speedLevelIdx = 0
+	g.setSpeedLevel(g.startSpeedLevelIdx)
+	g.difficulty = 0
 	g.spawnStat = map[string]int{}
+	g.completionLog = nil
+	g.forfeitHoldFrameCnt = 0
+	g.forfeited = false
+	g.paused = false
+	g.menuKeyFrameCnt = 0
+	g.chainDepth = 0
+	g.maxCombo = 0
+	g.bodyCnt = map[string]int{}
+	g.placedPieceCnt = 0
+	g.adaptiveSpawnCnt = 0
+	g.bombCnt = 0
+	g.comboCount = 0
+	g.holdPiece = nil
+	g.holdUsed = false
+	g.lockDelayFrameCount = 0
 
 	g.background.activate(true)
 	g.apc.activate(true)
 	g.grid.activate(true)
 	g.sideBar.activate(true)
+	g.fpsCounter.activate(g.showFPS)
+	g.sprintCountdown.activate(0 < g.sprintTarget)
+	g.sprintStartTime = time.Now()
 	g.apc.p = g.activePiece
 
+	if g.challengeDesc != nil {
+		g.lockChallengePieces()
+	}
+
+	if g.tutorialMode {
+		g.tutorialStepIdx = 0
+		g.showTutorialStep()
+	}
+
 	MUSIC_PLAYER.Play()
 }
 
+/*
+seedRng restarts the piece generation from the seed.
+*/
+func (g *Game) seedRng(seed int64) {
+	g.rngSeed = seed
+	g.rng = rand.New(rand.NewSource(seed))
+	g.adaptiveRng = rand.New(rand.NewSource(seed + 1))
+}
+
+/*
+recordReplay starts a new game recording the inputs. The replay is saved to the path at the end of each game,
+and on quit.
+*/
+func (g *Game) recordReplay(path string) {
+	g.replayRecorder = NewReplayRecorder(path)
+	g.Reset()
+}
+
+/*
+saveReplay saves the inputs of the game if it is recorded.
+*/
+func (g *Game) saveReplay() {
+	if g.replayRecorder != nil {
+		if err := g.replayRecorder.save(); err != nil {
+			log.Printf("%v", err)
+		}
+	}
+}
+
+/*
+quit exits the application. The replay of the unfinished game is saved.
+*/
+func (g *Game) quit() {
+	log.Printf("Quit with score %d", g.score)
+	g.saveReplay()
+	os.Exit(0)
+}
+
+/*
+clearGameMode ends the practice, the challenge, the daily challenge, the sprint, the marathon and the tutorial modes.
+The replays are kept.
+*/
+func (g *Game) clearGameMode() {
+	g.practice = false
+	g.challengeDesc = nil
+	g.dailyDate = time.Time{}
+	g.sprintTarget = 0
+	if g.marathon {
+		g.marathon = false
+		g.startSpeedLevelIdx = currentOptions.StartSpeedLevel - 1
+	}
+	g.tutorialMode = false
+}
+
+/*
+startTutorialMode starts a new game guided by tutorialSteps. The other modes are ended.
+*/
+func (g *Game) startTutorialMode() {
+	g.clearGameMode()
+	g.tutorialMode = true
+	g.Reset()
+}
+
+/*
+showTutorialStep shows the prompt of the current tutorial step.
+*/
+func (g *Game) showTutorialStep() {
+	g.tutorialPrompt.text = []string{T(tutorialSteps[g.tutorialStepIdx].prompt)}
+	g.tutorialPrompt.activate(true)
+}
+
+/*
+updateTutorialMode advances the tutorial when the player performs the action of the current step.
+After the last step the game goes on in normal play.
+*/
+func (g *Game) updateTutorialMode() {
+	if !g.input.isKeyPressed(tutorialSteps[g.tutorialStepIdx].action) {
+		return
+	}
+
+	g.tutorialStepIdx++
+	if g.tutorialStepIdx < len(tutorialSteps) {
+		g.showTutorialStep()
+		return
+	}
+
+	log.Printf("Tutorial completed")
+	g.tutorialMode = false
+	g.tutorialPrompt.activate(false)
+	g.seedRng(time.Now().UnixNano())
+}
+
+/*
+Returns the position of the tutorial prompt, near the top of the grid.
+*/
+func (g *Game) getTutorialPromptPos() Pos {
+	x, y := grid2ScrPos(float32(gridSize.w)/2, 3)
+	return Pos{int(x), int(y)}
+}
+
 /*
 LoadImage loads an image from the specified file path.
 
@@ -225,21 +908,50 @@ func LoadImage(path string) (*ebiten.Image, error) {
 
 var allPieces []Piece
 var allBodies []*Body
+var builtinBodies []*Body // the bodies used when there is no bodiesFileName
+var showDebugOverlay bool // GridComp draws the cell occupancy over the grid. follows Game.debugMode
 
+//go:generate go run atlasgen.go -out assets/sprites assets/head10x10.png assets/torso10x10.png assets/right_brk_torso10x10.png assets/left_brk_torso10x10.png assets/leg10x10.png assets/bomb11x11.png assets/eraser_old10x10.png assets/eraser_new10x10.png assets/row_bomb10x10.png assets/transpose10x10.png assets/earthquake10x10.png assets/nuke10x10.png assets/wildcard10x10.png assets/bar20x10.png
 func init() {
+	// the piece images are cut from the sprite atlas, so the locked pieces are drawn by one call
+	sprites, err := loadSpriteAtlas(spriteAtlasPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	sprite := func(path string) *ebiten.Image {
+		img, ok := sprites[path]
+		if !ok {
+			log.Fatalf("sprite %s is missing from the atlas, run go generate", path)
+		}
+		return img
+	}
+
 	allPieces = []Piece{
-		{image: mustLoadImage("assets/head10x10.png"), currentRotation: 0, size: Size{1, 1}, pieceType: "Head"},
-		{image: mustLoadImage("assets/torso10x10.png"), currentRotation: 0, size: Size{1, 1}, pieceType: "Torso"},
-		{image: mustLoadImage("assets/right_brk_torso10x10.png"), currentRotation: 0, size: Size{1, 1}, pieceType: "RightBrkTorso"},
-		{image: mustLoadImage("assets/left_brk_torso10x10.png"), currentRotation: 0, size: Size{1, 1}, pieceType: "LeftBrkTorso"},
-		{image: mustLoadImage("assets/leg10x10.png"), currentRotation: 0, size: Size{1, 1}, pieceType: "Leg"},
-		{image: mustLoadImage("assets/bomb11x11.png"), currentRotation: 0, size: Size{1, 1}, pieceType: "Bomb"},
+		{image: sprite("assets/head10x10.png"), currentRotation: 0, size: Size{1, 1}, pieceType: "Head"},
+		{image: sprite("assets/torso10x10.png"), currentRotation: 0, size: Size{1, 1}, pieceType: "Torso"},
+		{image: sprite("assets/right_brk_torso10x10.png"), currentRotation: 0, size: Size{1, 1}, pieceType: "RightBrkTorso"},
+		{image: sprite("assets/left_brk_torso10x10.png"), currentRotation: 0, size: Size{1, 1}, pieceType: "LeftBrkTorso"},
+		{image: sprite("assets/leg10x10.png"), currentRotation: 0, size: Size{1, 1}, pieceType: "Leg"},
+		{image: sprite("assets/bomb11x11.png"), currentRotation: 0, size: Size{1, 1}, pieceType: "Bomb"},
+		{image: sprite("assets/eraser_old10x10.png"), currentRotation: 0, size: Size{1, 1}, pieceType: "EraserOld"},
+		{image: sprite("assets/eraser_new10x10.png"), currentRotation: 0, size: Size{1, 1}, pieceType: "EraserNew"},
+		{image: sprite("assets/row_bomb10x10.png"), currentRotation: 0, size: Size{1, 1}, pieceType: "RowBomb"},
+		{image: sprite("assets/transpose10x10.png"), currentRotation: 0, size: Size{1, 1}, pieceType: "Transpose"},
+		{image: sprite("assets/earthquake10x10.png"), currentRotation: 0, size: Size{1, 1}, pieceType: "Earthquake"},
+		{image: sprite("assets/nuke10x10.png"), currentRotation: 0, size: Size{1, 1}, pieceType: "Nuke"},
+		{image: sprite("assets/wildcard10x10.png"), currentRotation: 0, size: Size{1, 1}, pieceType: "Wildcard"},
+		{image: sprite("assets/bar20x10.png"), currentRotation: 0, size: Size{2, 1}, pieceType: "Bar"},
+	}
+
+	for i := range allPieces {
+		allPieces[i].color = pieceColorScheme.getPieceColor(allPieces[i].pieceType)
+		defaultPieceImages[allPieces[i].pieceType] = allPieces[i].image
 	}
 
 	// size of a piece
 	genericSize := allPieces[0].size
 
-	allBodies = []*Body{
+	builtinBodies = []*Body{
 		{ // bar shape, consists of 2 parts
 			name:  "Asshead",
 			score: 500,
@@ -247,10 +959,12 @@ func init() {
 				{pos: Pos{0, 0}, rotation: 0, pieceType: "Head"},
 				{pos: Pos{0, genericSize.h}, rotation: 0, pieceType: "Leg"},
 			},
+			triggerEffect: func(game *Game, matchedPieces []*Piece) { game.startWaveEffect(matchedPieces[0]) },
 		},
 		{ // bar shape, consists of 3 parts
 			name:  "Fellow",
 			score: 1000,
+			countAllRotations: true, // scores also upside-down
 			bodyPieces: []BodyPiece{ // defined as vertical bar
 				{pos: Pos{0, 0}, rotation: 0, pieceType: "Head"},
 				{pos: Pos{0, genericSize.h}, rotation: 0, pieceType: "Torso"},
@@ -260,6 +974,7 @@ func init() {
 		{ // L shape, consists of 3 parts
 			name:  "Killed Bill",
 			score: 3000,
+			completionSFX: hitSoundFile,
 			bodyPieces: []BodyPiece{ // defined as L shape
 				{pos: Pos{0, 0}, rotation: 90, pieceType: "Head"},
 				{pos: Pos{genericSize.h, 0}, rotation: 0, pieceType: "RightBrkTorso"},
@@ -271,27 +986,168 @@ func init() {
 			score: 3000,
 			bodyPieces: []BodyPiece{ // defined as L shape
 				{pos: Pos{0, 0}, rotation: 0, pieceType: "LeftBrkTorso"},
-				{pos: Pos{genericSize.h, 0}, rotation: 270, pieceType: "Head"},
+				{pos: Pos{genericSize.h, 0}, rotation: 270, pieceType: "Head", scoreWeight: 2},
+				{pos: Pos{0, genericSize.h}, rotation: 0, pieceType: "Leg"},
+			},
+		},
+		{ // plus shape, consists of 5 parts
+			name:  "Cruciform",
+			score: 5000,
+			bodyPieces: []BodyPiece{ // defined as a horizontal bar from the leg to the head, crossed by the broken torsos at the torso
 				{pos: Pos{0, genericSize.h}, rotation: 0, pieceType: "Leg"},
+				{pos: Pos{genericSize.w, genericSize.h}, rotation: 0, pieceType: "Torso"},
+				{pos: Pos{2 * genericSize.w, genericSize.h}, rotation: 0, pieceType: "Head"},
+				{pos: Pos{genericSize.w, 0}, rotation: 0, pieceType: "RightBrkTorso"},
+				{pos: Pos{genericSize.w, 2 * genericSize.h}, rotation: 0, pieceType: "LeftBrkTorso"},
+			},
+		},
+		{ // Z shape, consists of 4 parts
+			name:  "Zigzag",
+			score: 2500,
+			bodyPieces: []BodyPiece{ // defined as two horizontal bars, the lower one shifted to the right
+				{pos: Pos{0, 0}, rotation: 0, pieceType: "Head"},
+				{pos: Pos{genericSize.w, 0}, rotation: 0, pieceType: "RightBrkTorso"},
+				{pos: Pos{genericSize.w, genericSize.h}, rotation: 0, pieceType: "LeftBrkTorso"},
+				{pos: Pos{2 * genericSize.w, genericSize.h}, rotation: 0, pieceType: "Leg"},
 			},
 		},
+		{ // bar shape, consists of 2 double-cell parts
+			name:  "Bridge",
+			score: 1500,
+			bodyPieces: []BodyPiece{ // defined as horizontal bar
+				{pos: Pos{0, 0}, rotation: 0, pieceType: "Bar"},
+				{pos: Pos{2 * genericSize.w, 0}, rotation: 0, pieceType: "Bar"},
+			},
+		},
+	}
+	allBodies = builtinBodies
+}
+
+/*
+PracticeGame creates a game in practice mode. The game does not end when the grid is full, and
+the scores are not saved.
+*/
+func PracticeGame() *Game {
+	game := NewGame()
+	game.practice = true
+	return game
+}
+
+/*
+ChallengeGame creates a game starting with the pieces of the grid description locked, see parseGridDesc.
+The challenge is completed when all the locked pieces are cleared by forming bodies, and fails like
+the normal game when the grid is full. The scores are not saved.
+*/
+func ChallengeGame(gridDesc []string) *Game {
+	game := NewGame()
+	game.challengeDesc = gridDesc
+	game.lockChallengePieces()
+	return game
+}
+
+/*
+DailyChallengeGame creates a game in daily challenge mode. The pieces are generated from a seed derived from
+the current date, so every player gets the same pieces on the same day. The scores are saved separately
+for each day.
+*/
+func DailyChallengeGame() *Game {
+	game := NewGame()
+	game.startDailyChallenge()
+	return game
+}
+
+/*
+SprintGame creates a game in sprint mode. The game ends successfully when the score reaches the target score,
+the time taken is saved to sprintTimesFileName.
+*/
+func SprintGame(targetScore int) *Game {
+	game := NewGame()
+	game.sprintTarget = targetScore
+	game.Reset()
+	return game
+}
+
+/*
+MarathonGame creates a game in marathon mode. The game starts at the 1-based speed level maxSpeedLevel, the
+highest one if it is 0, and never accelerates.
+*/
+func MarathonGame(maxSpeedLevel int) *Game {
+	game := NewGame()
+	game.marathon = true
+	game.config.maxSpeedLevel = maxSpeedLevel
+	if game.config.maxSpeedLevel == 0 {
+		game.config.maxSpeedLevel = len(speedLevels)
+	}
+	game.startSpeedLevelIdx = game.getMaxSpeedLevelIdx()
+	game.Reset()
+	return game
+}
+
+/*
+getDefaultKeyBindings returns the keys of the actions used when there are no saved bindings.
+*/
+func getDefaultKeyBindings() map[string]KeyList {
+	return map[string]KeyList{
+		"rotate": []ebiten.Key{ebiten.KeyArrowUp, ebiten.KeyEnter, ebiten.KeyNumpad8, ebiten.KeyDigit8},
+		"left": []ebiten.Key{ebiten.KeyArrowLeft, ebiten.KeyNumpad7, ebiten.KeyDigit7},
+		"right": []ebiten.Key{ebiten.KeyArrowRight, ebiten.KeyNumpad9, ebiten.KeyDigit9},
+		"drop": []ebiten.Key{ebiten.KeyArrowDown, ebiten.KeyNumpad5, ebiten.KeySpace, ebiten.KeyDigit5},
+		"speedup": []ebiten.Key{ebiten.KeyS},
+		"hint": []ebiten.Key{ebiten.KeyH},
+		"debug": []ebiten.Key{ebiten.KeyBackquote},
+		"fps": []ebiten.Key{ebiten.KeyF3},
+		"fullscreen": []ebiten.Key{ebiten.KeyF11},
+		"screenshot": []ebiten.Key{ebiten.KeyF12},
+		"forfeit": []ebiten.Key{ebiten.KeyEscape},
+		"hold": []ebiten.Key{ebiten.KeyShiftLeft, ebiten.KeyShiftRight},
+		"mute": []ebiten.Key{ebiten.KeyM},
+		"nextTrack": []ebiten.Key{ebiten.KeyN},
+		"pause": []ebiten.Key{ebiten.KeyP},
+		"menu": []ebiten.Key{ebiten.KeyEscape},
+	}
+}
+
+/*
+loadKeyBindings loads the key bindings of the current profile. The bindings are saved when there are none yet.
+*/
+func loadKeyBindings(input *UserInput) {
+	path := getProfilePath(keyBindingsFileName)
+	if err := input.LoadKeyBindings(path); errors.Is(err, os.ErrNotExist) {
+		if err := input.SaveKeyBindings(path); err != nil {
+			log.Printf("%v", err)
+		}
+	} else if err != nil {
+		log.Printf("%v", err)
 	}
 }
 
 /*
 NewGame creates and returns a new Game instance with initialized pieces
-and game state.
+and game state. The optional replay player replaces the keyboard and the mouse by the recorded inputs,
+and the pieces are generated from the recorded seed.
 */
-func NewGame() *Game {
+func NewGame(replayPlayer ...*ReplayPlayer) *Game {
 	MUSIC_PLAYER.Play()
+	// load the custom bodies if any, otherwise the built-in bodies are used
+	if bodies, err := LoadBodiesFromJSON(bodiesFileName); err == nil {
+		allBodies = bodies
+	} else {
+		if !errors.Is(err, os.ErrNotExist) {
+			log.Printf("%v", err)
+		}
+		allBodies = builtinBodies
+	}
+
 	// initialze bodies
 	for _, body := range allBodies {
 		print("%v", body)
 		body.init()
 	}
+	allBodies = appendRotationVariants(allBodies)
+	allThemes = loadThemes(themesDirName)
 	
 	// load font
-	if normTextFace == nil || smallTextFace == nil {
+	if textFaceSource == nil {
 		ttfFile, err := os.Open("assets/veramono/VeraMono.ttf")
 		if err != nil {
 			log.Fatal(err)
@@ -301,61 +1157,148 @@ func NewGame() *Game {
 		if err != nil {
 			log.Fatal(err)
 		}
-		mplusFaceSource := s
+		textFaceSource = s
+	}
 
-		normTextFace = &text.GoTextFace {
-			Source: mplusFaceSource,
-			Size:   20,
-		}
+	// the faces are recreated by the current uiScale
+	normTextFace = &text.GoTextFace {
+		Source: textFaceSource,
+		Size:   normTextSize * uiScale,
+	}
 
-		smallTextFace = &text.GoTextFace {
-			Source: mplusFaceSource,
-			Size:   12,
-		}
+	smallTextFace = &text.GoTextFace {
+		Source: textFaceSource,
+		Size:   smallTextSize * uiScale,
+	}
+
+	bigTextFace = &text.GoTextFace {
+		Source: textFaceSource,
+		Size:   bigTextSize * uiScale,
 	}
 
 	game := &Game{
 		compMgr:    NewComponentMgr(),
-		spawnProb:  map[string]float32{ "Torso":0.5, "RightBrkTorso":0.5, "LeftBrkTorso":0.5, "Bomb":0.75 },
+		spawnProb:  map[string]float32{ "Torso":0.5, "RightBrkTorso":0.5, "LeftBrkTorso":0.5, "Bomb":0.75, "EraserOld":0.2, "EraserNew":0.2, "RowBomb":0.2, "Transpose":0.2, "Earthquake":0.2, "Nuke":0.2, "Wildcard":0.1, "Bar":0.2 },
 		spawnStat:  make(map[string]int),
+		bodyCnt:    make(map[string]int),
+		bombBlastRadius: 1,
+		config:     GameConfig{adaptiveBias: defaultAdaptiveBias},
 	}
+	game.seedRng(time.Now().UnixNano())
 
 	if userInput == nil {
-		userInput = NewUserInput(&map[string]KeyList{
-			"rotate": []ebiten.Key{ebiten.KeyArrowUp, ebiten.KeyEnter, ebiten.KeyNumpad8, ebiten.KeyDigit8},
-			"left": []ebiten.Key{ebiten.KeyArrowLeft, ebiten.KeyNumpad7, ebiten.KeyDigit7},
-			"right": []ebiten.Key{ebiten.KeyArrowRight, ebiten.KeyNumpad9, ebiten.KeyDigit9},
-			"drop": []ebiten.Key{ebiten.KeyArrowDown, ebiten.KeyNumpad5, ebiten.KeySpace, ebiten.KeyDigit5},
-			"speedup": []ebiten.Key{ebiten.KeyS}, } )
+		keyDesc := getDefaultKeyBindings()
+		userInput = NewUserInput(&keyDesc)
 	}
 
 	gridCenterX, gridCenterY := grid2ScrPos(float32(gridSize.w)/2, float32(gridSize.h)/2)
 
 	game.input = userInput
-	game.background = NewBackground(Pos{0, 0}, Size{screenWidth - sidebarWidth, screenHeight}, DrawOrderBkgd)
+	userInput.replaying = 0 < len(replayPlayer)
+	screenLayout = getLayout(Size{screenWidth, screenHeight}, sidebarWidth)
+	game.background = NewBackground(DrawOrderBkgd)
 	game.waveEffect = NewWaveEffect(false, Rect{Pos{0, 0}, Size{screenWidth, screenHeight}}, scale, waveEffectFillPcnt, (int)(waveEffectLifeTimeSec * ticksPerSec), DrawOrderWaveEffect)
 	game.grid = NewGridComp(gridSize, DrawOrderGrid)
 	game.rockEffect = NewRockEffect(true, (int)(rockEffectLifeTimeSec * ticksPerSec), rockEffectNofRock, DrawOrderRockEffect)
-	game.apc = NewPieceComp(game.grid, userInput, DrawOrderActivePiece)
-	game.gameOver = NewModalDialog([]string{}, Pos{int(gridCenterX), int(gridCenterY)}, DrawOrderGameOver)
-	game.sideBar = NewSideBar(userInput, Pos{screenWidth - sidebarWidth, 0}, Size{sidebarWidth, screenHeight}, func() { game.Reset() }, DrawOrderSideBar)
+	game.flashEffect = NewFlashEffect(flashEffectHoldFrameCnt, flashEffectFadeFrameCnt, DrawOrderFlashEffect)
+	game.flashEffect.SetColor(flashEffectColor)
+	game.bombParticles = NewParticleSystem(bombParticleCnt, (int)(bombParticleLifeTimeSec * ticksPerSec), 3, bombParticleColor, DrawOrderParticles)
+	game.screenShake = NewScreenShake(DrawOrderScreenShake)
+	game.fpsCounter = NewFPSCounter(Pos{4, 4}, DrawOrderFPS)
+	game.sprintCountdown = NewSprintCountdown(game.getSprintCountdownPos(), DrawOrderSprintCountdown)
+	game.comboLabel = NewFloatingText(scale, (int)(comboLabelLifeTimeSec * ticksPerSec), DrawOrderComboLabel)
+	game.apc = NewPieceComp(game.grid, userInput, func() { game.resetLockDelay() }, DrawOrderActivePiece)
+	game.gameOver = NewModalDialog([]string{}, Pos{int(gridCenterX), int(gridCenterY)}, DrawOrderGameOver).WithBackground(mustLoadImage("assets/smoke64x32.png"))
+	game.pauseDialog = NewModalDialog([]string{}, Pos{int(gridCenterX), int(gridCenterY)}, DrawOrderPause).WithFillColor(pauseDialogColor)
+	game.menu = NewMenuDialog(userInput, []MenuItem{
+		{"menu.resume", func() { game.menu.activate(false) }},
+		{"menu.options", func() {
+			game.menu.activate(false)
+			game.options.activate(true)
+		}},
+		{"menu.howToPlay", func() {
+			game.menu.activate(false)
+			game.tutorial.activate(true)
+		}},
+		{"menu.tutorial", func() { game.startTutorialMode() }},
+		{"menu.dailyChallenge", func() { game.startDailyChallenge() }},
+		{"menu.statistics", func() {
+			game.menu.activate(false)
+			game.stats.activate(true)
+		}},
+		{"menu.profile", func() { game.openProfileMenu() }},
+		{"menu.restart", func() { game.Reset() }},
+		{"menu.quit", func() { game.quit() }},
+	}, Pos{int(gridCenterX), int(gridCenterY)}, DrawOrderMenu)
+	game.profileMenu = NewMenuDialog(userInput, nil, Pos{int(gridCenterX), int(gridCenterY)}, DrawOrderProfiles)
+	game.options = NewOptions(game, userInput, Pos{int(gridCenterX), int(gridCenterY)}, func() { game.menu.activate(true) }, DrawOrderOptions)
+	game.achievementNotice = NewDialog([]string{}, game.getAchievementNoticePos(), achievementNoticeFrameCnt, DrawOrderAchievement)
+	game.initAchievements()
+	game.stats = NewStatsScreen(userInput, Pos{int(gridCenterX), int(gridCenterY)}, func() { game.menu.activate(true) }, DrawOrderStats)
+	game.tutorial = NewTutorialScreen(userInput, Pos{int(gridCenterX), int(gridCenterY)}, func() { game.menu.activate(true) }, DrawOrderTutorial)
+	game.tutorialPrompt = NewDialog([]string{}, game.getTutorialPromptPos(), 0, DrawOrderTutorialPrompt)
+	game.nameEntry = NewNameEntry(userInput, playerNameMaxLen, Pos{int(gridCenterX), int(gridCenterY)}, DrawOrderGameOver)
+	game.sideBar = NewSideBar(userInput, screenLayout.sidebar.pos, screenLayout.sidebar.size, func() { game.Reset() }, func() {
+		if !game.compMgr.isBlocked() {
+			game.dropPiece()
+		}
+	}, toggleMute, func() { game.relayout() }, DrawOrderSideBar)
 
 	game.compMgr.add(game.background)
 	game.compMgr.add(game.waveEffect)
+	for i := 0; i < chainWaveMaxCnt; i++ {
+		wave := NewWaveEffect(false, Rect{Pos{0, 0}, Size{screenWidth, screenHeight}}, scale, waveEffectFillPcnt, (int)(waveEffectLifeTimeSec * ticksPerSec), DrawOrderChainWave+i)
+		game.chainWaves = append(game.chainWaves, wave)
+		game.compMgr.add(wave)
+	}
 	game.compMgr.add(game.grid)
+	for i := 0; i < bodyMatchEffectMaxCnt; i++ {
+		effect := NewBodyMatchEffect(bodyMatchEffectFrameCnt, bodyMatchEffectFlyDist, DrawOrderBodyMatch+i)
+		game.bodyMatchEffects = append(game.bodyMatchEffects, effect)
+		game.compMgr.add(effect)
+	}
 	game.compMgr.add(game.rockEffect)
 	game.compMgr.add(game.apc)
+	game.compMgr.add(game.flashEffect)
+	game.compMgr.add(game.bombParticles)
+	game.compMgr.add(game.comboLabel)
 	game.compMgr.add(game.gameOver)
+	game.compMgr.add(game.nameEntry)
+	game.compMgr.add(game.pauseDialog)
+	game.compMgr.add(game.menu)
+	game.compMgr.add(game.profileMenu)
+	game.compMgr.add(game.options)
+	game.compMgr.add(game.stats)
+	game.compMgr.add(game.tutorial)
+	game.compMgr.add(game.tutorialPrompt)
+	game.compMgr.add(game.achievementNotice)
 	game.compMgr.add(game.sideBar)
+	game.compMgr.add(game.screenShake)
+	game.compMgr.add(game.fpsCounter)
+	game.compMgr.add(game.sprintCountdown)
 
 	game.activePiece = game.generatePiece()
-	game.nextPiece = game.generatePiece()
+	game.fillNextPieces()
 
 	game.background.activate(true)
 	game.apc.activate(true)
 	game.grid.activate(true)
 	game.sideBar.activate(true)
+	if currentProfile != nil {
+		game.sideBar.setProfileName(currentProfile.Name)
+	}
 	game.apc.p = game.activePiece
+
+	game.registerSpeedHook(game.background.setSpeedLevel)
+	game.registerSpeedHook(func(level int) {
+		MUSIC_PLAYER.SetPlaybackRate(1 + musicRatePerSpeedLevel*float64(level))
+	})
+	game.applyOptions()
+
+	if 0 < len(replayPlayer) {
+		game.replayPlayer = replayPlayer[0]
+		game.Reset()
+	}
 	
 	return game
 }
@@ -368,43 +1311,122 @@ Returns:
 - An error if the update fails, otherwise nil.
 */
 func (g *Game) Update() error {
-	g.frameCount++
-	g.gameTimeSec += 1 / float32(ticksPerSec)
+	// the time stops while paused
+	if !g.paused && g.menu.getState() == StateInactive && g.options.getState() == StateInactive && g.stats.getState() == StateInactive && g.profileMenu.getState() == StateInactive && g.tutorial.getState() == StateInactive {
+		g.frameCount++
+		g.gameTimeSec += 1 / float32(ticksPerSec)
+		g.difficulty = int(100 * difficultyRampProgress(g.getDifficultyRampSec()))
+	}
 
-	g.input.handleKeys()
-	g.input.handleMouse()
+	g.updateCnt++
+	if g.replayPlayer != nil {
+		g.replayPlayer.play(g.updateCnt, g.input)
+	}
+	g.input.handleKeys(g.frameCount)
+	g.input.handleMouse(g.frameCount)
+	g.input.handleTouches(g.frameCount)
+	if g.replayRecorder != nil {
+		g.replayRecorder.record(g.updateCnt, g.input)
+	}
 	g.compMgr.update(g.frameCount)
+	g.removeScorePopups()
+	g.handlePause()
+	g.handleMenuKey()
+
+	// the letter keys are typed into the name entry
+	if g.nameEntry.getState() == StateInactive {
+		if g.input.isKeyPressed("hint") {
+			g.grid.showHints = !g.grid.showHints
+		}
+
+		if g.input.isKeyPressed("debug") {
+			g.toggleDebugMode()
+		}
+
+		if g.input.isKeyPressed("fps") {
+			g.toggleFPS()
+		}
+
+		if g.input.isKeyPressed("mute") {
+			toggleMute()
+		}
+
+		if g.input.isKeyPressed("nextTrack") {
+			MUSIC_PLAYER.NextTrack()
+		}
+	}
+	MUSIC_PLAYER.update()
+
+	if g.input.isKeyPressed("fullscreen") {
+		g.toggleFullscreen()
+	}
+	// the screen is captured by the next Draw
+	if g.input.isKeyPressed("screenshot") {
+		g.screenshotRequested = true
+	}
 
-	if !g.compMgr.isBlocked() {
+	// the piece falling in is not controlled until it arrives
+	if !g.compMgr.isBlocked() && !g.apc.isSpawning() {
+		g.handleForfeitKey()
 		g.speedup()
 
-		if g.checkTimeToMoveDown() {
+		if g.input.isKeyPressed("hold") {
+			g.holdActivePiece()
+		}
+
+		g.turboDrop = g.config.turboDropEnabled && g.input.isKeyDown("drop")
+
+		if g.checkTimeToMoveDown() || g.input.isKeyPressed("softDrop") {
 			g.moveDown()
 		}
+		g.updateLockDelay()
 
-		if g.input.isKeyPressed("drop") {
+		if !g.config.turboDropEnabled && g.input.isKeyPressed("drop") {
 			g.dropPiece()
 		}
+
+		if g.tutorialMode {
+			g.updateTutorialMode()
+		}
 	}
 
-	g.sideBar.setValues(g.nextPiece, g.score, g.speedLevelIdx+1, g.loadTopScores())
+	g.checkAchievements()
+
+	g.sideBar.setValues(g.nextPieces[:], g.score, g.speedLevelIdx+1, g.gameTimeSec, g.loadTopScores(), g.completionLog)
+	g.sideBar.setHoldPiece(g.holdPiece)
+	g.sideBar.setActivePiece(g.activePiece)
+	g.sideBar.setPractice(g.practice)
+	g.sideBar.setMarathon(g.marathon)
+	g.sideBar.setMaxSpeedLevel(g.getMaxSpeedLevelIdx() + 1)
+	g.sideBar.setDailyDate(g.dailyDate)
+	g.sideBar.setBodyCandidate(g.grid.getBestCandidate())
+	g.sprintCountdown.setRemaining(max(g.sprintTarget-g.score, 0))
+	g.sideBar.setMuted(MUSIC_PLAYER.IsMuted())
+	g.sideBar.setShowRotationPreview(g.config.showRotationPreview)
 
 	return nil
 }
 
 /*
 determines if it is time to drop. increases speed if it is time to go to the next speed level.
+in turbo drop mode the piece falls with the maximum speed while the drop key is held.
 returns if it is time to drop.
 */
 func (g *Game) checkTimeToMoveDown() bool {
 	g.dropFrameCount++
 
 	speedLevel := speedLevels[g.speedLevelIdx]
-	if speedLevel.ticksPerDrop <= g.dropFrameCount {
+	ticksPerDrop := speedLevel.ticksPerDrop
+	if g.turboDrop {
+		// fall with the maximum speed while the drop key is held
+		ticksPerDrop = min(ticksPerDrop, speedLevels[len(speedLevels)-1].ticksPerDrop)
+	}
+
+	if ticksPerDrop <= g.dropFrameCount {
 		g.dropFrameCount = 0
 
-		if g.speedLevelIdx+1 < len(speedLevels) && float32(speedLevel.nextLevelTimeSec) < g.gameTimeSec {
-			g.speedLevelIdx++
+		if g.speedLevelIdx < g.getMaxSpeedLevelIdx() && float32(speedLevel.nextLevelTimeSec) < g.gameTimeSec {
+			g.increaseSpeedLevel()
 			log.Printf("speed level increased to %d at %d frames, %f sec", g.speedLevelIdx, g.frameCount, g.gameTimeSec)
 		}
 
@@ -415,27 +1437,125 @@ func (g *Game) checkTimeToMoveDown() bool {
 }
 
 /*
-drop moves the active piece down the grid,
-locking it in place if it cannot move further.
+drop moves the active piece down the grid.
+if it cannot move further, the lock delay is started.
 */
 func (g *Game) moveDown() {
 	if !g.grid.canMove(g.activePiece, 0, 1) {
-		g.handleActivePieceLanded()
+		if g.lockDelayFrameCount == 0 {
+			g.lockDelayFrameCount = 1
+		}
 	} else {
 		g.activePiece.pos.y++
 	}
 }
 
+/*
+updateLockDelay counts the frames while the active piece is touching down and locks it after lockDelayMaxFrames.
+If the piece is moved over a gap, the delay is cancelled.
+*/
+func (g *Game) updateLockDelay() {
+	if g.lockDelayFrameCount == 0 || g.activePiece == nil {
+		return
+	}
+
+	if g.grid.canMove(g.activePiece, 0, 1) {
+		g.lockDelayFrameCount = 0
+		return
+	}
+
+	g.lockDelayFrameCount++
+	if lockDelayMaxFrames < g.lockDelayFrameCount {
+		g.handleActivePieceLanded()
+	}
+}
+
+/*
+resetLockDelay restarts the lock delay after the active piece is moved or rotated.
+*/
+func (g *Game) resetLockDelay() {
+	if 0 < g.lockDelayFrameCount {
+		g.lockDelayFrameCount = 1
+	}
+}
+
 /*
 speedup handles the speding up when the "increase speed" key is pressed.
 */
 func (g *Game) speedup() {
-	if g.input.isKeyPressed("speedup") && g.speedLevelIdx+1 < len(speedLevels) {
-		g.speedLevelIdx++
+	if g.input.isKeyPressed("speedup") && g.speedLevelIdx < g.getMaxSpeedLevelIdx() {
+		g.increaseSpeedLevel()
 		log.Printf("speed level increased manually to %d at %f sec", g.speedLevelIdx, g.gameTimeSec)
 	}
 }
 
+func (g *Game) metrics() GameMetrics {
+	return GameMetrics{
+		skyline:          g.grid.countSkyline(),
+		skylineBumpiness: g.grid.skylineBumpiness(),
+		skylineMaxDrop:   g.grid.skylineMaxDrop(),
+	}
+}
+
+/*
+increaseSpeedLevel goes to the next speed level and notifies the speed hooks.
+*/
+func (g *Game) increaseSpeedLevel() {
+	g.setSpeedLevel(g.speedLevelIdx + 1)
+	playSFX("levelUp")
+	g.screenShake.SetShake(levelUpShakeFrameCnt, levelUpShakePixels)
+}
+
+/*
+Returns the index of the highest speed level of the game, capped by GameConfig.maxSpeedLevel.
+*/
+func (g *Game) getMaxSpeedLevelIdx() int {
+	if 0 < g.config.maxSpeedLevel {
+		return min(g.config.maxSpeedLevel, len(speedLevels)) - 1
+	}
+	return len(speedLevels) - 1
+}
+
+/*
+setSpeedLevel sets the speed level index and notifies the speed hooks.
+*/
+func (g *Game) setSpeedLevel(idx int) {
+	g.speedLevelIdx = idx
+	for _, hook := range g.speedHooks {
+		hook(g.speedLevelIdx)
+	}
+}
+
+/*
+applyColorScheme tints the piece types and all pieces of the game by the color scheme.
+*/
+func (g *Game) applyColorScheme(scheme ColorScheme) {
+	for i := range allPieces {
+		allPieces[i].color = scheme.getPieceColor(allPieces[i].pieceType)
+	}
+
+	for _, piece := range g.getAllPieces() {
+		piece.color = scheme.getPieceColor(piece.pieceType)
+	}
+	g.grid.cacheDirty = true // the locked pieces are recolored
+}
+
+/*
+getAllPieces returns the active, the held, the next and the locked pieces of the game.
+*/
+func (g *Game) getAllPieces() []*Piece {
+	pieces := append([]*Piece{g.activePiece, g.holdPiece}, g.nextPieces[:]...)
+	pieces = append(pieces, g.grid.lockedPieces...)
+	return slices.DeleteFunc(pieces, func(p *Piece) bool { return p == nil })
+}
+
+/*
+registerSpeedHook registers a callback called with the new speed level index whenever the speed level increases.
+*/
+func (g *Game) registerSpeedHook(fn func(level int)) {
+	g.speedHooks = append(g.speedHooks, fn)
+}
+
 /*
 Draw renders the current game state to the screen, including the active piece,
 locked pieces, and sidebar information.
@@ -445,43 +1565,205 @@ Parameters:
 */
 func (g *Game) Draw(screen *ebiten.Image) {
 	g.compMgr.draw(screen)
+
+	if g.screenshotRequested {
+		g.screenshotRequested = false
+		if path, err := g.saveScreenshot(screen, time.Now()); err != nil {
+			log.Printf("%v", err)
+		} else {
+			log.Printf("Screenshot saved to '%s'", path)
+		}
+	}
 }
 
 /*
-Layout returns the logical screen dimensions for the game.
+saveScreenshot saves the composited screen to the PNG file of the time, see createScreenshotFile. The screen is
+copied to an off-screen image allocated once, it is reallocated only if the screen is resized.
+Returns the path of the file.
+*/
+func (g *Game) saveScreenshot(screen *ebiten.Image, t time.Time) (string, error) {
+	bounds := screen.Bounds()
+	g.screenshotOnce.Do(func() {
+		g.screenshotImage = ebiten.NewImage(bounds.Dx(), bounds.Dy())
+	})
+	if g.screenshotImage.Bounds().Size() != bounds.Size() {
+		g.screenshotImage.Deallocate()
+		g.screenshotImage = ebiten.NewImage(bounds.Dx(), bounds.Dy())
+	}
+	g.screenshotImage.Clear()
+	g.screenshotImage.DrawImage(screen, nil)
 
-Parameters:
-- outsideWidth: The width of the outside environment.
-- outsideHeight: The height of the outside environment.
+	img := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	g.screenshotImage.ReadPixels(img.Pix)
 
-Returns:
-- The width and height of the game screen.
+	file, err := createScreenshotFile(t)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	if err := png.Encode(file, img); err != nil {
+		return "", fmt.Errorf("failed to encode screenshot '%s': %w", file.Name(), err)
+	}
+	return file.Name(), nil
+}
+
+/*
+createScreenshotFile creates a new PNG file named by the time. The existing files are not overwritten,
+the later screenshots of the same second are suffixed by a counter, e.g. screenshot_20060102_150405_2.png.
+*/
+func createScreenshotFile(t time.Time) (*os.File, error) {
+	path := t.Format(screenshotFileFormat)
+	for i := 2; i <= screenshotMaxSuffix; i++ {
+		file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if !errors.Is(err, os.ErrExist) {
+			if err != nil {
+				return nil, fmt.Errorf("failed to create screenshot '%s': %w", path, err)
+			}
+			return file, nil
+		}
+		path = fmt.Sprintf("%s_%d.png", strings.TrimSuffix(t.Format(screenshotFileFormat), ".png"), i)
+	}
+	return nil, fmt.Errorf("failed to create screenshot '%s': too many screenshots in a second", path)
+}
+
+/*
+Layout follows the size of the window. The components are rearranged when the window or the grid is resized.
 */
 func (g *Game) Layout(outsideWidth, outsideHeight int) (int, int) {
+	size := Size{max(outsideWidth, g.sideBar.width+1), max(outsideHeight, 1)}
+	if size != g.layoutScreenSize || gridSize != g.layoutGridSize {
+		screenWidth, screenHeight = size.w, size.h
+		g.relayout()
+	}
 	return screenWidth, screenHeight
 }
 
+/*
+relayout fits the grid to the play area left to the sidebar by the scale and moves the components to the screen size.
+Called when the window, the grid or the sidebar is resized.
+*/
+func (g *Game) relayout() {
+	g.layoutScreenSize = Size{screenWidth, screenHeight}
+	g.layoutGridSize = gridSize
+	g.sideBar.width = min(max(g.sideBar.width, sidebarWidth), screenWidth-1)
+	screenLayout = getLayout(g.layoutScreenSize, g.sideBar.width)
+	scale = screenLayout.getCellSize(gridSize)
+	log.Printf("Layout %dx%d, sidebar %d, scale %d", screenWidth, screenHeight, g.sideBar.width, scale)
+
+	for _, wave := range append([]*WaveEffectComp{g.waveEffect}, g.chainWaves...) {
+		wave.rect = Rect{Pos{0, 0}, Size{screenWidth, screenHeight}}
+		wave.pixelSize, wave.pixelSize_2 = scale, scale/2
+	}
+	g.sideBar.setLayout(screenLayout.sidebar.pos, screenLayout.sidebar.size)
+
+	// the dialogs are centered on the grid
+	x, y := grid2ScrPos(float32(gridSize.w)/2, float32(gridSize.h)/2)
+	center := Pos{int(x), int(y)}
+	g.gameOver.screenPos = center
+	g.pauseDialog.screenPos = center
+	g.menu.screenPos = center
+	g.profileMenu.screenPos = center
+	g.options.screenPos = center
+	g.stats.screenPos = center
+	g.tutorial.screenPos = center
+	g.tutorialPrompt.screenPos = g.getTutorialPromptPos()
+	g.achievementNotice.screenPos = g.getAchievementNoticePos()
+	g.sprintCountdown.screenPos = g.getSprintCountdownPos()
+	g.nameEntry.dialog.screenPos = center
+}
+
 /*
 Call this when the active piece is landed. If does the following:
 If the active piece is a bomb: destroys piece below.
+If the active piece is an eraser: destroys the oldest or the newest locked pieces.
+If the active piece is a row bomb: clears the row it landed on, then join and score bodies.
+If the active piece is a transpose: rotates the board, then join and score bodies.
+If the active piece is a nuke: clears the bottom rows, then join and score bodies.
 Otherwise locks the piece, join and score bodies, then spawn a new piece.
 Spawn a new piece.
 */
 func (g *Game) handleActivePieceLanded() {
+	g.holdUsed = false
+	g.lockDelayFrameCount = 0
+	g.placedPieceCnt++
+	if g.activePiece.isBomb() || g.activePiece.isRowBomb() || g.activePiece.isNuke() {
+		g.bombCnt++
+	}
+
 	if g.activePiece.isBomb() {
-		piecesBelow := g.grid.getPiecesBelow(g.activePiece)
-		for _, piece := range piecesBelow {
-			g.grid.unlockPiece(piece)
+		g.detonateBomb(g.activePiece, 0)
+
+		g.startWaveEffect(g.activePiece)
+		g.waveEffect.setInitialRadius(g.getBlastPixelRadius())
+		g.startParticleBurst(g.activePiece)
+		g.screenShake.SetShake(bombShakeFrameCnt, bombShakePixels)
+		playSFX("bomb")
+	} else if g.activePiece.isEraser() {
+		var erasedPieces []*Piece
+		if g.activePiece.pieceType == "EraserOld" {
+			erasedPieces = g.grid.unlockOldestN(eraserPieceCnt)
+		} else {
+			erasedPieces = g.grid.unlockNewestN(eraserPieceCnt)
+		}
+		log.Printf("Eraser '%s' removed %v", g.activePiece.pieceType, erasedPieces)
+
+		g.startWaveEffect(g.activePiece)
+		playSFX("eraser")
+	} else if g.activePiece.isRowBomb() {
+		clearedPieces := g.grid.applyRowClear(g.activePiece.pos.y)
+		log.Printf("Row bomb cleared row %d: %v", g.activePiece.pos.y, clearedPieces)
+
+		g.startRowWaveEffect(g.activePiece.pos.y)
+		playSFX("rowBomb")
+
+		// the fallen pieces may form bodies
+		if g.joinPieces(slices.Clone(g.grid.lockedPieces)) {
+			return
+		}
+	} else if g.activePiece.isTranspose() {
+		if err := g.grid.transpose(); err != nil {
+			log.Printf("Transpose: %v", err)
 		}
+		g.grid.compactGrid()
 
-		// play waveEffect effect centered on the bomb
-		x, y := grid2ScrPos(float32(g.activePiece.pos.x), float32(g.activePiece.pos.y))
-		w, h := grid2ScrSize(float32(g.activePiece.size.w), float32(g.activePiece.size.h))
-		g.waveEffect.setCenter(Pos{int(x+w/2), int(y+h/2)})
-		g.waveEffect.activate(true)
-		blastPlayer.Play()
+		g.startWaveEffect(g.activePiece)
+		playSFX("transpose")
+
+		// the fallen pieces may form bodies
+		if g.joinPieces(slices.Clone(g.grid.lockedPieces)) {
+			return
+		}
+	} else if g.activePiece.isNuke() {
+		yEnd := g.grid.size.h - 2
+		yStart := yEnd - nukeRowCnt + 1
+		clearedPieces := g.grid.removeRowRange(yStart, yEnd)
+		log.Printf("Nuke cleared rows %d-%d: %v", yStart, yEnd, clearedPieces)
+
+		g.startRowRangeWaveEffect(yStart, yEnd)
+		playSFX("nuke")
+
+		// the fallen pieces may form bodies
+		if g.joinPieces(slices.Clone(g.grid.lockedPieces)) {
+			return
+		}
+	} else if g.activePiece.isEarthquake() {
+		dx := earthquakeShifts[rand.Intn(len(earthquakeShifts))]
+		if err := g.grid.applyTranslation(dx, 0); err != nil {
+			log.Printf("Earthquake: %v", err)
+		}
+		g.grid.compactGrid()
+
+		g.startRowWaveEffect(g.activePiece.pos.y)
+		playSFX("earthquake")
+
+		// the fallen pieces may form bodies
+		if g.joinPieces(slices.Clone(g.grid.lockedPieces)) {
+			return
+		}
 	} else {
 		g.grid.lockPiece(g.activePiece)
+		playSFX("land")
 
 		changedPieces := []*Piece{g.activePiece}
 		if g.joinPieces(changedPieces) {
@@ -493,24 +1775,197 @@ func (g *Game) handleActivePieceLanded() {
 			return
 		}
 	}
+	g.comboCount = 0 // the piece landed without completing a body
 	g.spawnNewPiece()
 }
 
 
+/*
+play waveEffect effect centered on the piece
+*/
+func (g *Game) startWaveEffect(piece *Piece) {
+	x, y := grid2ScrPos(float32(piece.pos.x), float32(piece.pos.y))
+	w, h := grid2ScrSize(float32(piece.size.w), float32(piece.size.h))
+	g.waveEffect.setCenter(Pos{int(x+w/2), int(y+h/2)})
+	g.waveEffect.setHorizontalLine(false)
+	g.waveEffect.setInitialRadius(0)
+	g.waveEffect.activate(true)
+}
+
+/*
+Returns the half size of the blast area of the bombs in pixels.
+*/
+func (g *Game) getBlastPixelRadius() float64 {
+	return (float64(g.bombBlastRadius) + 0.5) * float64(scale)
+}
+
+/*
+detonateBomb destroys the pieces below the bomb. The destroyed bombs detonate too, up to bombChainMaxDepth chained bombs.
+depth is the nr of bombs detonated before in the chain.
+*/
+func (g *Game) detonateBomb(bomb *Piece, depth int) {
+	piecesBelow := g.grid.getPiecesBelow(bomb, g.bombBlastRadius)
+	for _, piece := range piecesBelow {
+		g.grid.unlockPiece(piece)
+	}
+
+	for _, piece := range piecesBelow {
+		if !piece.isBomb() {
+			continue
+		}
+		if bombChainMaxDepth <= depth {
+			log.Printf("Bomb chain reached the max depth %d at %v", bombChainMaxDepth, piece.pos)
+			return
+		}
+
+		log.Printf("Bomb chain detonates the bomb at %v", piece.pos)
+		g.startChainWaveEffect(piece)
+		g.detonateBomb(piece, depth+1)
+	}
+}
+
+/*
+play a chain wave effect centered on the piece, starting at the edge of the blast area. Skipped when all chain waves are playing.
+*/
+func (g *Game) startChainWaveEffect(piece *Piece) {
+	idx := slices.IndexFunc(g.chainWaves, func(w *WaveEffectComp) bool { return w.getState() == StateInactive })
+	if idx < 0 {
+		log.Printf("Too many chain waves, the wave at %v is not shown", piece.pos)
+		return
+	}
+
+	x, y := grid2ScrPos(float32(piece.pos.x), float32(piece.pos.y))
+	w, h := grid2ScrSize(float32(piece.size.w), float32(piece.size.h))
+	g.chainWaves[idx].setCenter(Pos{int(x+w/2), int(y+h/2)})
+	g.chainWaves[idx].setHorizontalLine(false)
+	g.chainWaves[idx].setInitialRadius(g.getBlastPixelRadius())
+	g.chainWaves[idx].activate(true)
+}
+
+/*
+play the fly-off of the matched pieces. The pieces are already unlocked, they are drawn by the effect only.
+Skipped when all fly-off effects are playing.
+*/
+func (g *Game) startBodyMatchEffect(pieces []*Piece) {
+	idx := slices.IndexFunc(g.bodyMatchEffects, func(e *BodyMatchEffectComp) bool { return e.getState() == StateInactive })
+	if idx < 0 {
+		log.Printf("Too many fly-off effects, the pieces %v are not shown", pieces)
+		return
+	}
+
+	g.bodyMatchEffects[idx].start(pieces, getCentroid(pieces))
+}
+
+/*
+scatter the bomb particles from the center of the piece
+*/
+func (g *Game) startParticleBurst(piece *Piece) {
+	x, y := grid2ScrPos(float32(piece.pos.x), float32(piece.pos.y))
+	w, h := grid2ScrSize(float32(piece.size.w), float32(piece.size.h))
+	g.bombParticles.burst(Pos{int(x+w/2), int(y+h/2)}, bombParticleRange*float64(g.bombBlastRadius))
+}
+
+/*
+play waveEffect effect along the row
+*/
+func (g *Game) startRowWaveEffect(row int) {
+	g.startRowRangeWaveEffect(row, row)
+}
+
+/*
+play waveEffect effect spanning the full width, centered on the rows in [yStart, yEnd]
+*/
+func (g *Game) startRowRangeWaveEffect(yStart, yEnd int) {
+	x, y := grid2ScrPos(float32(g.grid.size.w)/2, float32(yStart+yEnd+1)/2)
+	g.waveEffect.setCenter(Pos{int(x), int(y)})
+	g.waveEffect.setHorizontalLine(true)
+	g.waveEffect.activate(true)
+}
+
 /*
 spawnNewPiece make the next piece to be the active piece and
 creates the next active piece from the available pieces.
 */
 func (g *Game) spawnNewPiece() {
-	if g.activePiece != nil && g.activePiece.pos.y == 0 && !g.grid.canMove(g.activePiece, 0, 1) {
-		g.endGame()
+	if g.challengeDesc != nil && len(g.grid.lockedPieces) == 0 {
+		g.completeChallenge()
 		return
 	}
+	if 0 < g.sprintTarget && g.sprintTarget <= g.score {
+		g.completeSprint()
+		return
+	}
+
+	if g.activePiece != nil && g.activePiece.pos.y == 0 && !g.grid.canMove(g.activePiece, 0, 1) {
+		if !g.practice {
+			g.endGame()
+			return
+		}
+
+		// the practice goes on with the top rows cleared
+		removed := g.grid.removeRowRange(0, 1)
+		log.Printf("Practice grid is full, %d pieces of the top rows are removed", len(removed))
+	}
+
+	log.Printf("Spawn new piece '%s'", g.nextPieces[0].pieceType)
+	g.chainDepth = 0 // the join chain is over
+	g.activePiece = g.takeNextPiece()
+	g.apc.startSpawnAnim(g.activePiece)
+}
 
-	log.Printf("Spawn new piece '%s'", g.nextPiece.pieceType)
-	g.activePiece = g.nextPiece
+/*
+fillNextPieces fills the queue of the upcoming pieces with new pieces.
+*/
+func (g *Game) fillNextPieces() {
+	for i := range g.nextPieces {
+		g.nextPieces[i] = g.generatePiece()
+	}
+}
+
+/*
+takeNextPiece removes the immediate next piece from the queue and appends a new piece to the end of the queue.
+Returns the removed piece.
+*/
+func (g *Game) takeNextPiece() *Piece {
+	piece := g.nextPieces[0]
+	copy(g.nextPieces[:], g.nextPieces[1:])
+	g.nextPieces[len(g.nextPieces)-1] = g.generatePiece()
+	return piece
+}
+
+/*
+holdActivePiece saves the active piece for later. The previously held piece, or the next piece if
+nothing is held, becomes the active piece at the spawn position.
+Holding is allowed once until the active piece lands.
+Returns true if the pieces are swapped.
+*/
+func (g *Game) holdActivePiece() bool {
+	if g.holdUsed || g.activePiece == nil {
+		return false
+	}
+
+	newActivePiece := g.holdPiece
+	if newActivePiece == nil {
+		newActivePiece = g.nextPieces[0]
+	}
+
+	newActivePiece.pos = Pos{g.grid.size.w / 2, 0}
+	if !g.grid.canMove(newActivePiece, 0, 0) {
+		log.Printf("No space to swap in the '%s' piece", newActivePiece.pieceType)
+		return false
+	}
+
+	log.Printf("Hold '%s', activate '%s'", g.activePiece.pieceType, newActivePiece.pieceType)
+	if g.holdPiece == nil {
+		g.takeNextPiece()
+	}
+	g.holdPiece = g.activePiece
+	g.activePiece = newActivePiece
 	g.apc.p = g.activePiece
-	g.nextPiece = g.generatePiece()
+	g.holdUsed = true
+	g.lockDelayFrameCount = 0
+
+	return true
 }
 
 /*
@@ -522,7 +1977,7 @@ If any pieces were joined, it follows this procedure:
 Returns true if any pieces were joined
 */
 func (g *Game) joinPieces(changedPieces []*Piece) bool {
-	bodies, pieces := g.grid.joinPieces(changedPieces)
+	matches, pieces := g.grid.joinPieces(changedPieces)
 
 	if 0 < len(pieces) {
 		log.Printf("joined!!! %v", pieces)
@@ -543,12 +1998,30 @@ func (g *Game) joinPieces(changedPieces []*Piece) bool {
 			log.Fatalf("The changed pieces and joined pieces are disjunct!")
 		}
 
+		g.chainDepth++
+		if g.maxCombo < g.chainDepth {
+			g.maxCombo = g.chainDepth
+			log.Printf("New max combo %d", g.maxCombo)
+		}
+
+		// the consecutive completions are rewarded with a multiplier
+		multiplier := 1 + comboMultiplierStep*float64(g.comboCount)
+		g.comboCount++
+		if 1 < g.comboCount {
+			x, y := grid2ScrPos(float32(g.grid.size.w)/2, 2)
+			g.comboLabel.show(fmt.Sprintf("COMBO x%d", g.comboCount), Pos{int(x), int(y)})
+		}
+
 		// if a body is joined, start rock effect. score + compact grid only after the effect is over
 		g.activePiece = nil
 		g.apc.p = nil // do not want the PieceComp to draw the active piece
 		g.rockEffect.setTarget(pieces)
-		g.rockEffect.setCompletedCallback(func() { g.scoreBodies(bodies) })
+		g.rockEffect.setCompletedCallback(func() {
+			g.compMgr.resumeComponent(g.grid)
+			g.scoreBodies(matches, multiplier)
+		})
 		g.rockEffect.activate(true)
+		g.compMgr.pauseComponent(g.grid) // freeze the grid while its pieces are rocked
 		joinPlayer.SeekPlay(2)
 
 		return true
@@ -557,11 +2030,34 @@ func (g *Game) joinPieces(changedPieces []*Piece) bool {
 	}
 }
 
-func (g *Game) scoreBodies(bodies []*Body) {
-	log.Printf("scoreBodies(bodies: %v)", bodies)
+func (g *Game) scoreBodies(matches []BodyMatch, multiplier float64) {
+	log.Printf("scoreBodies(matches: %v, multiplier: %f)", matches, multiplier)
 
-	for _, b := range bodies {
-		g.score += b.score
+	var flashRegions []Rect
+	for _, m := range matches {
+		for _, p := range m.pieces {
+			flashRegions = append(flashRegions, getPieceScrRect(p))
+		}
+	}
+	g.flashEffect.SetRegions(flashRegions)
+	g.flashEffect.activate(true)
+
+	for _, m := range matches {
+		g.startBodyMatchEffect(m.pieces)
+		score := int(math.Round(float64(m.score) * multiplier))
+		g.score += score
+		g.logBodyCompletion(m.body, score)
+		g.showScorePopup(score, m.pieces)
+
+		if m.body.completionSFX != "" {
+			sfxPlayer.PlaySFX(m.body.completionSFX)
+		} else {
+			playSFX("bodyCompletion")
+		}
+
+		if m.body.triggerEffect != nil {
+			m.body.triggerEffect(g, m.pieces)
+		}
 	}
 
 	changedPieces := g.grid.compactGrid()
@@ -573,35 +2069,173 @@ func (g *Game) scoreBodies(bodies []*Body) {
 }
 
 /*
-generatePiece creates a new piece from the available pieces and
-positions it at the top of the grid.
+showScorePopup shows the score rising from the center of the pieces. Each popup is a separate component
+with its own draw order, because the component manager draws only one component of a draw order.
+The popup is not shown if scorePopupMaxCnt popups are already shown.
 */
-func (g *Game) generatePiece() *Piece {
-	// determine the random range
-	var randRange float32 = 0.0
-	for _, p := range allPieces {
-		prob, ok := g.spawnProb[p.pieceType]
+func (g *Game) showScorePopup(score int, pieces []*Piece) {
+	drawOrder := DrawOrderScorePopup
+	for slices.ContainsFunc(g.scorePopups, func(p *FloatingTextComp) bool { return p.drawOrder == drawOrder }) {
+		drawOrder++
+	}
+	if DrawOrderScorePopup+scorePopupMaxCnt <= drawOrder {
+		log.Printf("Too many score popups, the score %d is not shown", score)
+		return
+	}
+
+	box := getBoundingBox(pieces)
+	x, y := grid2ScrPos(float32(box.pos.x)+float32(box.size.w)/2, float32(box.pos.y)+float32(box.size.h)/2)
+
+	popup := NewFloatingText(2*scale, scorePopupLifeTimeFrameCnt, drawOrder).WithColor(scorePopupColor)
+	g.compMgr.add(popup)
+	popup.show(fmt.Sprintf("+%d", score), Pos{int(x), int(y)})
+	g.scorePopups = append(g.scorePopups, popup)
+}
+
+/*
+removeScorePopups removes the finished score popups from the component manager.
+Not to be called during the update of the components.
+*/
+func (g *Game) removeScorePopups() {
+	g.scorePopups = slices.DeleteFunc(g.scorePopups, func(p *FloatingTextComp) bool {
+		if p.getState() == StateInactive {
+			g.compMgr.remove(p)
+			return true
+		}
+		return false
+	})
+}
+
+/*
+Appends the body to the completion history. The oldest entry is dropped when the history is full.
+*/
+func (g *Game) logBodyCompletion(body *Body, score int) {
+	g.completionLog = append(g.completionLog, BodyCompletion{bodyName: body.name, score: score, frameCnt: g.frameCount})
+	g.bodyCnt[body.name]++
+	g.adaptiveSpawnCnt = 0 // the bias is restored for the next waiting body
+	if completionLogMaxLen < len(g.completionLog) {
+		g.completionLog = g.completionLog[len(g.completionLog)-completionLogMaxLen:]
+	}
+}
+
+/*
+Returns the progress of the difficulty ramp in [0,1] at the given game time.
+*/
+func difficultyRampProgress(timeSec float32) float32 {
+	return min(max(timeSec/difficultyRampSec, 0), 1)
+}
+
+/*
+getDifficultyRampSec returns the time of the difficulty ramp. It is the game time, except in the daily challenge,
+where the ramp follows the filled piece bags, so the piece sequence does not depend on the speed of the play.
+*/
+func (g *Game) getDifficultyRampSec() float32 {
+	if !g.dailyDate.IsZero() {
+		return difficultyRampSec * float32(max(g.pieceBagCnt-1, 0)) / float32(dailyRampBagCnt)
+	}
+	return g.gameTimeSec
+}
+
+/*
+difficultyRamp returns the spawn probabilities at the given game time.
+The probabilities listed in spawnProbRampStart are linearly interpolated towards spawnProbRampEnd
+during the difficulty ramp. The others are taken from spawnProb.
+*/
+func (g *Game) difficultyRamp(timeSec float32) map[string]float32 {
+	t := difficultyRampProgress(timeSec)
+
+	probs := make(map[string]float32, len(g.spawnProb))
+	for pieceType, prob := range g.spawnProb {
+		probs[pieceType] = prob
+	}
+	for pieceType, start := range spawnProbRampStart {
+		end := spawnProbRampEnd[pieceType]
+		probs[pieceType] = start + (end-start)*t
+	}
+
+	return probs
+}
+
+/*
+refillPieceBag fills the piece bag with the piece types and shuffles it. Each piece type having
+positive spawn probability is added at least once, the number of copies follows the probability.
+*/
+func (g *Game) refillPieceBag() {
+	g.pieceBagCnt++
+	spawnProb := g.difficultyRamp(g.getDifficultyRampSec())
+
+	g.pieceBag = g.pieceBag[:0]
+	for idx, p := range allPieces {
+		prob, ok := spawnProb[p.pieceType]
 		if !ok {
-			g.spawnProb[p.pieceType] = 1
 			prob = 1
 		}
+		if prob <= 0 {
+			continue
+		}
+
+		copyCnt := max(1, int(math.Round(float64(prob*pieceBagCopiesPerProb))))
+		for i := 0; i < copyCnt; i++ {
+			g.pieceBag = append(g.pieceBag, idx)
+		}
+	}
 
-		randRange += prob
+	g.rng.Shuffle(len(g.pieceBag), func(i, j int) { g.pieceBag[i], g.pieceBag[j] = g.pieceBag[j], g.pieceBag[i] })
+}
+
+/*
+pickAdaptivePieceType nudges the spawning toward completing the bodies which lack one piece only.
+With the probability of the adaptive bias one of the missing piece types is returned instead of the piece of the bag.
+The bias decays at each spawn while the bodies are waiting, and restarts when a body is completed.
+The tutorial and the daily challenge keep their seeded piece sequences without nudging.
+Returns "" if the piece of the bag is spawned.
+*/
+func (g *Game) pickAdaptivePieceType() string {
+	if g.config.adaptiveBias <= 0 || g.tutorialMode || !g.dailyDate.IsZero() {
+		return ""
 	}
 
-	randNum := rand.Float32() * randRange
+	// the disabled piece types are not nudged either
+	types := slices.DeleteFunc(g.grid.getNearCompleteTypes(), func(pieceType string) bool {
+		prob, ok := g.spawnProb[pieceType]
+		return ok && prob <= 0
+	})
+	if len(types) == 0 {
+		g.adaptiveSpawnCnt = 0
+		return ""
+	}
 
-	newPieceIdx := -1
-	for newPieceIdx+1 < len(allPieces) && 0 <= randNum {
-		newPieceIdx++
-		randNum -= g.spawnProb[allPieces[newPieceIdx].pieceType]
+	bias := g.config.adaptiveBias * float32(math.Pow(adaptiveBiasDecay, float64(g.adaptiveSpawnCnt)))
+	g.adaptiveSpawnCnt++
+	if bias <= g.adaptiveRng.Float32() {
+		return ""
 	}
+	return types[g.adaptiveRng.Intn(len(types))]
+}
+
+/*
+generatePiece creates a new piece drawn from the piece bag and
+positions it at the top of the grid. The bag is refilled when it is empty.
+The missing piece of a nearly complete body may be spawned instead, see pickAdaptivePieceType.
+*/
+func (g *Game) generatePiece() *Piece {
+	var newPiece *Piece
+	if pieceType := g.pickAdaptivePieceType(); pieceType != "" {
+		log.Printf("Adaptive spawn of '%s'", pieceType)
+		newPiece = getPieceByType(pieceType).clone()
+	} else {
+		if len(g.pieceBag) == 0 {
+			g.refillPieceBag()
+		}
 
-	newPiece := allPieces[newPieceIdx]
+		newPieceIdx := g.pieceBag[len(g.pieceBag)-1]
+		g.pieceBag = g.pieceBag[:len(g.pieceBag)-1]
+		newPiece = allPieces[newPieceIdx].clone()
+	}
 	newPiece.pos.x = g.grid.size.w / 2
 	newPiece.pos.y = 0
 	if !newPiece.isBomb() { // do not rotate bomb (it is symmetric and has a visual sparkle)
-		newPiece.currentRotation = rand.Intn(4) * 90
+		newPiece.currentRotation = g.rng.Intn(4) * 90
 	}
 
 	// update statistics
@@ -609,30 +2243,163 @@ func (g *Game) generatePiece() *Piece {
 	val++
 	g.spawnStat[newPiece.pieceType] = val
 
-	return &newPiece
+	return newPiece
 }
 
 /*
 main initializes the game window and starts the game loop.
 */
 var MUSIC_PLAYER *Audio
-var blastPlayer *Audio
 var joinPlayer *Audio
+var sfxPlayer *SFXPlayer
+
+const (
+	hitSoundFile = "assets/audio/547042__cogfirestudios__hit-impact-sword-3.wav"
+	typewriterSoundFile = "assets/audio/752749__sprinklecipher__toy-electronic-typewriter-full-carriage-return-2.mp3"
+	sfxPoolSize = 4 // nr of players per sound effect
+)
+
+// sound effects of the game events
+var sfxAssetFiles = map[string]string{
+	"land":           hitSoundFile,
+	"rotate":         typewriterSoundFile,
+	"hardDrop":       hitSoundFile,
+	"bodyCompletion": typewriterSoundFile, // default of the bodies without own sound
+	"bomb":           hitSoundFile,
+	"levelUp":        typewriterSoundFile,
+	"eraser":         hitSoundFile,
+	"rowBomb":        hitSoundFile,
+	"transpose":      hitSoundFile,
+	"earthquake":     hitSoundFile,
+	"nuke":           hitSoundFile,
+}
+
+// background music tracks, played in order
+var musicTracks = []string{"assets/audio/theme.mp3"}
 
 func init() {
-	MUSIC_PLAYER = NewAudio("assets/audio/theme.mp3", true) // looped
-	blastPlayer = NewAudio("assets/audio/547042__cogfirestudios__hit-impact-sword-3.wav", false) // not looped
-	joinPlayer = NewAudio("assets/audio/752749__sprinklecipher__toy-electronic-typewriter-full-carriage-return-2.mp3", false) // not looped
+	MUSIC_PLAYER = NewAudio(musicTracks, len(musicTracks) == 1) // a single track is looped
+	joinPlayer = NewAudio([]string{typewriterSoundFile}, false) // not looped
+
+	sfxPlayer = NewSFXPlayer(sfxPoolSize)
+	for _, assetFile := range sfxAssetFiles {
+		sfxPlayer.preload(assetFile)
+	}
+}
+
+/*
+toggleMute mutes or unmutes the music and all sounds of the game.
+*/
+func toggleMute() {
+	muted := !MUSIC_PLAYER.IsMuted()
+	log.Printf("Audio muted: %t", muted)
+
+	for _, a := range []*Audio{MUSIC_PLAYER, joinPlayer} {
+		if muted {
+			a.Mute()
+		} else {
+			a.Unmute()
+		}
+	}
+	if muted {
+		sfxPlayer.Mute()
+	} else {
+		sfxPlayer.Unmute()
+	}
+}
+
+/*
+playSFX plays the sound effect of the game event.
+*/
+func playSFX(event string) {
+	sfxPlayer.PlaySFX(sfxAssetFiles[event])
 }
 
 func main() {
 	log.SetFlags(log.Ltime)
+
+	practice := flag.Bool("practice", false, "play without game over, the scores are not saved")
+	marathon := flag.Bool("marathon", false, "play endlessly at the maximum speed without acceleration")
+	sprint := flag.Bool("sprint", false, fmt.Sprintf("play the Sprint %dK mode, reach the score as fast as possible", defaultSprintTarget/1000))
+	daily := flag.Bool("daily", false, "play the daily challenge, the same pieces for everyone on the same day")
+	record := flag.String("record", "", "record the inputs of the game to this .replay file")
+	replay := flag.String("replay", "", "replay the inputs of this .replay file")
+	challenge := flag.Int("challenge", 0, "play the challenge of this number from "+challengesFileName)
+	configPath := flag.String("config", configFileName, "load the game parameters from this TOML file")
+	flag.IntVar(&commandLine.gridWidth, "grid-width", 0, "width of the grid including the border, overrides the config and the options")
+	flag.IntVar(&commandLine.gridHeight, "grid-height", 0, "height of the grid including the border, overrides the config and the options")
+	flag.IntVar(&commandLine.startSpeed, "start-speed", 0, "start speed level, 1-based, overrides the options")
+	flag.IntVar(&commandLine.marathonLevel, "marathon-level", 0, "speed level of the marathon, 1-based, the highest one by default")
+	flag.BoolVar(&commandLine.fullscreen, "fullscreen", false, "start in fullscreen, overrides the options")
+	flag.StringVar(&commandLine.profile, "profile", "", "play with this profile, it is created if it does not exist")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags]\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	// the config is applied before the game of any mode is created, the flags take precedence over it
+	config, err := LoadConfig(*configPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if config.Language == "" {
+		config.Language = getSystemLanguage()
+	}
+	config.apply()
+	if err := commandLine.validate(); err != nil {
+		log.Fatal(err)
+	}
 	ebiten.SetWindowSize(screenWidth, screenHeight)
+	ebiten.SetWindowResizingMode(ebiten.WindowResizingModeEnabled)
 	ebiten.SetWindowTitle("TESTRis")
 
 	// init() is already called automatically by Go runtime
-	game := NewGame()
+	initProfile()
+	commandLine.apply()
+	// the options and the key bindings of the profile are loaded once, the games of the session share them
+	initOptions()
+	keyDesc := getDefaultKeyBindings()
+	userInput = NewUserInput(&keyDesc)
+	loadKeyBindings(userInput)
+	var game *Game
+	if *practice {
+		game = PracticeGame()
+	} else if *marathon {
+		game = MarathonGame(commandLine.marathonLevel)
+	} else if *sprint {
+		game = SprintGame(defaultSprintTarget)
+	} else if *daily {
+		game = DailyChallengeGame()
+	} else if 0 < *challenge {
+		challenges, err := LoadChallengesFromJSON(challengesFileName)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if len(challenges) < *challenge {
+			log.Fatalf("there are %d challenges only", len(challenges))
+		}
+		log.Printf("Challenge '%s'", challenges[*challenge-1].Name)
+		game = ChallengeGame(challenges[*challenge-1].Grid)
+	} else if *replay != "" {
+		player, err := LoadReplay(*replay)
+		if err != nil {
+			log.Fatal(err)
+		}
+		game = NewGame(player)
+	} else {
+		game = NewGame()
+	}
+	if *record != "" {
+		game.recordReplay(*record)
+	}
+	// the first player is asked for a name. the replayed inputs are not typed into it
+	if currentProfile == nil && *replay == "" {
+		game.promptProfile()
+	}
 	if err := ebiten.RunGame(game); err != nil {
 		log.Fatal(err)
 	}
+	// the window is closed
+	game.saveReplay()
 }
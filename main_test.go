@@ -1,10 +1,23 @@
 package main
 
 import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"log"
+	"math"
+	"math/rand"
 	"os"
+	"path/filepath"
 	"testing"
 	"slices"
 	"strings"
+	"time"
 
 	"github.com/hajimehoshi/ebiten/v2"
 )
@@ -26,21 +39,21 @@ func TestHighScore(t *testing.T) {
 	_ = os.Remove("highscore.txt")
 
 	// Test saving a high score
-	game.saveScore(100)
+	game.saveScore("", 100)
 	highScore := game.loadHighScore()
 	if highScore != 100 {
 		t.Errorf("Expected high score to be 100, got %d", highScore)
 	}
 
 	// Test updating the high score
-	game.saveScore(200)
+	game.saveScore("", 200)
 	highScore = game.loadHighScore()
 	if highScore != 200 {
 		t.Errorf("Expected high score to be 200, got %d", highScore)
 	}
 
 	// Test not updating if the score is lower
-	game.saveScore(250)
+	game.saveScore("", 250)
 	highScore = game.loadHighScore()
 	if highScore < 250 {
 		t.Errorf("Expected high score to remain 200, got %d", highScore)
@@ -49,6 +62,8 @@ func TestHighScore(t *testing.T) {
 
 // TestGameOver tests the game over functionality.
 func TestGameOver(t *testing.T) {
+	_ = os.Remove(statsFileName)
+	defer os.Remove(statsFileName)
 	game := NewGame()
 
 	// Simulate game over
@@ -57,306 +72,5240 @@ func TestGameOver(t *testing.T) {
 		t.Errorf("Expected state of gameOver component to be StateBlocking(%d), got %d", StateBlocking, game.gameOver.getState())
 	}
 
-	// Ensure high score is saved on game over
+	// Ensure high score is saved on game over, after entering the name
 	game.score = 300
 	game.endGame()
+	game.nameEntry.confirm()
 	highScore := game.loadHighScore()
 	if highScore != 300 {
 		t.Errorf("Expected high score to be 300 after game over, got %d", highScore)
 	}
 }
 
+// TestPracticeGame tests that the practice goes on when the grid is full and its scores are not saved.
+func TestPracticeGame(t *testing.T) {
+	_ = os.Remove(statsFileName)
+	defer os.Remove(statsFileName)
+	_ = os.Remove(highScoreFileName)
+	defer os.Remove(highScoreFileName)
+	game := PracticeGame()
+
+	// the top rows are cleared instead of the game over
+	below := getPieceByType("Leg").clone()
+	below.pos = Pos{gridSize.w / 2, 1}
+	game.grid.lockPiece(below)
+	top := getPieceByType("Head").clone()
+	top.pos = Pos{gridSize.w / 2, 0}
+	game.grid.lockPiece(top)
+	game.activePiece = top
+	game.spawnNewPiece()
+	if game.gameOver.getState() != StateInactive || game.activePiece == top {
+		t.Fatalf("Expected the practice to go on")
+	}
+	if slices.Contains(game.grid.lockedPieces, top) || slices.Contains(game.grid.lockedPieces, below) {
+		t.Errorf("Expected the top two rows to be cleared")
+	}
+
+	game.Update()
+	if !game.sideBar.practice {
+		t.Errorf("Expected the sidebar to show the practice")
+	}
+	screen := ebiten.NewImage(screenWidth, screenHeight)
+	game.sideBar.draw(screen)
+
+	// the scores are not saved
+	game.score = 300
+	game.endGame()
+	if game.nameEntry.getState() != StateInactive || game.loadHighScore() != 0 {
+		t.Errorf("Expected the practice score not to be saved")
+	}
+	game.forfeited = true
+	game.endGame()
+	if _, err := os.Stat(highScoreFileName); err == nil {
+		t.Errorf("Expected no high score file after the practice")
+	}
+}
+
+// TestChallengeGame tests the challenge mode with the pre-placed pieces.
+func TestChallengeGame(t *testing.T) {
+	_ = os.Remove(statsFileName)
+	defer os.Remove(statsFileName)
+	_ = os.Remove(highScoreFileName)
+	defer os.Remove(highScoreFileName)
+
+	challenges, err := LoadChallengesFromJSON(challengesFileName)
+	if err != nil || len(challenges) != 5 {
+		t.Fatalf("Expected 5 built-in challenges, got %d: %v", len(challenges), err)
+	}
+	if _, err := parseGridDesc([]string{"^L ^X"}, gridSize); err == nil {
+		t.Errorf("Expected an error for an unknown piece code")
+	}
+	if _, err := parseGridDesc([]string{strings.Repeat("^L ", gridSize.w-1)}, gridSize); err == nil {
+		t.Errorf("Expected an error for a row wider than the grid")
+	}
+	// the horizontal bar takes two places, the vertical one two rows
+	if pieces, err := parseGridDesc([]string{"^I ^L"}, gridSize); err != nil || len(pieces) != 2 || pieces[0].pieceType != "Bar" || pieces[1].pos.x != 3 {
+		t.Errorf("Expected the leg right to the bar, got %v %v", pieces, err)
+	}
+	if _, err := parseGridDesc([]string{"<I"}, gridSize); err == nil {
+		t.Errorf("Expected an error for a vertical bar below the grid")
+	}
+
+	game := ChallengeGame([]string{"^L _  ^L"})
+	if len(game.grid.lockedPieces) != 2 {
+		t.Fatalf("Expected 2 pre-placed pieces, got %d", len(game.grid.lockedPieces))
+	}
+	leg := game.grid.lockedPieces[0]
+	if leg.pieceType != "Leg" || leg.pos != (Pos{1, gridSize.h - 2}) {
+		t.Errorf("Expected the leg at the bottom left, got %s at %v", leg.pieceType, leg.pos)
+	}
+
+	// the restart locks the pieces again
+	game.grid.unlockPieces(slices.Clone(game.grid.lockedPieces))
+	game.Reset()
+	if len(game.grid.lockedPieces) != 2 {
+		t.Fatalf("Expected 2 pre-placed pieces after the restart, got %d", len(game.grid.lockedPieces))
+	}
+
+	// the pieces are still there
+	game.spawnNewPiece()
+	if game.gameOver.getState() != StateInactive {
+		t.Fatalf("Expected the challenge to go on")
+	}
+
+	// the cleared grid completes the challenge
+	game.gameTimeSec = 10
+	game.placedPieceCnt = 2
+	game.grid.unlockPieces(slices.Clone(game.grid.lockedPieces))
+	game.spawnNewPiece()
+	if game.gameOver.getState() == StateInactive || game.gameOver.text[0] != "CHALLENGE COMPLETE" {
+		t.Fatalf("Expected the challenge to be completed")
+	}
+	if score := game.getChallengeScore(); score != challengeBaseScore-10*challengeSecPenalty-2*challengePiecePenalty {
+		t.Errorf("Unexpected challenge score %d", score)
+	}
+
+	// the challenge scores are not saved
+	game.score = 300
+	game.endGame()
+	if game.nameEntry.getState() != StateInactive || game.loadHighScore() != 0 {
+		t.Errorf("Expected the challenge score not to be saved")
+	}
+}
+
+// TestDailyChallengeGame tests the seeded pieces and the separate scores of the daily challenge.
+func TestDailyChallengeGame(t *testing.T) {
+	_ = os.Remove(statsFileName)
+	defer os.Remove(statsFileName)
+	_ = os.Remove(highScoreFileName)
+	defer os.Remove(highScoreFileName)
+
+	if seed := getDailySeed(time.Date(2024, time.March, 7, 23, 59, 0, 0, time.Local)); seed != 20240307 {
+		t.Errorf("Expected the seed 20240307, got %d", seed)
+	}
+
+	// the same pieces are generated on the same day
+	pieceTypes := func(g *Game) []string {
+		types := []string{g.activePiece.pieceType}
+		for _, p := range g.nextPieces {
+			types = append(types, p.pieceType)
+		}
+		return types
+	}
+	game := DailyChallengeGame()
+	first := pieceTypes(game)
+	game.Reset()
+	if second := pieceTypes(game); !slices.Equal(first, second) {
+		t.Errorf("Expected the same pieces after the restart, got %v and %v", first, second)
+	}
+	if other := pieceTypes(DailyChallengeGame()); !slices.Equal(first, other) {
+		t.Errorf("Expected the same pieces in another daily challenge, got %v and %v", first, other)
+	}
+
+	// the pieces of the difficulty ramp do not depend on the speed of the play
+	playPieces := func(g *Game, pieceTimeSec float32) []string {
+		var types []string
+		for i := 0; i < 500; i++ {
+			g.gameTimeSec += pieceTimeSec
+			types = append(types, g.generatePiece().pieceType)
+		}
+		return types
+	}
+	slow, fast := DailyChallengeGame(), DailyChallengeGame()
+	if slowPieces, fastPieces := playPieces(slow, 5), playPieces(fast, 0.5); !slices.Equal(slowPieces, fastPieces) {
+		t.Errorf("Expected the same pieces in the slow and the fast daily challenge")
+	}
+	if slow.pieceBagCnt <= dailyRampBagCnt || slow.difficultyRamp(slow.getDifficultyRampSec())["Bomb"] != spawnProbRampEnd["Bomb"] {
+		t.Errorf("Expected the end of the difficulty ramp after %d piece bags, got %d", dailyRampBagCnt, slow.pieceBagCnt)
+	}
+
+	// the daily scores are kept separately from the normal scores
+	normalGame := NewGame()
+	normalGame.saveScore("normal", 500)
+	game.saveScore("daily", 200)
+	if topScores := game.loadTopScores(); len(topScores) != 1 || topScores[0].Name != "daily" {
+		t.Errorf("Expected the daily score only, got %v", topScores)
+	}
+	if topScores := normalGame.loadTopScores(); len(topScores) != 1 || topScores[0].Name != "normal" {
+		t.Errorf("Expected the normal score only, got %v", topScores)
+	}
+
+	game.Update()
+	if game.sideBar.dailyDate.IsZero() {
+		t.Errorf("Expected the sidebar to show the daily challenge")
+	}
+	screen := ebiten.NewImage(screenWidth, screenHeight)
+	game.sideBar.draw(screen)
+
+	// the daily challenge ends the other modes
+	modeGame := SprintGame(defaultSprintTarget)
+	modeGame.practice = true
+	modeGame.challengeDesc = []string{"^H"}
+	modeGame.startDailyChallenge()
+	if modeGame.practice || modeGame.challengeDesc != nil || modeGame.sprintTarget != 0 || modeGame.dailyDate.IsZero() {
+		t.Errorf("Expected the daily challenge only")
+	}
+
+	// the tutorial ends the daily challenge and the other modes
+	game.practice = true
+	game.sprintTarget = defaultSprintTarget
+	game.startTutorialMode()
+	if !game.dailyDate.IsZero() || game.practice || game.sprintTarget != 0 || !game.tutorialMode {
+		t.Errorf("Expected the tutorial to end the other modes")
+	}
+}
+
+// TestSprintGame tests the sprint mode ending at the target score.
+func TestSprintGame(t *testing.T) {
+	if DrawOrderScorePopup <= DrawOrderSprintCountdown && DrawOrderSprintCountdown < DrawOrderScorePopup+scorePopupMaxCnt {
+		t.Errorf("Expected the countdown outside the draw orders of the score popups")
+	}
+	_ = os.Remove(statsFileName)
+	defer os.Remove(statsFileName)
+	_ = os.Remove(highScoreFileName)
+	_ = os.Remove(sprintTimesFileName)
+	defer os.Remove(highScoreFileName)
+	defer os.Remove(sprintTimesFileName)
+
+	game := SprintGame(1000)
+	if game.sprintCountdown.getState() == StateInactive {
+		t.Fatalf("Expected the sprint countdown to be shown")
+	}
+	game.score = 400
+	game.Update()
+	if game.sprintCountdown.remaining != 600 {
+		t.Errorf("Expected 600 remaining, got %d", game.sprintCountdown.remaining)
+	}
+	screen := ebiten.NewImage(screenWidth, screenHeight)
+	game.sprintCountdown.draw(screen)
+
+	// below the target the game goes on
+	game.spawnNewPiece()
+	if game.gameOver.getState() != StateInactive {
+		t.Fatalf("Expected the sprint to go on")
+	}
+
+	// the target ends the sprint and saves the time
+	game.score = 1000
+	game.spawnNewPiece()
+	if game.gameOver.getState() == StateInactive || game.gameOver.text[0] != "SPRINT COMPLETE" || game.gameOver.text[2] != "New Best Time!" {
+		t.Fatalf("Expected the sprint to be completed with a new best time, got %v", game.gameOver.text)
+	}
+	if records := readSprintTimes(1000); len(records) != 1 {
+		t.Errorf("Expected 1 sprint time, got %v", records)
+	}
+	if records := readSprintTimes(defaultSprintTarget); len(records) != 0 {
+		t.Errorf("Expected no sprint time of another target, got %v", records)
+	}
+
+	// a slower run shows the best time
+	game.Reset()
+	game.sprintStartTime = time.Now().Add(-time.Second)
+	game.score = 1000
+	game.spawnNewPiece()
+	if !strings.HasPrefix(game.gameOver.text[2], "Best: 0:00.") {
+		t.Errorf("Expected the best time shown, got %v", game.gameOver.text)
+	}
+	if s := formatSprintTime(83.456); s != "1:23.46" {
+		t.Errorf("Expected 1:23.46, got %s", s)
+	}
+
+	// the sprint scores are not saved to the high scores
+	game.endGame()
+	if game.nameEntry.getState() != StateInactive || game.loadHighScore() != 0 {
+		t.Errorf("Expected the sprint score not to be saved")
+	}
+}
+
+// TestMarathonGame tests the marathon mode and the speed level cap.
+func TestMarathonGame(t *testing.T) {
+	_ = os.Remove(statsFileName)
+	_ = os.Remove(achievementsFileName)
+	defer os.Remove(statsFileName)
+	defer os.Remove(achievementsFileName)
+	game := MarathonGame(0)
+	if game.speedLevelIdx != len(speedLevels)-1 {
+		t.Errorf("Expected the marathon to start at the highest speed level, got %d", game.speedLevelIdx)
+	}
+
+	// the start level of the options does not override the level of the marathon
+	game = MarathonGame(3)
+	startSpeedLevel := currentOptions.StartSpeedLevel
+	currentOptions.StartSpeedLevel = 1
+	game.applyOptions()
+	game.Reset()
+	currentOptions.StartSpeedLevel = startSpeedLevel
+	if game.startSpeedLevelIdx != 2 || game.speedLevelIdx != 2 {
+		t.Errorf("Expected the marathon to stay at the speed level index 2, got %d", game.speedLevelIdx)
+	}
+	game.Update()
+	if !game.sideBar.marathon {
+		t.Errorf("Expected the sidebar to show the marathon")
+	}
+	screen := ebiten.NewImage(screenWidth, screenHeight)
+	game.sideBar.draw(screen)
+
+	// the capped speed level is not increased by the time or by the key
+	game = NewGame()
+	game.config.maxSpeedLevel = 3
+	game.gameTimeSec = 1000
+	for i := 0; i < 10*speedLevels[0].ticksPerDrop; i++ {
+		game.checkTimeToMoveDown()
+	}
+	if game.speedLevelIdx != 2 {
+		t.Errorf("Expected the speed level capped at index 2, got %d", game.speedLevelIdx)
+	}
+	game.input.simulatePress("speedup")
+	game.input.handleKeys(game.frameCount)
+	game.speedup()
+	game.input.simulateRelease("speedup")
+	game.input.handleKeys(game.frameCount)
+	if game.speedLevelIdx != 2 {
+		t.Errorf("Expected the speedup key to respect the cap, got %d", game.speedLevelIdx)
+	}
+
+	game.Update()
+	if _, isMax := game.sideBar.getSecondsToNextLevel(); !isMax {
+		t.Errorf("Expected the sidebar to show the capped level as the maximum")
+	}
+}
+
+// TestAdaptiveSpawn tests the nudging of the spawns toward the bodies lacking one piece.
+func TestAdaptiveSpawn(t *testing.T) {
+	game := NewGame()
+	fillGrid(game, []string{"^T", "^L"})
+	types := game.grid.getNearCompleteTypes()
+	if !slices.Contains(types, "Head") {
+		t.Fatalf("Expected the head to be missing, got %v", types)
+	}
+
+	// the first spawn is nudged with full bias, then the bias decays
+	game.config.adaptiveBias = 1
+	if pieceType := game.pickAdaptivePieceType(); !slices.Contains(types, pieceType) {
+		t.Errorf("Expected one of the missing types, got '%s'", pieceType)
+	}
+	game.adaptiveSpawnCnt = 40
+	if pieceType := game.pickAdaptivePieceType(); pieceType != "" {
+		t.Errorf("Expected the bias to be decayed, got '%s'", pieceType)
+	}
+
+	// no bias, no nudge
+	game.config.adaptiveBias = 0
+	game.adaptiveSpawnCnt = 0
+	if pieceType := game.pickAdaptivePieceType(); pieceType != "" {
+		t.Errorf("Expected no nudge without bias, got '%s'", pieceType)
+	}
+
+	// the decay restarts when there is no body waiting
+	game.config.adaptiveBias = 1
+	game.adaptiveSpawnCnt = 40
+	game.grid.unlockPieces(slices.Clone(game.grid.lockedPieces))
+	if pieceType := game.pickAdaptivePieceType(); pieceType != "" || game.adaptiveSpawnCnt != 0 {
+		t.Errorf("Expected no nudge and restarted decay on the empty grid, got '%s', %d", pieceType, game.adaptiveSpawnCnt)
+	}
+
+	// a single piece of a 2-piece body is not nudged
+	fillGrid(game, []string{"^L"})
+	if types := game.grid.getNearCompleteTypes(); len(types) != 0 {
+		t.Errorf("Unexpected nudge of %v for a lone piece", types)
+	}
+	game.grid.unlockPieces(slices.Clone(game.grid.lockedPieces))
+
+	// the completion restarts the decay
+	game.adaptiveSpawnCnt = 5
+	game.logBodyCompletion(allBodies[0], 10)
+	if game.adaptiveSpawnCnt != 0 {
+		t.Errorf("Expected the decay restarted by the completion, got %d", game.adaptiveSpawnCnt)
+	}
+
+	// the seeded modes keep their piece sequence
+	fillGrid(game, []string{"^T", "^L"})
+	game.tutorialMode = true
+	if pieceType := game.pickAdaptivePieceType(); pieceType != "" {
+		t.Errorf("Expected no nudge in the tutorial, got '%s'", pieceType)
+	}
+	game.tutorialMode = false
+	game.dailyDate = time.Now()
+	if pieceType := game.pickAdaptivePieceType(); pieceType != "" {
+		t.Errorf("Expected no nudge in the daily challenge, got '%s'", pieceType)
+	}
+
+	// the adaptive roll does not consume the numbers of the piece sequence
+	game.dailyDate = time.Time{}
+	game.seedRng(42)
+	expected := rand.New(rand.NewSource(42)).Int63()
+	game.pickAdaptivePieceType()
+	if game.rng.Int63() != expected {
+		t.Errorf("Expected the piece sequence to be kept by the adaptive roll")
+	}
+}
+
+// TestGameExportImportState tests the JSON round trip of the grid state.
+func TestGameExportImportState(t *testing.T) {
+	game := NewGame()
+	gridDesc := []string {
+	// 0   1   2   3
+		"_   ^T  _   <H",   // 0
+		">L  >T  vB  <L", } // 1
+	fillGrid(game, gridDesc)
+	state := game.ExportState()
+
+	other := NewGame()
+	fillGrid(other, []string{"^H"})
+	if err := other.ImportState(state); err != nil {
+		t.Fatalf("Import failed: %v", err)
+	}
+	if err := other.grid.validateConsistency(); err != nil {
+		t.Errorf("Inconsistent grid after import: %v", err)
+	}
+	if len(other.grid.lockedPieces) != len(game.grid.lockedPieces) {
+		t.Fatalf("Expected %d locked pieces. Got %d instead.", len(game.grid.lockedPieces), len(other.grid.lockedPieces))
+	}
+	for i, p := range other.grid.lockedPieces {
+		e := game.grid.lockedPieces[i]
+		if p.pieceType != e.pieceType || p.pos != e.pos || p.currentRotation != e.currentRotation || p.lockOrderIndex != e.lockOrderIndex {
+			t.Errorf("Expected piece '%s'@%v,%d. Got '%s'@%v,%d instead.", e.pieceType, e.pos, e.currentRotation, p.pieceType, p.pos, p.currentRotation)
+		}
+	}
+	if exported := other.ExportState(); exported != state {
+		t.Errorf("Expected the same state after the round trip. Got %s instead of %s", exported, state)
+	}
+
+	// the invalid states do not change the grid
+	invalidStates := []string{
+		"{",
+		`{"width":10,"height":10,"pieces":[]}`,
+		fmt.Sprintf(`{"width":%d,"height":%d,"pieces":[{"type":"Tail","x":1,"y":1,"rotation":0}]}`, gridSize.w, gridSize.h),
+		fmt.Sprintf(`{"width":%d,"height":%d,"pieces":[{"type":"Head","x":0,"y":1,"rotation":0}]}`, gridSize.w, gridSize.h),
+		fmt.Sprintf(`{"width":%d,"height":%d,"pieces":[{"type":"Head","x":1,"y":1,"rotation":45}]}`, gridSize.w, gridSize.h),
+	}
+	for _, s := range invalidStates {
+		if err := other.ImportState(s); err == nil {
+			t.Errorf("Expected error for the state %s", s)
+		}
+	}
+	if other.ExportState() != state {
+		t.Errorf("Expected the grid unchanged after the failed imports")
+	}
+
+	// the rotations are normalized
+	rotated := fmt.Sprintf(`{"width":%d,"height":%d,"pieces":[{"type":"Head","x":1,"y":1,"rotation":-90},{"type":"Leg","x":3,"y":1,"rotation":450}]}`, gridSize.w, gridSize.h)
+	if err := other.ImportState(rotated); err != nil || other.grid.lockedPieces[0].currentRotation != 270 || other.grid.lockedPieces[1].currentRotation != 90 {
+		t.Errorf("Expected the rotations normalized into [0, 360), error %v", err)
+	}
+}
+
+// TestGameReplay tests the recording and the replaying of the inputs of a game.
+func TestGameReplay(t *testing.T) {
+	_ = os.Remove(statsFileName)
+	defer os.Remove(statsFileName)
+	_ = os.Remove(highScoreFileName)
+	defer os.Remove(highScoreFileName)
+	path := filepath.Join(t.TempDir(), "test.replay")
+
+	// play a few pieces with moves, rotations and drops
+	inputs := map[int]string{5: "left", 20: "rotate", 40: "drop", 60: "right", 62: "right", 80: "drop", 100: "rotate", 120: "drop", 160: "mouseLeft", 163: "mouseLeft"}
+	// a tap rotates, a swipe moves left, and the double click above drops
+	tapPos := Pos{screenLayout.playArea.size.w / 2, screenHeight / 4}
+	touches := map[int]Pos{140: tapPos, 150: tapPos, 151: {tapPos.x - touchSwipeMinDist - 1, tapPos.y}}
+	const frameCnt = 200
+	game := NewGame()
+	game.recordReplay(path)
+	for i := 1; i <= frameCnt; i++ {
+		if keyName, ok := inputs[i]; ok {
+			game.input.simulatePress(keyName)
+		}
+		if pos, ok := touches[i]; ok {
+			game.input.simulatedTouches[1] = pos
+		}
+		game.Update()
+		if keyName, ok := inputs[i]; ok {
+			game.input.simulateRelease(keyName)
+		}
+		if _, ok := touches[i+1]; !ok {
+			delete(game.input.simulatedTouches, 1)
+		}
+	}
+	game.endGame()
+	state, score := game.ExportState(), game.score
+	if game.placedPieceCnt < 3 {
+		t.Fatalf("Expected at least 3 placed pieces, got %d", game.placedPieceCnt)
+	}
+
+	// the replay reproduces the game without any input
+	player, err := LoadReplay(path)
+	if err != nil {
+		t.Fatalf("Failed to load the replay: %v", err)
+	}
+	if len(player.replay.Events) != 2*len(inputs)+2 {
+		t.Errorf("Expected %d events, got %d", 2*len(inputs)+2, len(player.replay.Events))
+	}
+	for _, action := range []string{"rotate", "left"} {
+		if !slices.ContainsFunc(player.replay.Events, func(e MacroEvent) bool { return e.Key == touchEventPrefix+action }) {
+			t.Errorf("Expected the touch gesture '%s' in the replay", action)
+		}
+	}
+	replayed := NewGame(player)
+	for i := 1; i <= frameCnt; i++ {
+		replayed.Update()
+	}
+	if !player.isOver() {
+		t.Errorf("Expected all the events to be played")
+	}
+	if replayed.ExportState() != state || replayed.score != score {
+		t.Errorf("Expected the same grid and score %d after the replay, got score %d", score, replayed.score)
+	}
+
+	// the unfinished game is saved on quit too
+	unfinished := NewGame()
+	unfinished.recordReplay(path)
+	unfinished.input.simulatePress("left")
+	unfinished.Update()
+	unfinished.input.simulateRelease("left")
+	unfinished.saveReplay()
+	if player, err := LoadReplay(path); err != nil || len(player.replay.Events) != 1 {
+		t.Errorf("Expected the replay of the unfinished game, got %v", err)
+	}
+
+	if _, err := LoadReplay(filepath.Join(t.TempDir(), "missing.replay")); err == nil {
+		t.Errorf("Expected error for a missing replay")
+	}
+	NewGame() // stops the replaying of the shared input
+}
+
+// TestGameScreenshot tests the screenshot key and the file name of the screenshots.
+// The pixels cannot be read without running the game, so the saving is not tested.
+func TestGameScreenshot(t *testing.T) {
+	game := NewGame()
+	game.input.simulatePress("screenshot")
+	game.Update()
+	game.input.simulateRelease("screenshot")
+	game.input.handleKeys(game.frameCount)
+	if !game.screenshotRequested {
+		t.Errorf("Expected the screenshot to be requested for the next Draw")
+	}
+	game.screenshotRequested = false
+
+	if path := time.Date(2024, time.January, 15, 14, 30, 22, 0, time.Local).Format(screenshotFileFormat); path != "screenshot_20240115_143022.png" {
+		t.Errorf("Unexpected screenshot file name %s", path)
+	}
+
+	// the screenshots of the same second do not overwrite each other
+	shotTime := time.Date(2001, time.February, 3, 4, 5, 6, 0, time.Local)
+	for _, expected := range []string{"screenshot_20010203_040506.png", "screenshot_20010203_040506_2.png"} {
+		file, err := createScreenshotFile(shotTime)
+		if err != nil {
+			t.Fatalf("Failed to create the screenshot %s: %v", expected, err)
+		}
+		file.Close()
+		defer os.Remove(file.Name())
+		if file.Name() != expected {
+			t.Errorf("Expected the screenshot %s, got %s", expected, file.Name())
+		}
+	}
+}
+
+// TestLifetimeStats tests the statistics summed over the games and the statistics screen.
+func TestLifetimeStats(t *testing.T) {
+	_ = os.Remove(statsFileName)
+	defer os.Remove(statsFileName)
+	defer os.Remove(highScoreFileName)
+
+	for i := 0; i < 2; i++ {
+		game := NewGame()
+		game.placedPieceCnt = 10
+		game.bombCnt = 2
+		game.bodyCnt["Fellow"] = 3
+		game.gameTimeSec = 90
+		game.endGame()
+		game.nameEntry.confirm()
+	}
+
+	stats, err := loadLifetimeStats(statsFileName)
+	if err != nil {
+		t.Fatalf("Failed to load the statistics: %v", err)
+	}
+	if stats.GamesPlayed != 2 || stats.PiecesPlaced != 20 || stats.BombsDetonated != 4 || stats.BodyCnt["Fellow"] != 6 || stats.PlayTimeSec != 180 {
+		t.Errorf("Unexpected statistics %+v", stats)
+	}
+
+	// the screen is opened from the menu and shows the statistics
+	game := NewGame()
+	game.menu.activate(true)
+	game.menu.fire(slices.IndexFunc(game.menu.items, func(item MenuItem) bool { return item.label == "menu.statistics" }))
+	if game.stats.getState() == StateInactive || game.stats.stats.GamesPlayed != 2 {
+		t.Fatalf("Expected the statistics screen to be opened with the statistics")
+	}
+	if rows := game.stats.stats.getRows(); !slices.Contains(rows, [2]string{"Play time", "0h 03m 00s"}) {
+		t.Errorf("Expected the play time row, got %v", rows)
+	}
+	screen := ebiten.NewImage(screenWidth, screenHeight)
+	game.stats.draw(screen)
+
+	// the confirmed reset zeroes the statistics
+	game.stats.confirming = true
+	game.stats.draw(screen)
+	game.stats.confirmReset()
+	if game.stats.confirming || game.stats.stats.GamesPlayed != 0 {
+		t.Errorf("Expected the statistics to be reset")
+	}
+	if stats, _ := loadLifetimeStats(statsFileName); stats.GamesPlayed != 0 || len(stats.BodyCnt) != 0 {
+		t.Errorf("Expected zero statistics in the file, got %+v", stats)
+	}
+
+	// the menu key closes the screen back to the menu
+	tapMenuKey := func() {
+		game.input.simulatePress("menu")
+		game.Update()
+		game.input.simulateRelease("menu")
+		game.Update()
+	}
+	tapMenuKey()
+	if game.stats.getState() != StateInactive || game.menu.getState() == StateInactive {
+		t.Errorf("Expected the menu key to close the statistics back to the menu")
+	}
+}
+
+// TestLoadConfig tests loading, writing and applying the TOML config.
+func TestLoadConfig(t *testing.T) {
+	_ = os.Remove(configFileName)
+	defer os.Remove(configFileName)
+	defaults := getDefaultConfig()
+	defer defaults.apply()
+
+	// the defaults are written when there is no file, and read back the same
+	config, err := LoadConfig(configFileName)
+	if err != nil || fmt.Sprintf("%+v", *config) != fmt.Sprintf("%+v", *defaults) {
+		t.Fatalf("Expected the default config, got %+v, error %v", config, err)
+	}
+	if config, err := LoadConfig(configFileName); err != nil || fmt.Sprintf("%+v", *config) != fmt.Sprintf("%+v", *defaults) {
+		t.Errorf("Expected the written defaults to be read back, got %+v, error %v", config, err)
+	}
+
+	// the missing keys keep their defaults, the arrays may span several lines
+	configText := "grid_size = 14 # small grid\nspeed_levels = [\n  [20, 10],\n  [10, 20],\n]\nwave_effect_life_time_sec = 0.25\n"
+	if err := os.WriteFile(configFileName, []byte(configText), 0644); err != nil {
+		t.Fatalf("Failed to write the config: %v", err)
+	}
+	config, err = LoadConfig(configFileName)
+	if err != nil {
+		t.Fatalf("Failed to load the config: %v", err)
+	}
+	if config.GridSize != 14 || len(config.SpeedLevels) != 2 || config.SpeedLevels[1] != [2]int{10, 20} || config.WaveEffectLifeTimeSec != 0.25 || config.ScreenWidth != defaultScreenWidth {
+		t.Errorf("Unexpected config %+v", config)
+	}
+
+	config.apply()
+	if gridSize != (Size{14, 14}) || len(speedLevels) != 2 || speedLevels[0].ticksPerDrop != 20 || waveEffectLifeTimeSec != 0.25 {
+		t.Errorf("Expected the config to be applied")
+	}
+	game := NewGame()
+	if game.grid.size != (Size{14, 14}) {
+		t.Errorf("Expected the game on the configured grid, got %v", game.grid.size)
+	}
+
+	for _, invalid := range []string{"grid_size = 100", "unknown = 1", "[table]\nkey = 1", "speed_levels = [[1, 2]", "grid_size = ", "speed_levels = []", "grid_size = \"14\"", "rock_effect_life_time_sec = 0", "wave_effect_life_time_sec = 0.01"} {
+		if err := os.WriteFile(configFileName, []byte(invalid), 0644); err != nil {
+			t.Fatalf("Failed to write the config: %v", err)
+		}
+		if _, err := LoadConfig(configFileName); err == nil {
+			t.Errorf("Expected an error for the config %q", invalid)
+		}
+	}
+}
+
+// TestUIScale tests enlarging the texts and the sidebar by the ui scale of the config.
+func TestUIScale(t *testing.T) {
+	defaults := getDefaultConfig()
+	defer func() {
+		defaults.apply()
+		NewGame() // recreates the text faces
+	}()
+
+	config := getDefaultConfig()
+	config.UIScale = 1.5
+	if err := config.validate(); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	config.apply()
+	if uiScale != 1.5 || sidebarWidth != 270 || screenWidth != defaultScreenWidth+90 || screenHeight != 900 {
+		t.Errorf("Expected the enlarged sidebar and window, got sidebar %d, screen %dx%d", sidebarWidth, screenWidth, screenHeight)
+	}
+
+	game := NewGame()
+	if smallTextFace.Size != 18 || normTextFace.Size != 30 || bigTextFace.Size != 60 {
+		t.Errorf("Expected the scaled fonts, got %v %v %v", smallTextFace.Size, normTextFace.Size, bigTextFace.Size)
+	}
+	if game.sideBar.size.w != 270 || game.sideBar.restartTextBox.pos.y != 240 || game.sideBar.speakerBox.size != (Size{30, 21}) {
+		t.Errorf("Expected the scaled sidebar layout, got %+v %+v", game.sideBar.restartTextBox, game.sideBar.speakerBox)
+	}
+	screen := ebiten.NewImage(screenWidth, screenHeight)
+	game.Draw(screen)
+
+	config.UIScale = 4
+	if err := config.validate(); err == nil {
+		t.Errorf("Expected an error for the ui scale out of range")
+	}
+}
+
+// TestColorblindMode tests the symbols drawn over the body pieces in colorblind mode.
+func TestColorblindMode(t *testing.T) {
+	_ = os.Remove(configFileName)
+	defer os.Remove(configFileName)
+	defaults := getDefaultConfig()
+	defer defaults.apply()
+
+	if err := os.WriteFile(configFileName, []byte("colorblind_mode = true\n"), 0644); err != nil {
+		t.Fatalf("Failed to write the config: %v", err)
+	}
+	config, err := LoadConfig(configFileName)
+	if err != nil || !config.ColorblindMode {
+		t.Fatalf("Expected the colorblind mode in the config, error %v", err)
+	}
+	config.apply()
+
+	symbols := map[string]bool{}
+	for _, pieceType := range []string{"Head", "Torso", "Leg", "RightBrkTorso"} {
+		piece := getPieceByType(pieceType)
+		defaultImage := defaultPieceImages[pieceType]
+		if piece.image == defaultImage || piece.image.Bounds().Dx() != defaultImage.Bounds().Dx()*symbolImageScale {
+			t.Errorf("Expected the enlarged image with the symbol of %s", pieceType)
+		}
+		if getSymbolImage(defaultImage, pieceType) != piece.image {
+			t.Errorf("Expected the symbol image of %s to be cached", pieceType)
+		}
+		symbols[pieceSymbols[pieceType]] = true
+	}
+	if len(symbols) != 4 {
+		t.Errorf("Expected four distinct symbols, got %v", symbols)
+	}
+	if getPieceByType("Bomb").image != defaultPieceImages["Bomb"] {
+		t.Errorf("Expected no symbol on the bomb")
+	}
+
+	// the new game keeps the symbols after applying the theme
+	NewGame()
+	if getPieceByType("Head").image == defaultPieceImages["Head"] {
+		t.Errorf("Expected the symbols after applying the theme")
+	}
+
+	defaults.apply()
+	if getPieceByType("Head").image != defaultPieceImages["Head"] {
+		t.Errorf("Expected the default images without the colorblind mode")
+	}
+}
+
+// TestLanguage tests the language packs and switching the language on the options screen.
+func TestLanguage(t *testing.T) {
+	_ = os.Remove(optionsFileName)
+	defer os.Remove(optionsFileName)
+	defer func() {
+		configLanguage = defaultLanguage
+		setLanguage(defaultLanguage)
+	}()
+
+	// all packs have the strings of the default language
+	en, err := LoadLangPack(filepath.Join(langDirName, "en.json"))
+	if err != nil {
+		t.Fatalf("Failed to load the default language: %v", err)
+	}
+	codes := getLanguages(langDirName)
+	if !slices.Equal(codes, []string{"de", "en", "fr"}) {
+		t.Fatalf("Unexpected languages %v", codes)
+	}
+	for _, code := range codes {
+		pack, err := LoadLangPack(filepath.Join(langDirName, code+".json"))
+		if err != nil {
+			t.Fatalf("Failed to load language '%s': %v", code, err)
+		}
+		for key := range en {
+			if _, ok := pack[key]; !ok {
+				t.Errorf("Language '%s' lacks '%s'", code, key)
+			}
+		}
+	}
+
+	setLanguage("de")
+	if T("sidebar.score") != "PUNKTE" || T("sidebar.controlLeft") != "LNK: 7 <-" || T("unknown.key") != "unknown.key" {
+		t.Errorf("Expected the German strings, got '%s' '%s'", T("sidebar.score"), T("unknown.key"))
+	}
+	setLanguage("xx")
+	if language != defaultLanguage || T("sidebar.score") != "SCORE" {
+		t.Errorf("Expected the default language for the missing pack, got '%s'", language)
+	}
+
+	for locale, code := range map[string]string{"fr_FR.UTF-8": "fr", "de": "de", "C": "en", "": "en"} {
+		t.Setenv("LANG", locale)
+		if getSystemLanguage() != code {
+			t.Errorf("Expected language '%s' of locale '%s', got '%s'", code, locale, getSystemLanguage())
+		}
+	}
+	// the locale is resolved by main only, the applied config does not depend on it
+	t.Setenv("LANG", "fr_FR.UTF-8")
+	getDefaultConfig().apply()
+	if configLanguage != defaultLanguage {
+		t.Errorf("Expected the default language of the config, got '%s'", configLanguage)
+	}
+
+	// the language row overrides the language of the config, the change is applied immediately
+	game := NewGame()
+	configLanguage = "fr"
+	applyOptions()
+	if T("menu.quit") != "Quitter" {
+		t.Errorf("Expected the language of the config, got '%s'", T("menu.quit"))
+	}
+	languageRowIdx := slices.IndexFunc(optionRows, func(row OptionRow) bool { return row.label == "options.language" })
+	game.options.change(languageRowIdx, 1)
+	if currentOptions.Language != "de" || T("menu.quit") != "Beenden" {
+		t.Errorf("Expected the German language, got %q", currentOptions.Language)
+	}
+	if options, _ := loadOptions(optionsFileName); options.Language != "de" {
+		t.Errorf("Expected the language to be saved, got %q", options.Language)
+	}
+	game.options.change(languageRowIdx, -1)
+	if currentOptions.Language != "" || T("menu.quit") != "Quitter" {
+		t.Errorf("Expected the language of the config again, got %q", currentOptions.Language)
+	}
+	screen := ebiten.NewImage(screenWidth, screenHeight)
+	game.menu.activate(true)
+	game.Draw(screen)
+}
+
+// TestCommandLineConfig tests overriding the config and the options by the command line flags.
+func TestCommandLineConfig(t *testing.T) {
+	defaultGridSize := gridSize
+	defer func() {
+		commandLine = CommandLineConfig{}
+		gridSize = defaultGridSize
+		currentOptions = getDefaultOptions()
+		ebiten.SetFullscreen(false)
+	}()
+
+	for _, invalid := range []CommandLineConfig{{gridWidth: 100}, {gridHeight: 2}, {startSpeed: len(speedLevels) + 1}, {marathonLevel: -1}, {marathonLevel: len(speedLevels) + 1}, {profile: "../x"}, {profile: "a/b"}, {profile: " "}} {
+		if err := invalid.validate(); err == nil {
+			t.Errorf("Expected an error for the flags %+v", invalid)
+		}
+	}
+
+	commandLine = CommandLineConfig{gridWidth: 14, gridHeight: 16, startSpeed: 3, fullscreen: true}
+	if err := commandLine.validate(); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	commandLine.apply()
+	initOptions()
+	game := NewGame()
+	if gridSize != (Size{14, 16}) || game.grid.size != gridSize {
+		t.Errorf("Expected the grid size of the flags, got %v", game.grid.size)
+	}
+	if currentOptions.StartSpeedLevel != 3 || game.startSpeedLevelIdx != 2 || !currentOptions.Fullscreen {
+		t.Errorf("Expected the options overridden by the flags, got %+v", currentOptions)
+	}
+
+	// the height of the flags is kept when the width of the options is applied
+	commandLine = CommandLineConfig{gridHeight: 16}
+	gridSize = defaultGridSize
+	commandLine.apply()
+	currentOptions.GridSize = 18
+	applyOptions()
+	if gridSize != (Size{18, 16}) {
+		t.Errorf("Expected the height of the flags, got %v", gridSize)
+	}
+}
+
+// TestProfiles tests creating and switching the profiles, and their own high scores and key bindings.
+func TestProfiles(t *testing.T) {
+	dir := t.TempDir()
+	defer func(fileName, dirName string) {
+		currentProfile = nil
+		userInput.keyDesc = getDefaultKeyBindings()
+		profilesFileName, profilesDirName = fileName, dirName
+	}(profilesFileName, profilesDirName)
+	profilesFileName, profilesDirName = filepath.Join(dir, "profiles.json"), filepath.Join(dir, "profiles")
+
+	if getProfilePath(highScoreFileName) != highScoreFileName {
+		t.Errorf("Expected the working directory without a profile")
+	}
+
+	// a new profile is created from the profile picker of the menu
+	game := NewGame()
+	game.menu.activate(true)
+	game.menu.fire(slices.IndexFunc(game.menu.items, func(item MenuItem) bool { return item.label == "menu.profile" }))
+	if game.profileMenu.getState() == StateInactive || len(game.profileMenu.items) != 2 {
+		t.Fatalf("Expected the profile picker with the new profile and back items")
+	}
+	game.profileMenu.fire(0)
+	if game.nameEntry.getState() == StateInactive || game.nameEntry.prompt[0] != "New profile" {
+		t.Fatalf("Expected the name of the new profile to be asked")
+	}
+	game.nameEntry.handleChars([]rune("Ann"))
+	game.nameEntry.confirm()
+	if currentProfile == nil || currentProfile.Name != "Ann" || game.sideBar.profileName != "Ann" {
+		t.Fatalf("Expected profile Ann to be active")
+	}
+	if _, err := os.Stat(getProfilePath(keyBindingsFileName)); err != nil {
+		t.Errorf("Expected the key bindings of the profile to be saved: %v", err)
+	}
+
+	// the high scores and the sprint times are per profile
+	game.saveScore("Ann", 100)
+	saveSprintTime(defaultSprintTarget, 60)
+	game.switchProfile("Bob")
+	if len(game.loadTopScores()) != 0 {
+		t.Errorf("Expected no scores of the new profile")
+	}
+	if _, hasBest := getBestSprintTime(defaultSprintTarget); hasBest {
+		t.Errorf("Expected no sprint times of the new profile")
+	}
+	profiles, err := loadProfiles(profilesFileName)
+	if err != nil || profiles.Current != "Bob" || !slices.Equal(profiles.Names, []string{"Ann", "Bob"}) {
+		t.Errorf("Unexpected profiles %+v, error %v", profiles, err)
+	}
+
+	// the key bindings are per profile
+	game.input.AddBinding("hold", ebiten.KeyC)
+	if err := game.input.SaveKeyBindings(getProfilePath(keyBindingsFileName)); err != nil {
+		t.Fatalf("Failed to save the key bindings: %v", err)
+	}
+
+	game.openProfileMenu()
+	labels := []string{}
+	for _, item := range game.profileMenu.items {
+		labels = append(labels, item.label)
+	}
+	if !slices.Equal(labels, []string{"Ann", "* Bob", "profile.new", "profile.back"}) {
+		t.Errorf("Unexpected profile items %v", labels)
+	}
+	game.profileMenu.fire(0)
+	if currentProfile.Name != "Ann" || len(game.loadTopScores()) != 1 || slices.Contains(game.input.getBindings("hold"), ebiten.KeyC) {
+		t.Errorf("Expected the scores and the key bindings of Ann")
+	}
+
+	// the profile picker is closed back to the menu
+	game.openProfileMenu()
+	game.profileMenu.fire(len(game.profileMenu.items) - 1)
+	if game.profileMenu.getState() != StateInactive || game.menu.getState() == StateInactive {
+		t.Errorf("Expected the profile picker to be closed back to the menu")
+	}
+
+	// the profile is loaded at the start
+	currentProfile = nil
+	initProfile()
+	if currentProfile == nil || currentProfile.Name != "Ann" {
+		t.Errorf("Expected the last active profile to be loaded")
+	}
+}
+
+// TestAchievements tests the unlocking, the notification and the persistence of the achievements.
+func TestAchievements(t *testing.T) {
+	_ = os.Remove(achievementsFileName)
+	defer os.Remove(achievementsFileName)
+
+	game := NewGame()
+	game.Update()
+	if game.achievementNotice.getState() != StateInactive {
+		t.Fatalf("Expected no achievement at the start")
+	}
+
+	game.bombCnt = 10
+	game.maxCombo = 3
+	game.Update()
+	if game.achievementNotice.getState() == StateInactive || !slices.Equal(game.achievementNotice.text, []string{"ACHIEVEMENT UNLOCKED", "Chain 3", "Bomb Squad"}) {
+		t.Errorf("Expected the notification of the unlocked achievements, got %v", game.achievementNotice.text)
+	}
+
+	// the notification disappears after a while
+	for i := 0; i <= achievementNoticeFrameCnt; i++ {
+		game.Update()
+	}
+	if game.achievementNotice.getState() != StateInactive {
+		t.Errorf("Expected the notification to time out")
+	}
+
+	// the unlocks are persisted and notified only once
+	other := NewGame()
+	other.bombCnt = 10
+	other.Update()
+	if other.achievementNotice.getState() != StateInactive {
+		t.Errorf("Expected no notification of the unlocked achievement")
+	}
+	for _, a := range other.achievements {
+		if isUnlocked := a.unlocked != ""; isUnlocked != (a.id == "chain3" || a.id == "bombSquad") {
+			t.Errorf("Unexpected unlock state of '%s': '%s'", a.id, a.unlocked)
+		}
+	}
+
+	// the maximum speed is reached by the play only
+	marathon := MarathonGame(0)
+	marathon.Update()
+	if marathon.achievements[2].id != "speedDemon" || marathon.achievements[2].unlocked != "" {
+		t.Errorf("Expected no Speed Demon at the start level of the marathon")
+	}
+	other.setSpeedLevel(len(speedLevels) - 1)
+	other.Update()
+	if !slices.Equal(other.achievementNotice.text, []string{"ACHIEVEMENT UNLOCKED", "Speed Demon"}) {
+		t.Errorf("Expected Speed Demon at the reached maximum speed, got %v", other.achievementNotice.text)
+	}
+}
+
+// TestGameOverStats tests the statistics shown on the game over screen.
+func TestGameOverStats(t *testing.T) {
+	_ = os.Remove(statsFileName)
+	defer os.Remove(statsFileName)
+	game := NewGame()
+	defer os.Remove(highScoreFileName)
+
+	bomb := getPieceByType("Bomb").clone()
+	bomb.pos = Pos{4, game.grid.size.h - 2}
+	game.activePiece = bomb
+	game.handleActivePieceLanded()
+
+	torso := getPieceByType("Torso").clone()
+	torso.pos = Pos{8, game.grid.size.h - 2}
+	game.activePiece = torso
+	game.handleActivePieceLanded()
+
+	game.logBodyCompletion(allBodies[0], 10)
+	game.logBodyCompletion(allBodies[0], 10)
+	game.maxCombo = 2
+	game.endGame()
+
+	stats := game.gameOver.stats
+	if stats == nil {
+		t.Fatalf("Expected the statistics in the game over dialog")
+	}
+	rows := stats.getRows()
+	expectedTail := [][2]string{{"Pieces placed", "2"}, {"Bombs used", "1"}, {"Peak combo", "2"}}
+	bodies := getBaseBodies()
+	if len(rows) != len(bodies)+3 || rows[0] != [2]string{bodies[0].name, "2"} || !slices.Equal(rows[len(bodies):], expectedTail) {
+		t.Errorf("Unexpected statistics rows %v", rows)
+	}
+	if !slices.Contains([]string{"GAME OVER", "New High Score!"}, game.gameOver.text[0]) {
+		t.Errorf("Expected the game over message above the statistics, got %v", game.gameOver.text)
+	}
+
+	screen := ebiten.NewImage(screenWidth, screenHeight)
+	game.gameOver.draw(screen)
+
+	game.Reset()
+	if len(game.bodyCnt) != 0 || game.placedPieceCnt != 0 || game.bombCnt != 0 {
+		t.Errorf("Expected the statistics to be reset")
+	}
+}
+
+// TestGameNameEntry tests entering the player name for a new high score.
+func TestGameNameEntry(t *testing.T) {
+	_ = os.Remove(statsFileName)
+	defer os.Remove(statsFileName)
+	_ = os.Remove(highScoreFileName)
+	defer os.Remove(highScoreFileName)
+	if err := os.WriteFile(highScoreFileName, []byte("40\n"), 0644); err != nil {
+		t.Fatalf("Failed to write the legacy scores: %v", err)
+	}
+
+	game := NewGame()
+	game.score = 50
+	game.endGame()
+	if game.nameEntry.getState() != StateBlocking || game.gameOver.getState() != StateInactive {
+		t.Fatalf("Expected the name entry before the game over dialog")
+	}
+
+	game.nameEntry.handleChars([]rune("Al!ce Smith"))
+	if name := game.nameEntry.getName(); name != "Alce Smi" {
+		t.Errorf("Expected the name limited to %d valid characters, got '%s'", playerNameMaxLen, name)
+	}
+	screen := ebiten.NewImage(screenWidth, screenHeight)
+	game.nameEntry.draw(screen)
+
+	game.nameEntry.confirm()
+	if game.gameOver.getState() != StateBlocking || game.gameOver.text[0] != "New High Score!" {
+		t.Errorf("Expected the high score dialog after the name entry, got %v", game.gameOver.text)
+	}
+
+	// a lower score is saved without a name
+	game.score = 30
+	game.endGame()
+	if game.nameEntry.getState() != StateInactive {
+		t.Errorf("Expected no name entry for a lower score")
+	}
+
+	topScores := game.loadTopScores()
+	expected := []ScoreRecord{{Name: "Alce Smi", Score: 50}, {Score: 40}, {Score: 30}}
+	if len(topScores) != len(expected) {
+		t.Fatalf("Expected %d top scores, got %v", len(expected), topScores)
+	}
+	for i, record := range topScores {
+		if record.Name != expected[i].Name || record.Score != expected[i].Score {
+			t.Errorf("Expected top score %v, got %v", expected[i], record)
+		}
+	}
+	if topScores[0].Date == "" || topScores[1].Date != "" {
+		t.Errorf("Expected the date of the new records only, got %v", topScores)
+	}
+
+	game.sideBar.setValues(game.nextPieces[:], game.score, 1, 0, topScores, nil)
+	game.sideBar.draw(screen)
+}
+
 // TestLoadImage tests the LoadImage function.
 func TestLoadImage(t *testing.T) {
 	defer func() {
-		if r := recover(); r != nil {
-			t.Errorf("LoadImage panicked with error: %v", r)
+		if r := recover(); r != nil {
+			t.Errorf("LoadImage panicked with error: %v", r)
+		}
+	}()
+	LoadImage("assets/head.png")
+}
+
+// TestNewGame tests the NewGame function.
+func TestNewGame(t *testing.T) {
+	game := NewGame()
+	if game == nil {
+		t.Error("Expected new game instance, got nil")
+	}
+}
+
+// TestGameReset tests the Reset method of Game.
+func TestGameReset(t *testing.T) {
+	game := NewGame()
+	game.score = 342
+	game.Reset()
+	if game == nil {
+		t.Error("Expected game to be reset, got nil")
+	}
+	if game.score != 0 {
+		t.Error("Expected game score reset to 0")
+	}
+}
+
+// TestGameUpdate tests the Update method of Game.
+func TestGameUpdate(t *testing.T) {
+	game := NewGame()
+	err := game.Update()
+	if err != nil {
+		t.Errorf("Expected no error, got %v", err)
+	}
+}
+
+// TestGameDraw tests the Draw method of Game.
+func TestGameDraw(t *testing.T) {
+	game := NewGame()
+	game.sideBar.setValues(game.nextPieces[:], game.score, game.speedLevelIdx+1, game.gameTimeSec, game.loadTopScores(), game.completionLog)
+
+	screen := ebiten.NewImage(screenWidth, screenHeight)
+	game.Draw(screen)
+}
+
+// TestGameLayout tests the Layout method of Game fitting the game to the resized window.
+func TestGameLayout(t *testing.T) {
+	game := NewGame()
+	defer func() {
+		screenWidth, screenHeight, scale = defaultScreenWidth, defaultScreenHeight, defaultScale
+	}()
+
+	if w, h := game.Layout(1200, 900); w != 1200 || h != 900 || screenWidth != 1200 || screenHeight != 900 {
+		t.Fatalf("Expected the logical screen to follow the window, got %dx%d", w, h)
+	}
+	if expected := min((1200-sidebarWidth)/gridSize.w, 900/gridSize.h); scale != expected {
+		t.Errorf("Expected the scale %d, got %d", expected, scale)
+	}
+	if gridSize.w*scale > screenWidth-sidebarWidth || gridSize.h*scale > screenHeight {
+		t.Errorf("Expected the grid to fit left to the sidebar")
+	}
+	if game.sideBar.pos != (Pos{1200 - sidebarWidth, 0}) || game.sideBar.size.h != 900 || screenLayout.playArea.size != (Size{1200 - sidebarWidth, 900}) {
+		t.Errorf("Expected the sidebar at the right edge, got %v %v", game.sideBar.pos, game.sideBar.size)
+	}
+	x, y := grid2ScrPos(float32(gridSize.w)/2, float32(gridSize.h)/2)
+	if game.menu.screenPos != (Pos{int(x), int(y)}) || game.profileMenu.screenPos != game.menu.screenPos {
+		t.Errorf("Expected the menus centered on the grid, got %v %v", game.menu.screenPos, game.profileMenu.screenPos)
+	}
+
+	screen := ebiten.NewImage(screenWidth, screenHeight)
+	game.Draw(screen)
+
+	// the tiny window keeps the sidebar
+	game.Layout(100, 0)
+	if screenWidth <= sidebarWidth || screenHeight < 1 || scale < 1 {
+		t.Errorf("Expected a valid layout, got %dx%d scale %d", screenWidth, screenHeight, scale)
+	}
+
+	// the pieces of the small cells are rocked too
+	piece := getPieceByType("Head").clone()
+	piece.pos = Pos{2, 2}
+	game.rockEffect.setTarget([]*Piece{piece})
+	game.rockEffect.activate(true)
+	game.rockEffect.update(false, 1)
+	game.rockEffect.draw(screen)
+}
+
+// TestSidebarResize tests resizing the sidebar by dragging its divider.
+func TestSidebarResize(t *testing.T) {
+	game := NewGame()
+	defer func() {
+		screenWidth, screenHeight, scale = defaultScreenWidth, defaultScreenHeight, defaultScale
+		screenLayout = getLayout(Size{screenWidth, screenHeight}, sidebarWidth)
+	}()
+	game.Layout(1200, 900)
+	input := game.input
+	drag := func(x int, down bool) bool {
+		input.updateMouseState(down, false, 1)
+		return game.sideBar.dragDivider(Pos{x, 10})
+	}
+
+	// a press away from the divider does not drag
+	if drag(1200-sidebarWidth-20, true) || drag(500, true) {
+		t.Errorf("Expected no dragging")
+	}
+	drag(0, false)
+
+	// the play area snaps to a multiple of the grid width
+	if !drag(1200-sidebarWidth+2, true) || !drag(500, true) {
+		t.Fatalf("Expected the divider to be dragged")
+	}
+	playWidth := 1200 - game.sideBar.width
+	if playWidth%gridSize.w != 0 || abs(playWidth-500) > gridSize.w/2 {
+		t.Errorf("Expected the play area snapped near 500, got %d", playWidth)
+	}
+	if screenLayout.playArea.size.w != playWidth || game.sideBar.pos.x != playWidth || game.sideBar.size.w != game.sideBar.width {
+		t.Errorf("Expected the sidebar moved to the divider, got %+v %v", screenLayout, game.sideBar.pos)
+	}
+	if scale != playWidth/gridSize.w {
+		t.Errorf("Expected the grid to fill the play area, got scale %d", scale)
+	}
+	screen := ebiten.NewImage(screenWidth, screenHeight)
+	game.Draw(screen)
+
+	// the sidebar is not narrower than its initial width, the release ends the dragging
+	drag(1200, true)
+	if game.sideBar.width < sidebarWidth || (1200-game.sideBar.width)%gridSize.w != 0 {
+		t.Errorf("Expected the minimal sidebar width, got %d", game.sideBar.width)
+	}
+	if drag(300, false) || drag(300, true) {
+		t.Errorf("Expected the release to end the dragging")
+	}
+
+	// the play area is not narrower than the cells of the minimal size
+	drag(1200-game.sideBar.width, false)
+	drag(1200-game.sideBar.width, true)
+	drag(0, true)
+	drag(0, false)
+	if playWidth := 1200 - game.sideBar.width; playWidth != sidebarDragMinCellSize*gridSize.w || scale != sidebarDragMinCellSize {
+		t.Errorf("Expected the minimal play area, got width %d scale %d", playWidth, scale)
+	}
+	game.rockEffect.setTarget([]*Piece{game.activePiece})
+	game.rockEffect.activate(true)
+	game.rockEffect.update(false, 1)
+	game.Draw(screen)
+	drag(1200-game.sideBar.width, true)
+	drag(1200, true)
+	drag(0, false)
+
+	// the dragged width is kept when the window is resized
+	width := game.sideBar.width
+	game.Layout(1300, 900)
+	if game.sideBar.width != width || screenLayout.sidebar.pos.x != 1300-width {
+		t.Errorf("Expected the sidebar width kept, got %d", game.sideBar.width)
+	}
+}
+
+// TestGameCanMove tests the canMove method of Game.
+func TestGameCanMove(t *testing.T) {
+	game := NewGame()
+	if !game.grid.canMove(game.activePiece, 0, 1) {
+		t.Error("Expected piece to be able to move down")
+	}
+}
+
+// TestGridCanMoveCells tests the collisions by the cells occupied by the rotated pieces.
+func TestGridCanMoveCells(t *testing.T) {
+	game := NewGame()
+	grid := game.grid
+
+	// a 1x2 piece lying on its side occupies (5, 10) and (6, 10)
+	locked := getPieceByType("Torso").clone()
+	locked.size = Size{1, 2}
+	locked.currentRotation = 90
+	locked.pos = Pos{5, 10}
+	grid.lockPiece(locked)
+
+	piece := getPieceByType("Head").clone()
+	testCases := []struct {
+		pos      Pos
+		expected bool
+	}{
+		{Pos{6, 9}, false},  // above the right cell
+		{Pos{5, 9}, false},  // above the left cell
+		{Pos{7, 9}, true},   // right to the piece
+		{Pos{5, 10}, true},  // below its unrotated bounding box
+		{Pos{4, 9}, true},
+	}
+	for _, tc := range testCases {
+		piece.pos = tc.pos
+		if canMove := grid.canMove(piece, 0, 1); canMove != tc.expected {
+			t.Errorf("Expected canMove %v from %v, got %v", tc.expected, tc.pos, canMove)
+		}
+	}
+}
+
+// TestGameNextPieces tests the queue of the upcoming pieces.
+func TestGameNextPieces(t *testing.T) {
+	game := NewGame()
+	queue := game.nextPieces
+	for i, piece := range queue {
+		if piece == nil || piece == game.activePiece {
+			t.Fatalf("Expected a new piece at %d in the queue, got %v", i, piece)
+		}
+	}
+
+	// the queue is shifted by spawning
+	game.spawnNewPiece()
+	if game.activePiece != queue[0] || game.nextPieces[0] != queue[1] || game.nextPieces[1] != queue[2] {
+		t.Errorf("Expected the queue to be shifted")
+	}
+	if last := game.nextPieces[nextPieceCnt-1]; last == nil || slices.Contains(queue[:], last) {
+		t.Errorf("Expected a new piece at the end of the queue, got %v", last)
+	}
+
+	game.Update()
+	if !slices.Equal(game.sideBar.nextPieces, game.nextPieces[:]) {
+		t.Errorf("Expected the sidebar to show the queue")
+	}
+	screen := ebiten.NewImage(screenWidth, screenHeight)
+	game.sideBar.draw(screen)
+}
+
+// TestGameLockPiece tests the lockPiece method of Game.
+func TestGameLockPiece(t *testing.T) {
+	game := NewGame()
+	piece := game.activePiece
+
+	game.grid.lockPiece(game.activePiece)
+	if len(game.grid.lockedPieces) != 1 {
+		t.Errorf("Expected 1 locked piece, got %d", len(game.grid.lockedPieces))
+	}
+	if game.grid.content[gridSize.w/2][0] != piece {
+		t.Errorf("Expected Game.grid refers to the locked piece")
+	}
+}
+
+// TestGameSpawnNewPiece tests the spawnNewPiece method of Game.
+func TestGameSpawnNewPiece(t *testing.T) {
+	game := NewGame()
+	game.activePiece = game.nextPieces[0]
+	game.spawnNewPiece()
+	if game.activePiece == nil {
+		t.Error("Expected new active piece, got nil")
+	}
+}
+
+// TestPieceSpawnAnimation tests that the spawned piece falls in before it can be controlled.
+func TestPieceSpawnAnimation(t *testing.T) {
+	game := NewGame()
+	game.spawnNewPiece()
+	piece := game.activePiece
+	startPos := piece.pos
+	if game.apc.p != nil || !game.apc.isSpawning() || game.apc.spawnAnimY != spawnAnimStartY {
+		t.Fatalf("Expected the spawned piece to start falling in, got offset %v", game.apc.spawnAnimY)
+	}
+
+	// the move is ignored during the animation
+	game.input.simulatePress("left")
+	game.input.handleKeys(1)
+	for frame := 1; frame < spawnAnimFrameCnt; frame++ {
+		game.apc.update(false, frame)
+		if game.apc.spawnAnimY <= spawnAnimStartY || 0 <= game.apc.spawnAnimY {
+			t.Fatalf("Expected the offset between %v and 0 at frame %d, got %v", spawnAnimStartY, frame, game.apc.spawnAnimY)
+		}
+	}
+	if piece.pos != startPos || game.apc.p != nil {
+		t.Errorf("Expected the piece not to be controlled during the animation")
+	}
+
+	// the paused animation does not advance
+	offsetY := game.apc.spawnAnimY
+	game.apc.update(true, spawnAnimFrameCnt)
+	if game.apc.spawnAnimY != offsetY {
+		t.Errorf("Expected the paused animation to stay at %v, got %v", offsetY, game.apc.spawnAnimY)
+	}
+
+	game.apc.update(false, spawnAnimFrameCnt)
+	if game.apc.p != piece || game.apc.isSpawning() || game.apc.spawnAnimY != 0 {
+		t.Errorf("Expected the piece to be controlled after %d frames", spawnAnimFrameCnt)
+	}
+	game.input.simulateRelease("left")
+	game.input.handleKeys(spawnAnimFrameCnt + 1)
+}
+
+func fillGrid(g *Game, gridRows []string) [][]*Piece {
+	pieces, err := parseGridDesc(gridRows, gridSize)
+	if err != nil {
+		panic(err)
+	}
+
+	// the pieces are locked in the order of the description, the empty places are empty pieces
+	var piecesMatrix [][]*Piece
+	for _, rowDesc := range gridRows {
+		var piecesRow []*Piece
+		for _, pieceDesc := range strings.Fields(rowDesc) {
+			piece := &Piece{}
+			if pieceDesc != "_" {
+				piece, pieces = pieces[0], pieces[1:]
+				g.grid.lockPiece(piece)
+			}
+			piecesRow = append(piecesRow, piece)
+		}
+		piecesMatrix = append(piecesMatrix, piecesRow)
+	}
+
+	return piecesMatrix
+}
+
+// TestGridLockUnlockPieces tests the lockPieces and unlockPieces methods of Grid.
+func TestGridLockUnlockPieces(t *testing.T) {
+	game := NewGame()
+
+	gridDesc1 := []string {
+	// 0   1   2
+		"_   _   _",    // 0
+		">L  >T  <H", } // 1
+	piecesMat1 := fillGrid(game, gridDesc1);
+
+	gridDesc2 := []string {
+	// 0   1   2   3   4   5   6
+		"_   >H  ^T",   // 0
+		"_   _   _", }  // 1
+	piecesMat2 := fillGrid(game, gridDesc2);
+
+	if len(game.grid.lockedPieces) != 5 {
+		t.Errorf("Expected 5 locked pieces. Got %d instead.", len(game.grid.lockedPieces))
+	}
+
+	if game.grid.lockedPieces[0] != piecesMat2[0][1] { t.Errorf("Expected 1st locked piece d %v. Got %v instead.", piecesMat2[0][1], game.grid.lockedPieces[0]) }
+	if game.grid.lockedPieces[1] != piecesMat2[0][2] { t.Errorf("Expected 2nd locked piece d %v. Got %v instead.", piecesMat2[0][2], game.grid.lockedPieces[1]) }
+	if game.grid.lockedPieces[2] != piecesMat1[1][0] { t.Errorf("Expected 3rd locked piece d %v. Got %v instead.", piecesMat1[0][0], game.grid.lockedPieces[2]) }
+	if game.grid.lockedPieces[3] != piecesMat1[1][1] { t.Errorf("Expected 4th locked piece d %v. Got %v instead.", piecesMat1[0][1], game.grid.lockedPieces[3]) }
+	if game.grid.lockedPieces[4] != piecesMat1[1][2] { t.Errorf("Expected 5th locked piece d %v. Got %v instead.", piecesMat1[0][2], game.grid.lockedPieces[4]) }
+
+	// unlock (remove) 2 pieces
+	p1 := piecesMat2[0][2]
+	p2 := piecesMat1[1][1]
+	game.grid.unlockPiece(p1)
+	game.grid.unlockPiece(p2)
+
+	if len(game.grid.lockedPieces) != 3 {
+		t.Errorf("Expected 3 locked pieces. Got %d instead.", len(game.grid.lockedPieces))
+	}
+
+	if game.grid.lockedPieces[0] != piecesMat2[0][1] { t.Errorf("Expected 1st locked piece d %v. Got %v instead.", piecesMat2[0][1], game.grid.lockedPieces[0]) }
+	if game.grid.lockedPieces[1] != piecesMat1[1][0] { t.Errorf("Expected 2nd locked piece d %v. Got %v instead.", piecesMat1[0][0], game.grid.lockedPieces[1]) }
+	if game.grid.lockedPieces[2] != piecesMat1[1][2] { t.Errorf("Expected 3rd locked piece d %v. Got %v instead.", piecesMat1[0][2], game.grid.lockedPieces[2]) }
+
+	// swap positions of two pieces
+	p1.pos, p2.pos = p2.pos, p1.pos
+
+	// lock (add) the 2 pieces
+	game.grid.lockPiece(p1)
+	game.grid.lockPiece(p2)
+
+	if len(game.grid.lockedPieces) != 5 {
+		t.Errorf("Expected 5 locked pieces. Got %d instead.", len(game.grid.lockedPieces))
+	}
+
+	if game.grid.lockedPieces[0] != piecesMat2[0][1] { t.Errorf("Expected 1st locked piece d %v. Got %v instead.", piecesMat2[0][1], game.grid.lockedPieces[0]) }
+	if game.grid.lockedPieces[1] != p2               { t.Errorf("Expected 2nd locked piece d %v. Got %v instead.", p2, game.grid.lockedPieces[1]) }
+	if game.grid.lockedPieces[2] != piecesMat1[1][0] { t.Errorf("Expected 3rd locked piece d %v. Got %v instead.", piecesMat1[0][0], game.grid.lockedPieces[2]) }
+	if game.grid.lockedPieces[3] != p1               { t.Errorf("Expected 4th locked piece d %v. Got %v instead.", p1, game.grid.lockedPieces[3]) }
+	if game.grid.lockedPieces[4] != piecesMat1[1][2] { t.Errorf("Expected 5th locked piece d %v. Got %v instead.", piecesMat1[0][2], game.grid.lockedPieces[4]) }
+}
+
+// TestGameJoinAndScorePieces tests the joinAndScorePieces method of Game.
+func TestGameJoinAndScorePieces(t *testing.T) {
+	game := NewGame()
+	fellow := allBodies[ slices.IndexFunc(allBodies, func(b *Body) bool { return b.name == "Fellow" }) ]
+
+	// grid status
+	gridDesc := []string {
+	// 0   1   2   3   4   5   6
+		"_   _   ^T  _   <H",           // 0
+		"_   >H  >H  _   vT  <T  <L",   // 1
+		">L  >T  <H  <T  <L  ^L  vH", } // 2
+	piecesMat := fillGrid(game, gridDesc);
+
+	origScore := game.score
+	joined := game.joinPieces([]*Piece{ piecesMat[2][3] }) // <T in the bottom row
+
+	if !joined || game.rockEffect.getState() != StateBlocking {
+		t.Errorf("Expected to pieces joined (%t) and rocking (%d).", joined, game.rockEffect.getState())
+	}
+
+	// execute rock effect
+	for i := 1; i < 60*10; i++ {
+		game.rockEffect.update(false, i)
+	}
+
+	// 1 body joins and disappears:
+	//          ^T      <H
+	//      >H  >H      vT  <T  <L
+	//  >L  >T              ^L  vH
+
+	// pieces fallen:
+	//
+	//      >H  ^T      <H  <T  <L
+	//  >L  >T  >H      vT  ^L  vH
+	
+	// 2 more bodies join and disappear:
+	//
+	//      >H  ^T
+	//                  vT  ^L  vH
+
+	// pieces fallen:
+	//
+	//      >H  ^T      vT  ^L  vH
+
+	// the 2nd join is a combo
+	expectedScore := origScore + fellow.score + 2 * int(float64(fellow.score) * (1 + comboMultiplierStep))
+	if expectedScore != game.score {
+		t.Errorf("Expected score (%d) is %d", game.score, expectedScore)
+	}
+
+	if len(game.grid.lockedPieces) != 5 {
+		t.Errorf("Expected 5 locked pieces. Got %d instead.", len(game.grid.lockedPieces))
+	}
+
+	if game.grid.lockedPieces[0] != piecesMat[1][1] { t.Errorf("Expected 1st locked piece d %v. Got %v instead.", piecesMat[1][1], game.grid.lockedPieces[0]) }
+	if game.grid.lockedPieces[1] != piecesMat[0][2] { t.Errorf("Expected 2nd locked piece d %v. Got %v instead.", piecesMat[0][2], game.grid.lockedPieces[1]) }
+	if game.grid.lockedPieces[2] != piecesMat[1][4] { t.Errorf("Expected 3rd locked piece d %v. Got %v instead.", piecesMat[1][4], game.grid.lockedPieces[2]) }
+	if game.grid.lockedPieces[3] != piecesMat[2][5] { t.Errorf("Expected 4th locked piece d %v. Got %v instead.", piecesMat[2][5], game.grid.lockedPieces[3]) }
+	if game.grid.lockedPieces[4] != piecesMat[2][6] { t.Errorf("Expected 5th locked piece d %v. Got %v instead.", piecesMat[2][6], game.grid.lockedPieces[4]) }
+}
+
+// TestGameGeneratePiece tests the generatePiece methods of Game.
+func TestGameGeneratePiece(t *testing.T) {
+	game := NewGame()
+	for i := 0; i < 100000; i++ {
+		game.generatePiece()
+	}
+
+	spawnProb := game.difficultyRamp(game.gameTimeSec)
+	for a := 0; a < len(allPieces)-1; a++ {
+		spawnA := game.spawnStat[allPieces[a].pieceType]
+		probA, ok := spawnProb[allPieces[a].pieceType]
+		if !ok {
+			probA = 1.0
+		}
+
+		for b := a+1; b < len(allPieces); b++ {
+			spawnB := game.spawnStat[allPieces[b].pieceType]
+			probB, ok := spawnProb[allPieces[b].pieceType]
+			if !ok {
+				probB = 1.0
+			}
+
+			expectedSpawnA := float32(spawnB) * probA / probB
+
+			// allow may 10% error
+			if expectedSpawnA < float32(spawnA) * 0.9 || float32(spawnA) * 1.1 < expectedSpawnA {
+				t.Errorf("Incorrect nr of generated pieces: '%s'(%f%%):%d '%s'(%f%%):%d", allPieces[a].pieceType, 100*probA, spawnA, allPieces[b].pieceType, 100*probB, spawnB)
+			}
+//		t.Logf("Verify spawn, pieces idx:(%d,%d), spawn nr:(%d,%d), rel diff:%f", a, b, spawnB, spawnA, expectedSpawnA / float32(spawnA))
+		}
+	}
+}
+
+// TestGamePieceBag tests that every piece type is drawn in each cycle of the piece bag.
+func TestGamePieceBag(t *testing.T) {
+	game := NewGame()
+	game.pieceBag = nil
+	game.refillPieceBag()
+
+	bagSize := len(game.pieceBag)
+	spawnProb := game.difficultyRamp(game.gameTimeSec)
+	for cycle := 0; cycle < 3; cycle++ {
+		drawn := map[string]int{}
+		for i := 0; i < bagSize; i++ {
+			drawn[game.generatePiece().pieceType]++
+		}
+
+		for _, p := range allPieces {
+			prob, ok := spawnProb[p.pieceType]
+			if !ok {
+				prob = 1
+			}
+			expected := max(1, int(math.Round(float64(prob*pieceBagCopiesPerProb))))
+			if drawn[p.pieceType] != expected {
+				t.Errorf("Cycle %d: expected '%s' drawn %d times, got %d", cycle, p.pieceType, expected, drawn[p.pieceType])
+			}
+		}
+		if len(game.pieceBag) != 0 {
+			t.Errorf("Cycle %d: expected the bag to be empty, got %d pieces", cycle, len(game.pieceBag))
+		}
+	}
+}
+
+// TestGridExportImportLockedPiecesJSON tests the save state round trip of the locked pieces.
+func TestGridExportImportLockedPiecesJSON(t *testing.T) {
+	game := NewGame()
+
+	gridDesc := []string {
+	// 0   1   2   3
+		"_   ^T  _   <H",   // 0
+		">L  >T  vB  <L", } // 1
+	fillGrid(game, gridDesc)
+
+	data, err := game.grid.exportLockedPiecesJSON()
+	if err != nil {
+		t.Fatalf("Export failed: %v", err)
+	}
+
+	var expected []Piece
+	for _, p := range game.grid.lockedPieces {
+		expected = append(expected, *p)
+	}
+
+	game.grid.reset()
+	if err := game.grid.importLockedPiecesJSON(data); err != nil {
+		t.Fatalf("Import failed: %v", err)
+	}
+
+	if err := game.grid.validateConsistency(); err != nil {
+		t.Errorf("Inconsistent grid after import: %v", err)
+	}
+
+	if len(game.grid.lockedPieces) != len(expected) {
+		t.Fatalf("Expected %d locked pieces. Got %d instead.", len(expected), len(game.grid.lockedPieces))
+	}
+	for i, p := range game.grid.lockedPieces {
+		if p.pieceType != expected[i].pieceType || p.pos != expected[i].pos || p.currentRotation != expected[i].currentRotation {
+			t.Errorf("Expected piece '%s'@%v,%d. Got '%s'@%v,%d instead.", expected[i].pieceType, expected[i].pos, expected[i].currentRotation, p.pieceType, p.pos, p.currentRotation)
+		}
+	}
+
+	// unknown piece type must not change the grid
+	err = game.grid.importLockedPiecesJSON([]byte(`[{"type":"Tail","x":1,"y":1,"rotation":0}]`))
+	if err == nil || !strings.Contains(err.Error(), "Tail") {
+		t.Errorf("Expected error about unknown piece type. Got %v instead.", err)
+	}
+	if len(game.grid.lockedPieces) != len(expected) {
+		t.Errorf("Expected %d locked pieces after failed import. Got %d instead.", len(expected), len(game.grid.lockedPieces))
+	}
+}
+
+// TestGameTurboDrop tests that holding the drop key in turbo drop mode accelerates gravity.
+func TestGameTurboDrop(t *testing.T) {
+	game := NewGame()
+	game.config.turboDropEnabled = true
+
+	framesToDrop := func() int {
+		for i := 1; i <= 100; i++ {
+			if game.checkTimeToMoveDown() {
+				return i
+			}
+		}
+		return -1
+	}
+
+	game.turboDrop = true // drop key held
+	if frames := framesToDrop(); frames != speedLevels[len(speedLevels)-1].ticksPerDrop {
+		t.Errorf("Expected drop after %d frames while held. Got %d instead.", speedLevels[len(speedLevels)-1].ticksPerDrop, frames)
+	}
+
+	game.turboDrop = false // drop key released
+	if frames := framesToDrop(); frames != speedLevels[0].ticksPerDrop {
+		t.Errorf("Expected drop after %d frames after release. Got %d instead.", speedLevels[0].ticksPerDrop, frames)
+	}
+
+	if game.activePiece.pos.y != 0 {
+		t.Errorf("Expected the piece not to be dropped instantly. Got y=%d instead.", game.activePiece.pos.y)
+	}
+}
+
+// TestGridUnlockByLockOrder tests the FIFO and LIFO unlock strategies of Grid.
+func TestGridUnlockByLockOrder(t *testing.T) {
+	game := NewGame()
+
+	gridDesc := []string {
+	// 0   1   2
+		"^H  ^T  ^L",   // 0
+		"^L  ^T  ^H", } // 1
+	piecesMat := fillGrid(game, gridDesc) // locked row by row, left to right
+
+	// falling keeps the lock order
+	game.grid.unlockPiece(piecesMat[1][0])
+	game.grid.compactGrid() // ^H falls into the gap
+
+	oldest := game.grid.unlockOldestN(2)
+	if len(oldest) != 2 || oldest[0] != piecesMat[0][0] || oldest[1] != piecesMat[0][1] {
+		t.Errorf("Expected the 2 oldest pieces %v. Got %v instead.", piecesMat[0][:2], oldest)
+	}
+
+	newest := game.grid.unlockNewestN(2)
+	if len(newest) != 2 || newest[0] != piecesMat[1][2] || newest[1] != piecesMat[1][1] {
+		t.Errorf("Expected the 2 newest pieces %v,%v. Got %v instead.", piecesMat[1][2], piecesMat[1][1], newest)
+	}
+
+	if len(game.grid.lockedPieces) != 1 || game.grid.lockedPieces[0] != piecesMat[0][2] {
+		t.Errorf("Expected only %v to remain locked. Got %v instead.", piecesMat[0][2], game.grid.lockedPieces)
+	}
+
+	// more than available
+	if rest := game.grid.unlockNewestN(5); len(rest) != 1 || len(game.grid.lockedPieces) != 0 {
+		t.Errorf("Expected the last piece to be unlocked. Got %v instead.", rest)
+	}
+}
+
+// TestGameBodyCompletionHistory tests the aging and the capacity of the body completion history.
+func TestGameBodyCompletionHistory(t *testing.T) {
+	game := NewGame()
+	fadeFrameCnt := completionLogFadeSec * ticksPerSec
+
+	for i := 0; i < completionLogMaxLen; i++ {
+		game.frameCount = i
+		game.logBodyCompletion(allBodies[i%len(allBodies)], allBodies[i%len(allBodies)].getScore())
+	}
+
+	first := game.completionLog[0]
+	if alpha := first.getAlpha(0, fadeFrameCnt); alpha != 1 {
+		t.Errorf("Expected new entry to be opaque. Got alpha %f instead.", alpha)
+	}
+	if alpha := first.getAlpha(fadeFrameCnt/2, fadeFrameCnt); alpha != 0.5 {
+		t.Errorf("Expected half aged entry to be half transparent. Got alpha %f instead.", alpha)
+	}
+	if alpha := first.getAlpha(fadeFrameCnt, fadeFrameCnt); alpha != 0 {
+		t.Errorf("Expected aged entry to be invisible. Got alpha %f instead.", alpha)
+	}
+
+	// exceed the capacity
+	game.frameCount = completionLogMaxLen
+	game.logBodyCompletion(allBodies[0], allBodies[0].getScore())
+
+	if len(game.completionLog) != completionLogMaxLen {
+		t.Errorf("Expected %d history entries. Got %d instead.", completionLogMaxLen, len(game.completionLog))
+	}
+	if game.completionLog[0].frameCnt != 1 {
+		t.Errorf("Expected the oldest entry to be removed. The first entry is from frame %d.", game.completionLog[0].frameCnt)
+	}
+	if last := game.completionLog[len(game.completionLog)-1]; last.frameCnt != completionLogMaxLen || last.bodyName != allBodies[0].name {
+		t.Errorf("Expected the newest entry to be the last one. Got %v instead.", last)
+	}
+}
+
+// TestGridApplyRowClear tests the removal of a single row of Grid.
+func TestGridApplyRowClear(t *testing.T) {
+	game := NewGame()
+
+	gridDesc := []string {
+	// 0   1   2
+		"_   ^H  _",    // 0
+		"^T  ^T  _",    // 1
+		"^L  _   ^L", } // 2
+	piecesMat := fillGrid(game, gridDesc)
+	row := piecesMat[1][0].pos.y
+
+	removed := game.grid.applyRowClear(row)
+
+	if len(removed) != 2 || removed[0] != piecesMat[1][0] || removed[1] != piecesMat[1][1] {
+		t.Errorf("Expected the pieces of row %d to be removed. Got %v instead.", row, removed)
+	}
+
+	if err := game.grid.validateConsistency(); err != nil {
+		t.Errorf("Inconsistent grid after row clear: %v", err)
+	}
+
+	// the head fell onto the bottom row
+	if len(game.grid.lockedPieces) != 3 {
+		t.Errorf("Expected 3 locked pieces. Got %d instead.", len(game.grid.lockedPieces))
+	}
+	if head := piecesMat[0][1]; head.pos.y != piecesMat[2][0].pos.y {
+		t.Errorf("Expected the head to fall to row %d. Got %d instead.", piecesMat[2][0].pos.y, head.pos.y)
+	}
+
+	// empty row
+	if removed := game.grid.applyRowClear(0); len(removed) != 0 {
+		t.Errorf("Expected nothing removed from the empty row. Got %v instead.", removed)
+	}
+}
+
+// TestGridLockedPieceCache tests re-rendering the cached locked pieces only when they change.
+func TestGridLockedPieceCache(t *testing.T) {
+	game := NewGame()
+	grid := game.grid
+	screen := ebiten.NewImage(screenWidth, screenHeight)
+	grid.draw(screen)
+	if grid.lockedPieceCache == nil || grid.cacheDirty {
+		t.Fatalf("Expected the cache to be rendered")
+	}
+	cache := grid.lockedPieceCache
+
+	piece := getPieceByType("Torso").clone()
+	piece.pos = Pos{3, 10}
+	grid.lockPiece(piece)
+	if !grid.cacheDirty {
+		t.Errorf("Expected the lock to invalidate the cache")
+	}
+	grid.draw(screen)
+	if grid.cacheDirty || grid.lockedPieceCache != cache {
+		t.Errorf("Expected the cache to be re-rendered in place")
+	}
+
+	grid.unlockPiece(piece)
+	if !grid.cacheDirty {
+		t.Errorf("Expected the unlock to invalidate the cache")
+	}
+	grid.draw(screen)
+	game.applyColorScheme(colorblindColorScheme)
+	if !grid.cacheDirty {
+		t.Errorf("Expected the recoloring to invalidate the cache")
+	}
+	game.applyColorScheme(pieceColorScheme)
+
+	// a new scale reallocates the cache
+	defer func(s int) { scale = s }(scale)
+	scale++
+	grid.draw(screen)
+	if grid.lockedPieceCache == cache || grid.lockedPieceCache.Bounds().Dx() != grid.size.w*scale {
+		t.Errorf("Expected the cache resized to the scale")
+	}
+}
+
+// TestBatchRenderer tests drawing the sprites of the same texture by one call.
+func TestBatchRenderer(t *testing.T) {
+	NewGame() // tints the pieces
+	batch := NewBatchRenderer()
+	head, leg := getPieceByType("Head"), getPieceByType("Leg")
+	for i, piece := range []Piece{*head, *leg, *head} {
+		piece.pos = Pos{i + 1, 5}
+		piece.currentRotation = 90
+		op := &ebiten.DrawImageOptions{}
+		applyRotationToPiece(op, &piece)
+		batch.add(piece.image, op)
+	}
+	// the pieces of the atlas share its texture, the other images have their own
+	batch.add(ebiten.NewImage(10, 10), &ebiten.DrawImageOptions{})
+	if len(batch.batches) != 2 || len(batch.batches[0].vertices) != 12 || len(batch.batches[0].indices) != 18 {
+		t.Fatalf("Expected the pieces in one batch, got %d batches", len(batch.batches))
+	}
+
+	// the source of the leg is its rectangle in the atlas
+	legBounds := leg.image.Bounds()
+	if v := batch.batches[0].vertices[4]; v.SrcX != float32(legBounds.Min.X) || v.SrcY != float32(legBounds.Min.Y) {
+		t.Errorf("Expected the source at %v, got (%v, %v)", legBounds.Min, v.SrcX, v.SrcY)
+	}
+
+	// the vertices are placed like DrawImage would place the rotated image
+	x, y := grid2ScrPos(3, 5)
+	w, h := grid2ScrSize(1, 1)
+	minX, minY, maxX, maxY := float32(math.MaxFloat32), float32(math.MaxFloat32), float32(0), float32(0)
+	for _, v := range batch.batches[0].vertices[8:] {
+		minX, minY, maxX, maxY = min(minX, v.DstX), min(minY, v.DstY), max(maxX, v.DstX), max(maxY, v.DstY)
+	}
+	if math.Abs(float64(minX-x)) > 0.01 || math.Abs(float64(minY-y)) > 0.01 || math.Abs(float64(maxX-x-w)) > 0.01 || math.Abs(float64(maxY-y-h)) > 0.01 {
+		t.Errorf("Unexpected quad (%v, %v)-(%v, %v), expected (%v, %v)-(%v, %v)", minX, minY, maxX, maxY, x, y, x+w, y+h)
+	}
+	if c := head.color; batch.batches[0].vertices[0].ColorR != float32(c.R)/255 {
+		t.Errorf("Expected the tint of the piece, got %v", batch.batches[0].vertices[0].ColorR)
+	}
+
+	screen := ebiten.NewImage(screenWidth, screenHeight)
+	if drawCnt := batch.flush(screen); drawCnt != 2 || len(batch.batches) != 0 {
+		t.Errorf("Expected 2 draw calls and an empty queue, got %d", drawCnt)
+	}
+
+	// a dense grid of all piece types is drawn by one call
+	for _, piece := range allPieces {
+		batch.add(piece.image, &ebiten.DrawImageOptions{})
+	}
+	if drawCnt := batch.flush(screen); drawCnt != 1 {
+		t.Errorf("Expected 1 draw call for the pieces of the atlas, got %d", drawCnt)
+	}
+}
+
+// TestSpriteAtlas tests that the generated sprite atlas has the pixels of the piece images.
+func TestSpriteAtlas(t *testing.T) {
+	decode := func(path string) image.Image {
+		file, err := os.Open(path)
+		if err != nil {
+			t.Fatalf("Failed to open %s: %v", path, err)
+		}
+		defer file.Close()
+		img, err := png.Decode(file)
+		if err != nil {
+			t.Fatalf("Failed to decode %s: %v", path, err)
+		}
+		return img
+	}
+
+	data, err := os.ReadFile(spriteAtlasPath + ".json")
+	if err != nil {
+		t.Fatalf("Failed to read the atlas: %v", err)
+	}
+	var rects map[string]SpriteRect
+	if err := json.Unmarshal(data, &rects); err != nil {
+		t.Fatalf("Failed to parse the atlas: %v", err)
+	}
+	if len(rects) != len(allPieces) {
+		t.Errorf("Expected %d sprites, got %d", len(allPieces), len(rects))
+	}
+
+	// the atlas is regenerated by go generate when an image is changed
+	atlas := decode(spriteAtlasPath + ".png")
+	for path, rect := range rects {
+		img := decode(path)
+		if img.Bounds().Dx() != rect.W || img.Bounds().Dy() != rect.H {
+			t.Errorf("Expected the size %v of %s, got %dx%d", img.Bounds().Size(), path, rect.W, rect.H)
+			continue
+		}
+		for y := 0; y < rect.H; y++ {
+			for x := 0; x < rect.W; x++ {
+				r1, g1, b1, a1 := img.At(img.Bounds().Min.X+x, img.Bounds().Min.Y+y).RGBA()
+				r2, g2, b2, a2 := atlas.At(rect.X+x, rect.Y+y).RGBA()
+				if a1 != a2 || (a1 != 0 && (r1 != r2 || g1 != g2 || b1 != b2)) {
+					t.Fatalf("Expected the pixels of %s in the atlas, run go generate", path)
+				}
+			}
+		}
+	}
+
+	if _, err := loadSpriteAtlas("missing"); err == nil {
+		t.Errorf("Expected an error for the missing atlas")
+	}
+}
+
+// TestGridDangerZone tests the fullness of the top rows shown by the danger overlay.
+func TestGridDangerZone(t *testing.T) {
+	game := NewGame()
+	grid := game.grid
+	if level := grid.getDangerLevel(); level != 0 {
+		t.Errorf("Expected no danger on the empty grid, got %f", level)
+	}
+
+	// the pieces below the danger zone do not count
+	low := getPieceByType("Torso").clone()
+	low.pos = Pos{3, dangerRows}
+	grid.lockPiece(low)
+	if level := grid.getDangerLevel(); level != 0 {
+		t.Errorf("Expected no danger below the top %d rows, got %f", dangerRows, level)
+	}
+
+	high := getPieceByType("Head").clone()
+	high.pos = Pos{3, dangerRows - 1}
+	grid.lockPiece(high)
+	if level, expected := grid.getDangerLevel(), 1/float64((grid.size.w-2)*dangerRows); level != expected {
+		t.Errorf("Expected the danger level %f, got %f", expected, level)
+	}
+
+	screen := ebiten.NewImage(screenWidth, screenHeight)
+	for i := 0; i < dangerZonePulseFrameCnt; i++ {
+		grid.update(false, i)
+		grid.draw(screen)
+	}
+
+	grid.unlockPiece(high)
+	if level := grid.getDangerLevel(); level != 0 {
+		t.Errorf("Expected the overlay to disappear, got %f", level)
+	}
+}
+
+// TestGridRemoveRowRange tests removing the pieces of several rows at once.
+func TestGridRemoveRowRange(t *testing.T) {
+	game := NewGame()
+
+	gridDesc := []string {
+	// 0   1   2
+		"_   ^H  _",    // 0
+		"^T  ^T  _",    // 1
+		"^L  _   ^L",   // 2
+		"^H  _   _",    // 3
+		"^T  ^L  ^L", } // 4
+	piecesMat := fillGrid(game, gridDesc)
+
+	// the range is clamped to the bottom row
+	removed := game.grid.removeRowRange(piecesMat[2][0].pos.y, game.grid.size.h+5)
+	if len(removed) != 6 {
+		t.Errorf("Expected the 6 pieces of the bottom 3 rows to be removed. Got %v instead.", removed)
+	}
+	if err := game.grid.validateConsistency(); err != nil {
+		t.Errorf("Inconsistent grid after row range removal: %v", err)
+	}
+
+	// the pieces above fell to the bottom
+	bottomRow := game.grid.size.h - 2
+	if len(game.grid.lockedPieces) != 3 {
+		t.Errorf("Expected 3 locked pieces. Got %d instead.", len(game.grid.lockedPieces))
+	}
+	if torso := piecesMat[1][0]; torso.pos.y != bottomRow {
+		t.Errorf("Expected the torso to fall to row %d. Got %d instead.", bottomRow, torso.pos.y)
+	}
+	if head := piecesMat[0][1]; head.pos.y != bottomRow-1 {
+		t.Errorf("Expected the head to fall to row %d. Got %d instead.", bottomRow-1, head.pos.y)
+	}
+
+	// the range is clamped to the top row
+	if removed := game.grid.removeRowRange(-5, bottomRow-1); len(removed) != 1 || removed[0] != piecesMat[0][1] {
+		t.Errorf("Expected the head to be removed. Got %v instead.", removed)
+	}
+
+	// empty range
+	if removed := game.grid.removeRowRange(bottomRow, bottomRow-1); len(removed) != 0 {
+		t.Errorf("Expected nothing removed by the empty range. Got %v instead.", removed)
+	}
+}
+
+// TestGameNuke tests the landing of the nuke piece.
+func TestGameNuke(t *testing.T) {
+	game := NewGame()
+	fillGrid(game, []string {
+		"^H  _   _",
+		"^T  ^L  _",
+		"<L  ^L  ^H",
+		"^T  ^T  ^L", })
+
+	nuke := getPieceByType("Nuke").clone()
+	nuke.pos = Pos{5, game.grid.size.h - 2}
+	game.activePiece = nuke
+	game.handleActivePieceLanded()
+
+	// only the top row is left, fallen to the bottom
+	if len(game.grid.lockedPieces) != 1 || game.grid.lockedPieces[0].pos.y != game.grid.size.h-2 {
+		t.Errorf("Expected 1 locked piece in the bottom row. Got %v instead.", game.grid.lockedPieces)
+	}
+	if game.waveEffect.getState() == StateInactive || !game.waveEffect.isHorizontalLine {
+		t.Errorf("Expected the row wave effect to be played")
+	}
+}
+
+// TestPieceCompGhost tests the landing position shown by the ghost piece.
+func TestPieceCompGhost(t *testing.T) {
+	game := NewGame()
+	piecesMat := fillGrid(game, []string {
+		"_   _   ^T",
+		"^T  _   ^L",
+		"^L  _   ^H", })
+
+	piece := getPieceByType("Head").clone()
+	piece.pos = Pos{1, 0}
+	game.apc.p = piece
+
+	if pos := game.apc.getGhostPos(); pos != (Pos{1, piecesMat[1][0].pos.y - 1}) {
+		t.Errorf("Expected the ghost above the torso, got %v", pos)
+	}
+	if piece.pos != (Pos{1, 0}) {
+		t.Errorf("Expected the active piece not to move, got %v", piece.pos)
+	}
+
+	// the ghost follows the horizontal moves
+	piece.pos.x = 2
+	if pos := game.apc.getGhostPos(); pos != (Pos{2, game.grid.size.h - 2}) {
+		t.Errorf("Expected the ghost at the bottom, got %v", pos)
+	}
+
+	// the ghost overlaps the piece touching the locked pieces
+	piece.pos = Pos{3, piecesMat[0][2].pos.y - 1}
+	if pos := game.apc.getGhostPos(); pos != piece.pos {
+		t.Errorf("Expected the ghost at the piece position %v, got %v", piece.pos, pos)
+	}
+
+	screen := ebiten.NewImage(screenWidth, screenHeight)
+	game.apc.activate(true)
+	game.apc.draw(screen)
+	game.apc.ghostAlpha = 0
+	game.apc.draw(screen)
+}
+
+// TestGameHoldPiece tests saving the active piece for later.
+func TestGameHoldPiece(t *testing.T) {
+	game := NewGame()
+	first, second := game.activePiece, game.nextPieces[0]
+	first.pos.x = 3
+
+	// the next piece becomes active if nothing is held
+	if !game.holdActivePiece() {
+		t.Fatalf("Expected the first hold to succeed")
+	}
+	if game.holdPiece != first || game.activePiece != second || game.apc.p != second {
+		t.Errorf("Expected the first piece to be held and the second to be active")
+	}
+	if game.nextPieces[0] == first || game.nextPieces[0] == second {
+		t.Errorf("Expected a new next piece")
+	}
+	if second.pos != (Pos{game.grid.size.w / 2, 0}) {
+		t.Errorf("Expected the swapped in piece at the spawn position, got %v", second.pos)
+	}
+
+	// re-holding before landing is forbidden
+	if game.holdActivePiece() {
+		t.Errorf("Expected the second hold to be forbidden")
+	}
+
+	// the held piece is swapped back after landing
+	game.dropPiece()
+	third := game.activePiece
+	if game.holdUsed {
+		t.Errorf("Expected the landing to allow holding again")
+	}
+	next := game.nextPieces[0]
+	if !game.holdActivePiece() {
+		t.Fatalf("Expected the hold after landing to succeed")
+	}
+	if game.holdPiece != third || game.activePiece != first || game.nextPieces[0] != next {
+		t.Errorf("Expected the held and the active pieces to be swapped")
+	}
+	if first.pos != (Pos{game.grid.size.w / 2, 0}) {
+		t.Errorf("Expected the held piece at the spawn position, got %v", first.pos)
+	}
+
+	game.Reset()
+	if game.holdPiece != nil || game.holdUsed {
+		t.Errorf("Expected reset to clear the held piece")
+	}
+}
+
+// TestGameLockDelay tests that the landed piece can be moved during the lock delay.
+func TestGameLockDelay(t *testing.T) {
+	game := NewGame()
+	piece := getPieceByType("Head").clone()
+	piece.pos = Pos{5, game.grid.size.h - 2}
+	game.activePiece = piece
+	game.apc.p = piece
+
+	isLocked := func() bool { return game.grid.getPiece(piece.pos) == piece }
+
+	game.moveDown()
+	for i := 1; i < lockDelayMaxFrames; i++ {
+		game.updateLockDelay()
+	}
+	if isLocked() {
+		t.Fatalf("Expected the piece not to lock during the lock delay")
+	}
+
+	// the lateral move restarts the delay
+	game.input.simulatePress("left")
+	game.input.handleKeys(1)
+	game.apc.update(false, 1)
+	game.input.simulateRelease("left")
+	game.input.handleKeys(2)
+	if piece.pos.x != 4 || game.lockDelayFrameCount != 1 {
+		t.Errorf("Expected the move to restart the lock delay, got x=%d count=%d", piece.pos.x, game.lockDelayFrameCount)
+	}
+
+	for i := 1; i < lockDelayMaxFrames; i++ {
+		game.updateLockDelay()
+	}
+	if isLocked() {
+		t.Fatalf("Expected the piece not to lock after the move")
+	}
+	game.updateLockDelay()
+	if !isLocked() || game.lockDelayFrameCount != 0 {
+		t.Errorf("Expected the piece to lock after %d frames", lockDelayMaxFrames)
+	}
+
+	// the drop bypasses the delay
+	dropped := getPieceByType("Torso").clone()
+	dropped.pos = Pos{8, 0}
+	game.activePiece = dropped
+	game.dropPiece()
+	if game.grid.getPiece(dropped.pos) != dropped {
+		t.Errorf("Expected the dropped piece to lock instantly")
+	}
+}
+
+// TestPieceCompWallKick tests that the rotation blocked by the wall succeeds via kick.
+func TestPieceCompWallKick(t *testing.T) {
+	game := NewGame()
+	rightCol := game.grid.size.w - 2
+
+	// vertical 1x2 piece flush against the right wall. the horizontal rotation needs one more column
+	piece := &Piece{image: getPieceByType("Torso").image, size: Size{2, 1}, currentRotation: 90, pieceType: "Torso", pos: Pos{rightCol, 5}}
+	if !game.apc.rotate(piece) {
+		t.Fatalf("Expected the rotation to succeed via kick")
+	}
+	if piece.currentRotation != 180 || piece.pos != (Pos{rightCol - 1, 5}) {
+		t.Errorf("Expected the piece rotated to 180 at %v, got %d at %v", Pos{rightCol - 1, 5}, piece.currentRotation, piece.pos)
+	}
+
+	// the sideways kick is blocked by a locked piece, the upward kicks are tried next
+	game.grid.reset()
+	fillGrid(game, []string{
+		strings.Repeat("_   ", rightCol-2) + "^H",
+		"_",
+	})
+	piece.currentRotation = 90
+	piece.pos = Pos{rightCol, game.grid.size.h - 3}
+	if !game.apc.rotate(piece) {
+		t.Fatalf("Expected the rotation to succeed via the upward kick")
+	}
+	if expected := (Pos{rightCol - 1, game.grid.size.h - 4}); piece.pos != expected {
+		t.Errorf("Expected the piece kicked to %v, got %v", expected, piece.pos)
+	}
+
+	// the rotation in the free space does not move the piece
+	piece.currentRotation = 0
+	piece.pos = Pos{5, 5}
+	if !game.apc.rotate(piece) || piece.pos != (Pos{5, 5}) || piece.currentRotation != 90 {
+		t.Errorf("Expected the rotation in place, got %d at %v", piece.currentRotation, piece.pos)
+	}
+}
+
+// TestGameHardDropBonus tests the bonus score of the dropped piece.
+func TestGameHardDropBonus(t *testing.T) {
+	game := NewGame()
+	piece := getPieceByType("Head").clone()
+	piece.pos = Pos{5, 0}
+	game.activePiece = piece
+
+	// the piece travels from row 0 to the bottom row
+	game.dropPiece()
+	dropRows := game.grid.size.h - 2
+	if expected := dropRows * hardDropPointsPerRow; game.score != expected {
+		t.Errorf("Expected score %d after dropping %d rows, got %d", expected, dropRows, game.score)
+	}
+
+	// no bonus for the piece already landed
+	score := game.score
+	piece = getPieceByType("Torso").clone()
+	piece.pos = Pos{5, game.grid.size.h - 3}
+	game.activePiece = piece
+	game.dropPiece()
+	if game.score != score {
+		t.Errorf("Expected no bonus without falling, got %d", game.score-score)
+	}
+}
+
+// TestGameDifficultyRamp tests the interpolation of the spawn probabilities during the difficulty ramp.
+func TestGameDifficultyRamp(t *testing.T) {
+	game := NewGame()
+
+	for _, tc := range []struct {
+		timeSec  float32
+		bombProb float32
+	}{{0, 0.2}, {150, 0.55}, {300, 0.9}, {600, 0.9}} {
+		probs := game.difficultyRamp(tc.timeSec)
+		if math.Abs(float64(probs["Bomb"]-tc.bombProb)) > 1e-5 {
+			t.Errorf("Expected bomb probability %f at %fs. Got %f instead.", tc.bombProb, tc.timeSec, probs["Bomb"])
+		}
+		if probs["Torso"] != game.spawnProb["Torso"] {
+			t.Errorf("Expected torso probability %f at %fs. Got %f instead.", game.spawnProb["Torso"], tc.timeSec, probs["Torso"])
+		}
+	}
+}
+
+// TestGridTranspose tests the rotation of the board of Grid.
+func TestGridTranspose(t *testing.T) {
+	game := NewGame()
+
+	gridDesc := []string {
+	// 0   1   2
+		"^H  ^T  ^L",   // 0
+		"^T  _   <T",   // 1
+		"^L  ^L  ^H", } // 2
+	piecesMat := fillGrid(game, gridDesc)
+
+	type pieceState struct {
+		pos Pos
+		rot int
+	}
+	origState := map[*Piece]pieceState{}
+	for _, p := range game.grid.lockedPieces {
+		origState[p] = pieceState{p.pos, p.currentRotation}
+	}
+
+	if err := game.grid.transpose(); err != nil {
+		t.Errorf("Expected no error. Got %v instead.", err)
+	}
+
+	if err := game.grid.validateConsistency(); err != nil {
+		t.Errorf("Inconsistent grid after transpose: %v", err)
+	}
+
+	// rotated by 90 degrees counterclockwise: the left column becomes the bottom row
+	for p, orig := range origState {
+		expected := pieceState{Pos{orig.pos.y, gridSize.w - 1 - orig.pos.x}, (orig.rot + 90) % 360}
+		if p.pos != expected.pos || p.currentRotation != expected.rot {
+			t.Errorf("Expected '%s' at %v,%d. Got %v,%d instead.", p.pieceType, expected.pos, expected.rot, p.pos, p.currentRotation)
+		}
+	}
+	if piecesMat[0][0].pos.y != piecesMat[2][0].pos.y || piecesMat[0][0].pos.x >= piecesMat[2][0].pos.x {
+		t.Errorf("Expected the left column to become the bottom row. Got %v, %v", piecesMat[0][0].pos, piecesMat[2][0].pos)
+	}
+
+	// a piece in the top row would be rotated into the left wall
+	game.grid.reset()
+	topPiece := allPieces[0]
+	topPiece.pos = Pos{3, 0}
+	game.grid.lockPiece(&topPiece)
+	if err := game.grid.transpose(); err == nil {
+		t.Error("Expected an error for the piece rotated outside of the grid.")
+	}
+	if len(game.grid.lockedPieces) != 0 {
+		t.Errorf("Expected the piece outside of the grid to be skipped. Got %v instead.", game.grid.lockedPieces)
+	}
+}
+
+// TestBodyScoreWeight tests the body score weighted by the body pieces.
+func TestBodyScoreWeight(t *testing.T) {
+	NewGame() // initializes the bodies
+
+	for _, tc := range []struct {
+		name  string
+		score int
+	}{{"Fellow", 1000}, {"Failed Yoga", 4000}} {
+		body := allBodies[slices.IndexFunc(allBodies, func(b *Body) bool { return b.name == tc.name })]
+		if body.getScore() != tc.score {
+			t.Errorf("Expected score %d for '%s'. Got %d instead.", tc.score, tc.name, body.getScore())
+		}
+	}
+
+	body := &Body{
+		name:  "Weighted",
+		score: 900,
+		bodyPieces: []BodyPiece{
+			{pos: Pos{0, 0}, pieceType: "Head", scoreWeight: 0.5},
+			{pos: Pos{0, 1}, pieceType: "Torso"}, // default weight
+			{pos: Pos{0, 2}, pieceType: "Leg", scoreWeight: 3},
+		},
+	}
+	body.init()
+	if body.getScore() != 1350 {
+		t.Errorf("Expected score 1350 for '%s'. Got %d instead.", body.name, body.getScore())
+	}
+}
+
+// TestGridFindBodyCandidates tests finding the potential body matches of Grid.
+func TestGridFindBodyCandidates(t *testing.T) {
+	game := NewGame()
+	asshead := allBodies[slices.IndexFunc(allBodies, func(b *Body) bool { return b.name == "Asshead" })]
+
+	if candidates := game.grid.findBodyCandidates(asshead); len(candidates) != 0 {
+		t.Errorf("Expected no candidates in the empty grid. Got %d instead.", len(candidates))
+	}
+
+	// a head in the bottom row has no place for the leg
+	gridDesc := []string {
+	// 0   1   2
+		"^H  _   _", } // 0
+	fillGrid(game, gridDesc)
+
+	if candidates := game.grid.findBodyCandidates(asshead); len(candidates) != 0 {
+		t.Errorf("Expected no candidates. Got %d instead.", len(candidates))
+	}
+
+	game.grid.reset()
+	gridDesc = []string {
+	// 0   1   2
+		"_   <T  ^L", } // 0
+	piecesMat := fillGrid(game, gridDesc)
+
+	candidates := game.grid.findBodyCandidates(asshead)
+	if len(candidates) != 1 {
+		t.Fatalf("Expected 1 candidate. Got %d instead.", len(candidates))
+	}
+	leg := piecesMat[0][2]
+	if c := candidates[0]; c.getMissingCnt() != 1 || c.pieces[0] != nil || c.positions[0] != addPos(leg.pos, Pos{0, -1}) || c.rotations[0] != 0 || c.pieces[1] != leg {
+		t.Errorf("Expected missing head above the leg %v. Got %v instead.", leg.pos, c)
+	}
+
+	game.grid.reset()
+	gridDesc = []string {
+	// 0   1   2
+		"^L  _   ^L", } // 0
+	fillGrid(game, gridDesc)
+
+	if candidates := game.grid.findBodyCandidates(asshead); len(candidates) != 2 {
+		t.Errorf("Expected 2 candidates. Got %d instead.", len(candidates))
+	}
+
+	// the body completion progress shows the candidate of the most present pieces, the 2-piece bodies are skipped
+	if c := game.grid.getBestCandidate(); c == nil || c.body.name == "Asshead" || c.getMissingCnt() != len(c.pieces)-1 {
+		t.Errorf("Expected a larger body of a present leg. Got %v instead.", c)
+	}
+	game.grid.reset()
+	fillGrid(game, []string{"^T", "^L"})
+	c := game.grid.getBestCandidate()
+	if c == nil || c.getProgress() != float32(2)/3 || c.getMissingCnt() != 1 {
+		t.Fatalf("Expected a body of 3 pieces missing one. Got %v instead.", c)
+	}
+	if game.grid.getBestCandidate() != c {
+		t.Errorf("Expected the cached candidate while the grid does not change")
+	}
+	game.Update()
+	if game.sideBar.bodyCandidate != c {
+		t.Errorf("Expected the candidate on the sidebar")
+	}
+	screen := ebiten.NewImage(screenWidth, screenHeight)
+	game.sideBar.draw(screen)
+	game.grid.reset()
+	if c := game.grid.getBestCandidate(); c != nil {
+		t.Errorf("Expected no progress on the empty grid. Got %s instead.", c.body.name)
+	}
+}
+
+// TestUserInputDoubleClick tests the double click detection of UserInput.
+func TestUserInputDoubleClick(t *testing.T) {
+	testCases := []struct {
+		name        string
+		clickFrames []int
+		expected    []bool
+	}{
+		{"single click", []int{5}, []bool{false}},
+		{"within window", []int{5, 14}, []bool{false, true}},
+		{"window boundary", []int{5, 15}, []bool{false, true}},
+		{"outside window", []int{5, 16}, []bool{false, false}},
+		{"pair restarts after miss", []int{5, 16, 26}, []bool{false, false, true}},
+		{"triple click", []int{5, 7, 9}, []bool{false, true, false}},
+	}
+
+	for _, tc := range testCases {
+		input := NewUserInput(&map[string]KeyList{})
+		for i, frame := range tc.clickFrames {
+			input.updateMouseState(true, false, frame)
+			if input.isDoubleClick() != tc.expected[i] {
+				t.Errorf("%s: expected double click %t at frame %d", tc.name, tc.expected[i], frame)
+			}
+
+			input.updateMouseState(false, false, frame+1)
+			if input.isDoubleClick() {
+				t.Errorf("%s: unexpected double click at release frame %d", tc.name, frame+1)
+			}
+		}
+	}
+}
+
+// TestUserInputDAS tests the delayed auto-shift of the held directional keys.
+func TestUserInputDAS(t *testing.T) {
+	input := NewUserInput(&map[string]KeyList{"left": {}, "rotate": {}})
+
+	input.simulatePress("left")
+	input.simulatePress("rotate")
+	var leftPresses, rotatePresses []int
+	for frame := 1; frame <= 16; frame++ {
+		input.handleKeys(frame)
+		if input.isKeyPressed("left") {
+			leftPresses = append(leftPresses, frame)
+		}
+		if input.isKeyPressed("rotate") {
+			rotatePresses = append(rotatePresses, frame)
+		}
+	}
+
+	// pressed, then repeated after 10 frames in every 2 frames
+	if expected := []int{1, 11, 13, 15}; !slices.Equal(leftPresses, expected) {
+		t.Errorf("Expected left presses at frames %v, got %v", expected, leftPresses)
+	}
+	if expected := []int{1}; !slices.Equal(rotatePresses, expected) {
+		t.Errorf("Expected rotate to be pressed once at %v, got %v", expected, rotatePresses)
+	}
+
+	// the release restarts the delay
+	input.simulateRelease("left")
+	input.handleKeys(17)
+	if !input.keyState["left"].release || input.keyState["left"].heldFrames != 0 {
+		t.Errorf("Expected left to be released")
+	}
+	input.simulatePress("left")
+	for frame := 18; frame <= 27; frame++ {
+		input.handleKeys(frame)
+		if input.isKeyPressed("left") != (frame == 18) {
+			t.Errorf("Expected left to be pressed only at frame 18, pressed %t at %d", input.isKeyPressed("left"), frame)
+		}
+	}
+}
+
+// TestUserInputTouchGestures tests the actions triggered by the touch gestures.
+func TestUserInputTouchGestures(t *testing.T) {
+	input := NewUserInput(&map[string]KeyList{"left": {ebiten.KeyArrowLeft}, "right": {ebiten.KeyArrowRight}})
+	expectPressed := func(step string, expected ...string) {
+		for _, action := range []string{"left", "right", "rotate", "drop", "softDrop"} {
+			if pressed := input.isKeyPressed(action); pressed != slices.Contains(expected, action) {
+				t.Errorf("%s: expected %s pressed %t", step, action, !pressed)
+			}
+		}
+	}
+
+	// a swipe to the right by two and a half cells moves twice
+	input.updateTouches(map[ebiten.TouchID]Pos{1: {100, 100}}, 1)
+	expectPressed("touch")
+	input.updateTouches(map[ebiten.TouchID]Pos{1: {100 + scale + 5, 100}}, 2)
+	expectPressed("first cell", "right")
+	input.updateTouches(map[ebiten.TouchID]Pos{1: {100 + scale + 10, 100}}, 3)
+	expectPressed("within the second cell")
+	input.updateTouches(map[ebiten.TouchID]Pos{1: {100 + 2*scale + 15, 120}}, 4)
+	expectPressed("second cell", "right")
+	input.updateTouches(map[ebiten.TouchID]Pos{}, 5)
+	expectPressed("swipe release")
+
+	// tap in the upper and the lower half
+	input.updateTouches(map[ebiten.TouchID]Pos{2: {100, 100}}, 10)
+	input.updateTouches(map[ebiten.TouchID]Pos{}, 11)
+	expectPressed("upper tap", "rotate")
+	input.updateTouches(map[ebiten.TouchID]Pos{2: {100, screenHeight - 100}}, 12)
+	input.updateTouches(map[ebiten.TouchID]Pos{}, 13)
+	expectPressed("lower tap")
+
+	// slow and fast downward swipes
+	input.updateTouches(map[ebiten.TouchID]Pos{3: {100, 100}}, 20)
+	input.updateTouches(map[ebiten.TouchID]Pos{3: {100, 100 + 2*scale}}, 30)
+	input.updateTouches(map[ebiten.TouchID]Pos{}, 31)
+	expectPressed("slow swipe", "softDrop")
+	input.updateTouches(map[ebiten.TouchID]Pos{4: {100, 100}}, 40)
+	input.updateTouches(map[ebiten.TouchID]Pos{4: {100, 100 + 3*touchFastSwipeSpeed}}, 41)
+	input.updateTouches(map[ebiten.TouchID]Pos{}, 42)
+	expectPressed("fast swipe", "drop")
+
+	// the keys still work while a touch is held
+	input.updateTouches(map[ebiten.TouchID]Pos{5: {100, 100}}, 50)
+	input.simulatePress("left")
+	input.handleKeys(50)
+	expectPressed("key press", "left")
+}
+
+// TestUserInputKeyBindings tests the rebinding of the keys and saving and loading the bindings.
+func TestUserInputKeyBindings(t *testing.T) {
+	input := NewUserInput(&map[string]KeyList{
+		"rotate": []ebiten.Key{ebiten.KeyArrowUp},
+		"drop":   []ebiten.Key{ebiten.KeySpace},
+	})
+
+	input.AddBinding("rotate", ebiten.KeyW)
+	input.AddBinding("rotate", ebiten.KeyW)
+	input.AddBinding("unknown", ebiten.KeyX)
+	input.RemoveBinding("drop", ebiten.KeySpace)
+	if keys := input.getBindings("rotate"); !slices.Equal(keys, KeyList{ebiten.KeyArrowUp, ebiten.KeyW}) {
+		t.Errorf("Expected the rotate keys ArrowUp and W, got %v", keys)
+	}
+	if keys := input.getBindings("drop"); len(keys) != 0 {
+		t.Errorf("Expected no drop keys, got %v", keys)
+	}
+	if _, ok := input.keyDesc["unknown"]; ok {
+		t.Errorf("Expected no binding of the unknown action")
+	}
+
+	path := t.TempDir() + "/bindings.json"
+	if err := input.LoadKeyBindings(path); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Expected a missing file error, got %v", err)
+	}
+	if err := input.SaveKeyBindings(path); err != nil {
+		t.Fatalf("Unexpected save error %v", err)
+	}
+
+	loaded := NewUserInput(&map[string]KeyList{
+		"rotate": []ebiten.Key{ebiten.KeyEnter},
+		"drop":   []ebiten.Key{ebiten.KeySpace},
+		"hold":   []ebiten.Key{ebiten.KeyShiftLeft},
+	})
+	if err := loaded.LoadKeyBindings(path); err != nil {
+		t.Fatalf("Unexpected load error %v", err)
+	}
+	for action, expected := range map[string]KeyList{"rotate": {ebiten.KeyArrowUp, ebiten.KeyW}, "drop": {}, "hold": {ebiten.KeyShiftLeft}} {
+		if keys := loaded.getBindings(action); !slices.Equal(keys, expected) {
+			t.Errorf("Expected the loaded %s keys %v, got %v", action, expected, keys)
+		}
+	}
+}
+
+// TestGridApplyGravityAfterClear tests that the gravity after a row clear matches the full compaction.
+func TestGridApplyGravityAfterClear(t *testing.T) {
+	gridDesc := []string {
+	// 0   1   2   3
+		"^H  _   _   _ ",  // 0
+		"^T  ^H  _   _ ",  // 1
+		"_   ^T  ^H  _ ",  // 2
+		"^L  ^L  ^T  ^H",  // 3
+		"_   ^L  _   ^T", } // 4
+
+	naive := NewGame()
+	fillGrid(naive, gridDesc)
+	clearedRow := naive.grid.lockedPieces[len(naive.grid.lockedPieces)-1].pos.y - 1
+	naive.grid.unlockPieces(slices.DeleteFunc(slices.Clone(naive.grid.lockedPieces), func(p *Piece) bool { return p.pos.y != clearedRow }))
+	naiveFallen := naive.grid.compactGrid()
+
+	game := NewGame()
+	piecesMat := fillGrid(game, gridDesc)
+	game.grid.unlockPieces(slices.DeleteFunc(slices.Clone(game.grid.lockedPieces), func(p *Piece) bool { return p.pos.y != clearedRow }))
+	fallen := game.grid.applyGravityAfterClear([]int{clearedRow})
+
+	if len(fallen) != len(naiveFallen) || len(fallen) != 5 {
+		t.Errorf("Expected %d fallen pieces, got %d", len(naiveFallen), len(fallen))
+	}
+
+	if len(game.grid.lockedPieces) != len(naive.grid.lockedPieces) {
+		t.Fatalf("Expected %d locked pieces, got %d", len(naive.grid.lockedPieces), len(game.grid.lockedPieces))
+	}
+	for i, piece := range game.grid.lockedPieces {
+		expected := naive.grid.lockedPieces[i]
+		if piece.pieceType != expected.pieceType || piece.pos != expected.pos {
+			t.Errorf("Expected '%s'@%v at index %d, got '%s'@%v", expected.pieceType, expected.pos, i, piece.pieceType, piece.pos)
+		}
+	}
+
+	if piecesMat[4][1].pos.y != clearedRow+1 || piecesMat[4][3].pos.y != clearedRow+1 {
+		t.Errorf("Expected the pieces below the cleared row to stay in place")
+	}
+
+	if err := game.grid.validateConsistency(); err != nil {
+		t.Errorf("Grid is inconsistent: %v", err)
+	}
+
+	if fallen := game.grid.applyGravityAfterClear(nil); len(fallen) != 0 {
+		t.Errorf("Expected no fallen pieces without cleared rows, got %d", len(fallen))
+	}
+}
+
+/*
+prepareGravityBenchmark fills the bottom 10 rows of the grid with 50 pieces and clears the middle row.
+Returns the cleared row.
+*/
+func prepareGravityBenchmark(game *Game) int {
+	game.grid.reset()
+	headIdx := slices.IndexFunc(allPieces, func(p Piece) bool { return p.pieceType == "Head" })
+
+	bottom := gridSize.h - 2
+	for y := bottom - 9; y <= bottom; y++ {
+		for x := 1; x <= 5; x++ {
+			piece := allPieces[headIdx]
+			piece.pos = Pos{x, y}
+			game.grid.lockPiece(&piece)
+		}
+	}
+
+	clearedRow := bottom - 5
+	game.grid.unlockPieces(slices.DeleteFunc(slices.Clone(game.grid.lockedPieces), func(p *Piece) bool { return p.pos.y != clearedRow }))
+
+	return clearedRow
+}
+
+// BenchmarkGridCompactGrid benchmarks the full compaction after a row clear.
+func BenchmarkGridCompactGrid(b *testing.B) {
+	game := NewGame()
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		prepareGravityBenchmark(game)
+		b.StartTimer()
+
+		game.grid.compactGrid()
+	}
+}
+
+// BenchmarkGridApplyGravityAfterClear benchmarks the gravity limited to the pieces above the cleared row.
+func BenchmarkGridApplyGravityAfterClear(b *testing.B) {
+	game := NewGame()
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		clearedRow := prepareGravityBenchmark(game)
+		b.StartTimer()
+
+		game.grid.applyGravityAfterClear([]int{clearedRow})
+	}
+}
+
+// TestDialogBackground tests drawing the dialog with and without background image.
+func TestDialogBackground(t *testing.T) {
+	screen := ebiten.NewImage(screenWidth, screenHeight)
+
+	dialog := NewModalDialog([]string{"Solid"}, Pos{100, 100}, DrawOrderGameOver).WithBackground(nil)
+	dialog.activate(true)
+	dialog.draw(screen)
+
+	img := ebiten.NewImage(4, 2)
+	dialog = NewDialog([]string{"Textured", "dialog"}, Pos{100, 100}, 10, DrawOrderGameOver).WithBackground(img)
+	if dialog.background != img {
+		t.Errorf("Expected the background image to be set")
+	}
+	dialog.activate(true)
+	dialog.draw(screen)
+}
+
+// TestGridPiecesAboveBelowRow tests the row based queries of the locked pieces.
+func TestGridPiecesAboveBelowRow(t *testing.T) {
+	game := NewGame()
+	gridDesc := []string {
+	// 0   1   2
+		"^H  _   _ ",  // 0
+		"_   _   _ ",  // 1
+		"^T  ^H  ^L",  // 2
+		"^L  _   ^L", } // 3
+	piecesMat := fillGrid(game, gridDesc)
+	row := piecesMat[2][0].pos.y
+
+	testCases := []struct {
+		name     string
+		pieces   []*Piece
+		expected []*Piece
+	}{
+		{"above", game.grid.piecesAboveRow(row), []*Piece{piecesMat[0][0]}},
+		{"below", game.grid.piecesBelowRow(row), []*Piece{piecesMat[3][0], piecesMat[3][2]}},
+		{"above the empty row", game.grid.piecesAboveRow(row - 1), []*Piece{piecesMat[0][0]}},
+		{"below the empty row", game.grid.piecesBelowRow(row - 1), []*Piece{piecesMat[2][0], piecesMat[2][1], piecesMat[2][2], piecesMat[3][0], piecesMat[3][2]}},
+		{"above the top row", game.grid.piecesAboveRow(0), []*Piece{}},
+		{"below the bottom row", game.grid.piecesBelowRow(row + 1), []*Piece{}},
+	}
+
+	for _, tc := range testCases {
+		if !slices.Equal(tc.pieces, tc.expected) {
+			t.Errorf("%s: expected %v, got %v", tc.name, tc.expected, tc.pieces)
+		}
+	}
+}
+
+// BenchmarkGridPiecesAboveRow benchmarks the binary search based row query.
+func BenchmarkGridPiecesAboveRow(b *testing.B) {
+	game := NewGame()
+	clearedRow := prepareGravityBenchmark(game)
+
+	for i := 0; i < b.N; i++ {
+		game.grid.piecesAboveRow(clearedRow)
+	}
+}
+
+// TestPieceMovement_Left tests moving the active piece left with simulated key presses.
+func TestPieceMovement_Left(t *testing.T) {
+	game := NewGame()
+	defer game.input.simulateRelease("left")
+
+	x := game.activePiece.pos.x
+	for i := 1; i <= 3; i++ {
+		game.input.simulatePress("left")
+		game.Update()
+		if game.activePiece.pos.x != x-i {
+			t.Errorf("Expected x=%d after %d presses, got %d", x-i, i, game.activePiece.pos.x)
+		}
+
+		// holding the key does not repeat the move
+		game.Update()
+		if game.activePiece.pos.x != x-i {
+			t.Errorf("Expected x=%d while holding the key, got %d", x-i, game.activePiece.pos.x)
+		}
+
+		game.input.simulateRelease("left")
+		game.Update()
+	}
+}
+
+// TestGridIslands tests the connectivity analysis of the locked pieces.
+func TestGridIslands(t *testing.T) {
+	testCases := []struct {
+		name            string
+		gridDesc        []string
+		expectedCnt     int
+		expectedLargest int
+	}{
+		{"no pieces", []string{}, 0, 0},
+		{"single piece", []string{"_   ^H  _ "}, 1, 1},
+		{"disconnected pieces", []string{
+			"^H  _   ^H  _   ^L",
+			"_   ^T  _   _   _ ",
+		}, 4, 1},
+		{"diagonal is not connected", []string{
+			"^H  _ ",
+			"_   ^T",
+		}, 2, 1},
+		{"connected and separate groups", []string{
+			"^H  ^T  _   ^L",
+			"_   ^T  _   ^L",
+			"^L  ^L  _   _ ",
+		}, 2, 5},
+	}
+
+	for _, tc := range testCases {
+		game := NewGame()
+		fillGrid(game, tc.gridDesc)
+
+		if cnt := game.grid.islandCount(); cnt != tc.expectedCnt {
+			t.Errorf("%s: expected %d islands, got %d", tc.name, tc.expectedCnt, cnt)
+		}
+		if cnt := islandCountBFS(game.grid); cnt != tc.expectedCnt {
+			t.Errorf("%s: expected %d islands with BFS, got %d", tc.name, tc.expectedCnt, cnt)
+		}
+		if size := game.grid.largestIslandSize(); size != tc.expectedLargest {
+			t.Errorf("%s: expected the largest island with %d pieces, got %d", tc.name, tc.expectedLargest, size)
+		}
+	}
+}
+
+/*
+islandCountBFS counts the islands with breadth-first search. the reference for the union-find benchmark.
+*/
+func islandCountBFS(g *GridComp) int {
+	visited := map[*Piece]bool{}
+	cnt := 0
+
+	for _, start := range g.lockedPieces {
+		if visited[start] {
+			continue
+		}
+
+		cnt++
+		visited[start] = true
+		queue := []*Piece{start}
+		for 0 < len(queue) {
+			piece := queue[0]
+			queue = queue[1:]
+
+			size := rotateSize(piece.size, piece.currentRotation)
+			for x := piece.pos.x; x < piece.pos.x+size.w; x++ {
+				for y := piece.pos.y; y < piece.pos.y+size.h; y++ {
+					for _, d := range []Pos{{-1, 0}, {1, 0}, {0, -1}, {0, 1}} {
+						neighbor := g.getPiece(Pos{x + d.x, y + d.y})
+						if neighbor != nil && !visited[neighbor] {
+							visited[neighbor] = true
+							queue = append(queue, neighbor)
+						}
+					}
+				}
+			}
+		}
+	}
+
+	return cnt
+}
+
+/*
+prepareIslandBenchmark locks 200 pieces into the grid in a checkerboard-like pattern with many islands.
+*/
+func prepareIslandBenchmark(game *Game) {
+	headIdx := slices.IndexFunc(allPieces, func(p Piece) bool { return p.pieceType == "Head" })
+
+	cnt := 0
+	for y := gridSize.h - 2; 0 <= y && cnt < 200; y-- {
+		for x := 1; x < gridSize.w-1 && cnt < 200; x++ {
+			if (x*7+y*3)%5 != 0 {
+				piece := allPieces[headIdx]
+				piece.pos = Pos{x, y}
+				game.grid.lockPiece(&piece)
+				cnt++
+			}
+		}
+	}
+}
+
+// BenchmarkGridIslandCountUnionFind benchmarks the union-find based island count.
+func BenchmarkGridIslandCountUnionFind(b *testing.B) {
+	game := NewGame()
+	prepareIslandBenchmark(game)
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		game.grid.islandCount()
+	}
+}
+
+// BenchmarkGridIslandCountBFS benchmarks the breadth-first search based island count.
+func BenchmarkGridIslandCountBFS(b *testing.B) {
+	game := NewGame()
+	prepareIslandBenchmark(game)
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		islandCountBFS(game.grid)
+	}
+}
+
+// TestGamePause tests pausing and resuming the game by the pause key.
+func TestGamePause(t *testing.T) {
+	_ = os.Remove(statsFileName)
+	defer os.Remove(statsFileName)
+	game := NewGame()
+	defer game.input.simulateRelease("pause")
+	game.Update()
+
+	game.input.simulatePress("pause")
+	game.Update()
+	game.input.simulateRelease("pause")
+	if !game.paused || game.pauseDialog.getState() != StateBlocking {
+		t.Fatalf("Expected the game to be paused")
+	}
+
+	frameCount, gameTimeSec, pieceY := game.frameCount, game.gameTimeSec, game.activePiece.pos.y
+	for i := 0; i < 5*speedLevels[0].ticksPerDrop; i++ {
+		game.Update()
+	}
+	if game.frameCount != frameCount || game.gameTimeSec != gameTimeSec || game.activePiece.pos.y != pieceY {
+		t.Errorf("Expected the game to stand still while paused")
+	}
+
+	r := game.pauseDialog.getRect()
+	if !game.pauseDialog.contains(Pos{r.pos.x + r.size.w/2, r.pos.y + r.size.h/2}) || game.pauseDialog.contains(Pos{r.pos.x - 1, r.pos.y}) {
+		t.Errorf("Expected the clicks on the pause dialog only to resume")
+	}
+	screen := ebiten.NewImage(screenWidth, screenHeight)
+	game.Draw(screen)
+
+	game.input.simulatePress("pause")
+	game.Update()
+	game.input.simulateRelease("pause")
+	if game.paused || game.pauseDialog.getState() != StateInactive {
+		t.Errorf("Expected the game to be resumed")
+	}
+
+	// no pause over the game over dialog
+	game.Update()
+	if game.frameCount != frameCount+1 {
+		t.Errorf("Expected the frame counter to advance after resuming")
+	}
+	game.endGame()
+	game.input.simulatePress("pause")
+	game.Update()
+	if game.paused {
+		t.Errorf("Expected no pause after the game is over")
+	}
+}
+
+// TestGameMenu tests opening the menu by the menu key and firing its items.
+func TestGameMenu(t *testing.T) {
+	_ = os.Remove(statsFileName)
+	defer os.Remove(statsFileName)
+	_ = os.Remove(highScoreFileName)
+	defer os.Remove(highScoreFileName)
+	game := NewGame()
+	defer game.input.simulateRelease("menu")
+	tapMenuKey := func() {
+		game.input.simulatePress("menu")
+		game.Update()
+		game.input.simulateRelease("menu")
+		game.Update()
+	}
+
+	tapMenuKey()
+	if game.menu.getState() != StateBlocking {
+		t.Fatalf("Expected the menu to be opened")
+	}
+	frameCount := game.frameCount
+	game.Update()
+	if game.frameCount != frameCount {
+		t.Errorf("Expected the time to stop while the menu is open")
+	}
+
+	labels := []string{}
+	for _, item := range game.menu.items {
+		labels = append(labels, item.label)
+	}
+	if !slices.Equal(labels, []string{"menu.resume", "menu.options", "menu.howToPlay", "menu.tutorial", "menu.dailyChallenge", "menu.statistics", "menu.profile", "menu.restart", "menu.quit"}) {
+		t.Errorf("Unexpected menu items %v", labels)
+	}
+	if idx := game.menu.getItemAt(game.menu.getItemRect(1).pos); idx != 1 {
+		t.Errorf("Expected the second item at its rect, got %d", idx)
+	}
+	screen := ebiten.NewImage(screenWidth, screenHeight)
+	game.Draw(screen)
+
+	// resume
+	game.menu.fire(0)
+	if game.menu.getState() != StateInactive {
+		t.Errorf("Expected the menu to be closed by resume")
+	}
+
+	// the menu key closes the menu
+	tapMenuKey()
+	tapMenuKey()
+	if game.menu.getState() != StateInactive {
+		t.Errorf("Expected the menu to be closed by the menu key")
+	}
+
+	// restart from the game over screen
+	game.score = 10
+	game.endGame()
+	game.nameEntry.confirm()
+	tapMenuKey()
+	if game.menu.getState() != StateBlocking {
+		t.Fatalf("Expected the menu to be opened over the game over screen")
+	}
+	game.menu.fire(7)
+	if game.score != 0 || game.gameOver.getState() != StateInactive || game.menu.getState() != StateInactive {
+		t.Errorf("Expected the game to be restarted")
+	}
+
+	// holding the key forfeits without opening the menu
+	game.input.simulatePress("menu")
+	game.input.simulatePress("forfeit")
+	for i := 0; i < forfeitHoldSec*ticksPerSec; i++ {
+		game.Update()
+	}
+	game.input.simulateRelease("forfeit")
+	game.input.simulateRelease("menu")
+	game.Update()
+	if !game.forfeited || game.menu.getState() != StateInactive {
+		t.Errorf("Expected the forfeit without the menu")
+	}
+}
+
+// TestGameForfeit tests forfeiting the game by holding the forfeit key.
+func TestGameForfeit(t *testing.T) {
+	_ = os.Remove(statsFileName)
+	defer os.Remove(statsFileName)
+	_ = os.Remove(highScoreFileName)
+	defer os.Remove(highScoreFileName)
+
+	game := NewGame()
+	game.saveScore("", 70)
+	game.score = 120
+	defer game.input.simulateRelease("forfeit")
+
+	game.input.simulatePress("forfeit")
+	for i := 0; i < forfeitHoldSec*ticksPerSec-1; i++ {
+		game.Update()
+	}
+	if game.gameOver.getState() != StateInactive {
+		t.Fatalf("Expected the game to continue before holding the key for %d sec", forfeitHoldSec)
+	}
+
+	game.Update()
+	if game.gameOver.getState() != StateBlocking {
+		t.Fatalf("Expected state of gameOver component to be StateBlocking(%d), got %d", StateBlocking, game.gameOver.getState())
+	}
+	if !slices.Equal(game.gameOver.text, []string{"Forfeited"}) {
+		t.Errorf("Expected the forfeit dialog, got %v", game.gameOver.text)
+	}
+	if game.score != 0 {
+		t.Errorf("Expected score 0 after forfeit, got %d", game.score)
+	}
+
+	data, err := os.ReadFile(highScoreFileName)
+	if err != nil || !strings.Contains(string(data), `{"forfeit":true,"date":"`) {
+		t.Errorf("Expected a forfeit entry in the high score file, got %q (%v)", data, err)
+	}
+	if topScores := game.loadTopScores(); len(topScores) != 1 || topScores[0].Score != 70 {
+		t.Errorf("Expected the forfeit entry to be ignored in the top scores, got %v", topScores)
+	}
+
+	game.Reset()
+	if game.forfeited {
+		t.Errorf("Expected the forfeit to be cleared by reset")
+	}
+}
+
+// TestGridLockPieceBatch tests that locking a batch of pieces is the same as locking them one by one.
+func TestGridLockPieceBatch(t *testing.T) {
+	gridDesc := []string {
+	// 0   1   2   3
+		"^H  _   _   ^H",  // 0
+		"^T  ^H  _   ^T",  // 1
+		"^L  ^T  ^L  ^L", } // 2
+
+	single := NewGame()
+	fillGrid(single, gridDesc)
+
+	game := NewGame()
+	piecesMat := fillGrid(game, gridDesc)
+
+	// unlock every other piece and lock them back in reversed order
+	var batch []*Piece
+	for i, piece := range slices.Clone(game.grid.lockedPieces) {
+		if i%2 == 0 {
+			batch = append(batch, piece)
+		}
+	}
+	game.grid.unlockPieces(batch)
+	slices.Reverse(batch)
+	game.grid.lockPieceBatch(batch)
+
+	if err := game.grid.validateConsistency(); err != nil {
+		t.Errorf("Grid is inconsistent after the batch lock: %v", err)
+	}
+	if len(game.grid.lockedPieces) != len(single.grid.lockedPieces) {
+		t.Fatalf("Expected %d locked pieces, got %d", len(single.grid.lockedPieces), len(game.grid.lockedPieces))
+	}
+	for i, piece := range game.grid.lockedPieces {
+		expected := single.grid.lockedPieces[i]
+		if piece.pieceType != expected.pieceType || piece.pos != expected.pos {
+			t.Errorf("Expected '%s'@%v at index %d, got '%s'@%v", expected.pieceType, expected.pos, i, piece.pieceType, piece.pos)
+		}
+	}
+	if game.grid.getPiece(piecesMat[1][1].pos) != piecesMat[1][1] {
+		t.Errorf("Expected the grid matrix to refer to the relocked piece")
+	}
+
+	// empty batch
+	game.grid.lockPieceBatch(nil)
+	if len(game.grid.lockedPieces) != len(single.grid.lockedPieces) {
+		t.Errorf("Expected no change by an empty batch")
+	}
+}
+
+/*
+createLockBenchmarkPieces creates 1000 pieces for a grid with 50x20 usable cells, in a shuffled order.
+*/
+func createLockBenchmarkPieces() []*Piece {
+	headIdx := slices.IndexFunc(allPieces, func(p Piece) bool { return p.pieceType == "Head" })
+
+	var pieces []*Piece
+	for y := 0; y < 20; y++ {
+		for x := 1; x <= 50; x++ {
+			piece := allPieces[headIdx]
+			piece.pos = Pos{x, y}
+			pieces = append(pieces, &piece)
+		}
+	}
+	rand.New(rand.NewSource(1)).Shuffle(len(pieces), func(i, j int) { pieces[i], pieces[j] = pieces[j], pieces[i] })
+
+	return pieces
+}
+
+// BenchmarkGridLockPieceOneByOne benchmarks locking 1000 pieces one by one.
+func BenchmarkGridLockPieceOneByOne(b *testing.B) {
+	pieces := createLockBenchmarkPieces()
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		grid := NewGridComp(Size{52, 21}, DrawOrderGrid)
+		b.StartTimer()
+
+		for _, piece := range pieces {
+			grid.lockPiece(piece)
+		}
+	}
+}
+
+// BenchmarkGridLockPieceBatch benchmarks locking 1000 pieces in a batch.
+func BenchmarkGridLockPieceBatch(b *testing.B) {
+	pieces := createLockBenchmarkPieces()
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		grid := NewGridComp(Size{52, 21}, DrawOrderGrid)
+		b.StartTimer()
+
+		grid.lockPieceBatch(pieces)
+	}
+}
+
+/*
+component recording the pause flag of its last update
+*/
+type pauseProbeComp struct {
+	state      ComponentState
+	lastPaused bool
+	priority   int
+	updateLog  *[]*pauseProbeComp // the updated components are appended if set
+}
+
+func (c *pauseProbeComp) activate(isActive bool)               { c.state = StateActive }
+func (c *pauseProbeComp) reset()                               { c.state = StateInactive }
+func (c *pauseProbeComp) update(gamePaused bool, frameCnt int) {
+	c.lastPaused = gamePaused
+	if c.updateLog != nil {
+		*c.updateLog = append(*c.updateLog, c)
+	}
+}
+func (c *pauseProbeComp) draw(screen *ebiten.Image)            {}
+func (c *pauseProbeComp) getDrawOrder() int                    { return DrawOrderBkgd }
+func (c *pauseProbeComp) getPriority() int                     { return c.priority }
+func (c *pauseProbeComp) getState() ComponentState             { return c.state }
+
+// TestComponentMgrPauseComponent tests pausing a single component.
+func TestComponentMgrPauseComponent(t *testing.T) {
+	mgr := NewComponentMgr()
+	paused, running := &pauseProbeComp{}, &pauseProbeComp{}
+	mgr.add(paused)
+	mgr.add(running)
+	paused.activate(true)
+	running.activate(true)
+
+	mgr.pauseComponent(paused)
+	mgr.update(1)
+	if !paused.lastPaused || running.lastPaused {
+		t.Errorf("Expected only the paused component to be paused, got %t and %t", paused.lastPaused, running.lastPaused)
+	}
+	if mgr.isBlocked() {
+		t.Errorf("Expected the paused component not to block the game")
+	}
+
+	mgr.resumeComponent(paused)
+	mgr.update(2)
+	if paused.lastPaused {
+		t.Errorf("Expected the resumed component to run")
+	}
+
+	mgr.pauseComponent(paused)
+	mgr.reset()
+	paused.activate(true)
+	mgr.update(3)
+	if paused.lastPaused {
+		t.Errorf("Expected reset to resume the paused components")
+	}
+}
+
+// TestComponentMgrPriority tests the update order of the components by priority.
+func TestComponentMgrPriority(t *testing.T) {
+	var updateLog []*pauseProbeComp
+	low := &pauseProbeComp{priority: PriorityDefault, updateLog: &updateLog}
+	high := &pauseProbeComp{priority: PriorityInput, updateLog: &updateLog}
+	low2 := &pauseProbeComp{priority: PriorityDefault, updateLog: &updateLog}
+	high2 := &pauseProbeComp{priority: PriorityInput, updateLog: &updateLog}
+
+	mgr := NewComponentMgr()
+	for _, c := range []*pauseProbeComp{low, high, low2, high2} {
+		mgr.add(c)
+		c.activate(true)
+	}
+
+	// higher priority first, insertion order within the same priority
+	mgr.update(1)
+	if expected := []*pauseProbeComp{high, high2, low, low2}; !slices.Equal(updateLog, expected) {
+		t.Errorf("Expected update order %v, got %v", expected, updateLog)
+	}
+
+	// removal keeps the order
+	updateLog = nil
+	mgr.remove(high)
+	mgr.update(2)
+	if expected := []*pauseProbeComp{high2, low, low2}; !slices.Equal(updateLog, expected) {
+		t.Errorf("Expected update order %v after removal, got %v", expected, updateLog)
+	}
+
+	// the active piece is updated before the sidebar
+	game := NewGame()
+	apcIdx := slices.Index(game.compMgr.compList, Component(game.apc))
+	sideBarIdx := slices.Index(game.compMgr.compList, Component(game.sideBar))
+	if sideBarIdx < apcIdx {
+		t.Errorf("Expected the active piece (%d) to be updated before the sidebar (%d)", apcIdx, sideBarIdx)
+	}
+}
+
+// TestPieceColor tests the tint of the pieces.
+func TestPieceColor(t *testing.T) {
+	for _, piece := range allPieces {
+		if piece.color != pieceColorScheme.getPieceColor(piece.pieceType) {
+			t.Errorf("Expected '%s' to have the color of the scheme, got %v", piece.pieceType, piece.color)
+		}
+	}
+
+	if c := (ColorScheme{}).getPieceColor("Head"); c != (color.RGBA{R: 255, G: 255, B: 255, A: 255}) {
+		t.Errorf("Expected white by default, got %v", c)
+	}
+
+	piece := *getPieceByType("Head")
+	piece.color = color.RGBA{R: 255, G: 51, B: 0, A: 255}
+	op := &ebiten.DrawImageOptions{}
+	applyRotationToPiece(op, &piece)
+	if op.ColorScale.R() != 1 || math.Abs(float64(op.ColorScale.G())-0.2) > 1e-6 || op.ColorScale.B() != 0 || op.ColorScale.A() != 1 {
+		t.Errorf("Expected color scale (1, 0.2, 0, 1), got (%v, %v, %v, %v)", op.ColorScale.R(), op.ColorScale.G(), op.ColorScale.B(), op.ColorScale.A())
+	}
+
+	// zero value is not tinted
+	piece.color = color.RGBA{}
+	op = &ebiten.DrawImageOptions{}
+	applyRotationToPiece(op, &piece)
+	if op.ColorScale.R() != 1 || op.ColorScale.G() != 1 || op.ColorScale.B() != 1 || op.ColorScale.A() != 1 {
+		t.Errorf("Expected no tint for the zero color, got (%v, %v, %v, %v)", op.ColorScale.R(), op.ColorScale.G(), op.ColorScale.B(), op.ColorScale.A())
+	}
+}
+
+// TestBodyTriggerEffect tests that the effect of the body is triggered once with the matched pieces.
+func TestBodyTriggerEffect(t *testing.T) {
+	game := NewGame()
+	fellow := allBodies[slices.IndexFunc(allBodies, func(b *Body) bool { return b.name == "Fellow" })]
+
+	origEffect := fellow.triggerEffect
+	defer func() { fellow.triggerEffect = origEffect }()
+
+	var calls [][]*Piece
+	fellow.triggerEffect = func(g *Game, matchedPieces []*Piece) {
+		if g != game {
+			t.Errorf("Expected the effect to be called with the game")
+		}
+		calls = append(calls, matchedPieces)
+	}
+
+	gridDesc := []string {
+	// 0   1
+		"^H  _ ",  // 0
+		"^T  _ ",  // 1
+		"^L  ^H", } // 2
+	piecesMat := fillGrid(game, gridDesc)
+
+	if !game.joinPieces([]*Piece{ piecesMat[2][0] }) {
+		t.Fatalf("Expected the pieces to join")
+	}
+	if len(calls) != 0 {
+		t.Errorf("Expected the effect to be triggered only after the rock effect")
+	}
+
+	for i := 1; i < 60*10; i++ {
+		game.rockEffect.update(false, i)
+	}
+
+	if len(calls) != 1 {
+		t.Fatalf("Expected the effect to be triggered once, got %d", len(calls))
+	}
+	expected := []*Piece{piecesMat[0][0], piecesMat[1][0], piecesMat[2][0]}
+	for _, piece := range expected {
+		if !slices.Contains(calls[0], piece) {
+			t.Errorf("Expected the matched pieces %v, got %v", expected, calls[0])
+		}
+	}
+	if len(calls[0]) != len(expected) {
+		t.Errorf("Expected %d matched pieces, got %d", len(expected), len(calls[0]))
+	}
+}
+
+// TestSideBarDrawNextPieceRotations tests drawing the rotation preview for all piece types.
+func TestSideBarDrawNextPieceRotations(t *testing.T) {
+	game := NewGame()
+	game.config.showRotationPreview = true
+	game.Update()
+	if !game.sideBar.showRotationPreview {
+		t.Errorf("Expected the rotation preview to be enabled by the config")
+	}
+
+	screen := ebiten.NewImage(screenWidth, screenHeight)
+	for i := range allPieces {
+		game.sideBar.nextPieces = []*Piece{&allPieces[i]}
+		game.sideBar.draw(screen)
+	}
+}
+
+// TestSideBarActivePieceRotations tests the rotations of the active piece shown in the sidebar.
+func TestSideBarActivePieceRotations(t *testing.T) {
+	game := NewGame()
+	game.Update()
+	if game.sideBar.activePiece != game.activePiece {
+		t.Errorf("Expected the sidebar to follow the active piece")
+	}
+
+	screen := ebiten.NewImage(screenWidth, screenHeight)
+	for i := range allPieces {
+		piece := allPieces[i].clone()
+		for _, rotation := range []int{0, 90, 180, 270, -90} {
+			piece.currentRotation = rotation
+			game.sideBar.setActivePiece(piece)
+			game.sideBar.draw(screen)
+		}
+		if piece.currentRotation != -90 || piece.pos != allPieces[i].pos {
+			t.Errorf("Expected the preview not to change the active piece '%s'", piece.pieceType)
+		}
+	}
+
+	// no preview between the pieces
+	game.activePiece = nil
+	game.Update()
+	if game.sideBar.activePiece != nil {
+		t.Errorf("Expected no active piece in the sidebar")
+	}
+	game.sideBar.draw(screen)
+}
+
+// TestSideBarSpeedProgress tests the progress towards the next speed level shown in the sidebar.
+func TestSideBarSpeedProgress(t *testing.T) {
+	game := NewGame()
+	for i := 0; i < 15*ticksPerSec; i++ {
+		game.Update()
+	}
+
+	progress, isMax := game.sideBar.getSpeedProgress()
+	if expected := game.gameTimeSec / float32(speedLevels[0].nextLevelTimeSec); isMax || progress != expected {
+		t.Errorf("Expected the progress %f, got %f", expected, progress)
+	}
+
+	game.sideBar.gameTimeSec = float32(speedLevels[0].nextLevelTimeSec) * 2
+	if progress, _ := game.sideBar.getSpeedProgress(); progress != 1 {
+		t.Errorf("Expected the progress clamped to 1, got %f", progress)
+	}
+
+	screen := ebiten.NewImage(screenWidth, screenHeight)
+	game.sideBar.draw(screen)
+
+	game.sideBar.speedLevel = len(speedLevels)
+	if progress, isMax := game.sideBar.getSpeedProgress(); progress != 1 || !isMax {
+		t.Errorf("Expected the full progress at the maximum level, got %f", progress)
+	}
+	game.sideBar.draw(screen)
+}
+
+// TestSideBarSpeedCountdown tests the countdown to the next speed level shown in the sidebar.
+func TestSideBarSpeedCountdown(t *testing.T) {
+	game := NewGame()
+	sideBar := game.sideBar
+	nextLevelSec := float32(speedLevels[0].nextLevelTimeSec)
+
+	sideBar.setValues(game.nextPieces[:], 0, 1, nextLevelSec-10.5, nil, nil)
+	if sec, isMax := sideBar.getSecondsToNextLevel(); sec != 11 || isMax {
+		t.Errorf("Expected 11 seconds to the next level, got %d", sec)
+	}
+	sideBar.setValues(game.nextPieces[:], 0, 1, nextLevelSec+1, nil, nil)
+	if sec, _ := sideBar.getSecondsToNextLevel(); sec != 0 {
+		t.Errorf("Expected no negative countdown, got %d", sec)
+	}
+
+	// the flashing countdown is drawn in both colors
+	screen := ebiten.NewImage(screenWidth, screenHeight)
+	sideBar.setValues(game.nextPieces[:], 0, 1, nextLevelSec-2, nil, nil)
+	for i := 0; i < 2*speedCountdownFlashFrameCnt; i++ {
+		sideBar.update(false, i)
+		sideBar.draw(screen)
+	}
+
+	sideBar.setValues(game.nextPieces[:], 0, len(speedLevels), 1000, nil, nil)
+	if _, isMax := sideBar.getSecondsToNextLevel(); !isMax {
+		t.Errorf("Expected the maximum speed level")
+	}
+	sideBar.draw(screen)
+}
+
+// TestGridApplyTranslation tests shifting all locked pieces of Grid.
+func TestGridApplyTranslation(t *testing.T) {
+	game := NewGame()
+	gridDesc := []string {
+	// 0   1   2
+		"^H  _   _ ",  // 0
+		"<T  _   vL", } // 1
+	piecesMat := fillGrid(game, gridDesc)
+	head, torso, leg := piecesMat[0][0], piecesMat[1][0], piecesMat[1][2]
+	headPos, torsoPos, legPos := head.pos, torso.pos, leg.pos
+
+	if err := game.grid.applyTranslation(2, 0); err != nil {
+		t.Errorf("Expected no error, got %v", err)
+	}
+	if head.pos != addPos(headPos, Pos{2, 0}) || torso.pos != addPos(torsoPos, Pos{2, 0}) || leg.pos != addPos(legPos, Pos{2, 0}) {
+		t.Errorf("Expected the pieces shifted by 2, got %v %v %v", head.pos, torso.pos, leg.pos)
+	}
+	if torso.currentRotation != 90 || leg.currentRotation != 180 {
+		t.Errorf("Expected the rotations to be kept")
+	}
+	if err := game.grid.validateConsistency(); err != nil {
+		t.Errorf("Grid is inconsistent: %v", err)
+	}
+
+	// shifting to the left border is still within the grid
+	if err := game.grid.applyTranslation(-2, 0); err != nil {
+		t.Errorf("Expected no error when shifting back, got %v", err)
+	}
+	if head.pos != headPos || game.grid.getPiece(Pos{0, headPos.y}) != nil {
+		t.Errorf("Expected the head at %v, got %v", headPos, head.pos)
+	}
+
+	// pieces beyond the left border are dropped
+	if err := game.grid.applyTranslation(-1, 0); err == nil {
+		t.Errorf("Expected an error for the pieces leaving the grid")
+	}
+	if len(game.grid.lockedPieces) != 1 || game.grid.lockedPieces[0] != leg || leg.pos != addPos(legPos, Pos{-1, 0}) {
+		t.Errorf("Expected only the leg to remain at %v, got %v", addPos(legPos, Pos{-1, 0}), game.grid.lockedPieces)
+	}
+	if err := game.grid.validateConsistency(); err != nil {
+		t.Errorf("Grid is inconsistent: %v", err)
+	}
+
+	// the right and bottom borders
+	if err := game.grid.applyTranslation(gridSize.w-2-leg.pos.x, 0); err != nil {
+		t.Errorf("Expected no error at the right border, got %v", err)
+	}
+	if err := game.grid.applyTranslation(0, 1); err == nil || len(game.grid.lockedPieces) != 0 {
+		t.Errorf("Expected the leg to be dropped below the bottom row")
+	}
+}
+
+// TestPieceMetadata tests the metadata of Piece.
+func TestPieceMetadata(t *testing.T) {
+	piece := getPieceByType("Head").clone()
+	if piece.metadata != nil {
+		t.Errorf("Expected no metadata allocated for a new piece")
+	}
+	if _, ok := piece.getMeta("frozen"); ok {
+		t.Errorf("Expected no value for an unset key")
+	}
+
+	piece.setMeta("frozen", true)
+	piece.setMeta("fire", 3)
+	if value, ok := piece.getMeta("frozen"); !ok || value != true {
+		t.Errorf("Expected frozen=true, got %v (%t)", value, ok)
+	}
+
+	piece.clearMeta("frozen")
+	if _, ok := piece.getMeta("frozen"); ok {
+		t.Errorf("Expected the cleared key to be removed")
+	}
+	if value, ok := piece.getMeta("fire"); !ok || value != 3 {
+		t.Errorf("Expected the other keys to be kept, got %v (%t)", value, ok)
+	}
+
+	clone := piece.clone()
+	clone.setMeta("fire", 5)
+	clone.setMeta("invisible", true)
+	if value, _ := piece.getMeta("fire"); value != 3 {
+		t.Errorf("Expected the original metadata to be unchanged by the clone, got %v", value)
+	}
+	if _, ok := piece.getMeta("invisible"); ok {
+		t.Errorf("Expected the keys added to the clone to be missing in the original")
+	}
+	if value, _ := clone.getMeta("fire"); value != 5 {
+		t.Errorf("Expected the clone to have its own value, got %v", value)
+	}
+
+	// the nested maps and slices are copied, the pointers are shared
+	other := getPieceByType("Leg").clone()
+	piece.setMeta("hits", []int{1, 2})
+	piece.setMeta("links", map[string]interface{}{"next": other, "path": []Pos{{1, 2}}})
+	clone = piece.clone()
+	clone.metadata["hits"].([]int)[0] = 9
+	cloneLinks := clone.metadata["links"].(map[string]interface{})
+	cloneLinks["path"].([]Pos)[0] = Pos{3, 4}
+	links := piece.metadata["links"].(map[string]interface{})
+	if piece.metadata["hits"].([]int)[0] != 1 || links["path"].([]Pos)[0] != (Pos{1, 2}) {
+		t.Errorf("Expected the nested values of the original to be unchanged by the clone")
+	}
+	if cloneLinks["next"] != other {
+		t.Errorf("Expected the pointed piece to be shared")
+	}
+}
+
+// TestGridSortLockedPieces tests restoring the order of the locked list.
+func TestGridSortLockedPieces(t *testing.T) {
+	game := NewGame()
+	gridDesc := []string {
+	// 0   1   2
+		"^H  _   ^H",  // 0
+		"^T  ^L  ^T",  // 1
+		"^L  ^H  ^L", } // 2
+	fillGrid(game, gridDesc)
+	expected := slices.Clone(game.grid.lockedPieces)
+
+	if !game.grid.isSorted() {
+		t.Fatalf("Expected the locked list to be sorted")
+	}
+
+	rand.New(rand.NewSource(1)).Shuffle(len(game.grid.lockedPieces), func(i, j int) {
+		game.grid.lockedPieces[i], game.grid.lockedPieces[j] = game.grid.lockedPieces[j], game.grid.lockedPieces[i]
+	})
+	if game.grid.isSorted() {
+		t.Fatalf("Expected the scrambled locked list not to be sorted")
+	}
+
+	game.grid.sortLockedPieces()
+	if !game.grid.isSorted() || !slices.Equal(game.grid.lockedPieces, expected) {
+		t.Errorf("Expected the locked list %v, got %v", expected, game.grid.lockedPieces)
+	}
+	if err := game.grid.validateConsistency(); err != nil {
+		t.Errorf("Grid is inconsistent: %v", err)
+	}
+}
+
+// TestGameSpeedHook tests that the speed hooks are called on automatic and manual speed increases.
+func TestGameSpeedHook(t *testing.T) {
+	game := NewGame()
+	var levels []int
+	game.registerSpeedHook(func(level int) { levels = append(levels, level) })
+
+	// automatic increase
+	game.gameTimeSec = float32(speedLevels[0].nextLevelTimeSec) + 1
+	game.dropFrameCount = speedLevels[0].ticksPerDrop
+	game.checkTimeToMoveDown()
+	if !slices.Equal(levels, []int{1}) {
+		t.Errorf("Expected the hook called with level 1, got %v", levels)
+	}
+
+	// manual increase
+	defer game.input.simulateRelease("speedup")
+	game.input.simulatePress("speedup")
+	game.input.handleKeys(game.frameCount)
+	game.speedup()
+	if !slices.Equal(levels, []int{1, 2}) {
+		t.Errorf("Expected the hook called with level 2, got %v", levels)
+	}
+
+	// built-in hook
+	if game.background.color == backgroundColor {
+		t.Errorf("Expected the background color to change with the speed level")
+	}
+	game.Reset()
+	if game.background.color != backgroundColor {
+		t.Errorf("Expected the background color to be reset")
+	}
+}
+
+// TestBodyOptionalPiece tests matching a body with and without its optional piece.
+func TestBodyOptionalPiece(t *testing.T) {
+	game := NewGame()
+	body := &Body{
+		name:  "Necked",
+		score: 900,
+		bodyPieces: []BodyPiece{
+			{pos: Pos{0, 0}, pieceType: "Head"},
+			{pos: Pos{1, 0}, pieceType: "Torso", optional: true},
+			{pos: Pos{0, 1}, pieceType: "Leg"},
+		},
+	}
+	body.init()
+
+	testCases := []struct {
+		name          string
+		gridDesc      []string
+		expectedCnt   int
+		expectedScore int
+	}{
+		{"with the optional piece", []string{
+			"^H  ^T",
+			"^L  _ ",
+		}, 3, 900},
+		{"without the optional piece", []string{
+			"^H  _ ",
+			"^L  _ ",
+		}, 2, 600},
+		{"mismatching optional piece", []string{
+			"^H  <T",
+			"^L  _ ",
+		}, 2, 600},
+		{"missing required piece", []string{
+			"^H  ^T",
+			"_   ^L",
+		}, 0, 0},
+	}
+
+	for _, tc := range testCases {
+		game.grid.reset()
+		piecesMat := fillGrid(game, tc.gridDesc)
+
+		match := body.matchAtLockedPiece(game.grid, piecesMat[0][0])
+		if tc.expectedCnt == 0 {
+			if match != nil {
+				t.Errorf("%s: expected no match, got %v", tc.name, match.pieces)
+			}
+			continue
+		}
+
+		if match == nil {
+			t.Errorf("%s: expected a match", tc.name)
+			continue
+		}
+		if len(match.pieces) != tc.expectedCnt || match.score != tc.expectedScore {
+			t.Errorf("%s: expected %d pieces and score %d, got %d pieces and score %d", tc.name, tc.expectedCnt, tc.expectedScore, len(match.pieces), match.score)
+		}
+	}
+}
+
+// TestGridCountAdjacentPairs tests counting the adjacent locked piece pairs.
+func TestGridCountAdjacentPairs(t *testing.T) {
+	testCases := []struct {
+		name     string
+		gridDesc []string
+		expected int
+	}{
+		{"L shape", []string{
+			"^H  _ ",
+			"^T  ^L",
+		}, 2},
+		{"row", []string{
+			"^H  ^T  ^L",
+		}, 2},
+		{"scattered", []string{
+			"^H  _   _ ",
+			"_   ^T  _ ",
+			"_   _   ^L",
+		}, 0},
+		{"square", []string{
+			"^H  ^T",
+			"^T  ^L",
+		}, 4},
+	}
+
+	for _, tc := range testCases {
+		game := NewGame()
+		piecesMat := fillGrid(game, tc.gridDesc)
+
+		if cnt := game.grid.countAdjacentPairs(); cnt != tc.expected {
+			t.Errorf("%s: expected %d adjacent pairs, got %d", tc.name, tc.expected, cnt)
+		}
+
+		// the count follows the unlocking
+		piece := piecesMat[0][0]
+		expected := tc.expected - len(game.grid.getAdjacentPieces(piece))
+		game.grid.unlockPiece(piece)
+		if cnt := game.grid.countAdjacentPairs(); cnt != expected {
+			t.Errorf("%s: expected %d adjacent pairs after unlocking, got %d", tc.name, expected, cnt)
+		}
+
+		game.grid.reset()
+		if cnt := game.grid.countAdjacentPairs(); cnt != 0 {
+			t.Errorf("%s: expected no adjacent pairs after reset, got %d", tc.name, cnt)
+		}
+	}
+}
+
+// TestGridPiecesByType tests the index of the locked pieces by piece type.
+func TestGridPiecesByType(t *testing.T) {
+	game := NewGame()
+	piecesMat := fillGrid(game, []string{
+		"^H  _   ^T",
+		"^T  ^H  _ ",
+		"^L  ^H  <L",
+	})
+
+	checkIndex := func(step string) {
+		for _, pieceType := range []string{"Head", "Torso", "Leg", "Bomb"} {
+			var expected []*Piece
+			for _, piece := range game.grid.lockedPieces {
+				if piece.pieceType == pieceType {
+					expected = append(expected, piece)
+				}
+			}
+
+			if pieces := game.grid.piecesByType(pieceType); !slices.Equal(pieces, expected) {
+				t.Errorf("%s: expected %s pieces %v, got %v", step, pieceType, expected, pieces)
+			}
+			if cnt := game.grid.countPiecesByType(pieceType); cnt != len(expected) {
+				t.Errorf("%s: expected %d %s pieces, got %d", step, len(expected), pieceType, cnt)
+			}
+		}
+	}
+
+	checkIndex("fill")
+	if cnt := game.grid.countPiecesByType("Head"); cnt != 3 {
+		t.Errorf("Expected 3 Head pieces, got %d", cnt)
+	}
+
+	game.grid.unlockPiece(piecesMat[1][1])
+	checkIndex("unlock")
+
+	game.grid.transpose()
+	checkIndex("transpose")
+
+	game.grid.compactGrid()
+	checkIndex("compact")
+
+	game.grid.reset()
+	checkIndex("reset")
+}
+
+// TestBodyMatchMissingPieceType tests that the body is not matched when the grid lacks a required piece type.
+func TestBodyMatchMissingPieceType(t *testing.T) {
+	game := NewGame()
+	body := &Body{
+		name:  "HeadTorsoLeg",
+		score: 3,
+		bodyPieces: []BodyPiece{
+			{pos: Pos{0, 0}, pieceType: "Head"},
+			{pos: Pos{0, 1}, pieceType: "Torso"},
+			{pos: Pos{0, 2}, pieceType: "Leg"},
+		},
+	}
+	body.init()
+
+	piecesMat := fillGrid(game, []string{
+		"^H",
+		"^T",
+	})
+	if match := body.matchAtLockedPiece(game.grid, piecesMat[0][0]); match != nil {
+		t.Errorf("Expected no match without Leg pieces, got %v", match)
+	}
+
+	game.grid.reset()
+	piecesMat = fillGrid(game, []string{
+		"^H",
+		"^T",
+		"^L",
+	})
+	if match := body.matchAtLockedPiece(game.grid, piecesMat[0][0]); match == nil || len(match.pieces) != 3 {
+		t.Errorf("Expected a match of 3 pieces, got %v", match)
+	}
+
+	if hits, misses := body.matchStats(); hits != 1 || misses != 1 {
+		t.Errorf("Expected 1 hit and 1 miss, got %d and %d", hits, misses)
+	}
+}
+
+// TestGameMaxCombo tests the high-water mark of the join chains.
+func TestGameMaxCombo(t *testing.T) {
+	game := NewGame()
+
+	// Fellow joins first, then the middle Asshead, then the outer Asshead
+	gridDesc := []string {
+		"^H",  // 0
+		"^H",  // 1
+		"^L",  // 2
+		"^L",  // 3
+		"^H",  // 4
+		"^T",  // 5
+		"^L", } // 6
+	piecesMat := fillGrid(game, gridDesc)
+
+	if !game.joinPieces([]*Piece{ piecesMat[5][0] }) {
+		t.Fatalf("Expected the pieces to join")
+	}
+	for i := 1; i < 60*10; i++ {
+		game.rockEffect.update(false, i)
+	}
+
+	if len(game.grid.lockedPieces) != 0 {
+		t.Errorf("Expected all pieces joined, got %v", game.grid.lockedPieces)
+	}
+	if game.maxCombo != 3 {
+		t.Errorf("Expected max combo 3, got %d", game.maxCombo)
+	}
+	if game.chainDepth != 0 {
+		t.Errorf("Expected the chain to be over, got depth %d", game.chainDepth)
+	}
+
+	// a shorter chain does not change the max combo
+	gridDesc = []string {
+		"^H",  // 0
+		"^L", } // 1
+	piecesMat = fillGrid(game, gridDesc)
+
+	if !game.joinPieces([]*Piece{ piecesMat[1][0] }) {
+		t.Fatalf("Expected the pieces to join")
+	}
+	for i := 1; i < 60*10; i++ {
+		game.rockEffect.update(false, i)
+	}
+
+	if game.maxCombo != 3 {
+		t.Errorf("Expected max combo to remain 3, got %d", game.maxCombo)
+	}
+
+	game.Reset()
+	if game.maxCombo != 0 {
+		t.Errorf("Expected max combo to be reset, got %d", game.maxCombo)
+	}
+}
+
+// TestGameComboMultiplier tests the score multiplier of the consecutive body completions.
+func TestGameComboMultiplier(t *testing.T) {
+	game := NewGame()
+
+	// Fellow joins first, then the middle Asshead, then the outer Asshead
+	piecesMat := fillGrid(game, []string {
+		"^H",
+		"^H",
+		"^L",
+		"^L",
+		"^H",
+		"^T",
+		"^L", })
+
+	if !game.joinPieces([]*Piece{ piecesMat[5][0] }) {
+		t.Fatalf("Expected the pieces to join")
+	}
+	for i := 1; i < 60*10; i++ {
+		game.rockEffect.update(false, i)
+	}
+
+	if len(game.completionLog) != 3 || game.comboCount != 3 {
+		t.Fatalf("Expected 3 consecutive completions, got %v and combo %d", game.completionLog, game.comboCount)
+	}
+	baseScore, expectedScore := 0, 0
+	for i, entry := range game.completionLog {
+		body := allBodies[slices.IndexFunc(allBodies, func(b *Body) bool { return b.name == entry.bodyName })]
+		baseScore += body.getScore()
+		expectedScore += int(math.Round(float64(body.getScore()) * (1 + comboMultiplierStep*float64(i))))
+	}
+	if game.score != expectedScore || game.score <= baseScore {
+		t.Errorf("Expected score %d higher than the base score %d, got %d", expectedScore, baseScore, game.score)
+	}
+	if game.comboLabel.getState() == StateInactive || game.comboLabel.text != "COMBO x3" {
+		t.Errorf("Expected the combo label to show the combo, got '%s'", game.comboLabel.text)
+	}
+
+	// landing without completion resets the combo
+	piece := getPieceByType("Torso").clone()
+	piece.pos = Pos{5, game.grid.size.h - 2}
+	game.activePiece = piece
+	game.handleActivePieceLanded()
+	if game.comboCount != 0 {
+		t.Errorf("Expected the combo to be reset, got %d", game.comboCount)
+	}
+
+	screen := ebiten.NewImage(screenWidth, screenHeight)
+	game.comboLabel.draw(screen)
+}
+
+// TestGameScorePopup tests the score popups shown for the matched bodies.
+func TestGameScorePopup(t *testing.T) {
+	game := NewGame()
+
+	head := getPieceByType("Head").clone()
+	head.pos = Pos{2, 10}
+	torso := getPieceByType("Torso").clone()
+	torso.pos = Pos{2, 11}
+	leg := getPieceByType("Leg").clone()
+	leg.pos = Pos{6, 4}
+	matches := []BodyMatch{{body: allBodies[0], pieces: []*Piece{head, torso}, score: 10}, {body: allBodies[0], pieces: []*Piece{leg}, score: 20}}
+	game.scoreBodies(matches, 1)
+
+	if len(game.scorePopups) != 2 {
+		t.Fatalf("Expected 2 score popups, got %d", len(game.scorePopups))
+	}
+	first, second := game.scorePopups[0], game.scorePopups[1]
+	if first.text != "+10" || first.pos != (Pos{int(2.5 * float64(scale)), 11 * scale}) {
+		t.Errorf("Expected '+10' centered over the first body, got '%s' at %v", first.text, first.pos)
+	}
+	if second.text != "+20" || second.pos != (Pos{int(6.5 * float64(scale)), int(4.5 * float64(scale))}) {
+		t.Errorf("Expected '+20' centered over the second body, got '%s' at %v", second.text, second.pos)
+	}
+	if first.drawOrder == second.drawOrder || first.getState() == StateInactive || second.getState() == StateInactive {
+		t.Errorf("Expected both popups shown with different draw orders")
+	}
+
+	screen := ebiten.NewImage(screenWidth, screenHeight)
+	game.compMgr.draw(screen)
+
+	for i := 0; i <= scorePopupLifeTimeFrameCnt; i++ {
+		game.compMgr.update(i)
+	}
+	game.removeScorePopups()
+	if len(game.scorePopups) != 0 || slices.Contains(game.compMgr.compList, Component(first)) {
+		t.Errorf("Expected the finished popups to be removed")
+	}
+}
+
+// TestGameBodyMatchEffect tests the pieces of the matched body flying off from the centroid.
+func TestGameBodyMatchEffect(t *testing.T) {
+	game := NewGame()
+
+	head := getPieceByType("Head").clone()
+	head.pos = Pos{4, 9}
+	torso := getPieceByType("Torso").clone()
+	torso.pos = Pos{4, 10}
+	leg := getPieceByType("Leg").clone()
+	leg.pos = Pos{4, 11}
+	pieces := []*Piece{head, torso, leg}
+	game.scoreBodies([]BodyMatch{{body: allBodies[0], pieces: pieces, score: 10}}, 1)
+
+	effect := game.bodyMatchEffects[0]
+	if effect.getState() == StateInactive || !slices.Equal(effect.target, pieces) {
+		t.Fatalf("Expected the fly-off of the matched pieces")
+	}
+	if effect.centroid != (PosF{4.5 * float64(scale), 10.5 * float64(scale)}) {
+		t.Errorf("Expected the centroid at the center of the torso, got %v", effect.centroid)
+	}
+	if slices.Contains(game.grid.lockedPieces, head) {
+		t.Errorf("Expected the flying pieces out of the grid")
+	}
+
+	for i := 0; i < bodyMatchEffectFrameCnt/2; i++ {
+		effect.update(false, i)
+	}
+	half := bodyMatchEffectFlyDist / 2
+	if effect.getOffset(head) != (PosF{0, -half}) || effect.getOffset(torso) != (PosF{}) || effect.getOffset(leg) != (PosF{0, half}) {
+		t.Errorf("Expected the pieces flying outward, got %v %v %v", effect.getOffset(head), effect.getOffset(torso), effect.getOffset(leg))
+	}
+
+	screen := ebiten.NewImage(screenWidth, screenHeight)
+	effect.draw(screen)
+
+	// the second body uses an other draw order
+	game.startBodyMatchEffect([]*Piece{torso})
+	if second := game.bodyMatchEffects[1]; second.getState() == StateInactive || second.drawOrder == effect.drawOrder {
+		t.Errorf("Expected the second fly-off with an other draw order")
+	}
+
+	for i := bodyMatchEffectFrameCnt / 2; i <= bodyMatchEffectFrameCnt; i++ {
+		effect.update(false, i)
+	}
+	if effect.getState() != StateInactive || effect.target != nil {
+		t.Errorf("Expected the effect to end after %d frames", bodyMatchEffectFrameCnt)
+	}
+}
+
+// TestGameFlashEffect tests the flash over the pieces of the completed bodies.
+func TestGameFlashEffect(t *testing.T) {
+	game := NewGame()
+
+	torso := getPieceByType("Torso").clone()
+	torso.pos = Pos{3, 5}
+	game.scoreBodies([]BodyMatch{{body: allBodies[0], pieces: []*Piece{torso}, score: 10}}, 1)
+
+	flash := game.flashEffect
+	if flash.getState() == StateInactive || !slices.Equal(flash.regions, []Rect{{Pos{3 * scale, 5 * scale}, Size{scale, scale}}}) {
+		t.Fatalf("Expected the flash over the removed piece, got %v", flash.regions)
+	}
+	if DrawOrderFlashEffect <= DrawOrderGrid || DrawOrderActivePiece <= DrawOrderFlashEffect {
+		t.Errorf("Expected the flash drawn between the grid and the active piece")
+	}
+
+	screen := ebiten.NewImage(screenWidth, screenHeight)
+	flash.draw(screen)
+
+	for i := 0; i < flashEffectHoldFrameCnt; i++ {
+		flash.update(false, i)
+	}
+	if flash.getAlpha() != 1 {
+		t.Errorf("Expected full alpha while holding, got %f", flash.getAlpha())
+	}
+	flash.update(false, 0)
+	if alpha := flash.getAlpha(); alpha <= 0 || 1 <= alpha {
+		t.Errorf("Expected the flash fading, got alpha %f", alpha)
+	}
+	for i := 0; i < flashEffectFadeFrameCnt; i++ {
+		flash.update(false, i)
+	}
+	if flash.getState() != StateInactive {
+		t.Errorf("Expected the flash to be over")
+	}
+}
+
+// TestGameBombParticles tests the particle burst of a landed bomb.
+func TestGameBombParticles(t *testing.T) {
+	game := NewGame()
+
+	bomb := getPieceByType("Bomb").clone()
+	bomb.pos = Pos{4, game.grid.size.h - 2}
+	game.activePiece = bomb
+	game.handleActivePieceLanded()
+
+	particles := game.bombParticles
+	center := PosF{float64(4*scale + bomb.size.w*scale/2), float64((game.grid.size.h-2)*scale + bomb.size.h*scale/2)}
+	if particles.getState() == StateInactive || len(particles.particles) != bombParticleCnt {
+		t.Fatalf("Expected the burst of %d particles", bombParticleCnt)
+	}
+	for _, p := range particles.particles {
+		if p.pos != center || p.lifetimeFrameCnt <= 0 {
+			t.Fatalf("Expected the live particles at the bomb center %v, got %v", center, p)
+		}
+	}
+
+	screen := ebiten.NewImage(screenWidth, screenHeight)
+	particles.draw(screen)
+
+	for i := 0; i < particles.lifetimeFrameCnt; i++ {
+		particles.update(false, i)
+	}
+	for _, p := range particles.particles {
+		if dist := math.Hypot(p.pos.x-center.x, p.pos.y-center.y); bombParticleRange*float64(game.bombBlastRadius)+1e-6 < dist {
+			t.Errorf("Expected the particle within the blast radius, got distance %f", dist)
+		}
+	}
+	if particles.getState() != StateInactive {
+		t.Errorf("Expected the burst to be over")
+	}
+}
+
+// TestGameScreenShake tests the screen shake of the bomb and the speed level increase.
+func TestGameScreenShake(t *testing.T) {
+	game := NewGame()
+	shake := game.screenShake
+
+	game.increaseSpeedLevel()
+	if shake.getState() == StateInactive || shake.maxDisplacement != levelUpShakePixels {
+		t.Errorf("Expected the level up shake")
+	}
+
+	bomb := getPieceByType("Bomb").clone()
+	bomb.pos = Pos{4, game.grid.size.h - 2}
+	game.activePiece = bomb
+	game.handleActivePieceLanded()
+	if shake.remainingFrameCnt != bombShakeFrameCnt || shake.maxDisplacement != bombShakePixels {
+		t.Fatalf("Expected the bomb shake, got %d frames of %d pixels", shake.remainingFrameCnt, shake.maxDisplacement)
+	}
+
+	// the displacement dampens linearly
+	screen := ebiten.NewImage(screenWidth, screenHeight)
+	for i := 0; i < bombShakeFrameCnt; i++ {
+		maxDisp := bombShakePixels * (bombShakeFrameCnt - i) / bombShakeFrameCnt
+		shake.update(false, i)
+		if maxDisp < abs(shake.offset.x) || maxDisp < abs(shake.offset.y) {
+			t.Errorf("Expected the offset within %d at frame %d, got %v", maxDisp, i, shake.offset)
+		}
+		game.Draw(screen)
+	}
+	shake.update(false, bombShakeFrameCnt)
+	if shake.getState() != StateInactive || shake.offset != (Pos{}) {
+		t.Errorf("Expected the shake to be over, got offset %v", shake.offset)
+	}
+}
+
+// TestSFXPlayer tests the player pool of the sound effects.
+func TestSFXPlayer(t *testing.T) {
+	sfx := NewSFXPlayer(2)
+	for i := 0; i < 3; i++ {
+		sfx.PlaySFX(hitSoundFile)
+	}
+	if pool := sfx.pools[hitSoundFile]; len(pool) != 2 {
+		t.Errorf("Expected a pool of 2 players, got %d", len(pool))
+	}
+
+	// the pool is created once per sound
+	pool := sfx.pools[hitSoundFile]
+	sfx.PlaySFX(hitSoundFile)
+	if !slices.Equal(pool, sfx.pools[hitSoundFile]) {
+		t.Errorf("Expected the players to be reused")
+	}
+
+	// missing file is reported, not played
+	sfx.PlaySFX("assets/audio/missing.wav")
+	if _, ok := sfx.pools["assets/audio/missing.wav"]; ok {
+		t.Errorf("Expected no pool for the missing file")
+	}
+
+	for event, assetFile := range sfxAssetFiles {
+		if _, ok := sfxPlayer.pools[assetFile]; !ok {
+			t.Errorf("Expected the sound of '%s' to be preloaded", event)
+		}
+	}
+}
+
+// TestAudioVolume tests the volume control and the mute of Audio.
+func TestAudioVolume(t *testing.T) {
+	a := NewAudio([]string{hitSoundFile}, false)
+
+	for _, tc := range []struct {
+		volume   float64
+		expected float64
+	}{
+		{1.5, 1}, {-1, 0}, {0.4, 0.4},
+	} {
+		a.SetVolume(tc.volume)
+		if a.getPlayer().Volume() != tc.expected {
+			t.Errorf("Expected volume %f for %f, got %f", tc.expected, tc.volume, a.getPlayer().Volume())
+		}
+	}
+
+	a.Mute()
+	if !a.IsMuted() || a.getPlayer().Volume() != 0 {
+		t.Errorf("Expected the muted player to be silent")
+	}
+	a.SetVolume(0.6) // applied when unmuted
+	if a.getPlayer().Volume() != 0 {
+		t.Errorf("Expected the muted player to stay silent, got %f", a.getPlayer().Volume())
+	}
+	a.Unmute()
+	if a.IsMuted() || a.getPlayer().Volume() != 0.6 {
+		t.Errorf("Expected the volume 0.6 after unmute, got %f", a.getPlayer().Volume())
+	}
+}
+
+// TestAudioPlaylist tests cycling through the tracks of the playlist.
+func TestAudioPlaylist(t *testing.T) {
+	tracks := []string{"assets/audio/theme.mp3", typewriterSoundFile, hitSoundFile}
+	a := NewAudio(tracks, false)
+	a.SetVolume(0.5)
+	a.Mute()
+
+	for i := 1; i <= 4; i++ {
+		a.NextTrack()
+		if expected := tracks[i%len(tracks)]; a.themeMusicAssetFile != expected {
+			t.Errorf("Expected track '%s' after %d skips, got '%s'", expected, i, a.themeMusicAssetFile)
+		}
+	}
+	if a.getPlayer().Volume() != 0 {
+		t.Errorf("Expected the next track to stay muted")
+	}
+	a.Unmute()
+	if a.getPlayer().Volume() != 0.5 {
+		t.Errorf("Expected the volume 0.5 of the next track, got %f", a.getPlayer().Volume())
+	}
+
+	// the track being over advances the playlist
+	a.Pause()
+	a.playing = true
+	a.update()
+	if expected := tracks[2]; a.themeMusicAssetFile != expected {
+		t.Errorf("Expected the track '%s' after the end of the track, got '%s'", expected, a.themeMusicAssetFile)
+	}
+
+	// the shuffled cycle has the same tracks
+	a.SetShuffle(true)
+	a.NextTrack()
+	shuffled := slices.Clone(a.tracks)
+	slices.Sort(shuffled)
+	expected := slices.Clone(tracks)
+	slices.Sort(expected)
+	if !slices.Equal(shuffled, expected) || a.trackIdx != 0 {
+		t.Errorf("Expected the shuffled playlist of the same tracks, got %v at %d", a.tracks, a.trackIdx)
+	}
+	a.Pause()
+}
+
+// TestRateReader tests the resampling of the stream by the playback rate.
+func TestRateReader(t *testing.T) {
+	var src []byte
+	for i := 0; i < 8; i++ {
+		src = append(src, byte(i), 0, byte(i), 0)
+	}
+	readFrames := func(r *rateReader) []byte {
+		out, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatalf("Unexpected read error %v", err)
+		}
+		var frames []byte
+		for i := 0; i+bytesPerFrame <= len(out); i += bytesPerFrame {
+			frames = append(frames, out[i])
+		}
+		return frames
+	}
+
+	r := newRateReader(bytes.NewReader(src), 2)
+	if frames := readFrames(r); !slices.Equal(frames, []byte{0, 2, 4, 6}) {
+		t.Errorf("Expected every second frame at rate 2, got %v", frames)
+	}
+
+	r.setRate(0.5)
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		t.Fatalf("Unexpected seek error %v", err)
+	}
+	if frames := readFrames(r); !slices.Equal(frames, []byte{0, 0, 1, 1, 2, 2, 3, 3, 4, 4, 5, 5, 6, 6, 7, 7}) {
+		t.Errorf("Expected repeated frames at rate 0.5, got %v", frames)
+	}
+}
+
+// TestAudioPlaybackRate tests the smooth change of the music tempo by the speed level.
+func TestAudioPlaybackRate(t *testing.T) {
+	game := NewGame()
+	defer MUSIC_PLAYER.SetPlaybackRate(1)
+
+	game.increaseSpeedLevel()
+	if MUSIC_PLAYER.targetPlaybackRate != 1+musicRatePerSpeedLevel {
+		t.Errorf("Expected the target rate %f, got %f", 1+musicRatePerSpeedLevel, MUSIC_PLAYER.targetPlaybackRate)
+	}
+
+	a := NewAudio([]string{hitSoundFile}, true)
+	a.SetPlaybackRate(1.5)
+	a.SetPlaybackRate(0)
+	a.update()
+	if a.playbackRate <= 1 || 1.5 <= a.playbackRate {
+		t.Errorf("Expected the rate between 1 and 1.5 after one update, got %f", a.playbackRate)
+	}
+	for i := 0; i < 200; i++ {
+		a.update()
+	}
+	if a.playbackRate != 1.5 || a.rateStream.getRate() != 1.5 {
+		t.Errorf("Expected the rate 1.5 after the transition, got %f", a.playbackRate)
+	}
+}
+
+// TestGameDebugMode tests toggling the debug overlay of the grid.
+func TestGameDebugMode(t *testing.T) {
+	game := NewGame()
+	defer func() { showDebugOverlay = false }()
+
+	game.input.simulatePress("debug")
+	game.Update()
+	game.input.simulateRelease("debug")
+	game.Update()
+	if !game.debugMode || !showDebugOverlay {
+		t.Fatalf("Expected the debug mode to be switched on by the key")
+	}
+
+	piece := getPieceByType("Torso").clone()
+	piece.pos = Pos{3, 5}
+	piece.currentRotation = -90
+	game.grid.lockPiece(piece)
+	screen := ebiten.NewImage(screenWidth, screenHeight)
+	game.grid.draw(screen)
+
+	game.toggleDebugMode()
+	if game.debugMode || showDebugOverlay {
+		t.Errorf("Expected the debug mode to be switched off")
+	}
+}
+
+// TestGameFPSCounter tests toggling the FPS counter and its rolling average.
+func TestGameFPSCounter(t *testing.T) {
+	game := NewGame()
+	counter := game.fpsCounter
+	if counter.getState() != StateInactive {
+		t.Errorf("Expected the FPS counter hidden by default")
+	}
+
+	game.input.simulatePress("fps")
+	game.Update()
+	game.input.simulateRelease("fps")
+	game.Update()
+	if !game.showFPS || counter.getState() == StateInactive {
+		t.Fatalf("Expected the FPS counter to be shown by the key")
+	}
+	for _, comp := range game.compMgr.compList {
+		if comp != Component(counter) && DrawOrderFPS <= comp.getDrawOrder() {
+			t.Errorf("Expected the FPS counter drawn over %T", comp)
+		}
+	}
+
+	// only the last fpsSampleCnt samples are averaged
+	counter.activate(true)
+	for i := 0; i < fpsSampleCnt; i++ {
+		counter.addSample(30)
+	}
+	for i := 0; i < fpsSampleCnt; i++ {
+		counter.addSample(60)
+	}
+	if avg := counter.getAverage(); avg != 60 {
+		t.Errorf("Expected the average 60, got %f", avg)
+	}
+	counter.addSample(0)
+	if avg, expected := counter.getAverage(), 60*float64(fpsSampleCnt-1)/float64(fpsSampleCnt); avg != expected {
+		t.Errorf("Expected the average %f, got %f", expected, avg)
+	}
+
+	screen := ebiten.NewImage(screenWidth, screenHeight)
+	counter.draw(screen)
+
+	// the counter stays shown after the restart
+	game.Reset()
+	if counter.getState() == StateInactive {
+		t.Errorf("Expected the FPS counter shown after the reset")
+	}
+	game.toggleFPS()
+	if game.showFPS || counter.getState() != StateInactive {
+		t.Errorf("Expected the FPS counter hidden")
+	}
+}
+
+// TestGameFullscreenToggle tests the fullscreen key of the game.
+func TestGameFullscreenToggle(t *testing.T) {
+	game := NewGame()
+	defer ebiten.SetFullscreen(false)
+	defer os.Remove(optionsFileName)
+
+	game.input.simulatePress("fullscreen")
+	game.Update()
+	game.input.simulateRelease("fullscreen")
+	game.Update()
+	if !ebiten.IsFullscreen() || !currentOptions.Fullscreen {
+		t.Fatalf("Expected the fullscreen mode switched on by the key")
+	}
+	game.toggleFullscreen()
+	if ebiten.IsFullscreen() || currentOptions.Fullscreen {
+		t.Errorf("Expected the window mode")
+	}
+}
+
+// TestGameMuteToggle tests the mute key of the game.
+func TestGameMuteToggle(t *testing.T) {
+	game := NewGame()
+	defer func() {
+		if MUSIC_PLAYER.IsMuted() {
+			toggleMute()
 		}
 	}()
-	LoadImage("assets/head.png")
+
+	game.input.simulatePress("mute")
+	game.Update()
+	game.input.simulateRelease("mute")
+	if !MUSIC_PLAYER.IsMuted() || !joinPlayer.IsMuted() || !sfxPlayer.IsMuted() || !game.sideBar.muted {
+		t.Errorf("Expected the audio to be muted by the key")
+	}
+
+	// the mute persists across the reset
+	game.Reset()
+	game.Update()
+	if !MUSIC_PLAYER.IsMuted() || MUSIC_PLAYER.getPlayer().Volume() != 0 {
+		t.Errorf("Expected the audio to stay muted after reset")
+	}
+
+	screen := ebiten.NewImage(screenWidth, screenHeight)
+	game.sideBar.draw(screen)
+
+	toggleMute()
+	if MUSIC_PLAYER.IsMuted() || sfxPlayer.IsMuted() || MUSIC_PLAYER.getPlayer().Volume() != 1 {
+		t.Errorf("Expected the audio to be unmuted")
+	}
+}
+
+// TestUserInputMacro tests recording and replaying a macro.
+func TestUserInputMacro(t *testing.T) {
+	macroName := "test_left_right_rotate"
+	defer os.Remove(macroDir) // only if empty
+	defer os.Remove(getMacroPath(macroName))
+
+	newGameWithHead := func() *Game {
+		game := NewGame()
+		game.activePiece = getPieceByType("Head").clone()
+		game.activePiece.pos = Pos{gridSize.w / 2, 0}
+		game.apc.p = game.activePiece
+		return game
+	}
+
+	// record
+	recorded := newGameWithHead()
+	recorded.input.macroRecord(macroName)
+	sequence := map[int][]string{1: {"left"}, 3: {"left", "rotate"}, 5: {"right"}, 7: {"rotate"}, 8: {"left"}}
+	for frame := 0; frame < 10; frame++ {
+		for _, key := range sequence[frame] {
+			recorded.input.simulatePress(key)
+		}
+		recorded.Update()
+		for _, key := range sequence[frame] {
+			recorded.input.simulateRelease(key)
+		}
+	}
+	recorded.Update() // records the last release
+	if err := recorded.input.macroStop(); err != nil {
+		t.Fatalf("Failed to stop recording: %v", err)
+	}
+
+	expectedPos, expectedRotation := recorded.activePiece.pos, recorded.activePiece.currentRotation
+	if expectedPos.x != gridSize.w/2-2 || expectedRotation != 180 {
+		t.Errorf("Expected the recorded piece at x=%d with rotation 180, got x=%d with rotation %d", gridSize.w/2-2, expectedPos.x, expectedRotation)
+	}
+
+	// replay
+	replayed := newGameWithHead()
+	if err := replayed.input.macroPlay(macroName); err != nil {
+		t.Fatalf("Failed to play macro: %v", err)
+	}
+	for frame := 0; frame < 11; frame++ {
+		replayed.Update()
+	}
+
+	if replayed.activePiece.pos != expectedPos || replayed.activePiece.currentRotation != expectedRotation {
+		t.Errorf("Expected the replayed piece at %v with rotation %d, got %v with rotation %d", expectedPos, expectedRotation, replayed.activePiece.pos, replayed.activePiece.currentRotation)
+	}
+	if replayed.input.playedMacro != nil {
+		t.Errorf("Expected the macro to be over")
+	}
+
+	if err := replayed.input.macroPlay("missing_macro"); err == nil {
+		t.Errorf("Expected an error for a missing macro")
+	}
+}
+
+// TestGridSkyline tests the skyline metrics of Grid.
+func TestGridSkyline(t *testing.T) {
+	testCases := []struct {
+		name              string
+		gridDesc          []string
+		expectedHeights   []int // heights of the first columns, the rest is empty
+		expectedBumpiness int
+		expectedMaxDrop   int
+	}{
+		{"empty", []string{}, []int{0, 0, 0}, 0, 0},
+		{"flat", []string{
+			"^H  ^T  ^L  ^H  ^T  ^L  ^H  ^T  ^L  ^H  ^T  ^L  ^H  ^T  ^L  ^H",
+		}, []int{1, 1, 1, 1}, 0, 0},
+		{"staircase", []string{
+			"^H  _   _ ",
+			"^T  ^H  _ ",
+			"^L  ^T  ^H",
+		}, []int{3, 2, 1, 0}, 3, 1},
+		{"spike", []string{
+			"_   ^H  _ ",
+			"_   ^T  _ ",
+			"_   ^T  _ ",
+			"^L  ^L  ^L",
+		}, []int{1, 4, 1, 0}, 7, 3},
+		{"hole below the top", []string{
+			"^H  _ ",
+			"_   _ ",
+			"^L  _ ",
+		}, []int{3, 0}, 3, 3},
+	}
+
+	for _, tc := range testCases {
+		game := NewGame()
+		fillGrid(game, tc.gridDesc)
+
+		skyline := game.grid.countSkyline()
+		if len(skyline) != gridSize.w-2 {
+			t.Errorf("%s: expected %d columns, got %d", tc.name, gridSize.w-2, len(skyline))
+			continue
+		}
+		if !slices.Equal(skyline[:len(tc.expectedHeights)], tc.expectedHeights) {
+			t.Errorf("%s: expected skyline starting with %v, got %v", tc.name, tc.expectedHeights, skyline)
+		}
+
+		metrics := game.metrics()
+		if metrics.skylineBumpiness != tc.expectedBumpiness || metrics.skylineMaxDrop != tc.expectedMaxDrop {
+			t.Errorf("%s: expected bumpiness %d and max drop %d, got %d and %d", tc.name, tc.expectedBumpiness, tc.expectedMaxDrop, metrics.skylineBumpiness, metrics.skylineMaxDrop)
+		}
+	}
+}
+
+// TestBodyMatchStats tests counting the body match attempts.
+func TestBodyMatchStats(t *testing.T) {
+	game := NewGame()
+	fellow := allBodies[slices.IndexFunc(allBodies, func(b *Body) bool { return b.name == "Fellow" })]
+	asshead := allBodies[slices.IndexFunc(allBodies, func(b *Body) bool { return b.name == "Asshead" })]
+
+	gridDesc := []string {
+		"^H  ^H",  // 0
+		"^T  <T",  // 1
+		"^L  ^L", } // 2
+	piecesMat := fillGrid(game, gridDesc)
+
+	fellow.matchAtLockedPiece(game.grid, piecesMat[1][1]) // miss, wrong rotation
+	fellow.matchAtLockedPiece(game.grid, piecesMat[1][0]) // hit
+	asshead.matchAtLockedPiece(game.grid, piecesMat[1][0]) // miss, no torso in the body
+
+	if hits, misses := fellow.matchStats(); hits != 1 || misses != 1 {
+		t.Errorf("Expected 1 hit and 1 miss for '%s', got %d and %d", fellow.name, hits, misses)
+	}
+	if hits, misses := asshead.matchStats(); hits != 0 || misses != 1 {
+		t.Errorf("Expected 0 hit and 1 miss for '%s', got %d and %d", asshead.name, hits, misses)
+	}
+
+	fellow.init()
+	if hits, misses := fellow.matchStats(); hits != 0 || misses != 0 {
+		t.Errorf("Expected the stats to be reset by init, got %d and %d", hits, misses)
+	}
+}
+
+// BenchmarkGridJoinPiecesHitRate runs 1000 joins of a Fellow and checks the hit rate of the bodies.
+func BenchmarkGridJoinPiecesHitRate(b *testing.B) {
+	game := NewGame()
+	gridDesc := []string {
+		"^H  _ ",  // 0
+		"^T  _ ",  // 1
+		"^L  <L", } // 2
+
+	for i := 0; i < b.N; i++ {
+		for _, body := range allBodies {
+			body.init()
+		}
+
+		for j := 0; j < 1000; j++ {
+			game.grid.reset()
+			piecesMat := fillGrid(game, gridDesc)
+			game.grid.joinPieces([]*Piece{piecesMat[1][0], piecesMat[2][1]})
+		}
+
+		hits, attempts := 0, 0
+		for _, body := range allBodies {
+			h, m := body.matchStats()
+			hits += h
+			attempts += h + m
+		}
+		// 1 hit of 8 attempts per join
+		if rate := float64(hits) / float64(attempts); rate < 0.1 {
+			b.Fatalf("Expected hit rate at least 0.1, got %f", rate)
+		}
+	}
+}
+
+// BenchmarkBodyMatchAtLockedPiece benchmarks matching the bodies at the pieces of a grid of 100 pieces of 5 types,
+// with and without the type index.
+func BenchmarkBodyMatchAtLockedPiece(b *testing.B) {
+	pieceTypes := []string{"Head", "Torso", "RightBrkTorso", "LeftBrkTorso", "Leg"}
+	grid := NewGridComp(Size{12, 11}, DrawOrderGrid)
+	for i := 0; i < 100; i++ {
+		piece := *getPieceByType(pieceTypes[i%len(pieceTypes)])
+		piece.pos = Pos{1 + i%10, i / 10}
+		grid.lockPiece(&piece)
+	}
+
+	// the logging of the matching would be measured otherwise
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	matchAll := func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			for _, piece := range grid.lockedPieces {
+				for _, body := range allBodies {
+					body.matchAtLockedPiece(grid, piece)
+				}
+			}
+		}
+	}
+	b.Run("Index", matchAll)
+	b.Run("Scan", func(b *testing.B) {
+		grid.typeIndex = nil
+		matchAll(b)
+	})
+}
+
+// TestGameOptions tests the options screen opened from the menu, applying and saving the options
+func TestGameOptions(t *testing.T) {
+	_ = os.Remove(optionsFileName)
+	defer os.Remove(optionsFileName)
+	defer os.Remove(highScoreFileName)
+	game := NewGame()
+	defaultGridSize := gridSize
+	defer func() { gridSize = defaultGridSize }()
+
+	game.menu.activate(true)
+	game.menu.fire(1)
+	if game.options.getState() != StateBlocking || game.menu.getState() != StateInactive {
+		t.Fatalf("Expected the options screen to replace the menu")
+	}
+	frameCount := game.frameCount
+	game.Update()
+	if game.frameCount != frameCount {
+		t.Errorf("Expected the time to stop on the options screen")
+	}
+	screen := ebiten.NewImage(screenWidth, screenHeight)
+	game.Draw(screen)
+
+	// music volume down
+	game.options.change(0, -1)
+	if v := MUSIC_PLAYER.volume; math.Abs(v-0.9) > 1e-9 {
+		t.Errorf("Expected the music volume 0.9, got %f", v)
+	}
+
+	// start speed level
+	game.options.change(2, 1)
+	if game.startSpeedLevelIdx != 1 || game.speedLevelIdx != 1 {
+		t.Errorf("Expected the start speed level index 1, got %d, current %d", game.startSpeedLevelIdx, game.speedLevelIdx)
+	}
+
+	// colorblind mode recolors the pieces in the game
+	game.options.change(4, 1)
+	if game.activePiece.color != colorblindColorScheme.getPieceColor(game.activePiece.pieceType) {
+		t.Errorf("Expected the active piece to be recolored")
+	}
+
+	// the grid size change restarts the game on the resized grid
+	game.score = 10
+	game.options.change(3, -1)
+	if gridSize.w != defaultGridSize.w-optionsGridSizeStep || game.grid.size != gridSize || len(game.grid.content) != gridSize.w {
+		t.Errorf("Expected the grid to be resized, got %v", game.grid.size)
+	}
+	if game.score != 0 || game.speedLevelIdx != 1 {
+		t.Errorf("Expected a restart at the start speed level, got score %d level %d", game.score, game.speedLevelIdx)
+	}
+	if game.options.getState() != StateBlocking {
+		t.Errorf("Expected the options screen to stay open after the restart")
+	}
+
+	// bomb blast radius
+	game.options.change(5, 1)
+	if game.bombBlastRadius != 2 {
+		t.Errorf("Expected the bomb blast radius 2, got %d", game.bombBlastRadius)
+	}
+
+	// fullscreen
+	game.options.change(6, 1)
+	if !ebiten.IsFullscreen() {
+		t.Errorf("Expected the fullscreen mode")
+	}
+
+	// the options are saved and loaded
+	options, err := loadOptions(optionsFileName)
+	if err != nil {
+		t.Fatalf("loadOptions failed: %v", err)
+	}
+	expected := Options{MusicVolume: 0.9, SFXVolume: 1, StartSpeedLevel: 2, GridSize: defaultGridSize.w - optionsGridSizeStep, Colorblind: true, Theme: "Classic", BombBlastRadius: 2, Fullscreen: true}
+	if math.Abs(options.MusicVolume-expected.MusicVolume) > 1e-9 {
+		t.Errorf("Expected the saved music volume %f, got %f", expected.MusicVolume, options.MusicVolume)
+	}
+	options.MusicVolume = expected.MusicVolume
+	if options != expected {
+		t.Errorf("Expected the saved options %+v, got %+v", expected, options)
+	}
+
+	// the edited options out of their ranges are clamped
+	invalid := `{"musicVolume": 2, "sfxVolume": -1, "startSpeedLevel": 100, "gridSize": 0, "bombBlastRadius": 9}`
+	if err := os.WriteFile(optionsFileName, []byte(invalid), 0644); err != nil {
+		t.Fatalf("Failed to write the options: %v", err)
+	}
+	options, err = loadOptions(optionsFileName)
+	if err != nil || options.MusicVolume != 1 || options.SFXVolume != 0 || options.StartSpeedLevel != len(speedLevels) || options.GridSize != optionsGridSizeMin || options.BombBlastRadius != optionsBombBlastRadiusMax {
+		t.Errorf("Expected the clamped options, got %+v, error %v", options, err)
+	}
+
+	// closing goes back to the menu
+	game.options.close()
+	if game.options.getState() != StateInactive || game.menu.getState() != StateBlocking {
+		t.Errorf("Expected the menu after closing the options screen")
+	}
+
+	// restore the defaults for the other tests
+	currentOptions = getDefaultOptions()
+	currentOptions.GridSize = defaultGridSize.w
+	applyOptions()
+	game.applyOptions()
 }
 
-// TestNewGame tests the NewGame function.
-func TestNewGame(t *testing.T) {
-	game := NewGame()
-	if game == nil {
-		t.Error("Expected new game instance, got nil")
+// TestThemes tests the built-in and the loaded color themes and their selection on the options screen.
+func TestThemes(t *testing.T) {
+	_ = os.Remove(optionsFileName)
+	defer os.Remove(optionsFileName)
+	themesDir := t.TempDir()
+	themeDesc := `{"name": "Test", "colors": {"sidebar": "#102030"}, "pieceColors": {"Head": "#ff0000"}, "pieceImages": {"Head": "assets/head.png"}}`
+	if err := os.WriteFile(filepath.Join(themesDir, "test.json"), []byte(themeDesc), 0644); err != nil {
+		t.Fatalf("Failed to write the theme: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(themesDir, "bad.json"), []byte(`{"name": "Bad", "colors": {"sidebar": "red"}}`), 0644); err != nil {
+		t.Fatalf("Failed to write the theme: %v", err)
+	}
+
+	// the invalid theme is skipped
+	game := NewGame()
+	allThemes = loadThemes(themesDir)
+	defer func() { allThemes = builtinThemes }()
+	names := []string{}
+	for _, theme := range allThemes {
+		names = append(names, theme.name)
+	}
+	if !slices.Equal(names, []string{"Classic", "Neon", "Monochrome", "Test"}) {
+		t.Fatalf("Unexpected themes %v", names)
+	}
+
+	// the theme row cycles the themes and recolors the game
+	themeRowIdx := slices.IndexFunc(optionRows, func(row OptionRow) bool { return row.label == "options.theme" })
+	game.options.change(themeRowIdx, 1)
+	neon := builtinThemes[1]
+	if currentOptions.Theme != "Neon" || sidebarColor != neon.sidebarColor || backgroundColor != neon.backgroundColor {
+		t.Errorf("Expected the Neon theme to be applied")
+	}
+	if game.activePiece.color != neon.pieceColors.getPieceColor(game.activePiece.pieceType) {
+		t.Errorf("Expected the active piece to be recolored by the theme")
+	}
+	if options, _ := loadOptions(optionsFileName); options.Theme != "Neon" {
+		t.Errorf("Expected the theme to be saved, got %q", options.Theme)
+	}
+	game.options.change(themeRowIdx, -1)
+	game.options.change(themeRowIdx, -1)
+	if currentOptions.Theme != "Test" {
+		t.Errorf("Expected the themes to be cycled, got %q", currentOptions.Theme)
+	}
+
+	// the missing colors of the loaded theme are the classic ones, its textures replace the piece images
+	if sidebarColor != (color.RGBA{R: 0x10, G: 0x20, B: 0x30, A: 255}) || backgroundColor != builtinThemes[0].backgroundColor {
+		t.Errorf("Expected the colors of the loaded theme")
+	}
+	head := getPieceByType("Head")
+	if head.image == defaultPieceImages["Head"] || head.color != (color.RGBA{R: 255, A: 255}) {
+		t.Errorf("Expected the texture and the color of the loaded theme")
+	}
+	if getPieceByType("Leg").image != defaultPieceImages["Leg"] {
+		t.Errorf("Expected the default image without a texture")
+	}
+
+	if _, err := parseHexColor("#12345"); err == nil {
+		t.Errorf("Expected an error for the short color")
+	}
+
+	// restore the defaults for the other tests
+	currentOptions = getDefaultOptions()
+	applyOptions()
+	game.applyOptions()
+	if sidebarColor != builtinThemes[0].sidebarColor || getPieceByType("Head").image != defaultPieceImages["Head"] {
+		t.Errorf("Expected the Classic theme to be restored")
 	}
 }
 
-// TestGameReset tests the Reset method of Game.
-func TestGameReset(t *testing.T) {
+// TestGameTutorialMode tests the guided steps of the tutorial mode and the deterministic pieces.
+func TestGameTutorialMode(t *testing.T) {
+	_ = os.Remove(highScoreFileName)
+	defer os.Remove(highScoreFileName)
 	game := NewGame()
-	game.score = 342
-	game.Reset()
-	if game == nil {
-		t.Error("Expected game to be reset, got nil")
+
+	game.menu.activate(true)
+	game.menu.fire(3)
+	if !game.tutorialMode || game.tutorialPrompt.getState() == StateInactive || game.menu.getState() != StateInactive {
+		t.Fatalf("Expected the tutorial mode started from the menu")
 	}
-	if game.score != 0 {
-		t.Error("Expected game score reset to 0")
+	pieceTypes := func() []string {
+		types := []string{game.activePiece.pieceType}
+		for _, p := range game.nextPieces {
+			types = append(types, p.pieceType)
+		}
+		return types
 	}
-}
+	firstRun := pieceTypes()
 
-// TestGameUpdate tests the Update method of Game.
-func TestGameUpdate(t *testing.T) {
-	game := NewGame()
-	err := game.Update()
-	if err != nil {
-		t.Errorf("Expected no error, got %v", err)
+	// the pieces are the same in each run
+	game.startTutorialMode()
+	if types := pieceTypes(); !slices.Equal(types, firstRun) {
+		t.Errorf("Expected the same pieces %v, got %v", firstRun, types)
 	}
-}
 
-// TestGameDraw tests the Draw method of Game.
-func TestGameDraw(t *testing.T) {
-	game := NewGame()
-	game.sideBar.setValues(game.nextPiece, game.score, game.speedLevelIdx+1, game.loadTopScores())
+	tapKey := func(key string) {
+		game.input.simulatePress(key)
+		game.Update()
+		game.input.simulateRelease(key)
+		game.Update()
+	}
+	for game.apc.isSpawning() {
+		game.Update()
+	}
+
+	// the wrong action does not advance
+	tapKey("rotate")
+	if game.tutorialStepIdx != 0 || game.tutorialPrompt.text[0] != T(tutorialSteps[0].prompt) {
+		t.Errorf("Expected to wait for the first step, got step %d", game.tutorialStepIdx)
+	}
 
 	screen := ebiten.NewImage(screenWidth, screenHeight)
-	game.Draw(screen)
-}
+	for i, step := range tutorialSteps {
+		for game.apc.isSpawning() {
+			game.Update()
+		}
+		if game.tutorialStepIdx != i || game.tutorialPrompt.text[0] != T(step.prompt) {
+			t.Fatalf("Expected the step '%s', got step %d", step.prompt, game.tutorialStepIdx)
+		}
+		game.Draw(screen)
+		tapKey(step.action)
+	}
 
-// TestGameLayout tests the Layout method of Game.
-func TestGameLayout(t *testing.T) {
-	game := NewGame()
-	width, height := game.Layout(0, 0)
-	if width != screenWidth || height != screenHeight {
-		t.Errorf("Expected (%d, %d), got (%d, %d)", screenWidth, screenHeight, width, height)
+	// normal play after the last step
+	if game.tutorialMode || game.tutorialPrompt.getState() != StateInactive {
+		t.Errorf("Expected the normal play after the tutorial")
 	}
 }
 
-// TestGameCanMove tests the canMove method of Game.
-func TestGameCanMove(t *testing.T) {
+// TestGameTutorial tests the how-to-play screen cycling through the bodies and its closing
+func TestGameTutorial(t *testing.T) {
+	_ = os.Remove(highScoreFileName)
+	defer os.Remove(highScoreFileName)
 	game := NewGame()
-	if !game.grid.canMove(game.activePiece, 0, 1) {
-		t.Error("Expected piece to be able to move down")
+	defer game.input.simulateRelease("menu")
+
+	game.menu.activate(true)
+	game.menu.fire(2)
+	if game.tutorial.getState() != StateBlocking || game.menu.getState() != StateInactive {
+		t.Fatalf("Expected the tutorial to replace the menu")
+	}
+	frameCount := game.frameCount
+	game.Update()
+	if game.frameCount != frameCount {
+		t.Errorf("Expected the time to stop on the tutorial")
 	}
-}
 
-// TestGameLockPiece tests the lockPiece method of Game.
-func TestGameLockPiece(t *testing.T) {
-	game := NewGame()
-	piece := game.activePiece
+	// the bodies follow each other
+	if idx, frames := game.tutorial.getAnimationState(); idx != 0 || frames != 1 {
+		t.Errorf("Expected the first body at frame 1, got %d at %d", idx, frames)
+	}
+	game.tutorial.frameCnt = getTutorialBodyFrameCnt(allBodies[0])
+	if idx, frames := game.tutorial.getAnimationState(); idx != 1 || frames != 0 {
+		t.Errorf("Expected the second body at frame 0, got %d at %d", idx, frames)
+	}
+	cycleFrameCnt := 0
+	for _, body := range getBaseBodies() {
+		cycleFrameCnt += getTutorialBodyFrameCnt(body)
+	}
+	game.tutorial.frameCnt = cycleFrameCnt + 5
+	if idx, frames := game.tutorial.getAnimationState(); idx != 0 || frames != 5 {
+		t.Errorf("Expected the animation to restart, got %d at %d", idx, frames)
+	}
 
-	game.grid.lockPiece(game.activePiece)
-	if len(game.grid.lockedPieces) != 1 {
-		t.Errorf("Expected 1 locked piece, got %d", len(game.grid.lockedPieces))
+	// every body is drawn while the pieces are falling and after assembled
+	screen := ebiten.NewImage(screenWidth, screenHeight)
+	for frame := 0; frame < cycleFrameCnt; frame += tutorialPieceDropFrameCnt / 2 {
+		game.tutorial.frameCnt = frame
+		game.Draw(screen)
 	}
-	if game.grid.content[gridSize.w/2][0] != piece {
-		t.Errorf("Expected Game.grid refers to the locked piece")
+
+	// the menu key closes back to the menu
+	game.input.simulatePress("menu")
+	game.Update()
+	if game.tutorial.getState() != StateBlocking {
+		t.Errorf("Expected the menu key not to close the tutorial at press")
+	}
+	game.input.simulateRelease("menu")
+	game.Update()
+	if game.tutorial.getState() != StateInactive || game.menu.getState() != StateBlocking {
+		t.Errorf("Expected the menu after closing the tutorial")
 	}
-}
 
-// TestGameSpawnNewPiece tests the spawnNewPiece method of Game.
-func TestGameSpawnNewPiece(t *testing.T) {
-	game := NewGame()
-	game.activePiece = game.nextPiece
-	game.spawnNewPiece()
-	if game.activePiece == nil {
-		t.Error("Expected new active piece, got nil")
+	// a click closes the tutorial, except the one opening it
+	game.menu.fire(2)
+	game.input.simulatePress("mouseLeft")
+	game.Update()
+	game.input.simulateRelease("mouseLeft")
+	if game.tutorial.getState() != StateBlocking {
+		t.Errorf("Expected the opening click not to close the tutorial")
+	}
+	game.Update()
+	game.input.simulatePress("mouseLeft")
+	game.Update()
+	game.input.simulateRelease("mouseLeft")
+	if game.tutorial.getState() != StateInactive {
+		t.Errorf("Expected the tutorial to be closed by a click")
 	}
 }
 
-func fillGrid(g *Game, gridRows []string) [][]*Piece {
-	headIdx := slices.IndexFunc(allPieces, func(p Piece) bool { return p.pieceType == "Head" })
-	torsoIdx := slices.IndexFunc(allPieces, func(p Piece) bool { return p.pieceType == "Torso" })
-	legIdx := slices.IndexFunc(allPieces, func(p Piece) bool { return p.pieceType == "Leg" })
-	bombIdx := slices.IndexFunc(allPieces, func(p Piece) bool { return p.pieceType == "Bomb" })
-
-	s := allPieces[headIdx].size.w // for simplicity consider all pieces have the same w+h size
-	bottom := gridSize.h - 1
+// TestBodiesJSON tests saving the built-in bodies and loading them back, and the errors of the body definitions
+func TestBodiesJSON(t *testing.T) {
+	path := filepath.Join(t.TempDir(), bodiesFileName)
+	if err := SaveBodiesToJSON(builtinBodies, path); err != nil {
+		t.Fatalf("SaveBodiesToJSON failed: %v", err)
+	}
 
-	var piecesMatrix [][]*Piece
+	bodies, err := LoadBodiesFromJSON(path)
+	if err != nil {
+		t.Fatalf("LoadBodiesFromJSON failed: %v", err)
+	}
+	if len(bodies) != len(builtinBodies) {
+		t.Fatalf("Expected %d bodies, got %d", len(builtinBodies), len(bodies))
+	}
+	for i, body := range bodies {
+		builtin := builtinBodies[i]
+		if body.name != builtin.name || body.score != builtin.score || body.completionSFX != builtin.completionSFX || !slices.Equal(body.bodyPieces, builtin.bodyPieces) {
+			t.Errorf("Body #%d differs after loading: %+v", i, body)
+		}
+		if body.countAllRotations != builtin.countAllRotations || len(body.rotationVariants) != len(builtin.rotationVariants) {
+			t.Errorf("Expected body '%s' to keep the rotation variants", body.name)
+		}
+		if (body.triggerEffect == nil) != (builtin.triggerEffect == nil) {
+			t.Errorf("Expected body '%s' to keep the trigger effect of the built-in body", body.name)
+		}
+		if body.pieceTypeToIdx == nil || body.getScore() != builtin.getScore() {
+			t.Errorf("Expected body '%s' to be initialized", body.name)
+		}
+	}
 
-	piecePos := Pos{1, bottom - len(gridRows) * s}
-	for _, rowDesc := range gridRows {
-		var piecesRow []*Piece
+	// a custom body
+	custom := `[{"name": "Stump", "score": 200, "bodyPieces": [{"pieceType": "Torso", "rotation": 0, "x": 0, "y": 0}, {"pieceType": "Leg", "rotation": 0, "x": 0, "y": 1, "optional": true}]}]`
+	if err := os.WriteFile(path, []byte(custom), 0644); err != nil {
+		t.Fatal(err)
+	}
+	bodies, err = LoadBodiesFromJSON(path)
+	if err != nil || len(bodies) != 1 || bodies[0].name != "Stump" || !bodies[0].bodyPieces[1].optional || bodies[0].bodyPieces[1].scoreWeight != 1 {
+		t.Errorf("Unexpected custom bodies %v, error %v", bodies, err)
+	}
 
-		piecePos.x = 1
-		pieceDescList := strings.Split(rowDesc, " ")
-		for _, pieceDesc := range pieceDescList {
-			var piece Piece
+	for _, invalid := range []string{
+		`{"name": "NotAnArray"}`,
+		`[{"name": "Ghost", "score": 100, "bodyPieces": [{"pieceType": "Ghost", "x": 0, "y": 0}]}]`,
+		`[{"name": "Optional", "score": 100, "bodyPieces": [{"pieceType": "Head", "x": 0, "y": 0, "optional": true}]}]`,
+		`[{"name": "Empty", "score": 100, "bodyPieces": []}]`,
+		`[]`,
+		`null`,
+	} {
+		if err := os.WriteFile(path, []byte(invalid), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := LoadBodiesFromJSON(path); err == nil {
+			t.Errorf("Expected an error for %s", invalid)
+		}
+	}
 
-			if pieceDesc == "" {
-				continue
-			}
-			
-			if pieceDesc != "_" {
-				piece = Piece{}
-				switch t := pieceDesc[1]; t {
-					case 'H': piece = allPieces[headIdx]
-					case 'T': piece = allPieces[torsoIdx]
-					case 'L': piece = allPieces[legIdx]
-					case 'B': piece = allPieces[bombIdx]
-				}
+	if _, err := LoadBodiesFromJSON(filepath.Join(t.TempDir(), "missing.json")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Expected a not exist error for a missing file, got %v", err)
+	}
+}
 
-				switch r := pieceDesc[0]; r {
-					case '^':  piece.currentRotation = 0
-					case '<': piece.currentRotation = 90
-					case 'v': piece.currentRotation = 180
-					default: piece.currentRotation = 270
-				}
+// TestBodyCruciform tests matching the plus shaped body in any rotation
+func TestBodyCruciform(t *testing.T) {
+	game := NewGame()
+	cruciform := allBodies[slices.IndexFunc(allBodies, func(b *Body) bool { return b.name == "Cruciform" })]
+	if cruciform.getScore() != 5000 {
+		t.Errorf("Expected the score 5000, got %d", cruciform.getScore())
+	}
+	if _, size := cruciform.getBoundingBox(); size != (Size{3, 3}) {
+		t.Errorf("Expected a 3x3 bounding box, got %v", size)
+	}
 
-				piece.pos = piecePos
+	testCases := []struct {
+		name     string
+		gridDesc []string
+		matched  bool
+	}{
+		{"upright", []string{
+			"_  ^R _ ",
+			"^L ^T ^H",
+			"_  ^F _ ",
+		}, true},
+		{"rotated", []string{
+			"_  <H _ ",
+			"<R <T <F",
+			"_  <L _ ",
+		}, true},
+		{"swapped broken torsos", []string{
+			"_  ^F _ ",
+			"^L ^T ^H",
+			"_  ^R _ ",
+		}, false},
+		{"missing head", []string{
+			"_  ^R _ ",
+			"^L ^T _ ",
+			"_  ^F _ ",
+		}, false},
+	}
 
-				g.grid.lockPiece(&piece)
-			}
+	for _, tc := range testCases {
+		game.grid.reset()
+		piecesMat := fillGrid(game, tc.gridDesc)
 
-			piecesRow = append(piecesRow, &piece)
-			piecePos.x += s
+		match := cruciform.matchAtLockedPiece(game.grid, piecesMat[1][1])
+		if tc.matched && (match == nil || len(match.pieces) != 5) {
+			t.Errorf("%s: expected a match of 5 pieces, got %v", tc.name, match)
+		}
+		if !tc.matched && match != nil {
+			t.Errorf("%s: expected no match, got %v", tc.name, match.pieces)
 		}
-
-		piecesMatrix = append(piecesMatrix, piecesRow)
-		piecePos.y += s
 	}
-
-	return piecesMatrix
 }
 
-// TestGridLockUnlockPieces tests the lockPieces and unlockPieces methods of Grid.
-func TestGridLockUnlockPieces(t *testing.T) {
+// TestBodyZigzag tests joining and scoring the Z shaped body
+func TestBodyZigzag(t *testing.T) {
+	_ = os.Remove(highScoreFileName)
+	defer os.Remove(highScoreFileName)
 	game := NewGame()
+	game.grid.reset()
 
-	gridDesc1 := []string {
+	gridDesc := []string {
 	// 0   1   2
-		"_   _   _",    // 0
-		">L  >T  <H", } // 1
-	piecesMat1 := fillGrid(game, gridDesc1);
+		"^H  ^R  _ ",  // 0
+		"_   ^F  ^L", } // 1
+	piecesMat := fillGrid(game, gridDesc)
 
-	gridDesc2 := []string {
-	// 0   1   2   3   4   5   6
-		"_   >H  ^T",   // 0
-		"_   _   _", }  // 1
-	piecesMat2 := fillGrid(game, gridDesc2);
-
-	if len(game.grid.lockedPieces) != 5 {
-		t.Errorf("Expected 5 locked pieces. Got %d instead.", len(game.grid.lockedPieces))
+	if !game.joinPieces([]*Piece{ piecesMat[1][2] }) {
+		t.Fatalf("Expected the pieces to join")
+	}
+	for i := 1; i < 60*10; i++ {
+		game.rockEffect.update(false, i)
 	}
 
-	if game.grid.lockedPieces[0] != piecesMat2[0][1] { t.Errorf("Expected 1st locked piece d %v. Got %v instead.", piecesMat2[0][1], game.grid.lockedPieces[0]) }
-	if game.grid.lockedPieces[1] != piecesMat2[0][2] { t.Errorf("Expected 2nd locked piece d %v. Got %v instead.", piecesMat2[0][2], game.grid.lockedPieces[1]) }
-	if game.grid.lockedPieces[2] != piecesMat1[1][0] { t.Errorf("Expected 3rd locked piece d %v. Got %v instead.", piecesMat1[0][0], game.grid.lockedPieces[2]) }
-	if game.grid.lockedPieces[3] != piecesMat1[1][1] { t.Errorf("Expected 4th locked piece d %v. Got %v instead.", piecesMat1[0][1], game.grid.lockedPieces[3]) }
-	if game.grid.lockedPieces[4] != piecesMat1[1][2] { t.Errorf("Expected 5th locked piece d %v. Got %v instead.", piecesMat1[0][2], game.grid.lockedPieces[4]) }
+	if len(game.grid.lockedPieces) != 0 {
+		t.Errorf("Expected all four pieces to be removed, got %v", game.grid.lockedPieces)
+	}
+	if game.score != 2500 {
+		t.Errorf("Expected the score 2500, got %d", game.score)
+	}
+	if len(game.completionLog) != 1 || game.completionLog[0].bodyName != "Zigzag" {
+		t.Errorf("Expected the Zigzag completion, got %v", game.completionLog)
+	}
+}
 
-	// unlock (remove) 2 pieces
-	p1 := piecesMat2[0][2]
-	p2 := piecesMat1[1][1]
-	game.grid.unlockPiece(p1)
-	game.grid.unlockPiece(p2)
+// TestBodyCountAllRotations tests matching a body with its pieces rotated relative to the body
+func TestBodyCountAllRotations(t *testing.T) {
+	game := NewGame()
+	fellow := allBodies[slices.IndexFunc(allBodies, func(b *Body) bool { return b.name == "Fellow" })]
+	asshead := allBodies[slices.IndexFunc(allBodies, func(b *Body) bool { return b.name == "Asshead" })]
+	if len(fellow.rotationVariants) != 3 || len(asshead.rotationVariants) != 0 {
+		t.Fatalf("Expected 3 rotation variants of Fellow only, got %d and %d", len(fellow.rotationVariants), len(asshead.rotationVariants))
+	}
+	for _, variant := range fellow.rotationVariants {
+		if !slices.Contains(allBodies, variant) || variant.variantOf != fellow || variant.getScore() != fellow.getScore() {
+			t.Errorf("Expected the variant in allBodies with the score of Fellow, got %+v", variant)
+		}
+	}
+	if bodies := getBaseBodies(); len(bodies) != len(builtinBodies) || slices.ContainsFunc(bodies, func(b *Body) bool { return b.variantOf != nil }) {
+		t.Errorf("Expected the base bodies without the variants, got %d bodies", len(bodies))
+	}
 
-	if len(game.grid.lockedPieces) != 3 {
-		t.Errorf("Expected 3 locked pieces. Got %d instead.", len(game.grid.lockedPieces))
+	testCases := []struct {
+		name     string
+		gridDesc []string
+		matched  bool
+	}{
+		{"upside-down Fellow", []string{
+			"^L",
+			"^T",
+			"^H",
+		}, true},
+		{"lying Fellow", []string{
+			"^L ^T ^H",
+		}, true},
+		{"upside-down Asshead", []string{
+			"^L",
+			"^H",
+		}, false},
 	}
 
-	if game.grid.lockedPieces[0] != piecesMat2[0][1] { t.Errorf("Expected 1st locked piece d %v. Got %v instead.", piecesMat2[0][1], game.grid.lockedPieces[0]) }
-	if game.grid.lockedPieces[1] != piecesMat1[1][0] { t.Errorf("Expected 2nd locked piece d %v. Got %v instead.", piecesMat1[0][0], game.grid.lockedPieces[1]) }
-	if game.grid.lockedPieces[2] != piecesMat1[1][2] { t.Errorf("Expected 3rd locked piece d %v. Got %v instead.", piecesMat1[0][2], game.grid.lockedPieces[2]) }
+	for _, tc := range testCases {
+		game.grid.reset()
+		piecesMat := fillGrid(game, tc.gridDesc)
 
-	// swap positions of two pieces
-	p1.pos, p2.pos = p2.pos, p1.pos
+		matches, _ := game.grid.joinPieces([]*Piece{piecesMat[len(piecesMat)-1][len(piecesMat[0])-1]})
+		if tc.matched && (len(matches) != 1 || matches[0].body.name != "Fellow" || matches[0].score != 1000) {
+			t.Errorf("%s: expected a Fellow match, got %v", tc.name, matches)
+		}
+		if !tc.matched && len(matches) != 0 {
+			t.Errorf("%s: expected no match, got %v", tc.name, matches)
+		}
+	}
+}
 
-	// lock (add) the 2 pieces
-	game.grid.lockPiece(p1)
-	game.grid.lockPiece(p2)
+// TestBodyWildcard tests the wildcard piece standing for any body piece of the matching rotation
+func TestBodyWildcard(t *testing.T) {
+	game := NewGame()
+	if game.spawnProb["Wildcard"] != 0.1 || getPieceByType("Wildcard") == nil {
+		t.Fatalf("Expected the rare Wildcard piece type")
+	}
 
-	if len(game.grid.lockedPieces) != 5 {
-		t.Errorf("Expected 5 locked pieces. Got %d instead.", len(game.grid.lockedPieces))
+	testCases := []struct {
+		name     string
+		gridDesc []string
+		changed  Pos    // the piece in the grid description starting the join
+		body     string // the name of the matched body, empty if none
+		pieceCnt int
+	}{
+		{"wildcard leg", []string{
+			"^H",
+			"^W",
+		}, Pos{0, 0}, "Asshead", 2},
+		{"wildcard head and leg", []string{
+			"^W",
+			"^T",
+			"^W",
+		}, Pos{0, 1}, "Fellow", 3},
+		{"wildcard rotation mismatch", []string{
+			"^H",
+			"<W",
+		}, Pos{0, 0}, "", 0},
+		{"wildcard does not start a match", []string{
+			"^H",
+			"^W",
+		}, Pos{0, 1}, "", 0},
 	}
 
-	if game.grid.lockedPieces[0] != piecesMat2[0][1] { t.Errorf("Expected 1st locked piece d %v. Got %v instead.", piecesMat2[0][1], game.grid.lockedPieces[0]) }
-	if game.grid.lockedPieces[1] != p2               { t.Errorf("Expected 2nd locked piece d %v. Got %v instead.", p2, game.grid.lockedPieces[1]) }
-	if game.grid.lockedPieces[2] != piecesMat1[1][0] { t.Errorf("Expected 3rd locked piece d %v. Got %v instead.", piecesMat1[0][0], game.grid.lockedPieces[2]) }
-	if game.grid.lockedPieces[3] != p1               { t.Errorf("Expected 4th locked piece d %v. Got %v instead.", p1, game.grid.lockedPieces[3]) }
-	if game.grid.lockedPieces[4] != piecesMat1[1][2] { t.Errorf("Expected 5th locked piece d %v. Got %v instead.", piecesMat1[0][2], game.grid.lockedPieces[4]) }
+	for _, tc := range testCases {
+		game.grid.reset()
+		piecesMat := fillGrid(game, tc.gridDesc)
+
+		matches, pieces := game.grid.joinPieces([]*Piece{piecesMat[tc.changed.y][tc.changed.x]})
+		if tc.body != "" && (len(matches) != 1 || matches[0].body.name != tc.body || len(pieces) != tc.pieceCnt) {
+			t.Errorf("%s: expected a %s match of %d pieces, got %v", tc.name, tc.body, tc.pieceCnt, matches)
+		}
+		if tc.body == "" && len(matches) != 0 {
+			t.Errorf("%s: expected no match, got %v", tc.name, matches)
+		}
+	}
 }
 
-// TestGameJoinAndScorePieces tests the joinAndScorePieces method of Game.
-func TestGameJoinAndScorePieces(t *testing.T) {
+// TestBarPiece tests the double-cell Bar piece in the grid and the Bridge body made of two bars
+func TestBarPiece(t *testing.T) {
 	game := NewGame()
-	fellow := allBodies[ slices.IndexFunc(allBodies, func(b *Body) bool { return b.name == "Fellow" }) ]
+	game.grid.reset()
+	bottom := gridSize.h - 2 // the lowest row inside the border
 
-	// grid status
-	gridDesc := []string {
-	// 0   1   2   3   4   5   6
-		"_   _   ^T  _   <H",           // 0
-		"_   >H  >H  _   vT  <T  <L",   // 1
-		">L  >T  <H  <T  <L  ^L  vH", } // 2
-	piecesMat := fillGrid(game, gridDesc);
+	bar := getPieceByType("Bar").clone()
+	if bar.size != (Size{2, 1}) || rotateSize(bar.size, 90) != (Size{1, 2}) || rotateSize(bar.size, 180) != (Size{2, 1}) {
+		t.Fatalf("Expected the rotation to swap the width and height of the bar")
+	}
 
-	origScore := game.score
-	joined := game.joinPieces([]*Piece{ piecesMat[2][3] }) // <T in the bottom row
+	// a single-cell gap between two locked pieces in the bottom row
+	for _, x := range []int{2, 4} {
+		leg := getPieceByType("Leg").clone()
+		leg.pos = Pos{x, bottom}
+		game.grid.lockPiece(leg)
+	}
+	bar.pos = Pos{3, bottom - 1}
+	if game.grid.canMove(bar, 0, 1) {
+		t.Errorf("Expected the horizontal bar not to fit in the single-cell gap")
+	}
+	bar.currentRotation = 90
+	bar.pos = Pos{3, bottom - 2}
+	if !game.grid.canMove(bar, 0, 1) {
+		t.Errorf("Expected the vertical bar to fit in the single-cell gap")
+	}
+	game.grid.drop(bar)
+	if bar.pos != (Pos{3, bottom - 1}) {
+		t.Errorf("Expected the vertical bar to land in the gap, got %v", bar.pos)
+	}
 
-	if !joined || game.rockEffect.getState() != StateBlocking {
-		t.Errorf("Expected to pieces joined (%t) and rocking (%d).", joined, game.rockEffect.getState())
+	// the locked bar occupies both cells and is removed from both
+	game.grid.lockPiece(bar)
+	if game.grid.getPiece(Pos{3, bottom - 1}) != bar || game.grid.getPiece(Pos{3, bottom}) != bar {
+		t.Errorf("Expected the locked bar in both cells")
+	}
+	game.grid.unlockPiece(bar)
+	if game.grid.getPiece(Pos{3, bottom - 1}) != nil || game.grid.getPiece(Pos{3, bottom}) != nil {
+		t.Errorf("Expected both cells to be empty after unlocking the bar")
 	}
 
-	// execute rock effect
-	for i := 1; i < 60*10; i++ {
-		game.rockEffect.update(false, i)
+	// two bars side by side join to a bridge
+	game.grid.reset()
+	var bars []*Piece
+	for _, x := range []int{2, 4} {
+		bar := getPieceByType("Bar").clone()
+		bar.pos = Pos{x, bottom}
+		game.grid.lockPiece(bar)
+		bars = append(bars, bar)
+	}
+	matches, pieces := game.grid.joinPieces([]*Piece{bars[1]})
+	if len(matches) != 1 || matches[0].body.name != "Bridge" || len(pieces) != 2 {
+		t.Errorf("Expected a Bridge match, got %v", matches)
 	}
 
-	// 1 body joins and disappears:
-	//          ^T      <H
-	//      >H  >H      vT  <T  <L
-	//  >L  >T              ^L  vH
+	// a vertical bar is drawn besides the bridge
+	verticalBar := getPieceByType("Bar").clone()
+	verticalBar.currentRotation = 90
+	verticalBar.pos = Pos{7, bottom - 1}
+	if game.grid.getPiece(verticalBar.pos) != nil || game.grid.getPiece(Pos{7, bottom}) != nil {
+		t.Fatalf("Expected free cells for the vertical bar")
+	}
+	game.grid.lockPiece(verticalBar)
+	screen := ebiten.NewImage(screenWidth, screenHeight)
+	game.Draw(screen)
+}
 
-	// pieces fallen:
-	//
-	//      >H  ^T      <H  <T  <L
-	//  >L  >T  >H      vT  ^L  vH
-	
-	// 2 more bodies join and disappear:
-	//
-	//      >H  ^T
-	//                  vT  ^L  vH
+// TestGameBombChain tests the bombs destroyed by a bomb detonating in a chain
+func TestGameBombChain(t *testing.T) {
+	game := NewGame()
+	game.grid.reset()
 
-	// pieces fallen:
-	//
-	//      >H  ^T      vT  ^L  vH
+	gridDesc := []string {
+		"^B",  // 0
+		"^B",  // 1
+		"^H",  // 2
+		"^L",  // 3
+		"^T",  // 4
+		"^H", } // 5
+	piecesMat := fillGrid(game, gridDesc)
+
+	bomb := getPieceByType("Bomb").clone()
+	bomb.pos = addPos(piecesMat[0][0].pos, Pos{0, -1})
+	game.detonateBomb(bomb, 0)
 
-	if origScore + 3 * fellow.score != game.score {
-		t.Errorf("Expected score (%d) is %d", game.score, origScore + 2 * fellow.score)
+	// each bomb destroys two rows below itself, the bottom two rows are out of reach
+	if len(game.grid.lockedPieces) != 2 || game.grid.lockedPieces[0] != piecesMat[4][0] {
+		t.Errorf("Expected the bottom two pieces to remain, got %v", game.grid.lockedPieces)
+	}
+	activeWaves := 0
+	for _, wave := range game.chainWaves {
+		if wave.getState() != StateInactive {
+			activeWaves++
+		}
+	}
+	if activeWaves != 2 {
+		t.Errorf("Expected a wave for each chained bomb, got %d", activeWaves)
 	}
 
-	if len(game.grid.lockedPieces) != 5 {
-		t.Errorf("Expected 5 locked pieces. Got %d instead.", len(game.grid.lockedPieces))
+	// the depth limit stops the chain
+	game.grid.reset()
+	fillGrid(game, gridDesc)
+	game.detonateBomb(bomb, bombChainMaxDepth)
+	if len(game.grid.lockedPieces) != 4 {
+		t.Errorf("Expected the chain to stop at the max depth, got %v", game.grid.lockedPieces)
 	}
 
-	if game.grid.lockedPieces[0] != piecesMat[1][1] { t.Errorf("Expected 1st locked piece d %v. Got %v instead.", piecesMat[1][1], game.grid.lockedPieces[0]) }
-	if game.grid.lockedPieces[1] != piecesMat[0][2] { t.Errorf("Expected 2nd locked piece d %v. Got %v instead.", piecesMat[0][2], game.grid.lockedPieces[1]) }
-	if game.grid.lockedPieces[2] != piecesMat[1][4] { t.Errorf("Expected 3rd locked piece d %v. Got %v instead.", piecesMat[1][4], game.grid.lockedPieces[2]) }
-	if game.grid.lockedPieces[3] != piecesMat[2][5] { t.Errorf("Expected 4th locked piece d %v. Got %v instead.", piecesMat[2][5], game.grid.lockedPieces[3]) }
-	if game.grid.lockedPieces[4] != piecesMat[2][6] { t.Errorf("Expected 5th locked piece d %v. Got %v instead.", piecesMat[2][6], game.grid.lockedPieces[4]) }
+	screen := ebiten.NewImage(screenWidth, screenHeight)
+	game.Draw(screen)
 }
 
-// TestGameGeneratePiece tests the generatePiece methods of Game.
-func TestGameGeneratePiece(t *testing.T) {
+// TestGameBombBlastRadius tests the area destroyed by a bomb with the blast radius
+func TestGameBombBlastRadius(t *testing.T) {
 	game := NewGame()
-	for i := 0; i < 100000; i++ {
-		game.generatePiece()
-	}
 
-	for a := 0; a < len(allPieces)-1; a++ {
-		spawnA := game.spawnStat[allPieces[a].pieceType]
-		probA, ok := game.spawnProb[allPieces[a].pieceType]
-		if !ok {
-			probA = 1.0
-		}
+	gridDesc := []string {
+	// 0   1   2   3   4   5   6
+		"_   _   _   _   _   _   _ ",  // 0
+		"^T  ^H  ^T  ^H  ^T  ^H  ^T",  // 1
+		"^L  ^T  ^L  ^T  ^L  ^T  ^L",  // 2
+		"^H  ^L  ^H  ^L  ^H  ^L  ^H",  // 3
+		"^T  ^H  ^T  ^H  ^T  ^H  ^T", } // 4
 
-		for b := a+1; b < len(allPieces); b++ {
-			spawnB := game.spawnStat[allPieces[b].pieceType]
-			probB, ok := game.spawnProb[allPieces[b].pieceType]
-			if !ok {
-				probB = 1.0
-			}
+	testCases := []struct {
+		radius    int
+		destroyed []Pos // in the grid description
+	}{
+		{1, []Pos{{2, 1}, {3, 1}, {4, 1}, {2, 2}, {3, 2}, {4, 2}}},
+		{2, []Pos{{1, 1}, {2, 1}, {3, 1}, {4, 1}, {5, 1}, {1, 2}, {2, 2}, {3, 2}, {4, 2}, {5, 2}, {1, 3}, {2, 3}, {3, 3}, {4, 3}, {5, 3}}},
+	}
 
-			expectedSpawnA := float32(spawnB) * probA / probB
+	for _, tc := range testCases {
+		game.grid.reset()
+		piecesMat := fillGrid(game, gridDesc)
+		bomb := getPieceByType("Bomb").clone()
+		bomb.pos = addPos(piecesMat[1][3].pos, Pos{0, -1})
 
-			// allow may 10% error
-			if expectedSpawnA < float32(spawnA) * 0.9 || float32(spawnA) * 1.1 < expectedSpawnA {
-				t.Errorf("Incorrect nr of generated pieces: '%s'(%f%%):%d '%s'(%f%%):%d", allPieces[a].pieceType, 100*probA, spawnA, allPieces[b].pieceType, 100*probB, spawnB)
-			}
-//		t.Logf("Verify spawn, pieces idx:(%d,%d), spawn nr:(%d,%d), rel diff:%f", a, b, spawnB, spawnA, expectedSpawnA / float32(spawnA))
+		game.bombBlastRadius = tc.radius
+		pieces := game.grid.getPiecesBelow(bomb, tc.radius)
+		var expected []*Piece
+		for _, pos := range tc.destroyed {
+			expected = append(expected, piecesMat[pos.y][pos.x])
 		}
+		slices.SortFunc(pieces, func(a, b *Piece) int { return a.lockOrderIndex - b.lockOrderIndex })
+		slices.SortFunc(expected, func(a, b *Piece) int { return a.lockOrderIndex - b.lockOrderIndex })
+		if !slices.Equal(pieces, expected) {
+			t.Errorf("radius %d: expected %d destroyed pieces, got %d", tc.radius, len(expected), len(pieces))
+		}
+	}
+
+	// the wave starts at the edge of the blast area
+	game.grid.reset()
+	game.bombBlastRadius = 2
+	game.activePiece = getPieceByType("Bomb").clone()
+	game.activePiece.pos = Pos{4, 3}
+	game.handleActivePieceLanded()
+	if game.waveEffect.initialRadius != 2.5*float64(scale) {
+		t.Errorf("Expected the wave to start at the blast area edge, got %f", game.waveEffect.initialRadius)
 	}
+	screen := ebiten.NewImage(screenWidth, screenHeight)
+	game.waveEffect.draw(screen)
 }
@@ -0,0 +1,329 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/hajimehoshi/ebiten/v2"
+	"github.com/hajimehoshi/ebiten/v2/vector"
+)
+
+/*
+the game parameters set on the options screen. saved to optionsFileName.
+*/
+type Options struct {
+	MusicVolume     float64 `json:"musicVolume"`     // in [0,1]
+	SFXVolume       float64 `json:"sfxVolume"`       // in [0,1]
+	StartSpeedLevel int     `json:"startSpeedLevel"` // 1-based
+	GridSize        int     `json:"gridSize"`        // width and height of the grid, including the border. -grid-height overrides the height
+	Colorblind      bool    `json:"colorblind"`      // the pieces are tinted by colorblindColorScheme
+	Theme           string  `json:"theme"`           // name of the color theme, see allThemes
+	BombBlastRadius int     `json:"bombBlastRadius"` // see Game.bombBlastRadius
+	Fullscreen      bool    `json:"fullscreen"`      // the 800x600 game is scaled to the display
+	Language        string  `json:"language"`        // code of the language pack, empty for the language of the config
+}
+
+func getDefaultOptions() Options {
+	return Options{MusicVolume: 1, SFXVolume: 1, StartSpeedLevel: 1, GridSize: gridSize.w, BombBlastRadius: 1, Theme: builtinThemes[0].name}
+}
+
+var currentOptions = getDefaultOptions() // the options of the current profile, see initOptions
+
+/*
+initOptions loads the options of the current profile and applies the ones not belonging to a game.
+Called at the startup before the game is created, and when the profile is switched.
+*/
+func initOptions() {
+	options, err := loadOptions(getProfilePath(optionsFileName))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.Printf("%v", err)
+	}
+	commandLine.overrideOptions(&options)
+	currentOptions = options
+	applyOptions()
+}
+
+/*
+applyOptions applies the volumes, the fullscreen mode, the language and the grid size of the current options.
+The rest is applied to the game by Game.applyOptions.
+*/
+func applyOptions() {
+	log.Printf("Applying options %+v", currentOptions)
+	MUSIC_PLAYER.SetVolume(currentOptions.MusicVolume)
+	sfxPlayer.SetVolume(currentOptions.SFXVolume)
+	ebiten.SetFullscreen(currentOptions.Fullscreen)
+
+	if currentOptions.Language != "" {
+		setLanguage(currentOptions.Language)
+	} else {
+		setLanguage(configLanguage)
+	}
+
+	// the grid is square unless its height is given by the command line
+	if currentOptions.GridSize != gridSize.w {
+		gridSize = Size{currentOptions.GridSize, currentOptions.GridSize}
+		if commandLine.gridHeight != 0 {
+			gridSize.h = commandLine.gridHeight
+		}
+	}
+}
+
+/*
+applyOptions updates the game by the current options. A changed grid size starts a new game on the resized grid.
+*/
+func (g *Game) applyOptions() {
+	g.bombBlastRadius = currentOptions.BombBlastRadius
+
+	theme := getTheme(currentOptions.Theme)
+	if currentOptions.Colorblind {
+		g.applyTheme(theme, colorblindColorScheme)
+	} else {
+		g.applyTheme(theme, theme.pieceColors)
+	}
+
+	// the new start level is applied to the running game too. the marathon keeps its fixed level
+	if startIdx := currentOptions.StartSpeedLevel - 1; !g.marathon && startIdx != g.startSpeedLevelIdx {
+		g.startSpeedLevelIdx = startIdx
+		g.setSpeedLevel(startIdx)
+	}
+
+	if g.grid.size != gridSize {
+		g.grid.resize(gridSize)
+		g.Reset()
+	}
+}
+
+/*
+loadOptions loads the options from a JSON file. The options missing from the file keep their defaults,
+the ones out of their ranges are clamped, see Options.clamp.
+*/
+func loadOptions(path string) (Options, error) {
+	options := getDefaultOptions()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return options, fmt.Errorf("failed to load options: %w", err)
+	}
+	if err := json.Unmarshal(data, &options); err != nil {
+		return getDefaultOptions(), fmt.Errorf("failed to parse options '%s': %w", path, err)
+	}
+	options.clamp()
+	return options, nil
+}
+
+/*
+clamp moves the options into the ranges of the options screen. The file may be edited by hand, or saved
+with the speed levels of another config.
+*/
+func (o *Options) clamp() {
+	o.MusicVolume = clampVolume(o.MusicVolume)
+	o.SFXVolume = clampVolume(o.SFXVolume)
+	o.StartSpeedLevel = min(max(o.StartSpeedLevel, 1), len(speedLevels))
+	o.GridSize = min(max(o.GridSize, optionsGridSizeMin), optionsGridSizeMax)
+	o.BombBlastRadius = min(max(o.BombBlastRadius, 1), optionsBombBlastRadiusMax)
+}
+
+func saveOptions(path string, options Options) error {
+	data, err := json.MarshalIndent(options, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal options: %w", err)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("failed to save options '%s': %w", path, err)
+	}
+	return nil
+}
+
+/*
+an option row of the options screen
+*/
+type OptionRow struct {
+	label  string // key of the label, see T
+	value  func(o *Options) string
+	change func(o *Options, dir int) // dir is -1 or 1
+}
+
+var optionRows = []OptionRow{
+	{"options.musicVolume", func(o *Options) string { return fmt.Sprintf("%d%%", int(o.MusicVolume*100+0.5)) },
+		func(o *Options, dir int) { o.MusicVolume = clampVolume(o.MusicVolume + float64(dir)*optionsVolumeStep) }},
+	{"options.sfxVolume", func(o *Options) string { return fmt.Sprintf("%d%%", int(o.SFXVolume*100+0.5)) },
+		func(o *Options, dir int) { o.SFXVolume = clampVolume(o.SFXVolume + float64(dir)*optionsVolumeStep) }},
+	{"options.startSpeed", func(o *Options) string { return fmt.Sprintf("%d", o.StartSpeedLevel) },
+		func(o *Options, dir int) { o.StartSpeedLevel = min(max(o.StartSpeedLevel+dir, 1), len(speedLevels)) }},
+	{"options.gridSize", func(o *Options) string { return fmt.Sprintf("%dx%d", o.GridSize, gridSize.h) },
+		func(o *Options, dir int) { o.GridSize = min(max(o.GridSize+dir*optionsGridSizeStep, optionsGridSizeMin), optionsGridSizeMax) }},
+	{"options.colorblind", func(o *Options) string { return T(map[bool]string{false: "options.off", true: "options.on"}[o.Colorblind]) },
+		func(o *Options, dir int) { o.Colorblind = !o.Colorblind }},
+	{"options.bombRadius", func(o *Options) string { return fmt.Sprintf("%d", o.BombBlastRadius) },
+		func(o *Options, dir int) { o.BombBlastRadius = min(max(o.BombBlastRadius+dir, 1), optionsBombBlastRadiusMax) }},
+	{"options.fullscreen", func(o *Options) string { return T(map[bool]string{false: "options.off", true: "options.on"}[o.Fullscreen]) },
+		func(o *Options, dir int) { o.Fullscreen = !o.Fullscreen }},
+	{"options.theme", func(o *Options) string { return o.Theme },
+		func(o *Options, dir int) {
+			idx := max(getThemeIdx(allThemes, o.Theme), 0)
+			o.Theme = allThemes[(idx+len(allThemes)+dir)%len(allThemes)].name
+		}},
+	{"options.language", func(o *Options) string {
+			if o.Language == "" {
+				return T("options.auto")
+			}
+			return strings.ToUpper(o.Language)
+		},
+		func(o *Options, dir int) {
+			codes := append([]string{""}, getLanguages(langDirName)...) // the first one is the language of the config
+			idx := max(slices.Index(codes, o.Language), 0)
+			o.Language = codes[(idx+len(codes)+dir)%len(codes)]
+		}},
+}
+
+/*
+OptionsComp is the options screen opened from the menu. It is inactive during the normal game.
+The selected row is changed by the up and down arrows, its value by the left and right arrows.
+The changes of the current options are applied to the game and saved immediately. Enter or the menu key closes the screen.
+*/
+type OptionsComp struct {
+	state ComponentState
+	game *Game
+	input *UserInput
+	selectedIdx int
+	screenPos Pos // center of the screen
+	drawOrder int
+	closeAction func()
+	upState ControlState
+	downState ControlState
+	leftState ControlState
+	rightState ControlState
+	enterState ControlState
+}
+
+func NewOptions(game *Game, input *UserInput, screenPos Pos, closeAction func(), drawOrder int) *OptionsComp {
+	return &OptionsComp {
+		game: game,
+		input: input,
+		screenPos: screenPos,
+		drawOrder: drawOrder,
+		closeAction: closeAction,
+	}
+}
+
+func (o *OptionsComp) activate(isActive bool) {
+	if isActive {
+		o.state = StateBlocking
+		o.selectedIdx = 0
+		// the keys held at the opening are not pressed
+		o.upState = ControlState{down: true}
+		o.downState = ControlState{down: true}
+		o.leftState = ControlState{down: true}
+		o.rightState = ControlState{down: true}
+		o.enterState = ControlState{down: true}
+	} else {
+		o.state = StateInactive
+	}
+}
+
+func (o *OptionsComp) reset() {
+	o.state = StateInactive
+}
+
+/*
+update handles the keys. The screen blocks the game, so the paused flag is ignored.
+*/
+func (o *OptionsComp) update(paused bool, frameCnt int) {
+	if o.state == StateInactive {
+		return
+	}
+
+	o.input.handleKeyPress(KeyList{ebiten.KeyArrowUp}, false, &o.upState)
+	o.input.handleKeyPress(KeyList{ebiten.KeyArrowDown}, false, &o.downState)
+	o.input.handleKeyPress(KeyList{ebiten.KeyArrowLeft}, false, &o.leftState)
+	o.input.handleKeyPress(KeyList{ebiten.KeyArrowRight}, false, &o.rightState)
+	o.input.handleKeyPress(KeyList{ebiten.KeyEnter, ebiten.KeyNumpadEnter}, false, &o.enterState)
+
+	if o.upState.press {
+		o.selectedIdx = (o.selectedIdx + len(optionRows) - 1) % len(optionRows)
+	}
+	if o.downState.press {
+		o.selectedIdx = (o.selectedIdx + 1) % len(optionRows)
+	}
+	if o.leftState.press {
+		o.change(o.selectedIdx, -1)
+	}
+	if o.rightState.press {
+		o.change(o.selectedIdx, 1)
+	}
+	if o.enterState.press {
+		o.close()
+	}
+}
+
+/*
+change steps the value of the option row, then applies and saves the options.
+*/
+func (o *OptionsComp) change(rowIdx int, dir int) {
+	optionRows[rowIdx].change(&currentOptions, dir)
+	applyOptions()
+	o.game.applyOptions()
+	if o.state == StateInactive {
+		o.activate(true) // the reset of a resized grid closes all components
+	}
+	if err := saveOptions(getProfilePath(optionsFileName), currentOptions); err != nil {
+		log.Printf("%v", err)
+	}
+}
+
+/*
+syncFullscreen saves the fullscreen mode changed outside of the options screen.
+*/
+func syncFullscreen() {
+	currentOptions.Fullscreen = ebiten.IsFullscreen()
+	if err := saveOptions(getProfilePath(optionsFileName), currentOptions); err != nil {
+		log.Printf("%v", err)
+	}
+}
+
+/*
+close closes the screen and calls the close action.
+*/
+func (o *OptionsComp) close() {
+	o.activate(false)
+	if o.closeAction != nil {
+		o.closeAction()
+	}
+}
+
+func (o *OptionsComp) draw(screen *ebiten.Image) {
+	if o.state == StateInactive {
+		return
+	}
+
+	lineHeight := int(smallTextFace.Size * 1.5)
+	w, h := uiPx(optionsScreenWidth), (len(optionRows)+2)*lineHeight+2*dialogBorder
+	x, y := o.screenPos.x-w/2, o.screenPos.y-h/2
+	vector.DrawFilledRect(screen, float32(x), float32(y), float32(w), float32(h), sidebarColor, false)
+
+	renderTextCentered(screen, T("options.title"), o.screenPos.x, y+dialogBorder, smallTextFace)
+	for i, row := range optionRows {
+		rowY := y + dialogBorder + (i+2)*lineHeight
+		if i == o.selectedIdx {
+			vector.DrawFilledRect(screen, float32(x), float32(rowY), float32(w), float32(lineHeight), menuSelectedColor, false)
+		}
+		renderText(screen, T(row.label), x+dialogBorder, rowY, smallTextFace)
+		renderText(screen, "< "+row.value(&currentOptions)+" >", x+w/2+dialogBorder, rowY, smallTextFace)
+	}
+}
+
+func (o *OptionsComp) getDrawOrder() int {
+  return o.drawOrder
+}
+
+func (o *OptionsComp) getPriority() int {
+  return PriorityDefault
+}
+
+func (o *OptionsComp) getState() ComponentState {
+	return o.state
+}
@@ -1,6 +1,10 @@
 package main
 
 import (
+	"image"
+	"image/color"
+	"log"
+	"reflect"
 	"slices"
 	"github.com/hajimehoshi/ebiten/v2"
 	"github.com/hajimehoshi/ebiten/v2/vector"
@@ -12,6 +16,200 @@ type Piece struct {
 	size            Size          // Dimensions of the piece on the grid
 	pieceType       string        // Head, Torso, Leg
 	pos             Pos           // Position of the piece on the grid (top left corner)
+	lockOrderIndex  int           // Order of locking in the grid (1 is the first). 0 means never locked
+	color           color.RGBA    // Tint of the image. White or zero value means no tint
+	metadata        map[string]interface{} // State attached by the effects. nil until the first setMeta
+}
+
+func (piece *Piece) setMeta(key string, value interface{}) {
+	if piece.metadata == nil {
+		piece.metadata = map[string]interface{}{}
+	}
+	piece.metadata[key] = value
+}
+
+func (piece *Piece) getMeta(key string) (interface{}, bool) {
+	value, ok := piece.metadata[key]
+	return value, ok
+}
+
+func (piece *Piece) clearMeta(key string) {
+	delete(piece.metadata, key)
+}
+
+/*
+Returns a copy of the piece. The metadata is copied by copyMetaValue, so the copies do not share its maps and slices.
+The pointers in the metadata are not followed, the copies share the pointed values.
+*/
+func (piece *Piece) clone() *Piece {
+	newPiece := *piece
+	if piece.metadata != nil {
+		newPiece.metadata = make(map[string]interface{}, len(piece.metadata))
+		for key, value := range piece.metadata {
+			newPiece.metadata[key] = copyMetaValue(value)
+		}
+	}
+	return &newPiece
+}
+
+/*
+copyMetaValue returns a deep copy of the maps, the slices and the arrays of the metadata value, the structs are copied
+by value. The pointers, the channels and the functions are kept, as they may refer to the shared objects of the game
+like the other pieces or the images.
+*/
+func copyMetaValue(value interface{}) interface{} {
+	if value == nil {
+		return nil
+	}
+	return copyReflectValue(reflect.ValueOf(value)).Interface()
+}
+
+func copyReflectValue(v reflect.Value) reflect.Value {
+	switch v.Kind() {
+	case reflect.Map:
+		if v.IsNil() {
+			return v
+		}
+		c := reflect.MakeMapWithSize(v.Type(), v.Len())
+		for iter := v.MapRange(); iter.Next(); {
+			c.SetMapIndex(iter.Key(), copyReflectValue(iter.Value()))
+		}
+		return c
+	case reflect.Slice:
+		if v.IsNil() {
+			return v
+		}
+		c := reflect.MakeSlice(v.Type(), v.Len(), v.Len())
+		for i := 0; i < v.Len(); i++ {
+			c.Index(i).Set(copyReflectValue(v.Index(i)))
+		}
+		return c
+	case reflect.Array:
+		c := reflect.New(v.Type()).Elem()
+		for i := 0; i < v.Len(); i++ {
+			c.Index(i).Set(copyReflectValue(v.Index(i)))
+		}
+		return c
+	case reflect.Interface:
+		if v.IsNil() {
+			return v
+		}
+		c := reflect.New(v.Type()).Elem()
+		c.Set(copyReflectValue(v.Elem()))
+		return c
+	}
+	return v
+}
+
+/*
+ColorScheme holds the tint colors of the pieces by piece type.
+*/
+type ColorScheme struct {
+	pieceColors map[string]color.RGBA
+}
+
+/*
+Returns the tint color of the piece type, white (no tint) if the scheme has no color for it.
+*/
+func (c ColorScheme) getPieceColor(pieceType string) color.RGBA {
+	pieceColor, ok := c.pieceColors[pieceType]
+	if !ok {
+		return color.RGBA{R: 255, G: 255, B: 255, A: 255}
+	}
+	return pieceColor
+}
+
+/*
+the symbols drawn over the body pieces in colorblind mode, so the pieces are told apart without the images.
+The halves of the broken torso share the diamond, they are told apart by their shapes.
+*/
+var pieceSymbols = map[string]string{
+	"Head":          "circle",
+	"Torso":         "square",
+	"Leg":           "triangle",
+	"RightBrkTorso": "diamond",
+	"LeftBrkTorso":  "diamond",
+}
+
+var colorblindMode bool // the body pieces are drawn with their symbols, see Config.ColorblindMode
+var symbolImages = map[*ebiten.Image]*ebiten.Image{} // the copies with the symbol by the original image
+
+/*
+getSymbolImage returns the image of the piece type with its symbol in colorblind mode, the image itself otherwise.
+The copy is enlarged by symbolImageScale, so the symbol is sharp on the small images.
+*/
+func getSymbolImage(img *ebiten.Image, pieceType string) *ebiten.Image {
+	symbol, ok := pieceSymbols[pieceType]
+	if !colorblindMode || !ok {
+		return img
+	}
+	if symbolImg, ok := symbolImages[img]; ok {
+		return symbolImg
+	}
+
+	bounds := img.Bounds()
+	symbolImg := ebiten.NewImage(bounds.Dx()*symbolImageScale, bounds.Dy()*symbolImageScale)
+	op := &ebiten.DrawImageOptions{}
+	op.GeoM.Scale(float64(symbolImageScale), float64(symbolImageScale))
+	symbolImg.DrawImage(img, op)
+	drawPieceSymbol(symbolImg, symbol)
+	symbolImages[img] = symbolImg
+	return symbolImg
+}
+
+/*
+drawPieceSymbol draws the black symbol on a white square to the center of the image.
+*/
+func drawPieceSymbol(img *ebiten.Image, symbol string) {
+	w, h := float32(img.Bounds().Dx()), float32(img.Bounds().Dy())
+	cx, cy := w/2, h/2
+	r := min(w, h) * symbolSizeRatio / 2
+	border := r / 4
+	vector.DrawFilledRect(img, cx-r-border, cy-r-border, 2*(r+border), 2*(r+border), color.White, true)
+
+	var path vector.Path
+	switch symbol {
+	case "circle":
+		vector.DrawFilledCircle(img, cx, cy, r, color.Black, true)
+		return
+	case "square":
+		vector.DrawFilledRect(img, cx-r*0.8, cy-r*0.8, 1.6*r, 1.6*r, color.Black, true)
+		return
+	case "triangle":
+		path.MoveTo(cx, cy-r)
+		path.LineTo(cx+r, cy+r)
+		path.LineTo(cx-r, cy+r)
+	case "diamond":
+		path.MoveTo(cx, cy-r)
+		path.LineTo(cx+r, cy)
+		path.LineTo(cx, cy+r)
+		path.LineTo(cx-r, cy)
+	default:
+		log.Printf("Unknown piece symbol '%s'", symbol)
+		return
+	}
+	path.Close()
+
+	vertices, indices := path.AppendVerticesAndIndicesForFilling(nil, nil)
+	for i := range vertices {
+		vertices[i].SrcX, vertices[i].SrcY = 1, 1
+		vertices[i].ColorR, vertices[i].ColorG, vertices[i].ColorB, vertices[i].ColorA = 0, 0, 0, 1
+	}
+	img.DrawTriangles(vertices, indices, getWhitePixel(), &ebiten.DrawTrianglesOptions{AntiAlias: true})
+}
+
+var whitePixel *ebiten.Image
+
+/*
+Returns a white 1x1 source image for DrawTriangles. Cut from the middle of a larger image, so the edges are not sampled.
+*/
+func getWhitePixel() *ebiten.Image {
+	if whitePixel == nil {
+		img := ebiten.NewImage(3, 3)
+		img.Fill(color.White)
+		whitePixel = img.SubImage(image.Rect(1, 1, 2, 2)).(*ebiten.Image)
+	}
+	return whitePixel
 }
 
 /*
@@ -19,15 +217,48 @@ Returns scale which also considers dimensions of the image.
 The size of the rendered image must be Piece.size on grid independently of the image resolution or size.
 */
 func (piece *Piece) getScale() (float64, float64) {
-	return scale * float64(piece.size.w) / float64(piece.image.Bounds().Max.X), scale * float64(piece.size.h) / float64(piece.image.Bounds().Max.Y)
+	return float64(scale*piece.size.w) / float64(piece.image.Bounds().Dx()), float64(scale*piece.size.h) / float64(piece.image.Bounds().Dy())
 }
 
 func (piece *Piece) isBomb() bool {
 	return piece.pieceType == "Bomb"
 }
 
+func (piece *Piece) isRowBomb() bool {
+	return piece.pieceType == "RowBomb"
+}
+
+func (piece *Piece) isNuke() bool {
+	return piece.pieceType == "Nuke"
+}
+
+func (piece *Piece) isTranspose() bool {
+	return piece.pieceType == "Transpose"
+}
+
+func (piece *Piece) isEarthquake() bool {
+	return piece.pieceType == "Earthquake"
+}
+
+/*
+the wildcard stands for any body piece of the matching rotation
+*/
+func (piece *Piece) isWildcard() bool {
+	return piece.pieceType == "Wildcard"
+}
+
+func (piece *Piece) isEraser() bool {
+	return piece.pieceType == "EraserOld" || piece.pieceType == "EraserNew"
+}
+
+/*
+Returns the prototype piece of the given type or nil if there is no such piece type.
+*/
 func getPieceByType(pieceType string) *Piece {
 	idx := slices.IndexFunc(allPieces, func(p Piece) bool { return p.pieceType == pieceType })
+	if idx < 0 {
+		return nil
+	}
 	return &allPieces[idx]
 }
 
@@ -43,10 +274,12 @@ func applyRotationToPiece(op *ebiten.DrawImageOptions, piece *Piece) {
 	imageScaleX, imageScaleY := piece.getScale()
 	op.GeoM.Scale(imageScaleX, imageScaleY)
 
-	// Center the rotation point (relative to the piece).
+	// Center the rotation point (relative to the piece). the center of a multi-cell piece depends on its rotated size
 	x, y := grid2ScrPos(float32(piece.pos.x), float32(piece.pos.y))
 	w, h := grid2ScrSize(float32(piece.size.w)/2, float32(piece.size.h)/2)
-	centerX, centerY := x+w, y+h
+	rotatedSize := rotateSize(piece.size, piece.currentRotation)
+	rotatedW, rotatedH := grid2ScrSize(float32(rotatedSize.w)/2, float32(rotatedSize.h)/2)
+	centerX, centerY := x+rotatedW, y+rotatedH
 
 	// Translate to the center of the piece.
 	op.GeoM.Translate(float64(-w), float64(-h))
@@ -56,6 +289,11 @@ func applyRotationToPiece(op *ebiten.DrawImageOptions, piece *Piece) {
 
 	// Translate the piece back to its grid position.
 	op.GeoM.Translate(float64(centerX), float64(centerY))
+
+	// Tint the image. the zero value is treated as white, i.e. no tint
+	if piece.color != (color.RGBA{}) {
+		op.ColorScale.ScaleWithColor(piece.color)
+	}
 }
 
 type PieceComp struct {
@@ -63,14 +301,20 @@ type PieceComp struct {
 	grid      *GridComp
 	input     *UserInput
 	state     ComponentState
+	moveAction func() // called after the piece is moved or rotated by the user
 	drawOrder int
+	ghostAlpha uint8 // opacity of the ghost piece showing the landing position. 0 hides the ghost
+	spawnPiece *Piece // the spawned piece falling in, it becomes p when it arrives
+	spawnAnimY float32 // vertical offset of spawnPiece in cells, advances from spawnAnimStartY to 0
 }
 
-func NewPieceComp(grid *GridComp, input *UserInput, drawOrder int) *PieceComp {
+func NewPieceComp(grid *GridComp, input *UserInput, moveAction func(), drawOrder int) *PieceComp {
 	return &PieceComp {
 		grid: grid,
 		input: input,
+		moveAction: moveAction,
 		drawOrder: drawOrder,
+		ghostAlpha: ghostPieceAlpha,
 	}
 }
 
@@ -84,35 +328,105 @@ func (p *PieceComp) activate(isActive bool) {
 
 func (p *PieceComp) reset() {
 	p.state = StateInactive
+	p.spawnPiece = nil
+	p.spawnAnimY = 0
+}
+
+/*
+startSpawnAnim starts the fall-in of the spawned piece. p is nil during the animation, so the piece is not
+controlled by the user.
+*/
+func (p *PieceComp) startSpawnAnim(piece *Piece) {
+	p.p = nil
+	p.spawnPiece = piece
+	p.spawnAnimY = spawnAnimStartY
+}
+
+func (p *PieceComp) isSpawning() bool {
+	return p.spawnPiece != nil
+}
+
+/*
+updateSpawnAnim advances the fall-in animation and hands over the piece when it arrives.
+*/
+func (p *PieceComp) updateSpawnAnim() {
+	step := -spawnAnimStartY / float32(spawnAnimFrameCnt)
+	p.spawnAnimY += step
+	if -step/2 < p.spawnAnimY { // the rounding errors of the steps are dropped
+		p.spawnAnimY = 0
+		p.p = p.spawnPiece
+		p.spawnPiece = nil
+	}
 }
 
 func (p *PieceComp) update(paused bool, frameCnt int) {
+	if p.state != StateInactive && !paused && p.isSpawning() {
+		p.updateSpawnAnim()
+		return
+	}
+
 	if p.state == StateInactive || paused || p.p == nil { // note that p.p can be nil while an effect is playing on the joined pieces
 		return
 	}
 	
 	piece := p.p
 
+	moved := false
 	if p.input.isKeyPressed("left") && p.grid.canMove(piece, -1, 0) {
 		piece.pos.x -= 1
+		moved = true
 	}
 
 	if p.input.isKeyPressed("right") && p.grid.canMove(piece, 1, 0) {
 		piece.pos.x += 1
+		moved = true
+	}
+
+	if p.input.isKeyPressed("rotate") && !piece.isBomb() && p.rotate(piece) {
+		moved = true
+		playSFX("rotate")
+	}
+
+	if moved && p.moveAction != nil {
+		p.moveAction()
 	}
+}
 
-	if p.input.isKeyPressed("rotate") && !piece.isBomb() {
-		piece.currentRotation = (piece.currentRotation + 90) % 360
+/*
+rotate rotates the piece by 90 degrees. If the rotated piece is blocked, the wall kick offsets are tried in order
+and the first free position is applied together with the rotation.
+Returns false if the piece cannot be rotated.
+*/
+func (p *PieceComp) rotate(piece *Piece) bool {
+	rotated := *piece
+	rotated.currentRotation = (piece.currentRotation + 90) % 360
+
+	for _, offset := range append([]Pos{{0, 0}}, wallKickOffsets...) {
+		if p.grid.canMove(&rotated, offset.x, offset.y) {
+			piece.currentRotation = rotated.currentRotation
+			piece.pos = addPos(piece.pos, offset)
+			return true
+		}
 	}
+
+	log.Printf("Rotation of '%s'@%v is blocked", piece.pieceType, piece.pos)
+	return false
 }
 
 func (p *PieceComp) draw(screen *ebiten.Image) {
 	if p.state != StateInactive && p.p != nil { // note that p.p can be nil while an effect is playing on the joined pieces
 		p.drawBoundingBox(screen)
+		p.drawGhost(screen)
 
 		op := &ebiten.DrawImageOptions{}
 		applyRotationToPiece(op, p.p)
 		screen.DrawImage(p.p.image, op)
+	} else if p.state != StateInactive && p.isSpawning() {
+		op := &ebiten.DrawImageOptions{}
+		applyRotationToPiece(op, p.spawnPiece)
+		_, offsetY := grid2ScrSize(0, p.spawnAnimY)
+		op.GeoM.Translate(0, float64(offsetY))
+		screen.DrawImage(p.spawnPiece.image, op)
 	}
 }
 
@@ -125,14 +439,44 @@ Parameters:
 */
 func (p *PieceComp) drawBoundingBox(screen *ebiten.Image) {
 	x, y := grid2ScrPos(float32(p.p.pos.x), float32(p.p.pos.y))
-	w, h := grid2ScrSize(float32(p.p.size.w), float32(p.p.size.h))
+	size := rotateSize(p.p.size, p.p.currentRotation)
+	w, h := grid2ScrSize(float32(size.w), float32(size.h))
 	vector.StrokeRect(screen, x, y, w+1, h+1, 1, boundingBoxColor, false)
 }
 
+/*
+drawGhost draws a semi-transparent copy of the active piece at its landing position.
+*/
+func (p *PieceComp) drawGhost(screen *ebiten.Image) {
+	if p.ghostAlpha == 0 {
+		return
+	}
+
+	ghost := *p.p
+	ghost.pos = p.getGhostPos()
+
+	op := &ebiten.DrawImageOptions{}
+	applyRotationToPiece(op, &ghost)
+	op.ColorScale.ScaleAlpha(float32(p.ghostAlpha) / 255)
+	screen.DrawImage(ghost.image, op)
+}
+
+/*
+getGhostPos returns the landing position of the active piece. It is recomputed on each call,
+so it follows the moves and rotations of the piece immediately.
+*/
+func (p *PieceComp) getGhostPos() Pos {
+	return p.grid.getLandingPos(p.p)
+}
+
 func (p *PieceComp) getDrawOrder() int {
   return p.drawOrder
 }
 
+func (p *PieceComp) getPriority() int {
+  return PriorityInput
+}
+
 func (p *PieceComp) getState() ComponentState {
 	return p.state
 }
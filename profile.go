@@ -0,0 +1,176 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+/*
+the list of the player profiles. saved to profilesFileName.
+*/
+type ProfileList struct {
+	Current string   `json:"current"` // name of the active profile
+	Names   []string `json:"names"`
+}
+
+/*
+a player profile. The high scores, the lifetime statistics, the options (the music volume among them)
+and the key bindings of the profile are saved to its own directory in profilesDirName.
+*/
+type Profile struct {
+	Name string
+}
+
+/*
+the active profile. nil if there is no profile, the files are in the working directory then.
+*/
+var currentProfile *Profile
+
+/*
+loadProfiles loads the profile list from a JSON file. A missing file means no profiles.
+*/
+func loadProfiles(path string) (ProfileList, error) {
+	var profiles ProfileList
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return profiles, nil
+	} else if err != nil {
+		return profiles, fmt.Errorf("failed to load profiles: %w", err)
+	}
+
+	if err := json.Unmarshal(data, &profiles); err != nil {
+		return ProfileList{}, fmt.Errorf("failed to parse profiles '%s': %w", path, err)
+	}
+	return profiles, nil
+}
+
+func saveProfiles(path string, profiles ProfileList) error {
+	data, err := json.MarshalIndent(profiles, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal profiles: %w", err)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("failed to save profiles '%s': %w", path, err)
+	}
+	return nil
+}
+
+/*
+initProfile activates the current profile of profilesFileName. Called before NewGame, so the game is created
+with the files of the profile.
+*/
+func initProfile() {
+	profiles, err := loadProfiles(profilesFileName)
+	if err != nil {
+		log.Printf("%v", err)
+	}
+	if slices.Contains(profiles.Names, profiles.Current) {
+		currentProfile = &Profile{Name: profiles.Current}
+		log.Printf("Profile '%s' loaded", currentProfile.Name)
+	}
+}
+
+/*
+getProfilePath returns the path of the file of the current profile.
+*/
+func getProfilePath(fileName string) string {
+	if currentProfile == nil {
+		return fileName
+	}
+	return filepath.Join(profilesDirName, currentProfile.Name, fileName)
+}
+
+/*
+activateProfile makes the profile current, creating it if it is new.
+*/
+func activateProfile(name string) {
+	profiles, err := loadProfiles(profilesFileName)
+	if err != nil {
+		log.Printf("%v", err)
+	}
+	if !slices.Contains(profiles.Names, name) {
+		profiles.Names = append(profiles.Names, name)
+	}
+	profiles.Current = name
+	if err := saveProfiles(profilesFileName, profiles); err != nil {
+		log.Printf("%v", err)
+	}
+
+	log.Printf("Profile '%s' activated", name)
+	currentProfile = &Profile{Name: name}
+	if err := os.MkdirAll(getProfilePath(""), 0755); err != nil {
+		log.Printf("Failed to create the directory of profile '%s': %v", name, err)
+	}
+}
+
+/*
+switchProfile activates the profile, creating it if it is new. The key bindings and the options of the profile
+are loaded and a new game is started.
+*/
+func (g *Game) switchProfile(name string) {
+	activateProfile(name)
+
+	g.input.keyDesc = getDefaultKeyBindings()
+	loadKeyBindings(g.input)
+	initOptions()
+	g.applyOptions()
+	g.sideBar.setProfileName(name)
+	g.Reset()
+}
+
+/*
+promptProfile asks for the name of a new profile. An empty name keeps the current profile.
+*/
+func (g *Game) promptProfile() {
+	g.nameEntry.startPrompt([]string{T("profile.new"), T("dialog.enterName")}, func(name string) {
+		if name = strings.TrimSpace(name); name == "" {
+			log.Printf("No profile name entered")
+			return
+		}
+		g.switchProfile(name)
+	})
+}
+
+/*
+openProfileMenu opens the profile picker listing the profiles, the active one marked by '*'.
+*/
+func (g *Game) openProfileMenu() {
+	profiles, err := loadProfiles(profilesFileName)
+	if err != nil {
+		log.Printf("%v", err)
+	}
+
+	var items []MenuItem
+	for _, name := range profiles.Names {
+		label := name
+		if currentProfile != nil && currentProfile.Name == name {
+			label = "* " + name
+		}
+		items = append(items, MenuItem{label, func() {
+			g.profileMenu.activate(false)
+			g.switchProfile(name)
+		}})
+	}
+	items = append(items, MenuItem{"profile.new", func() {
+		g.profileMenu.activate(false)
+		g.promptProfile()
+	}}, MenuItem{"profile.back", func() { g.closeProfileMenu() }})
+
+	g.profileMenu.items = items
+	g.menu.activate(false)
+	g.profileMenu.activate(true)
+}
+
+/*
+closeProfileMenu closes the profile picker back to the menu.
+*/
+func (g *Game) closeProfileMenu() {
+	g.profileMenu.activate(false)
+	g.menu.activate(true)
+}
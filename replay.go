@@ -0,0 +1,149 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+)
+
+/*
+a recorded game session: the seed of the pieces, the key and mouse button state changes and the touch gestures
+*/
+type replayJSON struct {
+	Seed   int64        `json:"seed"`
+	Events []MacroEvent `json:"events"` // the frame of an event is the nr of updates since the start of the game
+}
+
+/*
+ReplayRecorder captures the inputs of a game session. The session is saved as a .replay file
+at the end of the game and on quit, see Game.recordReplay.
+*/
+type ReplayRecorder struct {
+	path   string
+	replay replayJSON
+}
+
+func NewReplayRecorder(path string) *ReplayRecorder {
+	return &ReplayRecorder{path: path}
+}
+
+/*
+start restarts the recording for a new game with the seed of its pieces.
+*/
+func (r *ReplayRecorder) start(seed int64) {
+	r.replay = replayJSON{Seed: seed}
+}
+
+/*
+record adds the key and mouse button presses and releases and the touch gestures of the current update.
+The repeated presses of a held key are not recorded, the replay repeats them.
+*/
+func (r *ReplayRecorder) record(frame int, input *UserInput) {
+	// sorted for a stable file
+	keyNames := make([]string, 0, len(input.keyState))
+	for keyName := range input.keyState {
+		keyNames = append(keyNames, keyName)
+	}
+	slices.Sort(keyNames)
+
+	for _, keyName := range keyNames {
+		r.recordControl(MacroEvent{Frame: frame, Key: keyName}, input.keyState[keyName])
+	}
+
+	// the clicks are replayed at the recorded cursor position
+	x, y := input.cursorPosition()
+	r.recordControl(MacroEvent{Frame: frame, Key: "mouseLeft", X: x, Y: y}, &input.mouseLeftState)
+	r.recordControl(MacroEvent{Frame: frame, Key: "mouseRight", X: x, Y: y}, &input.mouseRightState)
+
+	// the gestures trigger their actions for a frame, so only their presses are recorded
+	for _, action := range slices.Sorted(maps.Keys(input.touchPressed)) {
+		if input.touchPressed[action] {
+			r.replay.Events = append(r.replay.Events, MacroEvent{Frame: frame, Key: touchEventPrefix + action, Down: true})
+		}
+	}
+}
+
+func (r *ReplayRecorder) recordControl(event MacroEvent, state *ControlState) {
+	if state.press && state.heldFrames == 1 || state.release {
+		event.Down = state.press
+		r.replay.Events = append(r.replay.Events, event)
+	}
+}
+
+/*
+save writes the recorded session to the .replay file as JSON.
+*/
+func (r *ReplayRecorder) save() error {
+	data, err := json.Marshal(r.replay)
+	if err != nil {
+		return fmt.Errorf("failed to marshal replay: %w", err)
+	}
+	if err := os.WriteFile(r.path, data, 0644); err != nil {
+		return fmt.Errorf("failed to save replay '%s': %w", r.path, err)
+	}
+
+	log.Printf("Replay saved to '%s' with %d events", r.path, len(r.replay.Events))
+	return nil
+}
+
+/*
+ReplayPlayer feeds the events of a recorded session back to UserInput frame by frame.
+The real keyboard, mouse and touches are not polled while the replay is played.
+*/
+type ReplayPlayer struct {
+	replay  replayJSON
+	nextIdx int // index of the next event to play
+}
+
+/*
+LoadReplay reads a .replay file saved by ReplayRecorder.
+*/
+func LoadReplay(path string) (*ReplayPlayer, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load replay: %w", err)
+	}
+
+	player := &ReplayPlayer{}
+	if err := json.Unmarshal(data, &player.replay); err != nil {
+		return nil, fmt.Errorf("failed to parse replay '%s': %w", path, err)
+	}
+	return player, nil
+}
+
+/*
+restart plays the replay from the beginning.
+*/
+func (p *ReplayPlayer) restart() {
+	p.nextIdx = 0
+}
+
+/*
+play simulates the presses and releases and the touch gestures of the events up to the frame.
+*/
+func (p *ReplayPlayer) play(frame int, input *UserInput) {
+	clear(input.touchPressed)
+	for ; p.nextIdx < len(p.replay.Events) && p.replay.Events[p.nextIdx].Frame <= frame; p.nextIdx++ {
+		event := p.replay.Events[p.nextIdx]
+		if action, ok := strings.CutPrefix(event.Key, touchEventPrefix); ok {
+			input.touchPressed[action] = true
+			continue
+		}
+		if event.Key == "mouseLeft" || event.Key == "mouseRight" {
+			input.simulatedCursor = Pos{event.X, event.Y}
+		}
+		if event.Down {
+			input.simulatePress(event.Key)
+		} else {
+			input.simulateRelease(event.Key)
+		}
+	}
+}
+
+func (p *ReplayPlayer) isOver() bool {
+	return len(p.replay.Events) <= p.nextIdx
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+/*
+a sprint time record of the sprintTimesFileName file
+*/
+type SprintRecord struct {
+	Target  int     `json:"target"`  // target score of the sprint
+	TimeSec float64 `json:"timeSec"` // wall-clock time from the first spawn to the target score
+	Date    string  `json:"date"`
+}
+
+/*
+readSprintTimes reads the sprint time records of the target score. The records are JSON lines.
+*/
+func readSprintTimes(target int) []SprintRecord {
+	data, err := os.ReadFile(getProfilePath(sprintTimesFileName))
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("Failed to read sprint times: %v", err)
+		}
+		return []SprintRecord{}
+	}
+
+	var records []SprintRecord
+	for _, line := range strings.Split(string(data), "\n") {
+		if line == "" {
+			continue
+		}
+
+		var record SprintRecord
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			log.Printf("Invalid sprint time entry %q: %v", line, err)
+			continue
+		}
+		if record.Target == target {
+			records = append(records, record)
+		}
+	}
+	return records
+}
+
+/*
+saveSprintTime appends the sprint time to the sprintTimesFileName file.
+*/
+func saveSprintTime(target int, timeSec float64) {
+	entry, err := json.Marshal(SprintRecord{Target: target, TimeSec: timeSec, Date: time.Now().Format(time.RFC3339)})
+	if err != nil {
+		log.Printf("Failed to marshal sprint time: %v", err)
+		return
+	}
+
+	file, err := os.OpenFile(getProfilePath(sprintTimesFileName), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		log.Printf("Failed to open sprint time file: %v", err)
+		return
+	}
+	defer file.Close()
+
+	if _, err := file.WriteString(string(entry) + "\n"); err != nil {
+		log.Printf("Failed to write sprint time: %v", err)
+	}
+}
+
+/*
+Returns the best time of the target score in seconds and true, or false if there is no time yet.
+*/
+func getBestSprintTime(target int) (float64, bool) {
+	records := readSprintTimes(target)
+	if len(records) == 0 {
+		return 0, false
+	}
+
+	best := records[0].TimeSec
+	for _, record := range records[1:] {
+		best = min(best, record.TimeSec)
+	}
+	return best, true
+}
+
+/*
+Returns the time in m:ss.cc format.
+*/
+func formatSprintTime(timeSec float64) string {
+	minutes := int(timeSec) / 60
+	return fmt.Sprintf("%d:%05.2f", minutes, timeSec-float64(minutes*60))
+}
+
+/*
+completeSprint ends the sprint successfully when the target score is reached. The time is saved and
+compared to the best time of the target.
+*/
+func (g *Game) completeSprint() {
+	g.apc.activate(false)
+	g.sprintCountdown.activate(false)
+	MUSIC_PLAYER.Pause()
+	g.updateLifetimeStats()
+
+	timeSec := time.Since(g.sprintStartTime).Seconds()
+	log.Printf("Sprint %d completed in %f sec", g.sprintTarget, timeSec)
+	bestSec, hasBest := getBestSprintTime(g.sprintTarget)
+	saveSprintTime(g.sprintTarget, timeSec)
+
+	text := []string{T("dialog.sprintComplete"), fmt.Sprintf(T("dialog.time"), formatSprintTime(timeSec))}
+	if !hasBest || timeSec < bestSec {
+		text = append(text, T("dialog.newBestTime"))
+	} else {
+		text = append(text, fmt.Sprintf(T("dialog.bestTime"), formatSprintTime(bestSec)))
+	}
+	g.gameOver.setStats(nil)
+	g.gameOver.text = text
+	g.gameOver.activate(true)
+}
+
+/*
+Returns the position of the sprint countdown, at the top of the grid.
+*/
+func (g *Game) getSprintCountdownPos() Pos {
+	x, y := grid2ScrPos(float32(gridSize.w)/2, 1)
+	return Pos{int(x), int(y)}
+}
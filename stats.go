@@ -0,0 +1,252 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"os"
+	"strconv"
+
+	"github.com/hajimehoshi/ebiten/v2"
+	"github.com/hajimehoshi/ebiten/v2/vector"
+)
+
+/*
+the statistics summed over all the games. saved to statsFileName at the end of each game.
+*/
+type LifetimeStats struct {
+	GamesPlayed    int            `json:"gamesPlayed"`
+	PiecesPlaced   int            `json:"piecesPlaced"`
+	BodyCnt        map[string]int `json:"bodyCnt"` // nr of completions per body name
+	BombsDetonated int            `json:"bombsDetonated"`
+	PlayTimeSec    float64        `json:"playTimeSec"`
+}
+
+/*
+loadLifetimeStats loads the statistics from a JSON file. A missing file means zero statistics.
+*/
+func loadLifetimeStats(path string) (LifetimeStats, error) {
+	stats := LifetimeStats{BodyCnt: map[string]int{}}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return stats, nil
+	} else if err != nil {
+		return stats, fmt.Errorf("failed to load statistics: %w", err)
+	}
+
+	if err := json.Unmarshal(data, &stats); err != nil {
+		return LifetimeStats{BodyCnt: map[string]int{}}, fmt.Errorf("failed to parse statistics '%s': %w", path, err)
+	}
+	if stats.BodyCnt == nil {
+		stats.BodyCnt = map[string]int{}
+	}
+	return stats, nil
+}
+
+func saveLifetimeStats(path string, stats LifetimeStats) error {
+	data, err := json.MarshalIndent(stats, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal statistics: %w", err)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("failed to save statistics '%s': %w", path, err)
+	}
+	return nil
+}
+
+/*
+ResetStats zeroes the lifetime statistics.
+*/
+func ResetStats() error {
+	log.Printf("Resetting the lifetime statistics")
+	return saveLifetimeStats(getProfilePath(statsFileName), LifetimeStats{BodyCnt: map[string]int{}})
+}
+
+/*
+add sums the statistics of a finished game.
+*/
+func (s *LifetimeStats) add(game *GameStats, playTimeSec float64) {
+	s.GamesPlayed++
+	s.PiecesPlaced += game.placedPieceCnt
+	s.BombsDetonated += game.bombCnt
+	s.PlayTimeSec += playTimeSec
+	for name, cnt := range game.bodyCnt {
+		s.BodyCnt[name] += cnt
+	}
+}
+
+/*
+Returns the rows of the statistics screen as label and value pairs. The bodies are listed in the order of allBodies.
+*/
+func (s *LifetimeStats) getRows() [][2]string {
+	totalSec := int(s.PlayTimeSec)
+	rows := [][2]string{
+		{T("stats.gamesPlayed"), strconv.Itoa(s.GamesPlayed)},
+		{T("stats.piecesPlaced"), strconv.Itoa(s.PiecesPlaced)},
+		{T("stats.bombsDetonated"), strconv.Itoa(s.BombsDetonated)},
+		{T("stats.playTime"), fmt.Sprintf("%dh %02dm %02ds", totalSec/3600, totalSec/60%60, totalSec%60)},
+	}
+	for _, body := range getBaseBodies() {
+		rows = append(rows, [2]string{body.name, strconv.Itoa(s.BodyCnt[body.name])})
+	}
+	return rows
+}
+
+/*
+updateLifetimeStats adds the statistics of the finished game to statsFileName.
+*/
+func (g *Game) updateLifetimeStats() {
+	stats, err := loadLifetimeStats(getProfilePath(statsFileName))
+	if err != nil {
+		log.Printf("%v", err)
+		return
+	}
+
+	stats.add(&GameStats{bodyCnt: maps.Clone(g.bodyCnt), placedPieceCnt: g.placedPieceCnt, bombCnt: g.bombCnt}, float64(g.gameTimeSec))
+	if err := saveLifetimeStats(getProfilePath(statsFileName), stats); err != nil {
+		log.Printf("%v", err)
+	}
+}
+
+/*
+StatsScreenComp is the lifetime statistics screen opened from the menu. The R key asks for the reset of
+the statistics, which is confirmed by Y and cancelled by N. Enter or the menu key closes the screen.
+*/
+type StatsScreenComp struct {
+	state ComponentState
+	input *UserInput
+	stats LifetimeStats // loaded at the opening
+	confirming bool // the reset confirmation is shown
+	screenPos Pos // center of the screen
+	drawOrder int
+	closeAction func()
+	enterState ControlState
+	resetState ControlState
+	yesState ControlState
+	noState ControlState
+}
+
+func NewStatsScreen(input *UserInput, screenPos Pos, closeAction func(), drawOrder int) *StatsScreenComp {
+	return &StatsScreenComp {
+		input: input,
+		screenPos: screenPos,
+		drawOrder: drawOrder,
+		closeAction: closeAction,
+	}
+}
+
+func (s *StatsScreenComp) activate(isActive bool) {
+	if isActive {
+		s.state = StateBlocking
+		s.confirming = false
+		s.load()
+		// the keys held at the opening are not pressed
+		s.enterState = ControlState{down: true}
+		s.resetState = ControlState{down: true}
+		s.yesState = ControlState{down: true}
+		s.noState = ControlState{down: true}
+	} else {
+		s.state = StateInactive
+	}
+}
+
+func (s *StatsScreenComp) reset() {
+	s.state = StateInactive
+}
+
+func (s *StatsScreenComp) load() {
+	stats, err := loadLifetimeStats(getProfilePath(statsFileName))
+	if err != nil {
+		log.Printf("%v", err)
+	}
+	s.stats = stats
+}
+
+/*
+update handles the keys. The screen blocks the game, so the paused flag is ignored.
+*/
+func (s *StatsScreenComp) update(paused bool, frameCnt int) {
+	if s.state == StateInactive {
+		return
+	}
+
+	s.input.handleKeyPress(KeyList{ebiten.KeyEnter, ebiten.KeyNumpadEnter}, false, &s.enterState)
+	s.input.handleKeyPress(KeyList{ebiten.KeyR}, false, &s.resetState)
+	s.input.handleKeyPress(KeyList{ebiten.KeyY}, false, &s.yesState)
+	s.input.handleKeyPress(KeyList{ebiten.KeyN}, false, &s.noState)
+
+	if s.confirming {
+		if s.yesState.press {
+			s.confirmReset()
+		} else if s.noState.press || s.enterState.press {
+			s.confirming = false
+		}
+		return
+	}
+
+	if s.resetState.press {
+		s.confirming = true
+	} else if s.enterState.press {
+		s.close()
+	}
+}
+
+/*
+confirmReset zeroes the statistics after the confirmation.
+*/
+func (s *StatsScreenComp) confirmReset() {
+	s.confirming = false
+	if err := ResetStats(); err != nil {
+		log.Printf("%v", err)
+	}
+	s.load()
+}
+
+/*
+close closes the screen and calls the close action.
+*/
+func (s *StatsScreenComp) close() {
+	s.activate(false)
+	if s.closeAction != nil {
+		s.closeAction()
+	}
+}
+
+func (s *StatsScreenComp) draw(screen *ebiten.Image) {
+	if s.state == StateInactive {
+		return
+	}
+
+	rows := s.stats.getRows()
+	lineHeight := int(smallTextFace.Size * 1.5)
+	w, h := uiPx(optionsScreenWidth), (len(rows)+4)*lineHeight+2*dialogBorder
+	x, y := s.screenPos.x-w/2, s.screenPos.y-h/2
+	vector.DrawFilledRect(screen, float32(x), float32(y), float32(w), float32(h), sidebarColor, false)
+
+	renderTextCentered(screen, T("stats.title"), s.screenPos.x, y+dialogBorder, smallTextFace)
+	for i, row := range rows {
+		rowY := y + dialogBorder + (i+2)*lineHeight
+		renderText(screen, row[0], x+dialogBorder, rowY, smallTextFace)
+		renderText(screen, row[1], x+w/2+dialogBorder, rowY, smallTextFace)
+	}
+
+	hint := T("stats.hint")
+	if s.confirming {
+		hint = T("stats.resetConfirm")
+	}
+	renderTextCentered(screen, hint, s.screenPos.x, y+h-dialogBorder-lineHeight, smallTextFace)
+}
+
+func (s *StatsScreenComp) getDrawOrder() int {
+  return s.drawOrder
+}
+
+func (s *StatsScreenComp) getPriority() int {
+  return PriorityDefault
+}
+
+func (s *StatsScreenComp) getState() ComponentState {
+	return s.state
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image/color"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/hajimehoshi/ebiten/v2"
+)
+
+/*
+a color theme of the game. The named colors replace the color variables of the same name when the theme is applied.
+*/
+type Theme struct {
+	name                string
+	sidebarColor        color.RGBA // the sidebar and the dialogs
+	backgroundColor     color.RGBA
+	backgroundFastColor color.RGBA // background at the maximum speed level
+	boundingBoxColor    color.RGBA
+	waveEffectColor     color.RGBA
+	hintPresentColor    color.RGBA
+	hintMissingColor    color.RGBA
+	menuSelectedColor   color.RGBA
+	pieceColors         ColorScheme
+	themeImage          map[string]*ebiten.Image // piece textures by piece type. the default images are used for the missing types
+}
+
+/*
+a theme descriptor of the themes directory. The colors are "#rrggbb" strings, the missing ones are taken from
+the Classic theme.
+*/
+type themeJSON struct {
+	Name        string            `json:"name"`
+	Colors      map[string]string `json:"colors"`      // by the keys of Theme.getColors
+	PieceColors map[string]string `json:"pieceColors"` // by piece type
+	PieceImages map[string]string `json:"pieceImages"` // image paths by piece type
+}
+
+var builtinThemes = []*Theme{
+	{
+		name: "Classic",
+		sidebarColor: sidebarColor,
+		backgroundColor: backgroundColor,
+		backgroundFastColor: backgroundFastColor,
+		boundingBoxColor: boundingBoxColor,
+		waveEffectColor: waveEffectColor,
+		hintPresentColor: hintPresentColor,
+		hintMissingColor: hintMissingColor,
+		menuSelectedColor: menuSelectedColor,
+		pieceColors: pieceColorScheme,
+	},
+	{
+		name: "Neon",
+		sidebarColor: color.RGBA{R: 25, G: 20, B: 45, A: 255},
+		backgroundColor: color.RGBA{R: 10, G: 10, B: 25, A: 255},
+		backgroundFastColor: color.RGBA{R: 45, G: 0, B: 55, A: 255},
+		boundingBoxColor: color.RGBA{R: 0, G: 255, B: 255, A: 255},
+		waveEffectColor: color.RGBA{R: 255, G: 0, B: 200, A: 255},
+		hintPresentColor: color.RGBA{R: 0, G: 255, B: 130, A: 255},
+		hintMissingColor: color.RGBA{R: 255, G: 40, B: 120, A: 255},
+		menuSelectedColor: color.RGBA{R: 120, G: 0, B: 200, A: 255},
+		pieceColors: ColorScheme{pieceColors: map[string]color.RGBA{
+			"Head":          {R: 255, G: 80, B: 200, A: 255},
+			"Torso":         {R: 0, G: 240, B: 255, A: 255},
+			"RightBrkTorso": {R: 80, G: 160, B: 255, A: 255},
+			"LeftBrkTorso":  {R: 180, G: 90, B: 255, A: 255},
+			"Leg":           {R: 120, G: 255, B: 60, A: 255},
+			"Bomb":          {R: 255, G: 60, B: 60, A: 255},
+		}},
+	},
+	{
+		name: "Monochrome",
+		sidebarColor: color.RGBA{R: 120, G: 120, B: 120, A: 255},
+		backgroundColor: color.RGBA{R: 60, G: 60, B: 60, A: 255},
+		backgroundFastColor: color.RGBA{R: 25, G: 25, B: 25, A: 255},
+		boundingBoxColor: color.RGBA{R: 230, G: 230, B: 230, A: 255},
+		waveEffectColor: color.RGBA{R: 200, G: 200, B: 200, A: 255},
+		hintPresentColor: color.RGBA{R: 255, G: 255, B: 255, A: 255},
+		hintMissingColor: color.RGBA{R: 20, G: 20, B: 20, A: 255},
+		menuSelectedColor: color.RGBA{R: 80, G: 80, B: 80, A: 255},
+		pieceColors: ColorScheme{pieceColors: map[string]color.RGBA{
+			"Head":          {R: 240, G: 240, B: 240, A: 255},
+			"Torso":         {R: 200, G: 200, B: 200, A: 255},
+			"RightBrkTorso": {R: 175, G: 175, B: 175, A: 255},
+			"LeftBrkTorso":  {R: 150, G: 150, B: 150, A: 255},
+			"Leg":           {R: 220, G: 220, B: 220, A: 255},
+			"Bomb":          {R: 110, G: 110, B: 110, A: 255},
+		}},
+	},
+}
+
+var allThemes = builtinThemes // the built-in themes and the ones of themesDirName
+var defaultPieceImages = map[string]*ebiten.Image{} // the images of allPieces before any theme, by piece type
+
+/*
+getColors returns the named colors of the theme by their descriptor keys.
+*/
+func (t *Theme) getColors() map[string]*color.RGBA {
+	return map[string]*color.RGBA{
+		"sidebar": &t.sidebarColor,
+		"background": &t.backgroundColor,
+		"backgroundFast": &t.backgroundFastColor,
+		"boundingBox": &t.boundingBoxColor,
+		"waveEffect": &t.waveEffectColor,
+		"hintPresent": &t.hintPresentColor,
+		"hintMissing": &t.hintMissingColor,
+		"menuSelected": &t.menuSelectedColor,
+	}
+}
+
+/*
+parseHexColor parses a "#rrggbb" color.
+*/
+func parseHexColor(s string) (color.RGBA, error) {
+	c := color.RGBA{A: 255}
+	if _, err := fmt.Sscanf(s, "#%02x%02x%02x", &c.R, &c.G, &c.B); err != nil || len(s) != 7 {
+		return c, fmt.Errorf("invalid color '%s'", s)
+	}
+	return c, nil
+}
+
+/*
+LoadThemeFromJSON loads a theme descriptor. The image paths are relative to the working directory.
+*/
+func LoadThemeFromJSON(path string) (*Theme, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load theme: %w", err)
+	}
+
+	var desc themeJSON
+	if err := json.Unmarshal(data, &desc); err != nil {
+		return nil, fmt.Errorf("failed to parse theme '%s': %w", path, err)
+	}
+	if desc.Name == "" {
+		return nil, fmt.Errorf("theme '%s' has no name", path)
+	}
+
+	// the missing colors are the classic ones
+	theme := *builtinThemes[0]
+	theme.name = desc.Name
+	theme.pieceColors = ColorScheme{pieceColors: map[string]color.RGBA{}}
+	theme.themeImage = map[string]*ebiten.Image{}
+
+	colors := theme.getColors()
+	for name, value := range desc.Colors {
+		c, ok := colors[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown color '%s' in theme '%s'", name, path)
+		}
+		if *c, err = parseHexColor(value); err != nil {
+			return nil, fmt.Errorf("theme '%s': %w", path, err)
+		}
+	}
+	for pieceType, value := range desc.PieceColors {
+		c, err := parseHexColor(value)
+		if err != nil {
+			return nil, fmt.Errorf("theme '%s': %w", path, err)
+		}
+		theme.pieceColors.pieceColors[pieceType] = c
+	}
+	for pieceType, imagePath := range desc.PieceImages {
+		img, err := LoadImage(imagePath)
+		if err != nil {
+			return nil, fmt.Errorf("theme '%s': %w", path, err)
+		}
+		theme.themeImage[pieceType] = img
+	}
+	return &theme, nil
+}
+
+/*
+loadThemes returns the built-in themes followed by the themes of the JSON files of the directory.
+The invalid theme files and the themes having the name of an earlier theme are skipped.
+*/
+func loadThemes(dir string) []*Theme {
+	themes := slices.Clone(builtinThemes)
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return themes
+	} else if err != nil {
+		log.Printf("Failed to read the themes: %v", err)
+		return themes
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".json") {
+			continue
+		}
+		theme, err := LoadThemeFromJSON(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			log.Printf("%v", err)
+			continue
+		}
+		if getThemeIdx(themes, theme.name) != -1 {
+			log.Printf("Theme '%s' of '%s' is skipped, the name is already used", theme.name, entry.Name())
+			continue
+		}
+		themes = append(themes, theme)
+	}
+	return themes
+}
+
+/*
+Returns the index of the named theme, -1 if there is no such theme.
+*/
+func getThemeIdx(themes []*Theme, name string) int {
+	return slices.IndexFunc(themes, func(t *Theme) bool { return t.name == name })
+}
+
+/*
+getTheme returns the named theme of allThemes, the Classic theme if there is no such theme.
+*/
+func getTheme(name string) *Theme {
+	if idx := getThemeIdx(allThemes, name); idx != -1 {
+		return allThemes[idx]
+	}
+	log.Printf("Unknown theme '%s', the Classic theme is used", name)
+	return builtinThemes[0]
+}
+
+/*
+applyTheme sets the colors and the piece textures of the theme. The pieces are tinted by the color scheme,
+which is the one of the theme unless the colorblind scheme is chosen.
+*/
+func (g *Game) applyTheme(theme *Theme, scheme ColorScheme) {
+	sidebarColor = theme.sidebarColor
+	backgroundColor = theme.backgroundColor
+	backgroundFastColor = theme.backgroundFastColor
+	boundingBoxColor = theme.boundingBoxColor
+	waveEffectColor = theme.waveEffectColor
+	hintPresentColor = theme.hintPresentColor
+	hintMissingColor = theme.hintMissingColor
+	menuSelectedColor = theme.menuSelectedColor
+	g.background.setSpeedLevel(g.speedLevelIdx)
+
+	getImage := func(pieceType string) *ebiten.Image {
+		if img, ok := theme.themeImage[pieceType]; ok {
+			return getSymbolImage(img, pieceType)
+		}
+		return getSymbolImage(defaultPieceImages[pieceType], pieceType)
+	}
+	for i := range allPieces {
+		allPieces[i].image = getImage(allPieces[i].pieceType)
+	}
+	for _, piece := range g.getAllPieces() {
+		piece.image = getImage(piece.pieceType)
+	}
+	g.applyColorScheme(scheme)
+}
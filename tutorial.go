@@ -0,0 +1,173 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/hajimehoshi/ebiten/v2"
+	"github.com/hajimehoshi/ebiten/v2/inpututil"
+	"github.com/hajimehoshi/ebiten/v2/vector"
+)
+
+/*
+a step of the tutorial mode. The step is completed when the player performs the action.
+*/
+type TutorialStep struct {
+	prompt string // key of the prompt, see T
+	action string // key name of UserInput
+}
+
+var tutorialSteps = []TutorialStep{
+	{"tutorial.moveLeft", "left"},
+	{"tutorial.moveRight", "right"},
+	{"tutorial.rotate", "rotate"},
+	{"tutorial.hold", "hold"},
+	{"tutorial.drop", "drop"},
+}
+
+/*
+TutorialScreenComp is the how-to-play screen opened from the menu. It cycles through the bodies and shows
+each body assembled by falling pieces, with its name and score.
+Any key or a click closes the screen, except the menu key, which is handled by Game.handleMenuKey.
+*/
+type TutorialScreenComp struct {
+	state ComponentState
+	input *UserInput
+	screenPos Pos // center of the screen
+	frameCnt int // frames since the screen was opened
+	drawOrder int
+	closeAction func()
+	anyKeyState ControlState
+}
+
+func NewTutorialScreen(input *UserInput, screenPos Pos, closeAction func(), drawOrder int) *TutorialScreenComp {
+	return &TutorialScreenComp {
+		input: input,
+		screenPos: screenPos,
+		drawOrder: drawOrder,
+		closeAction: closeAction,
+	}
+}
+
+func (t *TutorialScreenComp) activate(isActive bool) {
+	if isActive {
+		t.state = StateBlocking
+		t.frameCnt = 0
+		// the keys held at the opening are not pressed
+		t.anyKeyState = ControlState{down: true}
+	} else {
+		t.state = StateInactive
+	}
+}
+
+func (t *TutorialScreenComp) reset() {
+	t.state = StateInactive
+}
+
+/*
+update advances the animation and handles the closing. The screen blocks the game, so the paused flag is ignored.
+*/
+func (t *TutorialScreenComp) update(paused bool, frameCnt int) {
+	if t.state == StateInactive {
+		return
+	}
+
+	menuKeys := t.input.getBindings("menu")
+	keys := slices.DeleteFunc(inpututil.AppendPressedKeys(nil), func(k ebiten.Key) bool { return slices.Contains(menuKeys, k) })
+	t.input.updateControlState(0 < len(keys), &t.anyKeyState)
+
+	// the click opening the screen does not close it
+	if 0 < t.frameCnt && (t.anyKeyState.press || t.input.isMouseLeftClick()) {
+		t.close()
+		return
+	}
+	t.frameCnt++
+}
+
+/*
+close closes the screen and calls the close action.
+*/
+func (t *TutorialScreenComp) close() {
+	t.activate(false)
+	if t.closeAction != nil {
+		t.closeAction()
+	}
+}
+
+/*
+Returns the frames spent on a body: the pieces fall one after the other, then the assembled body is held.
+*/
+func getTutorialBodyFrameCnt(body *Body) int {
+	return len(body.bodyPieces)*tutorialPieceDropFrameCnt + tutorialHoldFrameCnt
+}
+
+/*
+getAnimationState returns the index of the shown body in getBaseBodies() and the frames spent on it.
+*/
+func (t *TutorialScreenComp) getAnimationState() (bodyIdx int, bodyFrameCnt int) {
+	bodies := getBaseBodies()
+	cycleFrameCnt := 0
+	for _, body := range bodies {
+		cycleFrameCnt += getTutorialBodyFrameCnt(body)
+	}
+
+	bodyFrameCnt = t.frameCnt % cycleFrameCnt
+	for bodyIdx = range bodies {
+		if frames := getTutorialBodyFrameCnt(bodies[bodyIdx]); bodyFrameCnt < frames {
+			break
+		} else {
+			bodyFrameCnt -= frames
+		}
+	}
+	return bodyIdx, bodyFrameCnt
+}
+
+func (t *TutorialScreenComp) draw(screen *ebiten.Image) {
+	if t.state == StateInactive {
+		return
+	}
+
+	lineHeight := int(smallTextFace.Size * 1.5)
+	w, h := uiPx(tutorialScreenSize.w), uiPx(tutorialScreenSize.h)
+	x, y := t.screenPos.x-w/2, t.screenPos.y-h/2
+	vector.DrawFilledRect(screen, float32(x), float32(y), float32(w), float32(h), sidebarColor, false)
+
+	bodyIdx, bodyFrameCnt := t.getAnimationState()
+	bodies := getBaseBodies()
+	body := bodies[bodyIdx]
+	renderTextCentered(screen, T("tutorial.title"), t.screenPos.x, y+dialogBorder, smallTextFace)
+	renderTextCentered(screen, T("tutorial.goal"), t.screenPos.x, y+dialogBorder+lineHeight, smallTextFace)
+	renderTextCentered(screen, fmt.Sprintf(T("tutorial.bodyScore"), body.name, body.getScore()), t.screenPos.x, y+dialogBorder+3*lineHeight, normTextFace)
+	renderTextCentered(screen, fmt.Sprintf(T("tutorial.pressAnyKey"), bodyIdx+1, len(bodies)), t.screenPos.x, y+h-dialogBorder-lineHeight, smallTextFace)
+
+	// the body is centered below the texts. the pieces land one by one, the falling one starts at the top of the area
+	_, boxSize := body.getBoundingBox()
+	cellSize := float64(tutorialCellSize)
+	areaTop := float64(y + dialogBorder + 5*lineHeight)
+	posUL := PosF{float64(t.screenPos.x) - float64(boxSize.w)*cellSize/2, float64(y+h-dialogBorder-2*lineHeight) - float64(boxSize.h)*cellSize}
+	for i := range body.bodyPieces {
+		startFrame := i * tutorialPieceDropFrameCnt
+		if bodyFrameCnt < startFrame {
+			break
+		}
+
+		piecePos := posUL
+		if progress := float64(bodyFrameCnt-startFrame) / float64(tutorialPieceDropFrameCnt); progress < 1 {
+			// the falling piece starts above its place by the height of the area
+			piecePos.y -= (1 - progress) * (posUL.y - areaTop)
+		}
+		drawBodyPiece(screen, body, i, piecePos, cellSize)
+	}
+}
+
+func (t *TutorialScreenComp) getDrawOrder() int {
+  return t.drawOrder
+}
+
+func (t *TutorialScreenComp) getPriority() int {
+  return PriorityDefault
+}
+
+func (t *TutorialScreenComp) getState() ComponentState {
+	return t.state
+}
@@ -1,23 +1,77 @@
 package main
 
 import (
+	"encoding/json"
+	"fmt"
 	"log"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
 	"github.com/hajimehoshi/ebiten/v2"
 )
 
+const macroDir = "macros"
+
+const touchEventPrefix = "touch:" // the key of the recorded touch gestures is the prefix and the action, e.g. "touch:rotate"
+
+/*
+a recorded key or mouse button state change. the mouse buttons are recorded as "mouseLeft" and "mouseRight".
+*/
+type MacroEvent struct {
+	Frame int    `json:"frame"` // frame relative to the start of the recording
+	Key   string `json:"key"`
+	Down  bool   `json:"down"`
+	X     int    `json:"x,omitempty"` // cursor position of the mouse button events of the replays
+	Y     int    `json:"y,omitempty"`
+}
+
+/*
+a macro being recorded or played
+*/
+type Macro struct {
+	name       string
+	events     []MacroEvent
+	startFrame int // frame of the first handleKeys after starting. -1 until then
+	nextIdx    int // index of the next event to play
+}
+
 type KeyList []ebiten.Key
 
 type ControlState struct {
-	down    bool
-	press   bool
-	release bool
+	down       bool
+	press      bool // also set by the auto-repeat while the control is held
+	release    bool
+	dasDelay   int // delayed auto-shift: frames after the press until the first repeated press. 0 disables the repeat
+	dasRepeat  int // frames between the repeated presses
+	heldFrames int // nr of frames the control is down, including the frame of the press
+}
+
+/*
+a touch being tracked for the gestures
+*/
+type TouchStart struct {
+	pos     Pos  // where the touch started
+	frame   int  // frame of the start
+	swiped  bool // a horizontal swipe already moved the piece
 }
 
 type UserInput struct {
 	keyDesc  map[string]KeyList
 	keyState map[string]*ControlState
+	simulatedDown map[string]bool // keys held down by simulatePress, used by the tests
+	simulatedTouches map[ebiten.TouchID]Pos // touches held down by the tests
+	simulatedCursor Pos // cursor position while replaying, see cursorPosition
 	mouseRightState ControlState
 	mouseLeftState  ControlState
+	lastClickFrame  int  // frame of the last left click. 0 if there is no click to pair with
+	doubleClick     bool
+	recordedMacro   *Macro // nil if no recording is in progress
+	playedMacro     *Macro // nil if no macro is played
+	touchPos        map[ebiten.TouchID]Pos // last position of the current touches
+	touchStart      map[ebiten.TouchID]*TouchStart
+	touchPressed    map[string]bool // actions triggered by the touch gestures in the current frame
+	replaying       bool // the keyboard, the mouse and the touches are not polled, only the simulated keys are down. see ReplayPlayer
 }
 
 func NewUserInput(keyDesc *map[string]KeyList) *UserInput {
@@ -25,42 +79,347 @@ func NewUserInput(keyDesc *map[string]KeyList) *UserInput {
 	userInput := &UserInput{
 		keyDesc: *keyDesc,
 		keyState: map[string]*ControlState{},
+		simulatedDown: map[string]bool{},
+		simulatedTouches: map[ebiten.TouchID]Pos{},
+		touchPos: map[ebiten.TouchID]Pos{},
+		touchStart: map[ebiten.TouchID]*TouchStart{},
+		touchPressed: map[string]bool{},
 	}
 
 	for keyName, _ := range *keyDesc {
 		userInput.keyState[keyName] = &ControlState{}
 	}
+	for _, keyName := range dasKeyNames {
+		if state, ok := userInput.keyState[keyName]; ok {
+			state.dasDelay = dasDelayFrameCnt
+			state.dasRepeat = dasRepeatFrameCnt
+		}
+	}
 	
 	return userInput
 }
 
-func (userInput *UserInput) handleKeys() {
+/*
+LoadKeyBindings loads the keys of the actions from a JSON file. The keys are stored by their names, e.g. "ArrowUp".
+The actions missing from the file keep their current keys, the unknown actions are skipped.
+*/
+func (userInput *UserInput) LoadKeyBindings(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to load key bindings: %w", err)
+	}
+
+	var keyDesc map[string]KeyList
+	if err := json.Unmarshal(data, &keyDesc); err != nil {
+		return fmt.Errorf("failed to parse key bindings '%s': %w", path, err)
+	}
+
+	for keyName, keys := range keyDesc {
+		if _, ok := userInput.keyDesc[keyName]; !ok {
+			log.Printf("Unknown action '%s' in key bindings '%s' is skipped", keyName, path)
+			continue
+		}
+		userInput.keyDesc[keyName] = keys
+	}
+
+	log.Printf("Key bindings loaded from '%s'", path)
+	return nil
+}
+
+/*
+SaveKeyBindings saves the keys of all actions to a JSON file.
+*/
+func (userInput *UserInput) SaveKeyBindings(path string) error {
+	data, err := json.MarshalIndent(userInput.keyDesc, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal key bindings: %w", err)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("failed to save key bindings '%s': %w", path, err)
+	}
+	return nil
+}
+
+/*
+AddBinding binds one more key to the action. The key is not added twice.
+*/
+func (userInput *UserInput) AddBinding(action string, key ebiten.Key) {
+	keys, ok := userInput.keyDesc[action]
+	if !ok {
+		log.Printf("Cannot bind key %v to unknown action '%s'", key, action)
+		return
+	}
+	if !slices.Contains(keys, key) {
+		userInput.keyDesc[action] = append(keys, key)
+	}
+}
+
+/*
+RemoveBinding unbinds the key from the action.
+*/
+func (userInput *UserInput) RemoveBinding(action string, key ebiten.Key) {
+	if keys, ok := userInput.keyDesc[action]; ok {
+		userInput.keyDesc[action] = slices.DeleteFunc(slices.Clone(keys), func(k ebiten.Key) bool { return k == key })
+	}
+}
+
+/*
+getBindings returns the keys bound to the action.
+*/
+func (userInput *UserInput) getBindings(action string) KeyList {
+	return slices.Clone(userInput.keyDesc[action])
+}
+
+func (userInput *UserInput) handleKeys(frameCnt int) {
+	userInput.playMacroEvents(frameCnt)
+
 	for keyName, keys := range userInput.keyDesc {
-		userInput.handleKeyPress(keys, userInput.keyState[keyName])
+		state := userInput.keyState[keyName]
+		userInput.handleKeyPress(keys, userInput.simulatedDown[keyName], state)
+		userInput.recordMacroEvent(frameCnt, keyName, state)
 	}
 }
 
-func (userInput *UserInput) handleMouse() {
-	down := ebiten.IsMouseButtonPressed(ebiten.MouseButtonLeft)
-	userInput.updateControlState(down, &userInput.mouseLeftState)
+func (userInput *UserInput) handleMouse(frameCnt int) {
+	leftDown := !userInput.replaying && ebiten.IsMouseButtonPressed(ebiten.MouseButtonLeft) || userInput.simulatedDown["mouseLeft"]
+	rightDown := !userInput.replaying && ebiten.IsMouseButtonPressed(ebiten.MouseButtonRight) || userInput.simulatedDown["mouseRight"]
+	userInput.updateMouseState(leftDown, rightDown, frameCnt)
 
-	down = ebiten.IsMouseButtonPressed(ebiten.MouseButtonRight)
-	userInput.updateControlState(down, &userInput.mouseRightState)
+	userInput.recordMacroEvent(frameCnt, "mouseLeft", &userInput.mouseLeftState)
+	userInput.recordMacroEvent(frameCnt, "mouseRight", &userInput.mouseRightState)
 }
 
+/*
+cursorPosition returns the position of the mouse cursor, or the recorded one while replaying.
+*/
+func (userInput *UserInput) cursorPosition() (int, int) {
+	if userInput.replaying {
+		return userInput.simulatedCursor.x, userInput.simulatedCursor.y
+	}
+	return ebiten.CursorPosition()
+}
+
+/*
+handleTouches detects the gestures of the touches. The actions triggered by the gestures are
+reported by isKeyPressed together with the keys. While replaying, the actions are set by ReplayPlayer instead.
+*/
+func (userInput *UserInput) handleTouches(frameCnt int) {
+	if userInput.replaying {
+		return
+	}
+
+	touchPos := maps.Clone(userInput.simulatedTouches)
+	for _, id := range ebiten.AppendTouchIDs(nil) {
+		x, y := ebiten.TouchPosition(id)
+		touchPos[id] = Pos{x, y}
+	}
+	userInput.updateTouches(touchPos, frameCnt)
+}
+
+/*
+updateTouches updates the tracked touches by the current ones and triggers the actions of the gestures:
+  - a horizontal swipe moves left or right by each cell width of the swipe
+  - a tap in the upper half of the game area rotates
+  - a downward swipe is a soft drop, a fast one is a hard drop
+The vertical gestures are detected when the touch is released.
+*/
+func (userInput *UserInput) updateTouches(touchPos map[ebiten.TouchID]Pos, frameCnt int) {
+	clear(userInput.touchPressed)
+
+	for id, pos := range touchPos {
+		start, ok := userInput.touchStart[id]
+		if !ok {
+			start = &TouchStart{pos: pos, frame: frameCnt}
+			userInput.touchStart[id] = start
+		}
+		userInput.touchPos[id] = pos
+
+		// the start is moved by a cell for each move, so a long swipe moves more cells
+		if dx := pos.x - start.pos.x; touchSwipeMinDist < abs(dx) {
+			if dx < 0 {
+				userInput.touchPressed["left"] = true
+				start.pos.x -= touchSwipeMinDist
+			} else {
+				userInput.touchPressed["right"] = true
+				start.pos.x += touchSwipeMinDist
+			}
+			start.swiped = true
+		}
+	}
+
+	for id, start := range userInput.touchStart {
+		if _, ok := touchPos[id]; ok {
+			continue
+		}
+		userInput.handleTouchRelease(start, userInput.touchPos[id], frameCnt)
+		delete(userInput.touchStart, id)
+		delete(userInput.touchPos, id)
+	}
+}
+
+func (userInput *UserInput) handleTouchRelease(start *TouchStart, pos Pos, frameCnt int) {
+	if start.swiped {
+		return
+	}
+
+	dist := subPos(pos, start.pos)
+	switch {
+	case touchSwipeMinDist < dist.y:
+		if touchFastSwipeSpeed*max(frameCnt-start.frame, 1) <= dist.y {
+			userInput.touchPressed["drop"] = true
+		} else {
+			userInput.touchPressed["softDrop"] = true
+		}
+	case abs(dist.x) < touchTapMaxDist && abs(dist.y) < touchTapMaxDist:
+		if start.pos.x < screenLayout.playArea.size.w && start.pos.y < screenHeight/2 {
+			userInput.touchPressed["rotate"] = true
+		}
+	}
+}
+
+/*
+macroRecord starts recording the key and mouse button state changes.
+*/
+func (userInput *UserInput) macroRecord(name string) {
+	log.Printf("Recording macro '%s'", name)
+	userInput.recordedMacro = &Macro{name: name, startFrame: -1}
+}
+
+/*
+macroStop stops the recording and saves the macro to macros/<name>.json.
+*/
+func (userInput *UserInput) macroStop() error {
+	macro := userInput.recordedMacro
+	if macro == nil {
+		return fmt.Errorf("no macro is being recorded")
+	}
+	userInput.recordedMacro = nil
+
+	data, err := json.Marshal(macro.events)
+	if err != nil {
+		return fmt.Errorf("failed to marshal macro '%s': %w", macro.name, err)
+	}
+
+	if err := os.MkdirAll(macroDir, 0755); err != nil {
+		return fmt.Errorf("failed to create macro directory: %w", err)
+	}
+	if err := os.WriteFile(getMacroPath(macro.name), data, 0644); err != nil {
+		return fmt.Errorf("failed to save macro '%s': %w", macro.name, err)
+	}
+
+	log.Printf("Macro '%s' saved with %d events", macro.name, len(macro.events))
+	return nil
+}
+
+/*
+macroPlay loads macros/<name>.json and replays it by simulating the presses and releases,
+starting with the next handleKeys.
+*/
+func (userInput *UserInput) macroPlay(name string) error {
+	data, err := os.ReadFile(getMacroPath(name))
+	if err != nil {
+		return fmt.Errorf("failed to load macro '%s': %w", name, err)
+	}
+
+	macro := &Macro{name: name, startFrame: -1}
+	if err := json.Unmarshal(data, &macro.events); err != nil {
+		return fmt.Errorf("failed to parse macro '%s': %w", name, err)
+	}
+
+	log.Printf("Playing macro '%s' with %d events", name, len(macro.events))
+	userInput.playedMacro = macro
+	return nil
+}
+
+func getMacroPath(name string) string {
+	return filepath.Join(macroDir, name+".json")
+}
+
+func (userInput *UserInput) recordMacroEvent(frameCnt int, keyName string, state *ControlState) {
+	macro := userInput.recordedMacro
+	if macro == nil {
+		return
+	}
+	if macro.startFrame < 0 {
+		macro.startFrame = frameCnt
+	}
+
+	// the repeated presses of a held key are not recorded, the replay repeats them
+	if state.press && state.heldFrames == 1 || state.release {
+		macro.events = append(macro.events, MacroEvent{Frame: frameCnt - macro.startFrame, Key: keyName, Down: state.press})
+	}
+}
+
+func (userInput *UserInput) playMacroEvents(frameCnt int) {
+	macro := userInput.playedMacro
+	if macro == nil {
+		return
+	}
+	if macro.startFrame < 0 {
+		macro.startFrame = frameCnt
+	}
+
+	for ; macro.nextIdx < len(macro.events) && macro.events[macro.nextIdx].Frame <= frameCnt-macro.startFrame; macro.nextIdx++ {
+		event := macro.events[macro.nextIdx]
+		if event.Down {
+			userInput.simulatePress(event.Key)
+		} else {
+			userInput.simulateRelease(event.Key)
+		}
+	}
+
+	if macro.nextIdx == len(macro.events) {
+		log.Printf("Macro '%s' is over", macro.name)
+		userInput.playedMacro = nil
+	}
+}
+
+/*
+updateMouseState updates the button states. Two left clicks within doubleClickFrameCnt frames
+make a double click. The second click of a double click does not start a new pair.
+*/
+func (userInput *UserInput) updateMouseState(leftDown, rightDown bool, frameCnt int) {
+	userInput.updateControlState(leftDown, &userInput.mouseLeftState)
+	userInput.updateControlState(rightDown, &userInput.mouseRightState)
+
+	userInput.doubleClick = false
+	if userInput.mouseLeftState.press {
+		userInput.doubleClick = 0 < userInput.lastClickFrame && frameCnt-userInput.lastClickFrame <= doubleClickFrameCnt
+		if userInput.doubleClick {
+			userInput.lastClickFrame = 0
+		} else {
+			userInput.lastClickFrame = frameCnt
+		}
+	}
+}
+
+/*
+updateControlState updates the state by the control being down in the current frame.
+If dasDelay is set, a held control is pressed again after dasDelay frames, then in every dasRepeat frames.
+*/
 func (userInput *UserInput) updateControlState(isControlDown bool, state *ControlState) {
 	state.press = isControlDown && !state.down
 	state.release = !isControlDown && state.down
 	state.down = isControlDown
+
+	if !isControlDown {
+		state.heldFrames = 0
+		return
+	}
+
+	state.heldFrames++
+	if heldAfterPress := state.heldFrames - 1; 0 < state.dasDelay && state.dasDelay <= heldAfterPress {
+		state.press = state.press || (heldAfterPress-state.dasDelay)%max(state.dasRepeat, 1) == 0
+	}
 }
 
 /*
 handleKeyPress centralizes the handling of key presses to reduce redundancy.
 */
-func (userInput *UserInput) handleKeyPress(keys KeyList, state *ControlState) {
-	down := false
+func (userInput *UserInput) handleKeyPress(keys KeyList, simulatedDown bool, state *ControlState) {
+	down := simulatedDown
 	for _, key := range keys {
-		if ebiten.IsKeyPressed(key) {
+		if !userInput.replaying && ebiten.IsKeyPressed(key) {
 			down = true
 			break
 		}
@@ -69,7 +428,26 @@ func (userInput *UserInput) handleKeyPress(keys KeyList, state *ControlState) {
 	userInput.updateControlState(down, state)
 }
 
+/*
+simulatePress holds the key down as if it was pressed, until simulateRelease is called.
+The press is detected by the next handleKeys, so it can be used with Game.Update without Ebiten.
+*/
+func (userInput *UserInput) simulatePress(keyName string) {
+	userInput.simulatedDown[keyName] = true
+}
+
+/*
+simulateRelease releases the key held down by simulatePress.
+*/
+func (userInput *UserInput) simulateRelease(keyName string) {
+	delete(userInput.simulatedDown, keyName)
+}
+
 func (userInput *UserInput) isKeyPressed(keyName string) bool {
+	if userInput.touchPressed[keyName] {
+		return true
+	}
+
 	state, ok := userInput.keyState[keyName]
 	if ok {
 		return state.press
@@ -78,6 +456,20 @@ func (userInput *UserInput) isKeyPressed(keyName string) bool {
 	}
 }
 
+func (userInput *UserInput) isKeyReleased(keyName string) bool {
+	state, ok := userInput.keyState[keyName]
+	return ok && state.release
+}
+
+func (userInput *UserInput) isKeyDown(keyName string) bool {
+	state, ok := userInput.keyState[keyName]
+	if ok {
+		return state.down
+	} else {
+		return false
+	}
+}
+
 func (userInput *UserInput) isMouseLeftClick() bool {
 	return userInput.mouseLeftState.press
 }
@@ -85,3 +477,7 @@ func (userInput *UserInput) isMouseLeftClick() bool {
 func (userInput *UserInput) isMouseRightClick() bool {
 	return userInput.mouseRightState.press
 }
+
+func (userInput *UserInput) isDoubleClick() bool {
+	return userInput.doubleClick
+}